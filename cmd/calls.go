@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/shakibhasan09/twilio-voice-openai/internal"
+	"github.com/spf13/cobra"
+)
+
+var (
+	callsOpts      internal.CallsOptions
+	callShowFormat string
+)
+
+var callsCmd = &cobra.Command{
+	Use:   "calls",
+	Short: "Query the local call log in CALL_LOG_DB",
+}
+
+var callsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List recent calls, newest first",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := internal.ListCalls(callsOpts); err != nil {
+			log.Fatal("Error listing calls: ", err)
+		}
+	},
+}
+
+var callsShowCmd = &cobra.Command{
+	Use:   "show <sid>",
+	Short: "Show a call and its timeline, by CallSid or call ID",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := internal.ShowCall(args[0], callShowFormat); err != nil {
+			log.Fatal("Error showing call: ", err)
+		}
+	},
+}
+
+func init() {
+	callsListCmd.Flags().StringVar(&callsOpts.Tenant, "tenant", "", "only list this tenant's calls")
+	callsListCmd.Flags().StringVar(&callsOpts.PhoneNumber, "number", "", "only list calls with this phone number")
+	callsListCmd.Flags().StringVar(&callsOpts.Since, "since", "", "only list calls started on or after this date (YYYY-MM-DD, UTC)")
+	callsListCmd.Flags().IntVar(&callsOpts.Limit, "limit", 20, "maximum number of calls to list, 0 for all")
+	callsListCmd.Flags().StringVar(&callsOpts.Format, "format", "table", "output format: table or json")
+	callsShowCmd.Flags().StringVar(&callShowFormat, "format", "table", "output format: table or json")
+	callsCmd.AddCommand(callsListCmd, callsShowCmd)
+	rootCmd.AddCommand(callsCmd)
+}
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/shakibhasan09/twilio-voice-openai/internal"
+	"github.com/spf13/cobra"
+)
+
+var chatOpts internal.ChatOptions
+
+var chatCmd = &cobra.Command{
+	Use:   "chat",
+	Short: "Talk to the assistant in the terminal over text, with the same instructions and tools as a call",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := internal.Chat(chatOpts); err != nil {
+			log.Fatal("Error running chat: ", err)
+		}
+	},
+}
+
+func init() {
+	chatCmd.Flags().StringVar(&chatOpts.From, "from", "+15555550100", "caller number the session runs as")
+	chatCmd.Flags().StringVar(&chatOpts.InstructionsPath, "instructions", "", "file with the instructions to test (defaults to SYSTEM_MESSAGE)")
+	rootCmd.AddCommand(chatCmd)
+}
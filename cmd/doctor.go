@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/shakibhasan09/twilio-voice-openai/internal"
+	"github.com/spf13/cobra"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check connectivity to OpenAI, Twilio and the tool webhook",
+	Run: func(cmd *cobra.Command, args []string) {
+		if !internal.Doctor() {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
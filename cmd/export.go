@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/shakibhasan09/twilio-voice-openai/internal"
+	"github.com/spf13/cobra"
+)
+
+var exportOpts internal.ExportOptions
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export stored calls as chat-format JSONL for fine-tuning and evals",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := internal.Export(exportOpts); err != nil {
+			log.Fatal("Error exporting calls: ", err)
+		}
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringVar(&exportOpts.Tenant, "tenant", "", "only export this tenant's calls")
+	exportCmd.Flags().StringVar(&exportOpts.PhoneNumber, "number", "", "only export calls with this phone number")
+	exportCmd.Flags().StringVar(&exportOpts.Since, "since", "", "only export calls started on or after this date (YYYY-MM-DD, UTC)")
+	exportCmd.Flags().IntVar(&exportOpts.Limit, "limit", 0, "export at most this many of the most recent calls, 0 for all")
+	exportCmd.Flags().StringVarP(&exportOpts.Output, "output", "o", "", "file to write, stdout by default")
+	exportCmd.Flags().BoolVar(&exportOpts.NoSystem, "no-system", false, "leave out the system prompt")
+	exportCmd.Flags().BoolVar(&exportOpts.Metadata, "metadata", false, "add each call's IDs, number, tenant and status as metadata")
+	rootCmd.AddCommand(exportCmd)
+}
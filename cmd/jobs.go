@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/shakibhasan09/twilio-voice-openai/internal"
+	"github.com/spf13/cobra"
+)
+
+var jobSince string
+
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "List and run background post-processing jobs",
+}
+
+var jobsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the jobs and their schedules",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := internal.ListJobs(); err != nil {
+			log.Fatal("Error listing jobs: ", err)
+		}
+	},
+}
+
+var jobsRunCmd = &cobra.Command{
+	Use:   "run <name>",
+	Short: "Run a job once, over yesterday's calls by default",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := internal.RunJob(args[0], jobSince); err != nil {
+			log.Fatal("Error running job: ", err)
+		}
+	},
+}
+
+func init() {
+	jobsRunCmd.Flags().StringVar(&jobSince, "since", "", "run over calls started on or after this date (YYYY-MM-DD, UTC) instead of yesterday's")
+	jobsCmd.AddCommand(jobsListCmd, jobsRunCmd)
+	rootCmd.AddCommand(jobsCmd)
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"log"
+	"time"
+
+	"github.com/shakibhasan09/twilio-voice-openai/internal"
+	"github.com/spf13/cobra"
+)
+
+var loadTestOpts internal.LoadTestOptions
+
+var loadTestCmd = &cobra.Command{
+	Use:   "loadtest",
+	Short: "Run concurrent simulated Twilio streams and report latency and resource usage",
+	Run: func(cmd *cobra.Command, args []string) {
+		if loadTestOpts.URL == "" && !loadTestOpts.Mock {
+			loadTestOpts.URL = internal.LocalStreamURL("+15555550100")
+		}
+
+		if err := internal.LoadTest(loadTestOpts); err != nil {
+			log.Fatal("Error running load test: ", err)
+		}
+	},
+}
+
+func init() {
+	loadTestCmd.Flags().IntVar(&loadTestOpts.Calls, "calls", 10, "number of concurrent simulated calls")
+	loadTestCmd.Flags().DurationVar(&loadTestOpts.RampUp, "ramp-up", 5*time.Second, "time over which calls are started")
+	loadTestCmd.Flags().BoolVar(&loadTestOpts.Mock, "mock", false, "run an in-process server against the mock OpenAI backend")
+	loadTestCmd.Flags().StringVar(&loadTestOpts.AudioPath, "audio", "", "WAV file with the caller's audio")
+	loadTestCmd.Flags().StringVar(&loadTestOpts.URL, "url", "", "media-stream websocket URL (defaults to the local server)")
+	loadTestCmd.Flags().DurationVar(&loadTestOpts.GreetingTimeout, "greeting-timeout", 10*time.Second, "how long to wait for the greeting before speaking")
+	loadTestCmd.Flags().DurationVar(&loadTestOpts.ReplyTimeout, "timeout", 30*time.Second, "how long to wait for the assistant's reply")
+	loadTestCmd.Flags().DurationVar(&loadTestOpts.Silence, "silence", 2*time.Second, "silence after which the assistant is considered done speaking")
+	loadTestCmd.MarkFlagRequired("audio")
+	rootCmd.AddCommand(loadTestCmd)
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/shakibhasan09/twilio-voice-openai/internal"
+	"github.com/spf13/cobra"
+)
+
+var replayOpts internal.ReplayOptions
+
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Replay a recorded call's caller side into a fresh session and print the new responses",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := internal.Replay(replayOpts); err != nil {
+			log.Fatal("Error replaying call: ", err)
+		}
+	},
+}
+
+func init() {
+	replayCmd.Flags().StringVar(&replayOpts.TranscriptPath, "transcript", "", "recorded transcript (.jsonl) whose caller turns are replayed as text")
+	replayCmd.Flags().StringVar(&replayOpts.AudioPath, "audio", "", "recorded caller audio (.wav) replayed in real time")
+	replayCmd.Flags().StringVar(&replayOpts.InstructionsPath, "instructions", "", "file with the instructions to test (defaults to SYSTEM_MESSAGE)")
+	replayCmd.MarkFlagsOneRequired("transcript", "audio")
+	replayCmd.MarkFlagsMutuallyExclusive("transcript", "audio")
+	rootCmd.AddCommand(replayCmd)
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/shakibhasan09/twilio-voice-openai/internal"
+	"github.com/spf13/cobra"
+)
+
+var reportOpts internal.ReportOptions
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Print a monthly billing summary per tenant and agent from USAGE_FILE",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := internal.Report(reportOpts); err != nil {
+			log.Fatal("Error building report: ", err)
+		}
+	},
+}
+
+func init() {
+	reportCmd.Flags().StringVar(&reportOpts.Month, "month", "", "month to report as YYYY-MM (defaults to the current month, UTC)")
+	reportCmd.Flags().StringVar(&reportOpts.Tenant, "tenant", "", "only report this tenant")
+	reportCmd.Flags().BoolVar(&reportOpts.Daily, "daily", false, "break the summary down by day")
+	reportCmd.Flags().StringVar(&reportOpts.Format, "format", "table", "output format: table, csv or json")
+	rootCmd.AddCommand(reportCmd)
+}
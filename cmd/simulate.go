@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"log"
+	"time"
+
+	"github.com/shakibhasan09/twilio-voice-openai/internal"
+	"github.com/spf13/cobra"
+)
+
+var simulateOpts internal.SimulateCallOptions
+
+var simulateFrom string
+
+var simulateCmd = &cobra.Command{
+	Use:   "simulate-call",
+	Short: "Play a WAV file into the media-stream endpoint as Twilio would",
+	Run: func(cmd *cobra.Command, args []string) {
+		if simulateOpts.URL == "" {
+			simulateOpts.URL = internal.LocalStreamURL(simulateFrom)
+		}
+
+		if err := internal.SimulateCall(simulateOpts); err != nil {
+			log.Fatal("Error simulating call: ", err)
+		}
+		log.Println("Assistant reply written to", simulateOpts.OutputPath)
+	},
+}
+
+func init() {
+	simulateCmd.Flags().StringVar(&simulateOpts.AudioPath, "audio", "", "WAV file with the caller's audio")
+	simulateCmd.Flags().StringVar(&simulateOpts.OutputPath, "out", "reply.wav", "WAV file to write the assistant's audio to")
+	simulateCmd.Flags().StringVar(&simulateOpts.URL, "url", "", "media-stream websocket URL (defaults to the local server)")
+	simulateCmd.Flags().StringVar(&simulateFrom, "from", "+15555550100", "caller number used in the default URL")
+	simulateCmd.Flags().DurationVar(&simulateOpts.GreetingTimeout, "greeting-timeout", 10*time.Second, "how long to wait for the greeting before speaking")
+	simulateCmd.Flags().DurationVar(&simulateOpts.ReplyTimeout, "timeout", 30*time.Second, "how long to wait for the assistant's reply")
+	simulateCmd.Flags().DurationVar(&simulateOpts.Silence, "silence", 2*time.Second, "silence after which the assistant is considered done speaking")
+	simulateCmd.MarkFlagRequired("audio")
+	rootCmd.AddCommand(simulateCmd)
+}
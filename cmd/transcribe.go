@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/shakibhasan09/twilio-voice-openai/internal"
+	"github.com/spf13/cobra"
+)
+
+var transcribeOpts internal.TranscribeOptions
+
+var transcribeCmd = &cobra.Command{
+	Use:   "transcribe <recording>",
+	Short: "Transcribe a stored recording with Whisper and save it as the call's transcript",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := internal.Transcribe(args[0], transcribeOpts); err != nil {
+			log.Fatal("Error transcribing recording: ", err)
+		}
+	},
+}
+
+func init() {
+	transcribeCmd.Flags().StringVar(&transcribeOpts.Language, "language", "", "language of the call as an ISO-639-1 code, detected by default")
+	transcribeCmd.Flags().BoolVar(&transcribeOpts.Force, "force", false, "replace the call's existing transcript")
+	rootCmd.AddCommand(transcribeCmd)
+}
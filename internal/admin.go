@@ -0,0 +1,415 @@
+package internal
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+func registerAdminRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/calls", requireScope(scopeRead, handleAdminListCalls))
+	mux.HandleFunc("GET /admin/callbacks", requireScope(scopeRead, handleAdminListCallbacks))
+	mux.HandleFunc("GET /admin/reminders", requireScope(scopeRead, handleAdminListReminders))
+	mux.HandleFunc("POST /admin/reminders", requireScope(scopeControl, handleAdminCreateReminder))
+	mux.HandleFunc("DELETE /admin/reminders/{id}", requireScope(scopeControl, handleAdminCancelReminder))
+	mux.HandleFunc("GET /admin/calls/{id}", requireScope(scopeRead, handleAdminGetCall))
+	mux.HandleFunc("GET /admin/calls/{id}/timeline", requireScope(scopeRead, handleAdminCallTimeline))
+	mux.HandleFunc("GET /admin/calls/{id}/transcript", requireScope(scopeTranscripts, handleAdminCallTranscript))
+	mux.HandleFunc("GET /admin/calls/{id}/listen", requireScope(scopeListen, handleAdminListen))
+	mux.HandleFunc("POST /admin/calls/{id}/hangup", requireScope(scopeControl, handleAdminHangupCall))
+	mux.HandleFunc("POST /admin/calls/{id}/redirect", requireScope(scopeControl, handleAdminRedirectCall))
+	mux.HandleFunc("POST /admin/calls/{id}/hold", requireScope(scopeControl, handleAdminHoldCall))
+	mux.HandleFunc("POST /admin/calls/{id}/resume", requireScope(scopeControl, handleAdminResumeCall))
+	mux.HandleFunc("GET /admin/tenants", requireScope(scopeRead, handleAdminListTenants))
+	mux.HandleFunc("GET /admin/usage", requireScope(scopeRead, handleAdminListUsage))
+	mux.HandleFunc("GET /admin/clips", requireScope(scopeRead, handleAdminListClips))
+	mux.HandleFunc("POST /admin/calls/{id}/play", requireScope(scopeControl, handleAdminPlayClip))
+	mux.HandleFunc("POST /admin/calls/{id}/recording/stop", requireScope(scopeControl, handleAdminRecording(false)))
+	mux.HandleFunc("POST /admin/calls/{id}/recording/start", requireScope(scopeControl, handleAdminRecording(true)))
+	mux.HandleFunc("POST /admin/secrets/refresh", requireScope(scopeConfigure, handleAdminRefreshSecrets))
+	mux.HandleFunc("GET /admin/audit", requireScope(scopeAudit, handleAdminListAudit))
+	mux.HandleFunc("GET /admin/logging", requireScope(scopeRead, handleAdminGetLogging))
+	mux.HandleFunc("PUT /admin/logging", requireScope(scopeConfigure, handleAdminSetLogging))
+	mux.HandleFunc("POST /admin/calls/{id}/debug", requireScope(scopeConfigure, handleAdminDebugCall))
+	mux.HandleFunc("PATCH /admin/calls/{id}/metadata", requireScope(scopeControl, handleAdminSetMetadata))
+	mux.HandleFunc("GET /admin/approvals", requireScope(scopeControl, handleAdminListApprovals))
+	mux.HandleFunc("POST /admin/approvals/{id}", requireScope(scopeControl, handleAdminDecideApproval))
+	mux.HandleFunc("GET /admin/jobs", requireScope(scopeRead, handleAdminListJobs))
+	mux.HandleFunc("POST /admin/jobs/{name}/run", requireScope(scopeControl, handleAdminRunJob))
+}
+
+func handleAdminListCalls(w http.ResponseWriter, r *http.Request) {
+	calls := []map[string]interface{}{}
+	for _, s := range listSessions() {
+		calls = append(calls, s.summary())
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"calls": calls})
+}
+
+func handleAdminListTenants(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tenants": listTenantUsage()})
+}
+
+func handleAdminListUsage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"usage": listUsage(usageFilter{
+		From:   query.Get("from"),
+		To:     query.Get("to"),
+		Tenant: query.Get("tenant"),
+		Agent:  query.Get("agent"),
+	})})
+}
+
+func handleAdminListCallbacks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"callbacks": listCallbacks()})
+}
+
+func handleAdminListReminders(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"reminders": listReminders()})
+}
+
+func handleAdminCreateReminder(w http.ResponseWriter, r *http.Request) {
+	var body reminder
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+	if config.ExternalURL == "" {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "EXTERNAL_URL or PUBLIC_HOSTNAME must be set to place reminder calls"})
+		return
+	}
+
+	created := &reminder{
+		PhoneNumber:  body.PhoneNumber,
+		CallerID:     body.CallerID,
+		At:           body.At,
+		Instructions: body.Instructions,
+		Greeting:     body.Greeting,
+		Variables:    body.Variables,
+	}
+	if created.Instructions == "" {
+		created.Instructions = config.SystemMessage
+	}
+	if created.Greeting == "" {
+		created.Greeting = config.XMLResponse
+	}
+	if err := scheduleReminder(created); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func handleAdminCancelReminder(w http.ResponseWriter, r *http.Request) {
+	if !cancelReminder(r.PathValue("id")) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no scheduled reminder with that id"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleAdminGetCall(w http.ResponseWriter, r *http.Request) {
+	s := lookupSession(r.PathValue("id"))
+	if s == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "call not found"})
+		return
+	}
+
+	call := s.summary()
+	call["timeline"] = s.timelineEvents()
+	writeJSON(w, http.StatusOK, call)
+}
+
+func handleAdminCallTimeline(w http.ResponseWriter, r *http.Request) {
+	s := lookupSession(r.PathValue("id"))
+	if s == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "call not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"timeline": s.timelineEvents()})
+}
+
+// handleAdminCallTranscript returns what has been said on the call so far.
+// Transcripts are only kept when RECORDING_DIR is set.
+func handleAdminCallTranscript(w http.ResponseWriter, r *http.Request) {
+	s := lookupSession(r.PathValue("id"))
+	if s == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "call not found"})
+		return
+	}
+	if s.recorder == nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "transcripts need RECORDING_DIR"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"transcript": s.recorder.transcriptEntries()})
+}
+
+// handleAdminListen streams a live call's audio over a websocket, in the
+// same format as AUDIO_FORK_URL, until the call ends or the listener leaves.
+func handleAdminListen(w http.ResponseWriter, r *http.Request) {
+	s, ok := liveCall(w, r)
+	if !ok {
+		return
+	}
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Error upgrading listener:", err)
+		return
+	}
+
+	listener := s.listen(ws)
+	s.record("call.listen", "admin")
+	// Reading handles control frames and notices when the listener leaves.
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			break
+		}
+	}
+	s.unlisten(listener)
+}
+
+func handleAdminHangupCall(w http.ResponseWriter, r *http.Request) {
+	s, ok := liveCall(w, r)
+	if !ok {
+		return
+	}
+
+	if err := hangupCall(s.callSid()); err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+	s.record("call.hangup", "admin")
+	writeJSON(w, http.StatusOK, s.summary())
+}
+
+// handleAdminRedirectCall moves a live call to new TwiML, given either a
+// "url" to fetch it from or inline "twiml".
+func handleAdminRedirectCall(w http.ResponseWriter, r *http.Request) {
+	s, ok := liveCall(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		URL   string `json:"url"`
+		TwiML string `json:"twiml"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || (body.URL == "") == (body.TwiML == "") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "body must set exactly one of url or twiml"})
+		return
+	}
+
+	var err error
+	if body.URL != "" {
+		err = redirectCall(s.callSid(), body.URL)
+	} else {
+		err = updateCallTwiML(s.callSid(), body.TwiML)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+	s.record("call.redirect", body.URL)
+	writeJSON(w, http.StatusOK, s.summary())
+}
+
+func handleAdminHoldCall(w http.ResponseWriter, r *http.Request) {
+	s, ok := liveCall(w, r)
+	if !ok {
+		return
+	}
+	s.hold("admin")
+	writeJSON(w, http.StatusOK, s.summary())
+}
+
+func handleAdminResumeCall(w http.ResponseWriter, r *http.Request) {
+	s, ok := liveCall(w, r)
+	if !ok {
+		return
+	}
+	s.resume(true)
+	writeJSON(w, http.StatusOK, s.summary())
+}
+
+func handleAdminListClips(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"clips": audioClipNames()})
+}
+
+// handleAdminPlayClip starts playing a clip, given its "clip" name, and
+// returns without waiting for it to finish.
+func handleAdminPlayClip(w http.ResponseWriter, r *http.Request) {
+	s, ok := liveCall(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Clip string `json:"clip"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || audioClips[body.Clip] == nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "body must name a clip from GET /admin/clips"})
+		return
+	}
+	if s.onHold() || s.playingClip() || s.sip() {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "the call is on hold, already playing a clip or bridged over SIP"})
+		return
+	}
+
+	go func() {
+		if err := s.playClip(body.Clip, "admin"); err != nil {
+			log.Println("Error playing audio clip:", err)
+		}
+	}()
+	writeJSON(w, http.StatusAccepted, s.summary())
+}
+
+func handleAdminRecording(on bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, ok := liveCall(w, r)
+		if !ok {
+			return
+		}
+		if s.recorder == nil {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "recording is not enabled"})
+			return
+		}
+		s.setRecording(on, "admin")
+		writeJSON(w, http.StatusOK, s.summary())
+	}
+}
+
+// liveCall looks up the call named in the path and makes sure Twilio can
+// still act on it, writing the error response otherwise.
+func liveCall(w http.ResponseWriter, r *http.Request) (*callSession, bool) {
+	s := lookupSession(r.PathValue("id"))
+	if s == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "call not found"})
+		return nil, false
+	}
+	if s.callSid() == "" || !s.active() {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "call is not live"})
+		return nil, false
+	}
+	return s, true
+}
+
+func handleAdminRefreshSecrets(w http.ResponseWriter, r *http.Request) {
+	if config.File.Secrets.Provider == "" {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "no secrets provider configured"})
+		return
+	}
+	if err := loadSecrets(); err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminListAudit returns audit entries, filtered by the optional
+// "actor", "call" and "since" (RFC 3339) query parameters. "verified" is
+// false once any entry in the log has been tampered with.
+func handleAdminListAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := auditFilter{Actor: query.Get("actor"), Call: query.Get("call")}
+	if since := query.Get("since"); since != "" {
+		var err error
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "since must be an RFC 3339 time"})
+			return
+		}
+	}
+	entries, verified := listAudit(filter)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"entries": entries, "verified": verified})
+}
+
+func handleAdminGetLogging(w http.ResponseWriter, r *http.Request) {
+	level, eventTypes := currentLogSettings()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"level": level, "event_types": eventTypes})
+}
+
+// handleAdminSetLogging changes the log level and logged event types until
+// the next restart. Fields left out of the body keep their value.
+func handleAdminSetLogging(w http.ResponseWriter, r *http.Request) {
+	level, eventTypes := currentLogSettings()
+	body := struct {
+		Level      string   `json:"level"`
+		EventTypes []string `json:"event_types"`
+	}{level, eventTypes}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+	if !validLogLevel(body.Level) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "level must be quiet, info or debug"})
+		return
+	}
+	setLogSettings(body.Level, body.EventTypes)
+	handleAdminGetLogging(w, r)
+}
+
+// handleAdminDebugCall turns full event logging on or off for one call,
+// given {"enabled": true|false}.
+func handleAdminDebugCall(w http.ResponseWriter, r *http.Request) {
+	s := lookupSession(r.PathValue("id"))
+	if s == nil || !s.active() {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no active call with that id"})
+		return
+	}
+	var body struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+	s.setDebug(body.Enabled)
+	writeJSON(w, http.StatusOK, s.summary())
+}
+
+// handleAdminSetMetadata merges a JSON object into the call's metadata;
+// null values remove their keys.
+func handleAdminSetMetadata(w http.ResponseWriter, r *http.Request) {
+	s, ok := liveCall(w, r)
+	if !ok {
+		return
+	}
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "body must be a JSON object"})
+		return
+	}
+	s.setMetadata(body, "admin")
+	writeJSON(w, http.StatusOK, s.summary())
+}
+
+// handleAdminListApprovals needs the control scope, as the requests hold
+// the tool's raw arguments.
+func handleAdminListApprovals(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"approvals": listApprovals(requestIdentity(r))})
+}
+
+// handleAdminDecideApproval approves or rejects a tool call waiting for
+// approval, given {"approved": true|false} and optionally a reason. The
+// approver is whoever authenticated the request.
+func handleAdminDecideApproval(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Approved *bool  `json:"approved"`
+		Reason   string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Approved == nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": `body must be a JSON object with "approved"`})
+		return
+	}
+	if !decideApproval(r.PathValue("id"), requestIdentity(r), approvalDecision{Approved: *body.Approved, Reason: body.Reason}) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "approval not found or already decided"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"approved": *body.Approved})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// twilioAllowlist holds the networks allowed to reach the Twilio-facing
+// endpoints: the static TWILIO_IP_ALLOWLIST plus the ranges last fetched from
+// TWILIO_IP_RANGES_URL.
+var twilioAllowlist struct {
+	sync.RWMutex
+	static  []*net.IPNet
+	fetched []*net.IPNet
+}
+
+func twilioAllowlistEnabled() bool {
+	return len(config.TwilioIPAllowlist) > 0 || config.TwilioIPRangesURL != ""
+}
+
+func startTwilioAllowlist() error {
+	static, err := parseNetworks(config.TwilioIPAllowlist)
+	if err != nil {
+		return fmt.Errorf("error parsing TWILIO_IP_ALLOWLIST: %v", err)
+	}
+	twilioAllowlist.Lock()
+	twilioAllowlist.static = static
+	twilioAllowlist.Unlock()
+
+	if config.TwilioIPRangesURL == "" {
+		return nil
+	}
+
+	refresh := func() {
+		networks, err := fetchNetworks(config.TwilioIPRangesURL)
+		if err != nil {
+			log.Println("Error refreshing Twilio IP ranges:", err)
+			return
+		}
+		twilioAllowlist.Lock()
+		twilioAllowlist.fetched = networks
+		twilioAllowlist.Unlock()
+		log.Printf("Loaded %d Twilio IP ranges\n", len(networks))
+	}
+
+	refresh()
+	go func() {
+		for range time.Tick(config.TwilioIPRangesRefresh) {
+			refresh()
+		}
+	}()
+
+	return nil
+}
+
+func twilioIPAllowed(ip net.IP) bool {
+	twilioAllowlist.RLock()
+	defer twilioAllowlist.RUnlock()
+
+	for _, networks := range [][]*net.IPNet{twilioAllowlist.static, twilioAllowlist.fetched} {
+		for _, network := range networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// twilioOnly rejects requests from outside the allowlist when one is
+// configured, and with TWILIO_VALIDATE_SIGNATURES requests without a valid
+// Twilio signature. An allowlist whose ranges failed to load rejects
+// everything.
+func twilioOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if twilioAllowlistEnabled() {
+			ip := net.ParseIP(clientIP(r))
+			if ip == nil || !twilioIPAllowed(ip) {
+				log.Printf("Rejected %s %s from %s: not in Twilio IP allowlist\n", r.Method, r.URL.Path, clientIP(r))
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+		}
+		if config.TwilioValidateSigs && !validTwilioSignature(r) {
+			log.Printf("Rejected %s %s from %s: invalid Twilio signature\n", r.Method, r.URL.Path, clientIP(r))
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// fetchNetworks downloads a list of networks, either as a JSON array of
+// strings or as plain text with one network per line.
+func fetchNetworks(url string) ([]*net.IPNet, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %v", err)
+	}
+
+	var ranges []string
+	if err := json.Unmarshal(body, &ranges); err != nil {
+		ranges = nil
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				ranges = append(ranges, line)
+			}
+		}
+	}
+
+	return parseNetworks(ranges)
+}
+
+func parseNetworks(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
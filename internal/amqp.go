@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// amqpPublishTimeout bounds how long one event waits for the broker to
+// confirm it.
+const amqpPublishTimeout = 5 * time.Second
+
+// amqpPublisher publishes to AMQP_EXCHANGE with publisher confirms. It is
+// only used by the amqp event sink's goroutine, and reconnects on the next
+// event after losing the connection.
+type amqpPublisher struct {
+	routingKey *template.Template
+	conn       *amqp.Connection
+	ch         *amqp.Channel
+}
+
+// startAMQP publishes call events to the exchange AMQP_EXCHANGE on
+// AMQP_URL, declaring it first, with the routing key from the
+// AMQP_ROUTING_KEY template.
+func startAMQP() error {
+	if config.AMQPURL == "" {
+		return nil
+	}
+
+	tmpl, err := template.New("routing key").Parse(config.AMQPRoutingKey)
+	if err != nil {
+		return fmt.Errorf("error parsing AMQP_ROUTING_KEY: %v", err)
+	}
+	if _, err := amqpRoutingKey(tmpl, callEvent{}); err != nil {
+		return err
+	}
+	p := &amqpPublisher{routingKey: tmpl}
+	if err := p.connect(); err != nil {
+		return err
+	}
+
+	go runEventSink("amqp", config.AMQPEventTypes, p.publish)
+	log.Printf("Publishing call events to AMQP exchange %s\n", config.AMQPExchange)
+	return nil
+}
+
+func (p *amqpPublisher) connect() error {
+	conn, err := amqp.DialConfig(config.AMQPURL, amqp.Config{
+		Properties: amqp.Table{"connection_name": "twilio-voice-openai"},
+	})
+	if err != nil {
+		return fmt.Errorf("error connecting to AMQP: %v", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error opening AMQP channel: %v", err)
+	}
+	if err := ch.ExchangeDeclare(config.AMQPExchange, config.AMQPExchangeType, true, false, false, false, nil); err != nil {
+		conn.Close()
+		return fmt.Errorf("error declaring AMQP exchange %s: %v", config.AMQPExchange, err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		conn.Close()
+		return fmt.Errorf("error enabling AMQP publisher confirms: %v", err)
+	}
+	p.conn, p.ch = conn, ch
+	return nil
+}
+
+func (p *amqpPublisher) close() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.conn, p.ch = nil, nil
+}
+
+// publish sends an event as a persistent JSON message and waits for the
+// broker to confirm it.
+func (p *amqpPublisher) publish(event callEvent) error {
+	if p.conn == nil || p.conn.IsClosed() || p.ch.IsClosed() {
+		p.close()
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+
+	key, err := amqpRoutingKey(p.routingKey, event)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error marshaling event: %v", err)
+	}
+	headers := amqp.Table{"type": event.Type, "call_sid": event.Call.CallSid}
+	if event.Call.Tenant != "" {
+		headers["tenant"] = event.Call.Tenant
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), amqpPublishTimeout)
+	defer cancel()
+	confirm, err := p.ch.PublishWithDeferredConfirmWithContext(ctx, config.AMQPExchange, key, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    event.ID,
+		Timestamp:    event.Time,
+		Type:         event.Type,
+		Headers:      headers,
+		Body:         body,
+	})
+	if err != nil {
+		p.close()
+		return err
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		p.close()
+		return err
+	}
+	if !acked {
+		return fmt.Errorf("broker rejected the message")
+	}
+	return nil
+}
+
+// amqpRoutingKey executes the routing key template against the event, e.g.
+// calls.{{or .Call.Tenant "default"}}.{{.Type}}.
+func amqpRoutingKey(tmpl *template.Template, event callEvent) (string, error) {
+	var key strings.Builder
+	if err := tmpl.Execute(&key, event); err != nil {
+		return "", fmt.Errorf("error executing AMQP_ROUTING_KEY: %v", err)
+	}
+	return key.String(), nil
+}
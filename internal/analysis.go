@@ -0,0 +1,251 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const analysisPrompt = `You review phone calls between a caller and an AI voice assistant. ` +
+	`Summarize the call in one or two sentences, and rate the caller's sentiment as positive, neutral or negative. ` +
+	`Answer with JSON only: {"synopsis": "...", "sentiment": "positive|neutral|negative"}`
+
+// callAnalysis is kept in the call log under "analysis" in the call's
+// summary.
+type callAnalysis struct {
+	Synopsis   string    `json:"synopsis"`
+	Sentiment  string    `json:"sentiment"`
+	Model      string    `json:"model"`
+	AnalyzedAt time.Time `json:"analyzed_at"`
+}
+
+var summarizeJob = &job{
+	name:        "summarize",
+	description: "Summarize calls and rate their sentiment where not done yet, then send a daily_summary webhook.",
+	run: func(from, to time.Time) (string, error) {
+		return analyzeCalls(from, to, false)
+	},
+}
+
+var sentimentJob = &job{
+	name:        "sentiment",
+	description: "Summarize calls and rate their sentiment again, e.g. after changing JOBS_MODEL.",
+	run: func(from, to time.Time) (string, error) {
+		return analyzeCalls(from, to, true)
+	},
+}
+
+// analyzeCalls analyzes the recorded calls started in [from, to), all of
+// them with redo and only new ones otherwise. Unless redoing, it then sends
+// a digest of those calls to the daily_summary webhook, one per tenant.
+func analyzeCalls(from, to time.Time, redo bool) (string, error) {
+	if storage == nil {
+		return "", fmt.Errorf("storage is not open")
+	}
+	calls, err := storage.ListCalls(callLogFilter{Since: from})
+	if err != nil {
+		return "", err
+	}
+
+	digests := map[string]*callDigest{}
+	total, analyzed, failed := 0, 0, 0
+	var lastErr error
+	for i := len(calls) - 1; i >= 0; i-- {
+		if !calls[i].StartedAt.Before(to) {
+			continue
+		}
+		total++
+		entry, err := storage.GetCall(calls[i].ID)
+		if err != nil || entry == nil {
+			return "", fmt.Errorf("error reading call %s: %v", calls[i].ID, err)
+		}
+
+		analysis := storedAnalysis(*entry)
+		if analysis == nil || redo {
+			a, err := analyzeCall(*entry)
+			if err != nil {
+				failed++
+				lastErr = fmt.Errorf("call %s: %v", entry.ID, err)
+			} else if a != nil {
+				analysis = a
+				if entry.Summary == nil {
+					entry.Summary = map[string]interface{}{}
+				}
+				entry.Summary["analysis"] = a
+				if err := storage.SaveCall(*entry); err != nil {
+					return "", fmt.Errorf("error saving call %s: %v", entry.ID, err)
+				}
+				analyzed++
+			}
+		}
+
+		digest := digests[entry.Tenant]
+		if digest == nil {
+			digest = &callDigest{From: from, To: to, ByStatus: map[string]int{}, BySentiment: map[string]int{}, Calls: []digestCall{}}
+			digests[entry.Tenant] = digest
+		}
+		digest.add(*entry, analysis)
+	}
+
+	result := fmt.Sprintf("analyzed %d of %d calls", analyzed, total)
+	if !redo {
+		for tenant, digest := range digests {
+			if err := deliverWebhook("daily_summary", map[string]interface{}{"tenant": tenant}, digest); err != nil {
+				return result, fmt.Errorf("error delivering daily_summary webhook: %v", err)
+			}
+		}
+		result += fmt.Sprintf(", sent %d digests", len(digests))
+	}
+	if failed > 0 {
+		return result, fmt.Errorf("%d calls failed, last: %v", failed, lastErr)
+	}
+	return result, nil
+}
+
+func storedAnalysis(entry callLogEntry) *callAnalysis {
+	raw, ok := entry.Summary["analysis"]
+	if !ok {
+		return nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+	var analysis callAnalysis
+	if err := json.Unmarshal(data, &analysis); err != nil || analysis.Synopsis == "" {
+		return nil
+	}
+	return &analysis
+}
+
+// analyzeCall asks JOBS_MODEL for a synopsis and sentiment of a call's
+// transcript, with the tenant's OpenAI key. It returns nil for a call
+// without a transcript.
+func analyzeCall(entry callLogEntry) (*callAnalysis, error) {
+	tenant := config.File.Tenants[entry.Tenant]
+	name := entry.CallSid
+	if name == "" {
+		name = entry.ID
+	}
+	entries, err := storage.Transcript(tenant.storageName(name))
+	if err != nil {
+		return nil, fmt.Errorf("error reading transcript: %v", err)
+	}
+
+	var transcript strings.Builder
+	for _, e := range entries {
+		switch e.Role {
+		case "caller":
+			fmt.Fprintf(&transcript, "Caller: %s\n", e.Text)
+		case "assistant":
+			fmt.Fprintf(&transcript, "Assistant: %s\n", e.Text)
+		case "tool_call":
+			fmt.Fprintf(&transcript, "(assistant called %s with %s)\n", e.Name, e.Arguments)
+		}
+	}
+	if transcript.Len() == 0 {
+		return nil, nil
+	}
+
+	var result callAnalysis
+	if err := chatCompletionJSON(tenant, analysisPrompt, transcript.String(), &result); err != nil {
+		return nil, err
+	}
+	result.Sentiment = strings.ToLower(result.Sentiment)
+	result.Model, result.AnalyzedAt = config.JobsModel, time.Now().UTC()
+	return &result, nil
+}
+
+// chatCompletionJSON sends one system and user message to the chat
+// completions API in JSON mode and decodes the answer into v.
+func chatCompletionJSON(tenant *tenantConfig, system, user string, v interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"model": config.JobsModel,
+		"messages": []map[string]string{
+			{"role": "system", "content": system},
+			{"role": "user", "content": user},
+		},
+		"response_format": map[string]string{"type": "json_object"},
+	})
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, openAIAPIURL("/chat/completions"), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header = openAIHeader(tenant)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := openAIClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status code: %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("error decoding response: %v", err)
+	}
+	if len(result.Choices) == 0 {
+		return fmt.Errorf("no choices in response")
+	}
+	if err := json.Unmarshal([]byte(result.Choices[0].Message.Content), v); err != nil {
+		return fmt.Errorf("error decoding model answer: %v", err)
+	}
+	return nil
+}
+
+// callDigest is the daily_summary webhook payload.
+type callDigest struct {
+	From            time.Time      `json:"from"`
+	To              time.Time      `json:"to"`
+	Count           int            `json:"call_count"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	EstimatedCost   float64        `json:"estimated_cost"`
+	ByStatus        map[string]int `json:"by_status"`
+	BySentiment     map[string]int `json:"by_sentiment"`
+	Calls           []digestCall   `json:"calls"`
+}
+
+type digestCall struct {
+	ID          string    `json:"id"`
+	CallSid     string    `json:"call_sid"`
+	PhoneNumber string    `json:"phone_number"`
+	StartedAt   time.Time `json:"started_at"`
+	Status      string    `json:"status,omitempty"`
+	Synopsis    string    `json:"synopsis,omitempty"`
+	Sentiment   string    `json:"sentiment,omitempty"`
+}
+
+func (d *callDigest) add(entry callLogEntry, analysis *callAnalysis) {
+	d.Count++
+	d.DurationSeconds += entry.DurationSeconds
+	d.EstimatedCost += entry.EstimatedCost
+	status := entry.Status
+	if status == "" {
+		status = "unknown"
+	}
+	d.ByStatus[status]++
+	call := digestCall{ID: entry.ID, CallSid: entry.CallSid, PhoneNumber: entry.PhoneNumber, StartedAt: entry.StartedAt, Status: entry.Status}
+	if analysis != nil {
+		call.Synopsis, call.Sentiment = analysis.Synopsis, analysis.Sentiment
+		d.BySentiment[analysis.Sentiment]++
+	}
+	d.Calls = append(d.Calls, call)
+}
@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+var approvalsTotal = newCounter("tool_approvals_total", "Tool calls sent for approval, by result (approved, rejected, timeout or error).", "result")
+
+// A tool that needs approval, because approval is set or it is named in
+// APPROVAL_TOOLS, doesn't run when the model calls it. The proposed call is
+// posted to the approval_request webhook (APPROVAL_WEBHOOK_URL), which can
+// be a Slack incoming webhook with a body template, and the model tells the
+// caller it is being processed. The tool runs only if an approver answers
+// with POST /admin/approvals/{id} within APPROVAL_TIMEOUT, and the model
+// then tells the caller how it went. Requests are kept in memory and are
+// lost on restart.
+
+// approvalRequest is a tool call waiting for an approver.
+type approvalRequest struct {
+	ID          string          `json:"id"`
+	CallSid     string          `json:"call_sid"`
+	Tenant      string          `json:"tenant,omitempty"`
+	Tool        string          `json:"tool"`
+	Arguments   json.RawMessage `json:"arguments"`
+	RequestedAt time.Time       `json:"requested_at"`
+	ExpiresAt   time.Time       `json:"expires_at"`
+
+	decided chan approvalDecision
+}
+
+// approvalDecision is an approver's answer to a request.
+type approvalDecision struct {
+	Approved bool   `json:"approved"`
+	Approver string `json:"approver"`
+	Reason   string `json:"reason"`
+}
+
+var approvals = struct {
+	sync.Mutex
+	pending map[string]*approvalRequest
+}{pending: map[string]*approvalRequest{}}
+
+func (t *tool) needsApproval() bool {
+	return t.approval || slices.Contains(config.ApprovalTools, t.name)
+}
+
+// listApprovals returns the requests waiting for an approver, of the
+// tenants they may decide for.
+func listApprovals(approver adminIdentity) []approvalRequest {
+	approvals.Lock()
+	defer approvals.Unlock()
+
+	list := make([]approvalRequest, 0, len(approvals.pending))
+	for _, request := range approvals.pending {
+		if approver.allowsTenant(request.Tenant) {
+			list = append(list, *request)
+		}
+	}
+	return list
+}
+
+// decideApproval hands an approver's decision to the call waiting for it,
+// recording the approver as who made it. It reports false if no request
+// with the ID is waiting for one of the approver's tenants.
+func decideApproval(id string, approver adminIdentity, decision approvalDecision) bool {
+	approvals.Lock()
+	request, ok := approvals.pending[id]
+	ok = ok && approver.allowsTenant(request.Tenant)
+	if ok {
+		delete(approvals.pending, id)
+	}
+	approvals.Unlock()
+	if ok {
+		decision.Approver = approver.Actor
+		request.decided <- decision
+	}
+	return ok
+}
+
+// requestApproval sends a tool call for approval, tells the model to let
+// the caller know, and waits for the decision. An approved call runs, and
+// the model is told the outcome either way.
+func (s *callSession) requestApproval(t *tool, callID, arguments string) {
+	if !json.Valid([]byte(arguments)) {
+		log.Printf("Error requesting approval for tool %s: arguments are not valid JSON\n", t.name)
+		s.record("tool.error", "invalid arguments for "+t.name)
+		s.toolFailed(callID, t.name)
+		return
+	}
+	now := time.Now().UTC()
+	request := &approvalRequest{
+		ID:          randomHex(8),
+		CallSid:     s.callSid(),
+		Tenant:      s.tenant.id(),
+		Tool:        t.name,
+		Arguments:   json.RawMessage(arguments),
+		RequestedAt: now,
+		ExpiresAt:   now.Add(config.ApprovalTimeout),
+		decided:     make(chan approvalDecision, 1),
+	}
+	approvals.Lock()
+	approvals.pending[request.ID] = request
+	approvals.Unlock()
+	defer func() {
+		approvals.Lock()
+		delete(approvals.pending, request.ID)
+		approvals.Unlock()
+	}()
+
+	if err := deliverWebhook("approval_request", s.summary(), request); err != nil {
+		log.Println("Error requesting approval:", err)
+		approvalsTotal.addFor(s, 1, "error")
+		s.record("tool.error", err.Error())
+		s.toolFailed(callID, t.name)
+		return
+	}
+	s.record("tool.approval_requested", t.name+" "+request.ID)
+	s.sendToolOutput(callID, "Not done yet: this needs a person's approval. Tell the caller their request is being processed and that you'll let them know on this call shortly. Don't call "+t.name+" again for it.", true)
+
+	var decision approvalDecision
+	select {
+	case decision = <-request.decided:
+	case <-time.After(config.ApprovalTimeout):
+		approvalsTotal.addFor(s, 1, "timeout")
+		s.record("tool.approval_timeout", t.name)
+		s.tellOutcome(fmt.Sprintf("No one approved the request to %s in time, so it was not done. Tell the caller, and that a colleague will follow up.", t.name))
+		return
+	case <-s.done:
+		return
+	}
+
+	if !decision.Approved {
+		approvalsTotal.addFor(s, 1, "rejected")
+		s.record("tool.rejected", fmt.Sprintf("%s by %s", t.name, decision.Approver))
+		note := fmt.Sprintf("The request to %s was declined, so it was not done.", t.name)
+		if decision.Reason != "" {
+			note += " The reason given: " + decision.Reason + "."
+		}
+		s.tellOutcome(note + " Tell the caller.")
+		return
+	}
+	approvalsTotal.addFor(s, 1, "approved")
+	s.record("tool.approved", fmt.Sprintf("%s by %s", t.name, decision.Approver))
+
+	output, err := t.run(s, arguments)
+	if err != nil {
+		log.Printf("Error running tool %s: %v\n", t.name, err)
+		s.record("tool.error", err.Error())
+		message := errorMessage(s.tenant, "tool_failed", errorMessageData{Tool: t.name})
+		s.tellOutcome(fmt.Sprintf("The request to %s was approved but then failed. Tell the caller this, in their language: %s", t.name, message))
+		return
+	}
+	s.record("tool.result", t.name)
+	if t.silent {
+		if err := s.sendOpenAI(systemNote(fmt.Sprintf("The request to %s was approved and done. Its output: %s", t.name, output))); err != nil {
+			log.Println("Error sending approval outcome to OpenAI:", err)
+		}
+		return
+	}
+	s.tellOutcome(fmt.Sprintf("The request to %s was approved and done. Its output: %s. Tell the caller.", t.name, output))
+}
+
+// tellOutcome adds a note about a tool call that finished after its output
+// was sent, and has the model tell the caller.
+func (s *callSession) tellOutcome(note string) {
+	if err := s.sendOpenAI(systemNote(note)); err != nil {
+		log.Println("Error sending approval outcome to OpenAI:", err)
+	}
+	s.respondToToolOutput()
+}
@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	twilioSampleRate = 8000
+	twilioFrameBytes = 160
+	mulawSilence     = 0xFF
+)
+
+func mulawEncode(sample int16) byte {
+	const bias, clip = 0x84, 32635
+
+	s := int(sample)
+	sign := 0
+	if s < 0 {
+		s = -s
+		sign = 0x80
+	}
+	if s > clip {
+		s = clip
+	}
+	s += bias
+
+	exponent := 7
+	for mask := 0x4000; s&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := (s >> (exponent + 3)) & 0x0F
+
+	return ^byte(sign | exponent<<4 | mantissa)
+}
+
+func mulawDecode(b byte) int16 {
+	u := ^b
+	sign := u & 0x80
+	exponent := (u >> 4) & 0x07
+	mantissa := u & 0x0F
+
+	s := ((int(mantissa) << 3) + 0x84) << exponent
+	s -= 0x84
+	if sign != 0 {
+		return int16(-s)
+	}
+	return int16(s)
+}
+
+func encodeMulaw(samples []int16) []byte {
+	out := make([]byte, len(samples))
+	for i, s := range samples {
+		out[i] = mulawEncode(s)
+	}
+	return out
+}
+
+func decodeMulaw(data []byte) []int16 {
+	out := make([]int16, len(data))
+	for i, b := range data {
+		out[i] = mulawDecode(b)
+	}
+	return out
+}
+
+func resample(samples []int16, fromRate, toRate int) []int16 {
+	if fromRate == toRate || len(samples) == 0 {
+		return samples
+	}
+
+	n := int(int64(len(samples)) * int64(toRate) / int64(fromRate))
+	out := make([]int16, n)
+	for i := range out {
+		pos := float64(i) * float64(fromRate) / float64(toRate)
+		idx := int(pos)
+		if idx >= len(samples)-1 {
+			out[i] = samples[len(samples)-1]
+			continue
+		}
+		frac := pos - float64(idx)
+		out[i] = int16(float64(samples[idx])*(1-frac) + float64(samples[idx+1])*frac)
+	}
+	return out
+}
+
+// readWAV decodes a PCM16 or μ-law WAV file into mono samples at the given
+// sample rate.
+func readWAV(path string, sampleRate int) ([]int16, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !isWAV(data) {
+		return nil, fmt.Errorf("%s is not a WAV file", path)
+	}
+	interleaved, channels, rate, err := decodeWAV(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return resample(mixDown(interleaved, channels), rate, sampleRate), nil
+}
+
+// mixDown averages interleaved channels into mono.
+func mixDown(interleaved []int16, channels int) []int16 {
+	mono := make([]int16, len(interleaved)/channels)
+	for i := range mono {
+		sum := 0
+		for c := 0; c < channels; c++ {
+			sum += int(interleaved[i*channels+c])
+		}
+		mono[i] = int16(sum / channels)
+	}
+	return mono
+}
+
+func isWAV(data []byte) bool {
+	return len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WAVE"
+}
+
+// decodeWAV returns the interleaved samples of a PCM16 or μ-law WAV file
+// with its channel count and sample rate.
+func decodeWAV(data []byte) ([]int16, int, int, error) {
+	var format, channels, bitsPerSample uint16
+	var rate uint32
+	var pcm []byte
+	for r := bytes.NewReader(data[12:]); ; {
+		var id [4]byte
+		var size uint32
+		if err := binary.Read(r, binary.LittleEndian, &id); err != nil {
+			break
+		}
+		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+			return nil, 0, 0, fmt.Errorf("error reading WAV chunk: %v", err)
+		}
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, 0, 0, fmt.Errorf("error reading WAV chunk: %v", err)
+		}
+		if size%2 == 1 {
+			r.ReadByte()
+		}
+
+		switch string(id[:]) {
+		case "fmt ":
+			if size < 16 {
+				return nil, 0, 0, fmt.Errorf("invalid WAV fmt chunk")
+			}
+			format = binary.LittleEndian.Uint16(chunk[0:2])
+			channels = binary.LittleEndian.Uint16(chunk[2:4])
+			rate = binary.LittleEndian.Uint32(chunk[4:8])
+			bitsPerSample = binary.LittleEndian.Uint16(chunk[14:16])
+			if format == 0xFFFE && size >= 26 {
+				format = binary.LittleEndian.Uint16(chunk[24:26])
+			}
+		case "data":
+			pcm = chunk
+		}
+	}
+
+	if channels == 0 || rate == 0 {
+		return nil, 0, 0, fmt.Errorf("missing WAV fmt chunk")
+	}
+
+	var interleaved []int16
+	switch {
+	case format == 1 && bitsPerSample == 16:
+		interleaved = make([]int16, len(pcm)/2)
+		for i := range interleaved {
+			interleaved[i] = int16(binary.LittleEndian.Uint16(pcm[i*2:]))
+		}
+	case format == 7 && bitsPerSample == 8:
+		interleaved = decodeMulaw(pcm)
+	default:
+		return nil, 0, 0, fmt.Errorf("unsupported WAV encoding (format %d, %d bits)", format, bitsPerSample)
+	}
+	return interleaved, int(channels), int(rate), nil
+}
+
+// writeWAV writes interleaved PCM16 samples as a WAV file.
+func writeWAV(path string, samples []int16, sampleRate, channels int) error {
+	return os.WriteFile(path, encodeWAV(samples, sampleRate, channels), 0o644)
+}
+
+func encodeWAV(samples []int16, sampleRate, channels int) []byte {
+	var buf bytes.Buffer
+	dataSize := uint32(len(samples) * 2)
+
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, 36+dataSize)
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))
+	binary.Write(&buf, binary.LittleEndian, uint16(1))
+	binary.Write(&buf, binary.LittleEndian, uint16(channels))
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate*channels*2))
+	binary.Write(&buf, binary.LittleEndian, uint16(channels*2))
+	binary.Write(&buf, binary.LittleEndian, uint16(16))
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, dataSize)
+	binary.Write(&buf, binary.LittleEndian, samples)
+	return buf.Bytes()
+}
@@ -0,0 +1,224 @@
+package internal
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditMemoryEntries is how many of the latest audit entries are kept in
+// memory for the API.
+const auditMemoryEntries = 10000
+
+// auditEntry records one admin action. Each entry's hash covers the entry
+// and the hash before it, so editing or removing a line of AUDIT_LOG_FILE
+// breaks the chain from there on. With AUDIT_HMAC_KEY the hashes are
+// HMACs, so without the key the chain can't be rebuilt after an edit.
+type auditEntry struct {
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`
+	Action   string    `json:"action"`
+	Call     string    `json:"call,omitempty"`
+	Status   int       `json:"status"`
+	RemoteIP string    `json:"remote_ip"`
+	PrevHash string    `json:"prev_hash"`
+	Hash     string    `json:"hash"`
+}
+
+func (e auditEntry) computeHash() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	if key := secret("AUDIT_HMAC_KEY"); key != "" {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(e.PrevHash))
+		mac.Write(data)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	sum := sha256.Sum256(append([]byte(e.PrevHash), data...))
+	return hex.EncodeToString(sum[:])
+}
+
+var auditLog = struct {
+	sync.Mutex
+	// entries are the latest auditMemoryEntries entries, and head the hash
+	// of the last one, which the next extends.
+	entries []auditEntry
+	head    string
+	// broken is the first entry whose hash does not match, or -1.
+	broken int
+}{broken: -1}
+
+// serveAudited runs an admin handler, recording it in the audit log unless
+// it only reads call data or mints client secrets. Entries are written when
+// the handler returns, so a listen-in shows up once the listener leaves.
+func serveAudited(scope, actor string, next http.HandlerFunc, w http.ResponseWriter, r *http.Request) {
+	if scope == scopeRead || scope == scopeRealtime {
+		next(w, r)
+		return
+	}
+
+	entry := auditEntry{
+		Time:     time.Now().UTC(),
+		Actor:    actor,
+		Action:   r.Method + " " + r.URL.Path,
+		RemoteIP: clientIP(r),
+	}
+	if strings.HasPrefix(r.URL.Path, "/admin/calls/") {
+		entry.Call = r.PathValue("id")
+		if s := lookupSession(entry.Call); s != nil && s.callSid() != "" {
+			entry.Call = s.callSid()
+		}
+	}
+
+	recorder := &statusRecorder{ResponseWriter: w}
+	next(recorder, r)
+	entry.Status = recorder.status
+	if entry.Status == 0 {
+		entry.Status = http.StatusOK
+	}
+	appendAudit(entry)
+}
+
+func appendAudit(entry auditEntry) {
+	auditLog.Lock()
+	defer auditLog.Unlock()
+
+	entry.PrevHash = auditLog.head
+	entry.Hash = entry.computeHash()
+	auditLog.head = entry.Hash
+	auditLog.entries = append(auditLog.entries, entry)
+	if n := len(auditLog.entries); n > auditMemoryEntries {
+		auditLog.entries = slices.Clone(auditLog.entries[n-auditMemoryEntries:])
+	}
+
+	if config.AuditLogFile == "" {
+		storeAudit(entry)
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("Error marshaling audit entry:", err)
+		return
+	}
+	f, err := os.OpenFile(config.AuditLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Println("Error opening audit log:", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Println("Error writing audit log:", err)
+		return
+	}
+	if err := f.Sync(); err != nil {
+		log.Println("Error syncing audit log:", err)
+	}
+}
+
+// loadAuditLog reads AUDIT_LOG_FILE, or the entries in storage without it,
+// so the API can serve past entries and new ones extend the chain. A broken
+// chain is reported, not repaired.
+func loadAuditLog() error {
+	var entries []auditEntry
+	source := config.AuditLogFile
+	if source == "" {
+		stored, err := storage.AuditEntries()
+		if err != nil {
+			return err
+		}
+		entries, source = stored, "the stored audit log"
+	} else {
+		read, err := readAuditFile()
+		if err != nil {
+			return err
+		}
+		entries = read
+	}
+
+	broken := -1
+	for i, entry := range entries {
+		prevHash := ""
+		if i > 0 {
+			prevHash = entries[i-1].Hash
+		}
+		if entry.PrevHash != prevHash || entry.Hash != entry.computeHash() {
+			broken = i
+			break
+		}
+	}
+	if broken >= 0 {
+		log.Printf("Warning: %s has been modified at entry %d, its hash chain is broken\n", source, broken+1)
+	}
+	if secret("AUDIT_HMAC_KEY") == "" {
+		log.Println("Warning: AUDIT_HMAC_KEY is not set, so the audit log's hash chain can be rebuilt by anyone who can edit it")
+	}
+
+	head := ""
+	if len(entries) > 0 {
+		head = entries[len(entries)-1].Hash
+		log.Printf("Audit log head: entry %d, hash %s\n", len(entries), head)
+	}
+	if len(entries) > auditMemoryEntries {
+		entries = entries[len(entries)-auditMemoryEntries:]
+	}
+	auditLog.Lock()
+	auditLog.entries, auditLog.head, auditLog.broken = entries, head, broken
+	auditLog.Unlock()
+	return nil
+}
+
+func readAuditFile() ([]auditEntry, error) {
+	f, err := os.Open(config.AuditLogFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", config.AuditLogFile, err)
+	}
+	defer f.Close()
+
+	var entries []auditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("error parsing %s line %d: %v", config.AuditLogFile, len(entries)+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", config.AuditLogFile, err)
+	}
+	return entries, nil
+}
+
+// auditFilter selects entries by actor, call and time; empty fields match
+// everything.
+type auditFilter struct {
+	Actor string
+	Call  string
+	Since time.Time
+}
+
+func listAudit(f auditFilter) ([]auditEntry, bool) {
+	auditLog.Lock()
+	defer auditLog.Unlock()
+
+	entries := []auditEntry{}
+	for _, entry := range auditLog.entries {
+		if (f.Actor == "" || entry.Actor == f.Actor) && (f.Call == "" || entry.Call == f.Call) && !entry.Time.Before(f.Since) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, auditLog.broken < 0
+}
@@ -0,0 +1,290 @@
+package internal
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	scopeRead        = "read"
+	scopeTranscripts = "transcripts"
+	scopeListen      = "listen"
+	scopeControl     = "control"
+	scopeConfigure   = "configure"
+	scopeAudit       = "audit"
+	scopeRealtime    = "realtime"
+)
+
+// roles bundle scopes for people using the admin API: viewers see calls,
+// usage and metrics; operators also read transcripts, listen in and act on
+// live calls; admins may also change configuration and read the audit log.
+var roles = map[string][]string{
+	"viewer":   {scopeRead},
+	"operator": {scopeRead, scopeTranscripts, scopeListen, scopeControl},
+	"admin":    {scopeRead, scopeTranscripts, scopeListen, scopeControl, scopeConfigure, scopeAudit, scopeRealtime},
+}
+
+type adminAuthConfig struct {
+	APIKeys []adminAPIKey   `json:"api_keys"`
+	JWT     *adminJWTConfig `json:"jwt"`
+}
+
+type adminAPIKey struct {
+	Name    string   `json:"name"`
+	Key     string   `json:"key"`
+	Role    string   `json:"role"`
+	Scopes  []string `json:"scopes"`
+	Tenants []string `json:"tenants"`
+}
+
+// adminIdentity is who made an admin request: the API key's name or the
+// JWT's subject, the scopes they hold and, if they are limited to some,
+// the tenants whose tool approvals they may see and decide.
+type adminIdentity struct {
+	Actor   string
+	Scopes  []string
+	Tenants []string
+}
+
+type identityKey struct{}
+
+// requestIdentity returns who made a request that passed requireScope.
+func requestIdentity(r *http.Request) adminIdentity {
+	if id, ok := r.Context().Value(identityKey{}).(adminIdentity); ok {
+		return id
+	}
+	return adminIdentity{Actor: "anonymous"}
+}
+
+// allowsTenant reports whether the identity may act for a tenant, where ""
+// is calls that don't belong to any.
+func (id adminIdentity) allowsTenant(tenant string) bool {
+	return len(id.Tenants) == 0 || slices.Contains(id.Tenants, tenant)
+}
+
+// adminJWTConfig validates bearer JWTs signed with either a shared HS256
+// secret or an RS256 public key. Scopes come from the space-separated "scope"
+// claim or the "scopes" array claim, plus those of the roles named in the
+// "role" or "roles" claim. A "tenants" array claim limits the tenants.
+type adminJWTConfig struct {
+	Secret    string `json:"secret"`
+	PublicKey string `json:"public_key"`
+	Issuer    string `json:"issuer"`
+	Audience  string `json:"audience"`
+}
+
+func adminAuthEnabled() bool {
+	return len(config.File.Admin.APIKeys) > 0 || config.File.Admin.JWT != nil
+}
+
+func validateAdminAuth() error {
+	for _, key := range config.File.Admin.APIKeys {
+		if key.Key == "" {
+			return fmt.Errorf("API key %s has an empty key", key.Name)
+		}
+		if key.Role != "" && roles[key.Role] == nil {
+			return fmt.Errorf("API key %s has unknown role %q, expected viewer, operator or admin", key.Name, key.Role)
+		}
+	}
+	return nil
+}
+
+// roleScopes adds the scopes of the named roles to the explicit ones.
+// Unknown roles grant nothing.
+func roleScopes(scopes []string, names ...string) []string {
+	for _, name := range names {
+		scopes = append(scopes, roles[name]...)
+	}
+	return scopes
+}
+
+// requireScope guards an admin or metrics handler. Without any API keys or
+// JWT settings in the config file the endpoints stay open, which Run warns
+// about at startup.
+func requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuthEnabled() {
+			serveAudited(scope, "anonymous", next, w, r)
+			return
+		}
+
+		id, err := authenticate(r)
+		if err != nil {
+			log.Printf("Rejected %s %s: %v\n", r.Method, r.URL.Path, err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		if !hasScope(id.Scopes, scope) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "missing scope " + scope})
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+		serveAudited(scope, id.Actor, next, w, r)
+	}
+}
+
+// hasScope reports whether the granted scopes cover the required one; the
+// control scope implies read.
+func hasScope(granted []string, required string) bool {
+	return slices.Contains(granted, required) || (required == scopeRead && slices.Contains(granted, scopeControl))
+}
+
+// authenticate returns who made the request.
+func authenticate(r *http.Request) (adminIdentity, error) {
+	return authenticateHeader(r.Header)
+}
+
+// authenticateHeader authenticates by the X-API-Key or Authorization
+// header, which gRPC clients send as metadata.
+func authenticateHeader(h http.Header) (adminIdentity, error) {
+	token := h.Get("X-API-Key")
+	if token == "" {
+		scheme, value, _ := strings.Cut(h.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return adminIdentity{}, fmt.Errorf("missing bearer token")
+		}
+		token = strings.TrimSpace(value)
+	}
+	if token == "" {
+		return adminIdentity{}, fmt.Errorf("missing bearer token")
+	}
+
+	for _, key := range config.File.Admin.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key.Key)) == 1 {
+			return adminIdentity{Actor: "key:" + key.Name, Scopes: roleScopes(key.Scopes, key.Role), Tenants: key.Tenants}, nil
+		}
+	}
+
+	if config.File.Admin.JWT != nil && strings.Count(token, ".") == 2 {
+		return verifyJWT(config.File.Admin.JWT, token)
+	}
+
+	return adminIdentity{}, fmt.Errorf("unknown API key")
+}
+
+func verifyJWT(cfg *adminJWTConfig, token string) (adminIdentity, error) {
+	parts := strings.Split(token, ".")
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return adminIdentity{}, fmt.Errorf("error decoding JWT header: %v", err)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return adminIdentity{}, fmt.Errorf("error decoding JWT signature: %v", err)
+	}
+
+	switch {
+	case header.Alg == "HS256" && cfg.Secret != "":
+		mac := hmac.New(sha256.New, []byte(cfg.Secret))
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return adminIdentity{}, fmt.Errorf("invalid JWT signature")
+		}
+	case header.Alg == "RS256" && cfg.PublicKey != "":
+		key, err := parseRSAPublicKey(cfg.PublicKey)
+		if err != nil {
+			return adminIdentity{}, err
+		}
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return adminIdentity{}, fmt.Errorf("invalid JWT signature")
+		}
+	default:
+		return adminIdentity{}, fmt.Errorf("unsupported JWT algorithm %q", header.Alg)
+	}
+
+	var claims struct {
+		Issuer    string          `json:"iss"`
+		Subject   string          `json:"sub"`
+		Audience  json.RawMessage `json:"aud"`
+		ExpiresAt int64           `json:"exp"`
+		NotBefore int64           `json:"nbf"`
+		Scope     string          `json:"scope"`
+		Scopes    []string        `json:"scopes"`
+		Role      string          `json:"role"`
+		Roles     []string        `json:"roles"`
+		Tenants   []string        `json:"tenants"`
+	}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return adminIdentity{}, fmt.Errorf("error decoding JWT claims: %v", err)
+	}
+
+	now := time.Now().Unix()
+	if claims.ExpiresAt == 0 || now >= claims.ExpiresAt {
+		return adminIdentity{}, fmt.Errorf("JWT expired")
+	}
+	if claims.NotBefore != 0 && now < claims.NotBefore {
+		return adminIdentity{}, fmt.Errorf("JWT not yet valid")
+	}
+	if cfg.Issuer != "" && claims.Issuer != cfg.Issuer {
+		return adminIdentity{}, fmt.Errorf("unexpected JWT issuer %q", claims.Issuer)
+	}
+	if cfg.Audience != "" && !jwtAudienceContains(claims.Audience, cfg.Audience) {
+		return adminIdentity{}, fmt.Errorf("unexpected JWT audience")
+	}
+
+	scopes := append(strings.Fields(claims.Scope), claims.Scopes...)
+	return adminIdentity{
+		Actor:   "jwt:" + claims.Subject,
+		Scopes:  roleScopes(scopes, append(claims.Roles, claims.Role)...),
+		Tenants: claims.Tenants,
+	}, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// jwtAudienceContains handles "aud" being either a string or an array.
+func jwtAudienceContains(raw json.RawMessage, audience string) bool {
+	var single string
+	if json.Unmarshal(raw, &single) == nil {
+		return single == audience
+	}
+	var many []string
+	if json.Unmarshal(raw, &many) == nil {
+		return slices.Contains(many, audience)
+	}
+	return false
+}
+
+func parseRSAPublicKey(data string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("error decoding JWT public key: no PEM block")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing JWT public key: %v", err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("JWT public key is not an RSA key")
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func jwtPart(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signHS256(t *testing.T, secret string, header, claims interface{}) string {
+	t.Helper()
+	signed := jwtPart(t, header) + "." + jwtPart(t, claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, claims interface{}) string {
+	t.Helper()
+	signed := jwtPart(t, map[string]string{"alg": "RS256", "typ": "JWT"}) + "." + jwtPart(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+func TestAuthenticateHeader(t *testing.T) {
+	admin := config.File.Admin
+	t.Cleanup(func() { config.File.Admin = admin })
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	const secret = "jwt-secret"
+	hs256 := map[string]string{"alg": "HS256", "typ": "JWT"}
+	now := time.Now().Unix()
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"sub": "alice", "iss": "https://idp.example.com", "aud": "voice-admin", "exp": now + 300, "scope": "read transcripts"}
+		for k, v := range extra {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	keys := []adminAPIKey{
+		{Name: "grafana", Key: "read-key", Scopes: []string{scopeRead}},
+		{Name: "ops", Key: "ops-key", Role: "operator", Tenants: []string{"acme"}},
+	}
+	hsConfig := &adminJWTConfig{Secret: secret, Issuer: "https://idp.example.com", Audience: "voice-admin"}
+	rsConfig := &adminJWTConfig{PublicKey: publicKey}
+
+	tests := []struct {
+		name        string
+		jwt         *adminJWTConfig
+		header      http.Header
+		wantActor   string
+		wantScopes  []string
+		wantTenants []string
+		wantErr     string
+	}{
+		{name: "API key as bearer", header: http.Header{"Authorization": {"Bearer read-key"}}, wantActor: "key:grafana", wantScopes: []string{scopeRead}},
+		{name: "API key header", header: http.Header{"X-Api-Key": {"ops-key"}}, wantActor: "key:ops", wantScopes: roles["operator"], wantTenants: []string{"acme"}},
+		{name: "unknown API key", header: http.Header{"Authorization": {"Bearer nope"}}, wantErr: "unknown API key"},
+		{name: "empty bearer", header: http.Header{"Authorization": {"Bearer "}}, wantErr: "missing bearer token"},
+		{name: "bearer without token", header: http.Header{"Authorization": {"Bearer"}}, wantErr: "missing bearer token"},
+		{name: "other scheme", header: http.Header{"Authorization": {"Basic cmVhZC1rZXk="}}, wantErr: "missing bearer token"},
+		{name: "no header", header: http.Header{}, wantErr: "missing bearer token"},
+
+		{name: "HS256", jwt: hsConfig, header: bearer(signHS256(t, secret, hs256, claims(nil))), wantActor: "jwt:alice", wantScopes: []string{scopeRead, scopeTranscripts}},
+		{name: "HS256 with another secret", jwt: hsConfig, header: bearer(signHS256(t, "other", hs256, claims(nil))), wantErr: "invalid JWT signature"},
+		{name: "RS256", jwt: rsConfig, header: bearer(signRS256(t, key, claims(nil))), wantActor: "jwt:alice", wantScopes: []string{scopeRead, scopeTranscripts}},
+		{
+			// An HS256 token keyed with the RSA public key must not pass
+			// as signed by its private key.
+			name: "HS256 keyed with the RS256 public key", jwt: rsConfig,
+			header:  bearer(signHS256(t, publicKey, hs256, claims(nil))),
+			wantErr: `unsupported JWT algorithm "HS256"`,
+		},
+		{
+			name: "alg none", jwt: hsConfig,
+			header:  bearer(jwtPart(t, map[string]string{"alg": "none"}) + "." + jwtPart(t, claims(nil)) + "."),
+			wantErr: `unsupported JWT algorithm "none"`,
+		},
+		{name: "expired", jwt: hsConfig, header: bearer(signHS256(t, secret, hs256, claims(map[string]interface{}{"exp": now - 1}))), wantErr: "JWT expired"},
+		{name: "no expiry", jwt: hsConfig, header: bearer(signHS256(t, secret, hs256, claims(map[string]interface{}{"exp": nil}))), wantErr: "JWT expired"},
+		{name: "not yet valid", jwt: hsConfig, header: bearer(signHS256(t, secret, hs256, claims(map[string]interface{}{"nbf": now + 60}))), wantErr: "JWT not yet valid"},
+		{name: "wrong issuer", jwt: hsConfig, header: bearer(signHS256(t, secret, hs256, claims(map[string]interface{}{"iss": "https://evil.example.com"}))), wantErr: "unexpected JWT issuer"},
+		{name: "wrong audience", jwt: hsConfig, header: bearer(signHS256(t, secret, hs256, claims(map[string]interface{}{"aud": "other"}))), wantErr: "unexpected JWT audience"},
+		{name: "audience list", jwt: hsConfig, header: bearer(signHS256(t, secret, hs256, claims(map[string]interface{}{"aud": []string{"other", "voice-admin"}}))), wantActor: "jwt:alice", wantScopes: []string{scopeRead, scopeTranscripts}},
+		{
+			name: "roles and tenants claims", jwt: hsConfig,
+			header:    bearer(signHS256(t, secret, hs256, claims(map[string]interface{}{"scope": nil, "scopes": []string{scopeAudit}, "role": "viewer", "tenants": []string{"acme"}}))),
+			wantActor: "jwt:alice", wantScopes: []string{scopeAudit, scopeRead}, wantTenants: []string{"acme"},
+		},
+	}
+	for _, tt := range tests {
+		config.File.Admin = adminAuthConfig{APIKeys: keys, JWT: tt.jwt}
+		id, err := authenticateHeader(tt.header)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %+v, %v; want error %q", tt.name, id, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: got error %v", tt.name, err)
+			continue
+		}
+		if id.Actor != tt.wantActor || !slices.Equal(id.Scopes, tt.wantScopes) || !slices.Equal(id.Tenants, tt.wantTenants) {
+			t.Errorf("%s: got %+v, want actor %s, scopes %v and tenants %v", tt.name, id, tt.wantActor, tt.wantScopes, tt.wantTenants)
+		}
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		granted  []string
+		required string
+		want     bool
+	}{
+		{[]string{scopeRead}, scopeRead, true},
+		{[]string{scopeControl}, scopeRead, true},
+		{[]string{scopeRead}, scopeControl, false},
+		{[]string{scopeControl}, scopeTranscripts, false},
+		{[]string{scopeConfigure}, scopeRead, false},
+		{roles["operator"], scopeListen, true},
+		{roles["viewer"], scopeControl, false},
+		{roleScopes(nil, "admin"), scopeRealtime, true},
+		{roleScopes(nil, "superuser"), scopeRead, false},
+		{nil, scopeRead, false},
+	}
+	for _, tt := range tests {
+		if got := hasScope(tt.granted, tt.required); got != tt.want {
+			t.Errorf("hasScope(%v, %s) = %v, want %v", tt.granted, tt.required, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// autoscaleInterval is how often the per-call resource gauges are sampled.
+const autoscaleInterval = 10 * time.Second
+
+// These gauges are meant to drive autoscaling, such as a Kubernetes HPA on
+// custom metrics: scale on active calls or capacity use, and size instances
+// from the per-call CPU and memory.
+var (
+	callsStartedTotal   = newCounter("calls_started_total", "Calls bridged by this instance since it started.")
+	activeCallsGauge    = newGauge("active_calls", "Calls currently bridged by this instance.")
+	capacityUtilization = newGauge("capacity_utilization", "Active calls as a share of READINESS_MAX_CALLS, or 0 without a limit.")
+	callsPerSecond      = newGauge("calls_per_second", "Calls started per second over the last sampling interval.")
+	cpuPerCall          = newGauge("cpu_cores_per_call", "Process CPU use in cores over the last sampling interval, divided by active calls (the whole process when idle).")
+	memoryPerCall       = newGauge("memory_bytes_per_call", "Memory held by the Go runtime, divided by active calls (the whole process when idle).")
+)
+
+// observeActiveCalls updates the call gauges; it is called whenever a call
+// starts or ends.
+func observeActiveCalls(active int) {
+	activeCallsGauge.set(float64(active))
+	if limit := readinessMaxCalls(); limit > 0 {
+		capacityUtilization.set(float64(active) / float64(limit))
+	}
+}
+
+// readinessMaxCalls is the number of calls after which GET /readyz reports
+// the instance as not ready, so a load balancer sends new calls elsewhere
+// before MAX_CONCURRENT_CALLS starts turning them away.
+func readinessMaxCalls() int {
+	if config.ReadinessMaxCalls > 0 {
+		return config.ReadinessMaxCalls
+	}
+	return config.MaxConcurrentCalls
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	active, limit := activeSessionCount(), readinessMaxCalls()
+	body := map[string]interface{}{"active_calls": active, "max_calls": limit}
+	if limit > 0 && active >= limit {
+		body["status"] = "at capacity"
+		writeJSON(w, http.StatusServiceUnavailable, body)
+		return
+	}
+	body["status"] = "ready"
+	writeJSON(w, http.StatusOK, body)
+}
+
+// processCPUSeconds reads the process's user and system CPU time from
+// /proc, so the CPU gauge is only available on Linux.
+func processCPUSeconds() (float64, error) {
+	data, err := os.ReadFile("/proc/self/stat")
+	if err != nil {
+		return 0, err
+	}
+	// The command name in parentheses may contain spaces; utime and stime
+	// are the 12th and 13th fields after it, in clock ticks of 1/100s.
+	_, after, ok := strings.Cut(string(data), ") ")
+	fields := strings.Fields(after)
+	if !ok || len(fields) < 13 {
+		return 0, fmt.Errorf("unexpected /proc/self/stat format")
+	}
+	utime, err1 := strconv.ParseFloat(fields[11], 64)
+	stime, err2 := strconv.ParseFloat(fields[12], 64)
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("unexpected /proc/self/stat format")
+	}
+	return (utime + stime) / 100, nil
+}
+
+// runtimeMemoryBytes is the memory mapped by the Go runtime, less heap
+// memory it has already returned to the OS.
+func runtimeMemoryBytes() float64 {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	return float64(samples[0].Value.Uint64() - samples[1].Value.Uint64())
+}
+
+// runAutoscaleSampler refreshes the rate and per-call gauges.
+func runAutoscaleSampler() {
+	lastCPU, cpuErr := processCPUSeconds()
+	lastStarts, lastTime := 0.0, time.Now()
+
+	ticker := time.NewTicker(autoscaleInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cpu, err := processCPUSeconds()
+		starts := callsStartedTotal.value()
+		elapsed := time.Since(lastTime).Seconds()
+		active := float64(activeSessionCount())
+
+		callsPerSecond.set((starts - lastStarts) / elapsed)
+		memoryPerCall.set(runtimeMemoryBytes() / max(active, 1))
+		if err == nil && cpuErr == nil {
+			cpuPerCall.set((cpu - lastCPU) / elapsed / max(active, 1))
+		}
+		lastCPU, cpuErr, lastStarts, lastTime = cpu, err, starts, time.Now()
+	}
+}
@@ -0,0 +1,217 @@
+package internal
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// awsCredentialsRefresh is how long before they expire role credentials
+// are fetched again.
+const awsCredentialsRefresh = 5 * time.Minute
+
+// awsCredentials sign requests to AWS.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Region          string
+}
+
+var awsRoleCredentials = struct {
+	sync.Mutex
+	creds   awsCredentials
+	expires time.Time
+}{}
+
+var awsMetadataClient = &http.Client{Timeout: 5 * time.Second}
+
+// loadAWSCredentials returns credentials for region, AWS_REGION when it is
+// empty. They are the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY keys when
+// those are set, and otherwise the IAM role of the pod (AWS_ROLE_ARN and
+// AWS_WEB_IDENTITY_TOKEN_FILE, as set up for EKS service accounts), of the
+// ECS task, or of the EC2 instance. Role credentials are cached until
+// shortly before they expire, so call it for every request.
+func loadAWSCredentials(region string) (awsCredentials, error) {
+	if region == "" {
+		region = getEnv("AWS_REGION", os.Getenv("AWS_DEFAULT_REGION"))
+	}
+	if region == "" {
+		return awsCredentials{}, fmt.Errorf("AWS_REGION must be set")
+	}
+	if os.Getenv("AWS_ACCESS_KEY_ID") != "" || os.Getenv("AWS_SECRET_ACCESS_KEY") != "" {
+		creds := awsCredentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+			Region:          region,
+		}
+		if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+			return creds, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set together")
+		}
+		return creds, nil
+	}
+
+	awsRoleCredentials.Lock()
+	defer awsRoleCredentials.Unlock()
+	if time.Until(awsRoleCredentials.expires) < awsCredentialsRefresh {
+		var creds awsCredentials
+		var expires time.Time
+		var err error
+		switch {
+		case os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE") != "" && os.Getenv("AWS_ROLE_ARN") != "":
+			creds, expires, err = fetchWebIdentityCredentials(region)
+		case os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" || os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "":
+			creds, expires, err = fetchContainerCredentials()
+		default:
+			creds, expires, err = fetchInstanceCredentials()
+		}
+		if err != nil {
+			return awsCredentials{}, fmt.Errorf("error getting AWS role credentials: %v", err)
+		}
+		awsRoleCredentials.creds, awsRoleCredentials.expires = creds, expires
+	}
+	creds := awsRoleCredentials.creds
+	creds.Region = region
+	return creds, nil
+}
+
+// fetchWebIdentityCredentials assumes AWS_ROLE_ARN with the token in
+// AWS_WEB_IDENTITY_TOKEN_FILE. The request needs no signature.
+func fetchWebIdentityCredentials(region string) (awsCredentials, time.Time, error) {
+	token, err := os.ReadFile(os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"))
+	if err != nil {
+		return awsCredentials{}, time.Time{}, fmt.Errorf("error reading web identity token: %v", err)
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {os.Getenv("AWS_ROLE_ARN")},
+		"RoleSessionName":  {getEnv("AWS_ROLE_SESSION_NAME", "twilio-voice-openai")},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	resp, err := awsMetadataClient.PostForm("https://sts."+region+".amazonaws.com/", form)
+	if err != nil {
+		return awsCredentials{}, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return awsCredentials{}, time.Time{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return awsCredentials{}, time.Time{}, fmt.Errorf("STS returned %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	var result struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(data, &result); err != nil {
+		return awsCredentials{}, time.Time{}, fmt.Errorf("error decoding STS response: %v", err)
+	}
+	c := result.Credentials
+	return awsCredentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.SessionToken}, c.Expiration, nil
+}
+
+// fetchContainerCredentials asks the ECS agent for the task role's
+// credentials.
+func fetchContainerCredentials() (awsCredentials, time.Time, error) {
+	u := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		u = "http://169.254.170.2" + relative
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return awsCredentials{}, time.Time{}, err
+	}
+	token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if file := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return awsCredentials{}, time.Time{}, fmt.Errorf("error reading container authorization token: %v", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	return fetchRoleCredentials(req)
+}
+
+// fetchInstanceCredentials reads the instance role's credentials from the
+// EC2 instance metadata service, version 2.
+func fetchInstanceCredentials() (awsCredentials, time.Time, error) {
+	const imds = "http://169.254.169.254/latest"
+	req, err := http.NewRequest(http.MethodPut, imds+"/api/token", nil)
+	if err != nil {
+		return awsCredentials{}, time.Time{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	token, err := awsMetadataGet(req)
+	if err != nil {
+		return awsCredentials{}, time.Time{}, fmt.Errorf("no AWS credentials: AWS_ACCESS_KEY_ID is not set and instance metadata is unavailable: %v", err)
+	}
+
+	req, err = http.NewRequest(http.MethodGet, imds+"/meta-data/iam/security-credentials/", nil)
+	if err != nil {
+		return awsCredentials{}, time.Time{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	role, err := awsMetadataGet(req)
+	if err != nil {
+		return awsCredentials{}, time.Time{}, fmt.Errorf("error reading instance role: %v", err)
+	}
+	role, _, _ = strings.Cut(role, "\n")
+
+	req, err = http.NewRequest(http.MethodGet, imds+"/meta-data/iam/security-credentials/"+role, nil)
+	if err != nil {
+		return awsCredentials{}, time.Time{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	return fetchRoleCredentials(req)
+}
+
+func fetchRoleCredentials(req *http.Request) (awsCredentials, time.Time, error) {
+	data, err := awsMetadataGet(req)
+	if err != nil {
+		return awsCredentials{}, time.Time{}, err
+	}
+	var body struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal([]byte(data), &body); err != nil {
+		return awsCredentials{}, time.Time{}, fmt.Errorf("error decoding role credentials: %v", err)
+	}
+	return awsCredentials{AccessKeyID: body.AccessKeyID, SecretAccessKey: body.SecretAccessKey, SessionToken: body.Token}, body.Expiration, nil
+}
+
+func awsMetadataGet(req *http.Request) (string, error) {
+	resp, err := awsMetadataClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var awsEventsClient = &http.Client{Timeout: 10 * time.Second}
+
+// startAWSEvents publishes call events to the SQS queue at SQS_QUEUE_URL
+// and to the EventBridge bus EVENTBRIDGE_BUS, whichever are set, signed with
+// the AWS credentials.
+func startAWSEvents() error {
+	if config.SQSQueueURL == "" && config.EventBridgeBus == "" {
+		return nil
+	}
+	if _, err := loadAWSCredentials(""); err != nil {
+		return err
+	}
+
+	if config.SQSQueueURL != "" {
+		go runEventSink("sqs", config.AWSEventTypes, publishSQS)
+		log.Printf("Publishing call events to SQS queue %s\n", config.SQSQueueURL)
+	}
+	if config.EventBridgeBus != "" {
+		go runEventSink("eventbridge", config.AWSEventTypes, publishEventBridge)
+		log.Printf("Publishing call events to EventBridge bus %s\n", config.EventBridgeBus)
+	}
+	return nil
+}
+
+// publishSQS sends an event as one message. On a FIFO queue the call's
+// events share a message group, so they are received in order, and the
+// event ID deduplicates retries.
+func publishSQS(event callEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error marshaling event: %v", err)
+	}
+	message := map[string]interface{}{
+		"QueueUrl":    config.SQSQueueURL,
+		"MessageBody": string(body),
+		"MessageAttributes": map[string]interface{}{
+			"type":     map[string]string{"DataType": "String", "StringValue": event.Type},
+			"call_sid": map[string]string{"DataType": "String", "StringValue": orDash(event.Call.CallSid)},
+		},
+	}
+	if strings.HasSuffix(config.SQSQueueURL, ".fifo") {
+		message["MessageGroupId"] = event.Call.ID
+		message["MessageDeduplicationId"] = event.ID
+	}
+	return callAWSJSON("sqs", "AmazonSQS.SendMessage", "application/x-amz-json-1.0", message, nil)
+}
+
+// publishEventBridge puts an event on the bus with the event type as its
+// detail-type, so rules can match on it.
+func publishEventBridge(event callEvent) error {
+	detail, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error marshaling event: %v", err)
+	}
+	entries := map[string]interface{}{
+		"Entries": []map[string]interface{}{{
+			"EventBusName": config.EventBridgeBus,
+			"Source":       config.EventBridgeSource,
+			"DetailType":   event.Type,
+			"Detail":       string(detail),
+			"Time":         event.Time.Unix(),
+		}},
+	}
+	var result struct {
+		FailedEntryCount int `json:"FailedEntryCount"`
+		Entries          []struct {
+			ErrorCode    string `json:"ErrorCode"`
+			ErrorMessage string `json:"ErrorMessage"`
+		} `json:"Entries"`
+	}
+	if err := callAWSJSON("events", "AWSEvents.PutEvents", "application/x-amz-json-1.1", entries, &result); err != nil {
+		return err
+	}
+	if result.FailedEntryCount > 0 && len(result.Entries) > 0 {
+		return fmt.Errorf("%s: %s", result.Entries[0].ErrorCode, result.Entries[0].ErrorMessage)
+	}
+	return nil
+}
+
+// callAWSJSON calls an action of an AWS JSON protocol API and decodes the
+// response into result, if not nil.
+func callAWSJSON(service, target, contentType string, input, result interface{}) error {
+	creds, err := loadAWSCredentials("")
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, "https://"+service+"."+creds.Region+".amazonaws.com/", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Amz-Target", target)
+	signAWSRequest(req, payload, service, creds)
+
+	resp, err := awsEventsClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status code: %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("error decoding response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,566 @@
+package internal
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/shakibhasan09/twilio-voice-openai/internal/realtimetest"
+)
+
+const bridgeTimeout = 5 * time.Second
+
+// The config is read once, since the goroutines of a call that just ended
+// may still read it.
+func TestMain(m *testing.M) {
+	os.Setenv("OPENAI_API_KEY", "sk-test")
+	os.Setenv("SYSTEM_MESSAGE", "You are a test.")
+	os.Setenv("CALL_LOG_DB", "off")
+	readConfig()
+	os.Exit(m.Run())
+}
+
+// bridgeCall is a Twilio media stream connected to the bridge, which is
+// connected to a fake Realtime server.
+type bridgeCall struct {
+	*simulatedCall
+	mock   *realtimetest.Server
+	events chan map[string]interface{}
+	sub    *eventSubscriber
+	ended  *callEnded
+}
+
+// startBridgeCall serves the bridge with OpenAI replaced by a fake running
+// rules, and starts a call on it. The call is ended when the test is done.
+func startBridgeCall(t *testing.T, rules ...realtimetest.Rule) *bridgeCall {
+	t.Helper()
+	call := connectBridge(t, rules...)
+	if err := call.sendStart(); err != nil {
+		t.Fatal(err)
+	}
+	return call
+}
+
+// connectBridge is startBridgeCall without the start event.
+func connectBridge(t *testing.T, rules ...realtimetest.Rule) *bridgeCall {
+	t.Helper()
+	mock := realtimetest.NewServer(rules...)
+	t.Cleanup(mock.Close)
+	config.OpenAIRealtimeURL = mock.WebsocketURL()
+
+	server := httptest.NewServer(newHandler())
+	t.Cleanup(server.Close)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + mediaStreamPath(nil, "+15555550100")
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("error connecting to %s: %v", url, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	call := &bridgeCall{
+		simulatedCall: &simulatedCall{conn: conn, streamSid: "MZ" + randomHex(16), callSid: "CA" + randomHex(16)},
+		mock:          mock,
+		events:        make(chan map[string]interface{}, 1024),
+		sub:           subscribeEvents("test"),
+	}
+	t.Cleanup(func() { call.end(t) })
+	go func() {
+		defer close(call.events)
+		for {
+			var data map[string]interface{}
+			if err := conn.ReadJSON(&data); err != nil {
+				return
+			}
+			call.events <- data
+		}
+	}()
+	return call
+}
+
+// next returns the next message the bridge sends Twilio that is one of
+// events.
+func (c *bridgeCall) next(t *testing.T, events ...string) map[string]interface{} {
+	t.Helper()
+	timeout := time.After(bridgeTimeout)
+	for {
+		select {
+		case data, ok := <-c.events:
+			if !ok {
+				t.Fatalf("stream closed while waiting for %s", strings.Join(events, " or "))
+			}
+			event, _ := data["event"].(string)
+			for _, want := range events {
+				if event == want {
+					return data
+				}
+			}
+		case <-timeout:
+			t.Fatalf("no %s within %s", strings.Join(events, " or "), bridgeTimeout)
+		}
+	}
+}
+
+// end stops the stream and waits for the call to end, returning its
+// call.ended event.
+func (c *bridgeCall) end(t *testing.T) *callEnded {
+	t.Helper()
+	if c.ended != nil {
+		return c.ended
+	}
+	defer unsubscribeEvents(c.sub)
+	if err := c.send(map[string]interface{}{"event": "stop", "streamSid": c.streamSid}); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(bridgeTimeout)
+	for {
+		select {
+		case event := <-c.sub.events:
+			if event.Type == "call.ended" && event.Call.CallSid == c.callSid {
+				c.ended = event.Ended
+				return c.ended
+			}
+		case <-timeout:
+			t.Fatalf("call didn't end within %s", bridgeTimeout)
+		}
+	}
+}
+
+// await waits for the fake to receive n events of a type from the bridge.
+func (c *bridgeCall) await(t *testing.T, eventType string, n int) []realtimetest.Event {
+	t.Helper()
+	deadline := time.Now().Add(bridgeTimeout)
+	for {
+		events := c.mock.ReceivedOfType(eventType)
+		if len(events) >= n {
+			return events
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("OpenAI received %d %s events within %s, want %d", len(events), eventType, bridgeTimeout, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// toolOutput waits for the bridge to send OpenAI the output of a function
+// call.
+func (c *bridgeCall) toolOutput(t *testing.T, callID string) string {
+	t.Helper()
+	deadline := time.Now().Add(bridgeTimeout)
+	for {
+		for _, event := range c.mock.ReceivedOfType("conversation.item.create") {
+			item, _ := event["item"].(map[string]interface{})
+			if item["type"] == "function_call_output" && item["call_id"] == callID {
+				output, _ := item["output"].(string)
+				return output
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no function_call_output for %s within %s", callID, bridgeTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// callTool has the model call a tool and returns the output.
+func (c *bridgeCall) callTool(t *testing.T, callID, name, arguments string) string {
+	t.Helper()
+	c.mock.Broadcast(realtimetest.FunctionCall("resp_"+callID, callID, name, arguments))
+	return c.toolOutput(t, callID)
+}
+
+// awaitTimeline waits for an event in the call's timeline and returns the
+// first one.
+func (c *bridgeCall) awaitTimeline(t *testing.T, event string) timelineEvent {
+	t.Helper()
+	deadline := time.Now().Add(bridgeTimeout)
+	for {
+		if s := lookupSession(c.callSid); s != nil {
+			for _, e := range s.timelineEvents() {
+				if e.Event == event {
+					return e
+				}
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no %s in the timeline within %s", event, bridgeTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func mediaPayload(t *testing.T, data map[string]interface{}) []byte {
+	t.Helper()
+	media, _ := data["media"].(map[string]interface{})
+	payload, _ := media["payload"].(string)
+	audio, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		t.Fatalf("error decoding media payload: %v", err)
+	}
+	return audio
+}
+
+func TestBridgePlaysGreeting(t *testing.T) {
+	greeting := realtimetest.Audio(time.Second)
+	call := startBridgeCall(t, realtimetest.Rule{On: "response.create", Times: 1, Events: realtimetest.AudioResponse("resp_greeting", greeting)})
+
+	call.await(t, "session.update", 1)
+	var played []byte
+	for len(played) < len(greeting) {
+		played = append(played, mediaPayload(t, call.next(t, "media"))...)
+	}
+	if len(played) != len(greeting) {
+		t.Errorf("played %d bytes of greeting, want %d", len(played), len(greeting))
+	}
+	if n := len(call.mock.ReceivedOfType("response.create")); n != 1 {
+		t.Errorf("got %d response.create events, want 1", n)
+	}
+}
+
+func TestBridgeMarksPlayback(t *testing.T) {
+	call := startBridgeCall(t, realtimetest.Rule{On: "response.create", Times: 1, Events: realtimetest.AudioResponse("resp_greeting", realtimetest.Audio(time.Second))})
+
+	// Each chunk of audio is followed by a mark naming the item and how
+	// far into it the chunk ends.
+	sent := 0
+	for sent < 8000 {
+		data := call.next(t, "media", "mark")
+		if data["event"] == "media" {
+			sent += len(mediaPayload(t, data))
+			continue
+		}
+		mark, _ := data["mark"].(map[string]interface{})
+		if want := fmt.Sprintf("item_resp_greeting:%d", sent/8); mark["name"] != want {
+			t.Errorf("mark %v after %d bytes of audio, want %s", mark["name"], sent, want)
+		}
+	}
+	if mark, _ := call.next(t, "mark")["mark"].(map[string]interface{}); mark["name"] != "item_resp_greeting:1000" {
+		t.Errorf("last mark %v, want item_resp_greeting:1000", mark["name"])
+	}
+}
+
+func TestBridgeRunsScriptedFunctionCall(t *testing.T) {
+	call := startBridgeCall(t, realtimetest.Rule{On: "response.create", Times: 1, Events: []realtimetest.Event{
+		realtimetest.FunctionCall("resp_tool", "call_save", saveDataTool.name, `{"key":"customer_id","value":"42"}`),
+	}})
+	if output := call.toolOutput(t, "call_save"); output != "Saved." {
+		t.Errorf("tool output %q, want %q", output, "Saved.")
+	}
+
+	metadata, _ := json.Marshal(call.end(t).Metadata)
+	if got, want := string(metadata), `{"customer_id":"42"}`; got != want {
+		t.Errorf("call metadata %s, want %s", got, want)
+	}
+}
+
+func TestBridgeFillsFormOverSeveralCalls(t *testing.T) {
+	call := startBridgeCall(t)
+	call.await(t, "session.update", 1)
+
+	steps := []struct {
+		arguments string
+		want      []string
+	}{
+		{`{"name":"Ada","email":"ada"}`, []string{"Saved name.", `Not saved, ask the caller again: email "ada": not a valid email address.`, "Next, ask the caller: What email address"}},
+		{`{"email":"Ada@Example.com"}`, []string{"Saved email.", "Read this back to the caller to check it: email: ada@example.com.", "Next, ask the caller: What is the meeting about?"}},
+		{`{"description":"a demo"}`, []string{"All details are in.", "a meeting for Ada (ada@example.com) about a demo"}},
+		// A change with the confirmation needs the changed details
+		// confirmed.
+		{`{"description":"a longer demo","confirmed":true}`, []string{"All details are in.", "about a longer demo"}},
+		{`{"confirmed":true}`, []string{"Your schedule has been set successfully!"}},
+	}
+	for i, step := range steps {
+		output := call.callTool(t, fmt.Sprintf("call_%d", i), scheduleTool.name, step.arguments)
+		for _, want := range step.want {
+			if !strings.Contains(output, want) {
+				t.Errorf("call %d with %s: output %q, want it to contain %q", i, step.arguments, output, want)
+			}
+		}
+	}
+	call.awaitTimeline(t, "form.submitted")
+}
+
+func TestBridgeWithholdsReadBackToolUntilConfirmed(t *testing.T) {
+	tools := config.ReadBackTools
+	t.Cleanup(func() { config.ReadBackTools = tools })
+	config.ReadBackTools = []string{saveDataTool.name}
+
+	call := startBridgeCall(t)
+	update := call.await(t, "session.update", 1)[0]
+	session, _ := update["session"].(map[string]interface{})
+	definitions, _ := json.Marshal(session["tools"])
+	if !strings.Contains(string(definitions), `"confirmed"`) {
+		t.Errorf("session tools %s, want a confirmed parameter", definitions)
+	}
+
+	steps := []struct {
+		arguments string
+		want      string
+	}{
+		// Confirmed is no use before the details have been read back.
+		{`{"key":"email","value":"ada@example.com","confirmed":true}`, "Not done yet. Read these details back to the caller and ask them to confirm: key: email; value: ada@example.com."},
+		{`{"key":"email","value":"ada@example.org","confirmed":true}`, "Not done yet. Read these details back to the caller and ask them to confirm: key: email; value: ada@example.org."},
+		{`{"key":"email","value":"ada@example.org","confirmed":true}`, "Saved."},
+	}
+	for i, step := range steps {
+		if output := call.callTool(t, fmt.Sprintf("call_%d", i), saveDataTool.name, step.arguments); !strings.HasPrefix(output, step.want) {
+			t.Errorf("call %d with %s: output %q, want %q", i, step.arguments, output, step.want)
+		}
+	}
+	metadata, _ := json.Marshal(call.end(t).Metadata)
+	if got, want := string(metadata), `{"email":"ada@example.org"}`; got != want {
+		t.Errorf("call metadata %s, want %s", got, want)
+	}
+}
+
+func TestBridgeConfirmsDestructiveToolAfterCallerTurn(t *testing.T) {
+	tools := config.DestructiveTools
+	t.Cleanup(func() { config.DestructiveTools = tools })
+	config.DestructiveTools = []string{saveDataTool.name}
+
+	const arguments = `{"key":"customer_id","value":"42"`
+	call := startBridgeCall(t, realtimetest.Rule{On: "response.create", Times: 1, Events: []realtimetest.Event{
+		realtimetest.FunctionCall("resp_ask", "call_ask", saveDataTool.name, arguments+"}"),
+	}})
+	tokenPattern := regexp.MustCompile(`confirmation_token "([0-9a-f]+)"`)
+	token := func(callID string) string {
+		t.Helper()
+		output := call.toolOutput(t, callID)
+		match := tokenPattern.FindStringSubmatch(output)
+		if match == nil {
+			t.Fatalf("tool output %q, want a confirmation token", output)
+		}
+		return match[1]
+	}
+
+	// The model can't use the token before the caller has answered.
+	first := token("call_ask")
+	call.mock.Broadcast(realtimetest.FunctionCall("resp_eager", "call_eager", saveDataTool.name, fmt.Sprintf(`%s,"confirmation_token":%q}`, arguments, first)))
+	second := token("call_eager")
+
+	call.mock.Broadcast(realtimetest.SpeechTurn("item_yes")...)
+	call.mock.Broadcast(realtimetest.FunctionCall("resp_confirm", "call_confirm", saveDataTool.name, fmt.Sprintf(`%s,"confirmation_token":%q}`, arguments, second)))
+	if output := call.toolOutput(t, "call_confirm"); output != "Saved." {
+		t.Errorf("confirmed tool output %q, want %q", output, "Saved.")
+	}
+}
+
+func TestBridgeWaitsForApproval(t *testing.T) {
+	tools, keys := config.ApprovalTools, config.File.Admin.APIKeys
+	t.Cleanup(func() { config.ApprovalTools, config.File.Admin.APIKeys = tools, keys })
+	config.ApprovalTools = []string{saveDataTool.name}
+	config.File.Admin.APIKeys = []adminAPIKey{
+		{Name: "acme", Key: "acme-key", Role: "operator", Tenants: []string{"acme"}},
+		{Name: "ops", Key: "ops-key", Role: "operator"},
+	}
+
+	call := startBridgeCall(t, realtimetest.Rule{On: "response.create", Times: 1, Events: []realtimetest.Event{
+		realtimetest.FunctionCall("resp_tool", "call_save", saveDataTool.name, `{"key":"customer_id","value":"42"}`),
+	}})
+	if output := call.toolOutput(t, "call_save"); !strings.HasPrefix(output, "Not done yet") {
+		t.Fatalf("tool output %q, want it to wait for approval", output)
+	}
+
+	list := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/admin/approvals", nil)
+	request.Header.Set("X-API-Key", "ops-key")
+	requireScope(scopeControl, handleAdminListApprovals)(list, request)
+	var body struct{ Approvals []approvalRequest }
+	if err := json.NewDecoder(list.Body).Decode(&body); err != nil || len(body.Approvals) != 1 {
+		t.Fatalf("got approvals %s, want one", list.Body)
+	}
+	id := body.Approvals[0].ID
+
+	decide := func(key string, decision string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/admin/approvals/"+id, strings.NewReader(decision))
+		r.Header.Set("X-API-Key", key)
+		r.SetPathValue("id", id)
+		requireScope(scopeControl, handleAdminDecideApproval)(w, r)
+		return w.Code
+	}
+	// A key for another tenant can't decide, and can't name the approver.
+	if code := decide("acme-key", `{"approved": true}`); code != 404 {
+		t.Errorf("decision by another tenant's key got status %d, want 404", code)
+	}
+	if code := decide("ops-key", `{"approved": true, "approver": "someone else"}`); code != 200 {
+		t.Errorf("decision got status %d, want 200", code)
+	}
+
+	if event := call.awaitTimeline(t, "tool.approved"); event.Detail != "save_call_data by key:ops" {
+		t.Errorf("timeline has %s %q, want it approved by key:ops", event.Event, event.Detail)
+	}
+	metadata, _ := json.Marshal(call.end(t).Metadata)
+	if got, want := string(metadata), `{"customer_id":"42"}`; got != want {
+		t.Errorf("call metadata %s, want %s", got, want)
+	}
+}
+
+func TestBridgeConfirmsToolOutputOutOfBand(t *testing.T) {
+	tools := config.ApprovalTools
+	t.Cleanup(func() { config.ApprovalTools = tools })
+	config.ApprovalTools = []string{saveDataTool.name}
+
+	call := startBridgeCall(t, realtimetest.Rule{On: "response.create", Times: 1, Events: []realtimetest.Event{
+		realtimetest.FunctionCall("resp_tool", "call_save", saveDataTool.name, `{"key":"customer_id","value":"42"}`),
+	}})
+	call.toolOutput(t, "call_save")
+	outOfBand := func(event realtimetest.Event) bool {
+		response, _ := event["response"].(map[string]interface{})
+		metadata, _ := response["metadata"].(map[string]interface{})
+		return response["conversation"] == "none" && response["tool_choice"] == "none" && metadata["purpose"] == toolConfirmation
+	}
+	if confirm := call.await(t, "response.create", 2)[1]; !outOfBand(confirm) {
+		t.Errorf("tool output confirmed with %v, want an out-of-band response", confirm)
+	}
+
+	// An outcome that comes in while the model is talking waits for it to
+	// finish. A rejection is told even for a silent tool.
+	call.mock.Broadcast(realtimetest.Event{"type": "response.created", "response": map[string]interface{}{"id": "resp_talk", "status": "in_progress"}})
+	call.awaitTimeline(t, "response.start")
+	decideApproval(listApprovals(adminIdentity{})[0].ID, adminIdentity{Actor: "test"}, approvalDecision{Approved: false})
+	time.Sleep(200 * time.Millisecond)
+	if n := len(call.mock.ReceivedOfType("response.create")); n != 2 {
+		t.Fatalf("got %d response.create events while the model was talking, want 2", n)
+	}
+	call.mock.Broadcast(realtimetest.Event{"type": "response.done", "response": map[string]interface{}{"id": "resp_talk", "status": "completed"}})
+	if confirm := call.await(t, "response.create", 3)[2]; !outOfBand(confirm) {
+		t.Errorf("rejection confirmed with %v, want an out-of-band response", confirm)
+	}
+}
+
+func TestBridgeTruncatesInterruptedReply(t *testing.T) {
+	call := startBridgeCall(t, realtimetest.Rule{On: "response.create", Times: 1, Events: realtimetest.AudioResponse("resp_greeting", realtimetest.Audio(5*time.Second))})
+
+	call.next(t, "media")
+	call.mock.Broadcast(realtimetest.Event{"type": "input_audio_buffer.speech_started", "item_id": "item_caller"})
+
+	call.next(t, "clear")
+	truncate := call.await(t, "conversation.item.truncate", 1)[0]
+	if truncate["item_id"] != "item_resp_greeting" {
+		t.Errorf("truncated item %v, want item_resp_greeting", truncate["item_id"])
+	}
+	if played, _ := truncate["audio_end_ms"].(float64); played >= 5000 {
+		t.Errorf("truncated at %vms, want less than the 5000ms sent", played)
+	}
+}
+
+func TestBridgeTruncatesFromLastMark(t *testing.T) {
+	call := startBridgeCall(t, realtimetest.Rule{On: "response.create", Times: 1, Events: realtimetest.AudioResponse("resp_greeting", realtimetest.Audio(5*time.Second))})
+
+	// The whole reply is sent at once, but Twilio says only 2s has played.
+	for {
+		mark, _ := call.next(t, "mark")["mark"].(map[string]interface{})
+		if mark["name"] == "item_resp_greeting:2000" {
+			break
+		}
+	}
+	if err := call.send(map[string]interface{}{"event": "mark", "streamSid": call.streamSid, "mark": map[string]string{"name": "item_resp_greeting:2000"}}); err != nil {
+		t.Fatal(err)
+	}
+	const sinceMark = 200 * time.Millisecond
+	time.Sleep(sinceMark)
+	call.mock.Broadcast(realtimetest.Event{"type": "input_audio_buffer.speech_started", "item_id": "item_caller"})
+
+	// The caller heard up to the mark and what played since.
+	truncate := call.await(t, "conversation.item.truncate", 1)[0]
+	if played, _ := truncate["audio_end_ms"].(float64); played < 2000+float64(sinceMark.Milliseconds())/2 || played >= 3000 {
+		t.Errorf("truncated at %vms, want about %dms", played, 2000+sinceMark.Milliseconds())
+	}
+}
+
+// toneShare is how much of the power of 16-bit PCM is in a tone of freq,
+// by the Goertzel algorithm.
+func toneShare(pcm []byte, rate, freq float64) float64 {
+	coeff := 2 * math.Cos(2*math.Pi*freq/rate)
+	var s1, s2, total float64
+	n := len(pcm) / 2
+	for i := 0; i < n; i++ {
+		x := float64(int16(binary.LittleEndian.Uint16(pcm[i*2:])))
+		s1, s2 = x+coeff*s1-s2, s1
+		total += x * x
+	}
+	if total == 0 {
+		return 0
+	}
+	power := s1*s1 + s2*s2 - coeff*s1*s2
+	return 2 * power / float64(n) / total
+}
+
+func tone(rate, freq float64, d time.Duration) []byte {
+	n := int(rate * d.Seconds())
+	pcm := make([]byte, 0, n*2)
+	for i := 0; i < n; i++ {
+		pcm = binary.LittleEndian.AppendUint16(pcm, uint16(int16(8000*math.Sin(2*math.Pi*freq*float64(i)/rate))))
+	}
+	return pcm
+}
+
+func TestBridgeCarriesWidebandAudio(t *testing.T) {
+	format := config.OpenAIAudioFormat
+	t.Cleanup(func() { config.OpenAIAudioFormat = format })
+	config.OpenAIAudioFormat = "pcm16"
+
+	// 6kHz is more than 8kHz audio can carry.
+	const freq = 6000
+	call := connectBridge(t, realtimetest.Rule{On: "response.create", Times: 1, Events: realtimetest.AudioResponse("resp_greeting", tone(openAISampleRate, freq, time.Second))})
+	if err := call.send(map[string]interface{}{"event": "connected", "protocol": "Call", "version": "1.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+	err := call.send(map[string]interface{}{
+		"event":     "start",
+		"streamSid": call.streamSid,
+		"start": map[string]interface{}{
+			"streamSid":   call.streamSid,
+			"callSid":     call.callSid,
+			"tracks":      []string{"inbound"},
+			"mediaFormat": map[string]interface{}{"encoding": "audio/G722", "sampleRate": g722SampleRate, "channels": 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The greeting reaches the caller with its full band.
+	decoder := newG722Decoder()
+	var played []byte
+	for len(played) < len(tone(g722SampleRate, freq, time.Second)) {
+		played = append(played, decoder.decode(mediaPayload(t, call.next(t, "media")))...)
+	}
+	if share := toneShare(played, g722SampleRate, freq); share < 0.5 {
+		t.Errorf("%.2f of the greeting's power is at %dHz, want most of it", share, freq)
+	}
+
+	// So does the caller's audio to OpenAI.
+	encoder := newG722Encoder()
+	question := tone(g722SampleRate, freq, time.Second)
+	for offset := 0; offset < len(question); offset += 20 * g722SampleRate / 1000 * 2 {
+		if err := call.sendMedia(encoder.encode(question[offset : offset+20*g722SampleRate/1000*2])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var sent []byte
+	deadline := time.Now().Add(bridgeTimeout)
+	for len(sent) < len(tone(openAISampleRate, freq, 900*time.Millisecond)) {
+		if time.Now().After(deadline) {
+			t.Fatalf("OpenAI received %d bytes of audio within %s", len(sent), bridgeTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+		sent = nil
+		for _, event := range call.mock.ReceivedOfType("input_audio_buffer.append") {
+			audio, _ := base64.StdEncoding.DecodeString(event["audio"].(string))
+			sent = append(sent, audio...)
+		}
+	}
+	if share := toneShare(sent, openAISampleRate, freq); share < 0.5 {
+		t.Errorf("%.2f of the power of the audio sent to OpenAI is at %dHz, want most of it", share, freq)
+	}
+}
@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const callbackCheckInterval = 30 * time.Second
+
+// callbackJob is a caller who reached us at capacity and asked to be called
+// back. Jobs are kept in memory and are lost on restart.
+type callbackJob struct {
+	ID          string    `json:"id"`
+	PhoneNumber string    `json:"phone_number"`
+	CallerID    string    `json:"caller_id"`
+	DueAt       time.Time `json:"due_at"`
+	Attempts    int       `json:"attempts"`
+
+	baseURL string
+}
+
+var callbacks = struct {
+	sync.Mutex
+	jobs []*callbackJob
+}{}
+
+func activeSessionCount() int {
+	sessions.Lock()
+	defer sessions.Unlock()
+	return len(sessions.active)
+}
+
+func atCapacity() bool {
+	return config.MaxConcurrentCalls > 0 && activeSessionCount() >= config.MaxConcurrentCalls
+}
+
+// writeBusyTwiML answers a call that can't be taken, offering a callback
+// when CALLBACK_ENABLED is set and offerCallback is true.
+func writeBusyTwiML(w http.ResponseWriter, tenant *tenantConfig, offerCallback bool) {
+	message := errorMessage(tenant, "over_capacity", errorMessageData{})
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><Response>`)
+	if config.CallbackEnabled && offerCallback {
+		writeCallbackGather(&b, message, "/callback-request")
+	}
+	b.WriteString("<Say>")
+	xml.EscapeText(&b, []byte(message+" Please try again later. Goodbye."))
+	b.WriteString("</Say><Hangup/></Response>")
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write(b.Bytes())
+}
+
+// writeCallbackGather writes the Gather that says message and asks the
+// caller when to be called back, posting their answer to action.
+func writeCallbackGather(b *bytes.Buffer, message, action string) {
+	b.WriteString(`<Gather input="dtmf" finishOnKey="#" timeout="10" action="`)
+	xml.EscapeText(b, []byte(action))
+	b.WriteString(`" method="POST"><Say>`)
+	xml.EscapeText(b, []byte(strings.TrimSpace(message+" To get a call back, enter the number of hours from now that suits you, then press pound. Enter zero to be called as soon as a line is free.")))
+	b.WriteString(`</Say></Gather>`)
+}
+
+// handleCallbackRequest receives the Gather result from writeBusyTwiML and
+// schedules the callback.
+func handleCallbackRequest(w http.ResponseWriter, r *http.Request) {
+	hours, err := strconv.Atoi(r.FormValue("Digits"))
+	message := "Sorry, I didn't get that. Please try again later. Goodbye."
+	if err == nil && hours >= 0 && hours <= 72 {
+		job := &callbackJob{
+			ID:          randomHex(8),
+			PhoneNumber: r.FormValue("From"),
+			CallerID:    r.FormValue("To"),
+			DueAt:       time.Now().Add(time.Duration(hours) * time.Hour),
+			baseURL:     externalURL(r),
+		}
+		callbacks.Lock()
+		callbacks.jobs = append(callbacks.jobs, job)
+		callbacks.Unlock()
+		log.Printf("Scheduled callback %s to %s at %s\n", job.ID, job.PhoneNumber, job.DueAt.Format(time.RFC3339))
+
+		message = "Thanks, we'll call you back as soon as a line is free. Goodbye."
+		if hours > 0 {
+			message = fmt.Sprintf("Thanks, we'll call you back in about %d hours. Goodbye.", hours)
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><Response><Say>`)
+	xml.EscapeText(&b, []byte(message))
+	b.WriteString("</Say><Hangup/></Response>")
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write(b.Bytes())
+}
+
+// runCallbacks places due callbacks whenever there is spare capacity. A
+// failed call is retried on later checks, up to three attempts.
+func runCallbacks() {
+	for range time.Tick(callbackCheckInterval) {
+		for _, job := range dueCallbacks() {
+			if err := placeCallback(job); err != nil {
+				log.Printf("Error placing callback %s: %v\n", job.ID, err)
+				job.Attempts++
+				if job.Attempts < 3 {
+					callbacks.Lock()
+					callbacks.jobs = append(callbacks.jobs, job)
+					callbacks.Unlock()
+				}
+			}
+		}
+	}
+}
+
+// dueCallbacks takes the callbacks that are due, as many as there are free
+// lines for.
+func dueCallbacks() []*callbackJob {
+	callbacks.Lock()
+	defer callbacks.Unlock()
+
+	free := len(callbacks.jobs)
+	if config.MaxConcurrentCalls > 0 {
+		free = config.MaxConcurrentCalls - activeSessionCount()
+	}
+
+	var due, pending []*callbackJob
+	for _, job := range callbacks.jobs {
+		if time.Now().After(job.DueAt) && len(due) < free && !tenantForNumber(job.CallerID).atCapacity() {
+			due = append(due, job)
+		} else {
+			pending = append(pending, job)
+		}
+	}
+	callbacks.jobs = pending
+	return due
+}
+
+func placeCallback(job *callbackJob) error {
+	_, placed, err := placeLockedCall(tenantForNumber(job.CallerID), job.PhoneNumber, job.CallerID, job.baseURL)
+	if err != nil {
+		return err
+	}
+	if !placed {
+		log.Printf("Postponing callback %s: %s is on a call\n", job.ID, job.PhoneNumber)
+		job.DueAt = time.Now().Add(time.Minute)
+		callbacks.Lock()
+		callbacks.jobs = append(callbacks.jobs, job)
+		callbacks.Unlock()
+		return nil
+	}
+	log.Printf("Placed callback %s to %s\n", job.ID, job.PhoneNumber)
+	return nil
+}
+
+func listCallbacks() []callbackJob {
+	callbacks.Lock()
+	defer callbacks.Unlock()
+
+	list := make([]callbackJob, 0, len(callbacks.jobs))
+	for _, job := range callbacks.jobs {
+		list = append(list, *job)
+	}
+	return list
+}
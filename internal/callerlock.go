@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"log"
+	"time"
+)
+
+var callerLockRejectionsTotal = newCounter("caller_lock_rejections_total", "Calls not put through because the caller was already on a call.", "direction")
+
+// callerLockKey is the state store key of the lock that CALLER_LOCK puts
+// on a call: per caller number with "number", per tenant with "tenant".
+// It is empty when calls aren't locked.
+func callerLockKey(tenant *tenantConfig, number string) string {
+	switch config.CallerLock {
+	case "number":
+		return "lock:number:" + number
+	case "tenant":
+		return "lock:tenant:" + tenant.id()
+	default:
+		return ""
+	}
+}
+
+// lockCaller takes the lock for a call with the given CallSid and reports
+// whether the call may go ahead. A lock the call already holds, as an
+// outbound call does when Twilio fetches its TwiML, counts as taken. When
+// the state store fails, calls go ahead.
+func lockCaller(key, callSid string) bool {
+	if key == "" {
+		return true
+	}
+	ok, err := callState.setNX(key, []byte(callSid), config.CallerLockTTL)
+	if err == nil && !ok {
+		ok, err = callState.expireIf(key, []byte(callSid), config.CallerLockTTL)
+	}
+	if err != nil {
+		log.Println("Error locking caller:", err)
+		return true
+	}
+	return ok
+}
+
+// claimCallerLock takes the lock for a call we placed, which
+// placeLockedCall holds under placeholder until it learns the CallSid.
+// Twilio can fetch the call's TwiML before then, so the call takes over
+// the placeholder itself.
+func claimCallerLock(key, callSid, placeholder string) bool {
+	if key == "" || placeholder == "" {
+		return lockCaller(key, callSid)
+	}
+	ok, err := callState.replaceIf(key, []byte(placeholder), []byte(callSid), config.CallerLockTTL)
+	if err != nil {
+		log.Println("Error locking caller:", err)
+		return true
+	}
+	return ok || lockCaller(key, callSid)
+}
+
+func unlockCaller(key, callSid string) {
+	if key == "" {
+		return
+	}
+	if err := callState.deleteIf(key, []byte(callSid)); err != nil {
+		log.Println("Error unlocking caller:", err)
+	}
+}
+
+// placeLockedCall places an outbound call under the callee's lock. It
+// reports false without placing the call while the callee is on another.
+func placeLockedCall(tenant *tenantConfig, to, from, baseURL string) (string, bool, error) {
+	key := callerLockKey(tenant, to)
+	// The lock is held under a placeholder until Twilio returns the CallSid.
+	// The placeholder goes in the call's TwiML URL, for claimCallerLock.
+	token := "placing-" + randomHex(8)
+	if !lockCaller(key, token) {
+		callerLockRejectionsTotal.add(1, "outbound")
+		return "", false, nil
+	}
+	callSid, err := placeOutboundCall(to, from, baseURL, token)
+	if err != nil {
+		unlockCaller(key, token)
+		return "", true, err
+	}
+	// Only a placeholder this call still holds is replaced. If the call's
+	// TwiML was fetched first, it has claimed the lock already.
+	if key != "" && !claimCallerLock(key, callSid, token) {
+		log.Printf("Lost the lock on %s while placing call %s\n", to, callSid)
+	}
+	return callSid, true, nil
+}
+
+// holdCallerLock keeps the call's lock from expiring until the call ends,
+// then releases it.
+func (s *callSession) holdCallerLock() {
+	key, callSid := callerLockKey(s.tenant, s.phoneNumber), s.callSid()
+	if key == "" || callSid == "" {
+		return
+	}
+	ticker := time.NewTicker(config.CallerLockTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			unlockCaller(key, callSid)
+			return
+		case <-ticker.C:
+			lockCaller(key, callSid)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v28.3.0
+// source: call_events.proto
+
+package callevents
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	CallEvents_StreamEvents_FullMethodName = "/twiliovoiceopenai.callevents.v1.CallEvents/StreamEvents"
+)
+
+// CallEventsClient is the client API for CallEvents service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// CallEvents streams what happens on calls as it happens: calls starting
+// and ending, their timeline, and transcripts as they are spoken.
+type CallEventsClient interface {
+	// StreamEvents sends matching events until the client cancels. Events
+	// from before the stream opened are not replayed, and a client that
+	// can't keep up misses events rather than slowing calls down.
+	StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[CallEvent], error)
+}
+
+type callEventsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCallEventsClient(cc grpc.ClientConnInterface) CallEventsClient {
+	return &callEventsClient{cc}
+}
+
+func (c *callEventsClient) StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[CallEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &CallEvents_ServiceDesc.Streams[0], CallEvents_StreamEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamEventsRequest, CallEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CallEvents_StreamEventsClient = grpc.ServerStreamingClient[CallEvent]
+
+// CallEventsServer is the server API for CallEvents service.
+// All implementations must embed UnimplementedCallEventsServer
+// for forward compatibility.
+//
+// CallEvents streams what happens on calls as it happens: calls starting
+// and ending, their timeline, and transcripts as they are spoken.
+type CallEventsServer interface {
+	// StreamEvents sends matching events until the client cancels. Events
+	// from before the stream opened are not replayed, and a client that
+	// can't keep up misses events rather than slowing calls down.
+	StreamEvents(*StreamEventsRequest, grpc.ServerStreamingServer[CallEvent]) error
+	mustEmbedUnimplementedCallEventsServer()
+}
+
+// UnimplementedCallEventsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedCallEventsServer struct{}
+
+func (UnimplementedCallEventsServer) StreamEvents(*StreamEventsRequest, grpc.ServerStreamingServer[CallEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamEvents not implemented")
+}
+func (UnimplementedCallEventsServer) mustEmbedUnimplementedCallEventsServer() {}
+func (UnimplementedCallEventsServer) testEmbeddedByValue()                    {}
+
+// UnsafeCallEventsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CallEventsServer will
+// result in compilation errors.
+type UnsafeCallEventsServer interface {
+	mustEmbedUnimplementedCallEventsServer()
+}
+
+func RegisterCallEventsServer(s grpc.ServiceRegistrar, srv CallEventsServer) {
+	// If the following call pancis, it indicates UnimplementedCallEventsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&CallEvents_ServiceDesc, srv)
+}
+
+func _CallEvents_StreamEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CallEventsServer).StreamEvents(m, &grpc.GenericServerStream[StreamEventsRequest, CallEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CallEvents_StreamEventsServer = grpc.ServerStreamingServer[CallEvent]
+
+// CallEvents_ServiceDesc is the grpc.ServiceDesc for CallEvents service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var CallEvents_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "twiliovoiceopenai.callevents.v1.CallEvents",
+	HandlerType: (*CallEventsServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamEvents",
+			Handler:       _CallEvents_StreamEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "call_events.proto",
+}
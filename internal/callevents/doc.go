@@ -0,0 +1,5 @@
+// Package callevents is the gRPC API for streaming call events, generated
+// from call_events.proto.
+package callevents
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative call_events.proto
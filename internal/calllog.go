@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// The call log keeps one entry per finished call in storage, so even small
+// deployments have call history; with the default sqlite backend that is a
+// local database, CALL_LOG_DB (default calls.db, "off" to disable). The
+// summary and timeline are kept as JSON next to the fields used for
+// listing.
+
+// callLogEntry is a finished call in the call log.
+type callLogEntry struct {
+	ID              string                 `json:"id"`
+	CallSid         string                 `json:"call_sid"`
+	PhoneNumber     string                 `json:"phone_number"`
+	Line            string                 `json:"line,omitempty"`
+	Tenant          string                 `json:"tenant,omitempty"`
+	Model           string                 `json:"model"`
+	Status          string                 `json:"status,omitempty"`
+	StartedAt       time.Time              `json:"started_at"`
+	EndedAt         time.Time              `json:"ended_at"`
+	DurationSeconds float64                `json:"duration_seconds"`
+	InputTokens     int64                  `json:"input_tokens"`
+	OutputTokens    int64                  `json:"output_tokens"`
+	ToolCalls       int                    `json:"tool_calls"`
+	EstimatedCost   float64                `json:"estimated_cost"`
+	Summary         map[string]interface{} `json:"summary,omitempty"`
+	Timeline        []timelineEvent        `json:"timeline,omitempty"`
+}
+
+// callLogFilter selects calls by tenant, phone number and start time; empty
+// fields match everything. Limit caps the number of calls, newest first.
+type callLogFilter struct {
+	Tenant      string
+	PhoneNumber string
+	Since       time.Time
+	Limit       int
+}
+
+func (f callLogFilter) matches(entry callLogEntry) bool {
+	return (f.Tenant == "" || entry.Tenant == f.Tenant) && (f.PhoneNumber == "" || entry.PhoneNumber == f.PhoneNumber) &&
+		!entry.StartedAt.Before(f.Since)
+}
+
+// logEntry is the call log entry of a finished call.
+func (s *callSession) logEntry() callLogEntry {
+	s.mu.Lock()
+	entry := callLogEntry{
+		ID:              s.id,
+		CallSid:         s.call,
+		PhoneNumber:     s.phoneNumber,
+		Line:            s.line,
+		Tenant:          s.tenant.id(),
+		Model:           s.model,
+		Status:          s.status,
+		StartedAt:       s.startedAt.UTC(),
+		EndedAt:         s.endedAt.UTC(),
+		DurationSeconds: s.endedAt.Sub(s.startedAt).Seconds(),
+		InputTokens:     s.tokens.inputTokens(),
+		OutputTokens:    s.tokens.outputTokens(),
+		ToolCalls:       s.toolCalls,
+		EstimatedCost:   estimateCost(s.tokens, s.endedAt.Sub(s.startedAt)),
+	}
+	s.mu.Unlock()
+	entry.Summary = s.summary()
+	entry.Timeline = s.timelineEvents()
+	return entry
+}
+
+// logCall writes a finished call to the call log.
+func (s *callSession) logCall(entry callLogEntry) {
+	if storage == nil {
+		return
+	}
+	if err := storage.SaveCall(entry); err != nil {
+		log.Println("Error writing call log:", err)
+	}
+}
+
+// callLogJSON returns the summary and timeline columns of an entry.
+func callLogJSON(entry callLogEntry) (summary, timeline []byte, err error) {
+	if summary, err = json.Marshal(entry.Summary); err != nil {
+		return nil, nil, err
+	}
+	if timeline, err = json.Marshal(entry.Timeline); err != nil {
+		return nil, nil, err
+	}
+	return summary, timeline, nil
+}
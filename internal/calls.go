@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+type CallsOptions struct {
+	Tenant      string
+	PhoneNumber string
+	Since       string
+	Limit       int
+	Format      string
+}
+
+func openCallLogForCLI() (Storage, error) {
+	readConfig()
+	if config.StorageBackend == "sqlite" {
+		if !callLogEnabled() {
+			return nil, fmt.Errorf("the call log is disabled, CALL_LOG_DB is off")
+		}
+		// Don't create an empty database just to report no calls.
+		if _, err := os.Stat(config.CallLogDB); err != nil {
+			return nil, fmt.Errorf("no call log at %s", config.CallLogDB)
+		}
+	}
+	return openStorage()
+}
+
+// ListCalls prints the most recent calls from the call log.
+func ListCalls(opts CallsOptions) error {
+	filter := callLogFilter{Tenant: opts.Tenant, PhoneNumber: opts.PhoneNumber, Limit: opts.Limit}
+	if opts.Since != "" {
+		since, err := time.Parse(time.DateOnly, opts.Since)
+		if err != nil {
+			return fmt.Errorf("invalid date %q, expected YYYY-MM-DD", opts.Since)
+		}
+		filter.Since = since
+	}
+
+	store, err := openCallLogForCLI()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	entries, err := store.ListCalls(filter)
+	if err != nil {
+		return err
+	}
+
+	switch opts.Format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]interface{}{"calls": entries})
+	case "", "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "Started (UTC)\tCallSid\tNumber\tTenant\tDuration\tStatus\tTool calls\tEst. cost")
+		for _, entry := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%.2f\n",
+				entry.StartedAt.Format(time.DateTime), orDash(entry.CallSid), orDash(entry.PhoneNumber), orDash(entry.Tenant),
+				time.Duration(entry.DurationSeconds*float64(time.Second)).Round(time.Second), orDash(entry.Status),
+				entry.ToolCalls, entry.EstimatedCost)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown format %q, expected table or json", opts.Format)
+	}
+}
+
+// ShowCall prints one call from the call log, found by CallSid or call ID,
+// with its timeline.
+func ShowCall(id, format string) error {
+	store, err := openCallLogForCLI()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	entry, err := store.GetCall(id)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return fmt.Errorf("no call %s in the call log", id)
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entry)
+	case "", "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "Call ID\t%s\n", entry.ID)
+		fmt.Fprintf(w, "CallSid\t%s\n", orDash(entry.CallSid))
+		fmt.Fprintf(w, "Number\t%s\n", orDash(entry.PhoneNumber))
+		fmt.Fprintf(w, "Line\t%s\n", orDash(entry.Line))
+		fmt.Fprintf(w, "Tenant\t%s\n", orDash(entry.Tenant))
+		fmt.Fprintf(w, "Model\t%s\n", entry.Model)
+		fmt.Fprintf(w, "Status\t%s\n", orDash(entry.Status))
+		fmt.Fprintf(w, "Started (UTC)\t%s\n", entry.StartedAt.Format(time.DateTime))
+		fmt.Fprintf(w, "Duration\t%s\n", time.Duration(entry.DurationSeconds*float64(time.Second)).Round(time.Second))
+		fmt.Fprintf(w, "Tokens\t%d in, %d out\n", entry.InputTokens, entry.OutputTokens)
+		fmt.Fprintf(w, "Tool calls\t%d\n", entry.ToolCalls)
+		fmt.Fprintf(w, "Est. cost\t%.2f\n", entry.EstimatedCost)
+		if analysis := storedAnalysis(*entry); analysis != nil {
+			fmt.Fprintf(w, "Sentiment\t%s\n", analysis.Sentiment)
+			fmt.Fprintf(w, "Synopsis\t%s\n", analysis.Synopsis)
+		}
+		fmt.Fprintln(w)
+		for _, event := range entry.Timeline {
+			fmt.Fprintf(w, "+%.1fs\t%s\t%s\n", float64(event.OffsetMs)/1000, event.Event, event.Detail)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown format %q, expected table or json", format)
+	}
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+const pendingCallStatusTTL = time.Hour
+
+var terminalCallStatuses = map[string]bool{
+	"completed": true,
+	"busy":      true,
+	"failed":    true,
+	"no-answer": true,
+	"canceled":  true,
+}
+
+// pendingCallStatus is a status callback for a call whose media stream
+// hasn't started. They are kept in the state store under "status:" and the
+// CallSid, and merged into the session's timeline when the stream starts,
+// or closed out as a call record of their own if the call ends first.
+type pendingCallStatus struct {
+	From   string    `json:"from"`
+	Status string    `json:"status"`
+	Time   time.Time `json:"time"`
+}
+
+func handleCallStatus(w http.ResponseWriter, r *http.Request) {
+	callSid, status := r.FormValue("CallSid"), r.FormValue("CallStatus")
+	if callSid == "" || status == "" {
+		http.Error(w, "missing CallSid or CallStatus", http.StatusBadRequest)
+		return
+	}
+	terminal := terminalCallStatuses[status]
+
+	if s := lookupSession(callSid); s != nil {
+		// A completed call whose stream is still open has lost its media
+		// connection without a stop event; close it out.
+		s.setStatus(status)
+		if terminal && s.active() {
+			s.hangup()
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if live := callSnapshotFor(callSid); live != nil {
+		// The stream is on another instance, which closes the call out
+		// itself.
+		log.Printf("Call %s is on %s (pid %d), ignoring status %s\n", callSid, live.Host, live.PID, status)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := pushState("status:"+callSid, pendingCallStatus{From: r.FormValue("From"), Status: status, Time: time.Now()}, pendingCallStatusTTL); err != nil {
+		log.Println("Error saving call status:", err)
+	}
+	if terminal {
+		number := r.FormValue("From")
+		if r.FormValue("Direction") == "outbound-api" {
+			number = r.FormValue("To")
+		}
+		unlockCaller(callerLockKey(tenantForCall(r), number), callSid)
+		if from, timeline := takePendingCallStatuses(callSid); len(timeline) > 0 {
+			log.Printf("Call %s ended with status %s before its media stream started\n", callSid, status)
+			closeCallRecord(callSid, status, from, timeline)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// takePendingCallStatuses returns the caller and the status callbacks of a
+// call whose media stream is starting or that ended before it did.
+func takePendingCallStatuses(callSid string) (string, []timelineEvent) {
+	values, err := callState.takeList("status:" + callSid)
+	if err != nil {
+		log.Println("Error reading call statuses:", err)
+		return "", nil
+	}
+	var from string
+	var timeline []timelineEvent
+	for _, value := range values {
+		var status pendingCallStatus
+		if _, err := decodeState(value, nil, &status); err != nil {
+			log.Println("Error reading call status:", err)
+			continue
+		}
+		from = status.From
+		timeline = append(timeline, timelineEvent{Time: status.Time, Event: "call.status", Detail: status.Status})
+	}
+	return from, timeline
+}
+
+// closeCallRecord files a call that never got a media stream alongside the
+// ended sessions, so it still shows up in the admin API and webhooks.
+func closeCallRecord(callSid, status, from string, timeline []timelineEvent) {
+	startedAt := timeline[0].Time
+	s := &callSession{
+		id:          randomHex(8),
+		phoneNumber: from,
+		startedAt:   startedAt,
+		done:        make(chan struct{}),
+		call:        callSid,
+		status:      status,
+		endedAt:     time.Now(),
+	}
+	s.hangupOnce.Do(func() { close(s.done) })
+	for _, event := range timeline {
+		event.OffsetMs = event.Time.Sub(startedAt).Milliseconds()
+		s.timeline = append(s.timeline, event)
+	}
+	s.record("call.end", status)
+
+	archiveSession(s)
+	go func() {
+		if err := deliverWebhook("call.ended", s.summary(), s.summary()); err != nil {
+			log.Println("Error delivering call.ended webhook:", err)
+		}
+	}()
+}
+
+func (s *callSession) setStatus(status string) {
+	s.mu.Lock()
+	s.status = status
+	s.mu.Unlock()
+	s.record("call.status", status)
+	s.saveSnapshot()
+}
@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+type ChatOptions struct {
+	From             string
+	InstructionsPath string
+}
+
+// Chat runs the assistant as a text conversation in the terminal. It uses
+// the same instructions, greeting and tools as a phone call, and tool calls
+// run for real, so webhooks fire just as they would on a call.
+func Chat(opts ChatOptions) error {
+	loadConfig()
+
+	instructions := config.SystemMessage
+	if opts.InstructionsPath != "" {
+		data, err := os.ReadFile(opts.InstructionsPath)
+		if err != nil {
+			return fmt.Errorf("error reading instructions: %v", err)
+		}
+		instructions = string(data)
+	}
+
+	conn, model, err := dialOpenAI(nil)
+	if err != nil {
+		return fmt.Errorf("error connecting to OpenAI WebSocket: %v", err)
+	}
+	defer conn.Close()
+
+	// With no Twilio stream the session runs like a SIP sideband: tools that
+	// need call audio, such as hold, refuse to run.
+	s := newCallSession(opts.From, model, nil, nil, conn)
+	defer s.hangup()
+
+	session := sessionConfig(instructions)
+	session["modalities"] = []string{"text"}
+	session["turn_detection"] = nil
+	delete(session, "input_audio_format")
+	delete(session, "output_audio_format")
+	delete(session, "input_audio_transcription")
+	messages := append(greetingMessages(model, session, config.XMLResponse), map[string]interface{}{"type": "response.create"})
+	for _, msg := range messages {
+		if err := s.sendOpenAI(msg); err != nil {
+			return fmt.Errorf("error sending message: %v", err)
+		}
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- readChatEvents(s)
+	}()
+
+	input := make(chan string)
+	go func() {
+		defer close(input)
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			input <- scanner.Text()
+		}
+	}()
+
+	for {
+		select {
+		case err := <-readErr:
+			return err
+		case <-s.done:
+			fmt.Println("The assistant ended the call.")
+			return nil
+		case line, ok := <-input:
+			if !ok {
+				return nil
+			}
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			if err := sendChatMessage(s, line); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func sendChatMessage(s *callSession, text string) error {
+	s.addTranscript("caller", "", text)
+	item := map[string]interface{}{
+		"type": "conversation.item.create",
+		"item": map[string]interface{}{
+			"type":    "message",
+			"role":    "user",
+			"content": []map[string]string{{"type": "input_text", "text": text}},
+		},
+	}
+	if err := s.sendOpenAI(item); err != nil {
+		return fmt.Errorf("error sending message: %v", err)
+	}
+	if err := s.sendOpenAI(map[string]interface{}{"type": "response.create"}); err != nil {
+		return fmt.Errorf("error sending response create: %v", err)
+	}
+	return nil
+}
+
+// readChatEvents prints the assistant's replies and dispatches its tool
+// calls the way handleOpenAIMessages does on a call.
+func readChatEvents(s *callSession) error {
+	for {
+		var event map[string]interface{}
+		if err := s.openAIWs.ReadJSON(&event); err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("error reading from OpenAI WebSocket: %v", err)
+		}
+
+		switch normalizeRealtimeEvent(event) {
+		case "error":
+			log.Println("OpenAI error:", event["error"])
+		case "response.text.done":
+			text, _ := event["text"].(string)
+			fmt.Printf("Assistant: %s\n", text)
+			itemID, _ := event["item_id"].(string)
+			s.addTranscript("assistant", itemID, text)
+		case "response.done":
+			response, _ := event["response"].(map[string]interface{})
+			output, _ := response["output"].([]interface{})
+			for _, o := range output {
+				item, _ := o.(map[string]interface{})
+				if item["type"] == "function_call" {
+					fmt.Printf("Tool call: %v(%v)\n", item["name"], item["arguments"])
+				}
+			}
+			handleOpenAIResponse(response, s)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var openAIClient = &http.Client{
+	Transport: openAITransport(),
+	Timeout:   30 * time.Second,
+}
+
+// openAIAPIURL resolves path, such as /realtime/sessions, against the API
+// root OPENAI_REALTIME_URL lives under, so a custom host is used for REST
+// calls too.
+func openAIAPIURL(path string) string {
+	u, err := url.Parse(config.OpenAIRealtimeURL)
+	if err != nil {
+		return "https://api.openai.com/v1" + path
+	}
+	switch u.Scheme {
+	case "wss":
+		u.Scheme = "https"
+	case "ws":
+		u.Scheme = "http"
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/realtime") + path
+	u.RawQuery = ""
+	return u.String()
+}
+
+// handleRealtimeClientSecret mints a short-lived Realtime client secret for
+// a browser or WebRTC client, configured with the server's instructions and
+// voice. It needs the realtime scope, so it is refused outright when no
+// admin auth is configured.
+func handleRealtimeClientSecret(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthEnabled() {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "admin auth must be configured to mint client secrets"})
+		return
+	}
+
+	model := realtimeModels()[0]
+	secret, err := mintClientSecret(model)
+	if err != nil {
+		log.Println("Error minting realtime client secret:", err)
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusCreated, secret)
+}
+
+type clientSecret struct {
+	Value     string `json:"client_secret"`
+	ExpiresAt int64  `json:"expires_at"`
+	Model     string `json:"model"`
+}
+
+func mintClientSecret(model string) (*clientSecret, error) {
+	session := sessionConfig(config.SystemMessage)
+	// The client brings its own audio transport and tools.
+	delete(session, "input_audio_format")
+	delete(session, "output_audio_format")
+	delete(session, "tools")
+	session["model"] = model
+
+	path := "/realtime/sessions"
+	var payload interface{} = session
+	if realtimeGA(model) {
+		path = "/realtime/client_secrets"
+		ttl := int(config.RealtimeClientSecretTTL.Seconds())
+		payload = map[string]interface{}{
+			"expires_after": map[string]interface{}{"anchor": "created_at", "seconds": ttl},
+			"session":       gaSession(session),
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling JSON: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, openAIAPIURL(path), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header = openAIHeader(nil)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := openAIClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("unexpected status code: %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	// The beta API nests the secret under client_secret; GA returns it at
+	// the top level.
+	var result struct {
+		Value        string `json:"value"`
+		ExpiresAt    int64  `json:"expires_at"`
+		ClientSecret *struct {
+			Value     string `json:"value"`
+			ExpiresAt int64  `json:"expires_at"`
+		} `json:"client_secret"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error decoding response: %v", err)
+	}
+	if result.ClientSecret != nil {
+		result.Value, result.ExpiresAt = result.ClientSecret.Value, result.ClientSecret.ExpiresAt
+	}
+	if result.Value == "" {
+		return nil, fmt.Errorf("no client secret in response")
+	}
+
+	return &clientSecret{Value: result.Value, ExpiresAt: result.ExpiresAt, Model: model}, nil
+}
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// audioClips are the WAV files in AUDIO_CLIPS_DIR as μ-law, keyed by file
+// name without the extension, e.g. "legal_notice".
+var audioClips = map[string][]byte{}
+
+func loadAudioClips() error {
+	if config.AudioClipsDir == "" {
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(config.AudioClipsDir, "*.wav"))
+	if err != nil {
+		return fmt.Errorf("error listing audio clips: %v", err)
+	}
+	for _, path := range paths {
+		samples, err := readWAV(path, twilioSampleRate)
+		if err != nil {
+			return fmt.Errorf("error reading audio clip %s: %v", path, err)
+		}
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		audioClips[name] = encodeMulaw(samples)
+	}
+
+	if config.AudioClipOnStart != "" && audioClips[config.AudioClipOnStart] == nil {
+		return fmt.Errorf("AUDIO_CLIP_ON_START names %s, which is not in %s", config.AudioClipOnStart, config.AudioClipsDir)
+	}
+	if config.GreetingStrategy == "audio" && audioClips[config.GreetingAudioClip] == nil {
+		return fmt.Errorf("GREETING_AUDIO_CLIP names %s, which is not in %s", config.GreetingAudioClip, config.AudioClipsDir)
+	}
+	for id, t := range config.File.Tenants {
+		if strategy, clip := t.greetingStrategy(); strategy == "audio" && audioClips[clip] == nil {
+			return fmt.Errorf("tenant %s: greeting audio clip %s is not in %s", id, clip, config.AudioClipsDir)
+		}
+	}
+	return nil
+}
+
+func audioClipNames() []string {
+	names := make([]string, 0, len(audioClips))
+	for name := range audioClips {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// playClip plays a clip to the caller and returns once it has finished.
+// Model audio is cut off and caller audio is kept from OpenAI while it
+// plays; if the model was speaking, it is asked to carry on afterwards.
+func (s *callSession) playClip(name, source string) error {
+	audio, ok := audioClips[name]
+	if !ok {
+		return fmt.Errorf("unknown audio clip %s", name)
+	}
+	if s.sip() {
+		return fmt.Errorf("audio clips can't be played in SIP mode")
+	}
+
+	s.mu.Lock()
+	if s.clip != "" || s.holdStop != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("the call is on hold or already playing a clip")
+	}
+	s.clip = name
+	responding := s.responding
+	s.mu.Unlock()
+
+	s.interrupt()
+	if responding {
+		if err := s.sendOpenAI(map[string]interface{}{"type": "response.cancel"}); err != nil {
+			log.Println("Error sending response cancel:", err)
+		}
+	}
+	s.record("clip.start", name+" ("+source+")")
+
+	completed := s.streamAudio(audio, false, nil)
+
+	s.mu.Lock()
+	s.clip = ""
+	s.mu.Unlock()
+	if !completed {
+		return nil
+	}
+	s.record("clip.done", name)
+
+	if err := s.sendOpenAI(map[string]interface{}{"type": "input_audio_buffer.clear"}); err != nil {
+		log.Println("Error sending input audio buffer clear:", err)
+	}
+	if responding {
+		s.awaitRateLimit()
+		if err := s.sendOpenAI(map[string]interface{}{"type": "response.create"}); err != nil {
+			log.Println("Error sending response create:", err)
+		}
+	}
+	return nil
+}
+
+func (s *callSession) playingClip() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clip != ""
+}
+
+// startCall plays AUDIO_CLIP_ON_START, if set, before the greeting.
+func (s *callSession) startCall(greetingSaid bool) {
+	defer s.recoverPanic("start")
+
+	if config.AudioClipOnStart != "" {
+		if err := s.playClip(config.AudioClipOnStart, "start"); err != nil {
+			log.Println("Error playing start clip:", err)
+		}
+	}
+	if err := sendInitialMessages(s, greetingSaid); err != nil {
+		log.Println("Error sending initial messages:", err)
+		s.hangup()
+		return
+	}
+	s.joinConference(s.callSid())
+}
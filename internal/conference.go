@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const conferenceJoinTimeout = time.Minute
+
+// conferenceJoin is an AI leg dialled into a conference. Twilio gives no
+// way to tag the hairpinned call that arrives at CONFERENCE_AI_NUMBER, so
+// pending joins are queued under "conference:pending" in the state store,
+// matched to incoming calls in order and then kept under "conference:" and
+// the new leg's CallSid until its media stream starts.
+type conferenceJoin struct {
+	Room    string    `json:"room"`
+	Summary string    `json:"summary"`
+	Created time.Time `json:"created"`
+}
+
+var conferenceTool = &tool{
+	name:        "start_conference",
+	description: "Bring a specialist into the call as a three-way conference with the caller. Tell the caller you are adding someone before calling this.",
+	parameters: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"summary":     map[string]string{"type": "string", "description": "One paragraph on who the caller is and what they need, for the people joining the conference."},
+			"participant": map[string]string{"type": "string", "description": "E.164 phone number of the specialist to add, if the caller asked for a specific one."},
+		},
+		"required": []string{"summary"},
+	},
+	enabled: func() bool { return config.ConferenceCallerID != "" },
+	run: func(s *callSession, arguments string) (string, error) {
+		var args struct {
+			Summary     string `json:"summary"`
+			Participant string `json:"participant"`
+		}
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return "", fmt.Errorf("error parsing JSON: %v", err)
+		}
+		participant := args.Participant
+		if participant == "" {
+			participant = config.ConferenceSpecialistNumber
+		}
+		if participant == "" {
+			return "", fmt.Errorf("no conference participant given and CONFERENCE_SPECIALIST_NUMBER not set")
+		}
+
+		room := "conference-" + s.callSid()
+		s.redirectAfterResponse(&pendingRedirect{
+			twiml:  conferenceTwiML(room),
+			event:  "conference",
+			detail: room,
+			after: func() {
+				if err := addConferenceParticipant(room, participant, false); err != nil {
+					log.Println("Error adding specialist to conference:", err)
+				}
+				if config.ConferenceAIMode != "muted" && config.ConferenceAIMode != "unmuted" {
+					return
+				}
+				join := conferenceJoin{Room: room, Summary: args.Summary, Created: time.Now()}
+				if err := pushState("conference:pending", join, conferenceJoinTimeout); err != nil {
+					log.Println("Error saving conference join:", err)
+				}
+				if err := addConferenceParticipant(room, config.ConferenceAINumber, config.ConferenceAIMode == "muted"); err != nil {
+					log.Println("Error adding AI to conference:", err)
+				}
+			},
+		})
+
+		return "The conference is ready. Briefly tell the caller you're adding a specialist to the call now, then stop talking.", nil
+	},
+}
+
+func conferenceTwiML(room string) string {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><Response><Dial><Conference startConferenceOnEnter="true" endConferenceOnExit="true">`)
+	xml.EscapeText(&b, []byte(room))
+	b.WriteString("</Conference></Dial></Response>")
+	return b.String()
+}
+
+func addConferenceParticipant(room, to string, muted bool) error {
+	params := url.Values{
+		"From":                   {config.ConferenceCallerID},
+		"To":                     {to},
+		"StartConferenceOnEnter": {"false"},
+		"EndConferenceOnExit":    {"false"},
+		"Muted":                  {fmt.Sprint(muted)},
+	}
+	return twilioRequest(http.MethodPost, "/Conferences/"+url.PathEscape(room)+"/Participants.json", params, nil)
+}
+
+// claimConferenceJoin is called for incoming calls to CONFERENCE_AI_NUMBER
+// and attaches the oldest pending AI join to the call.
+func claimConferenceJoin(callSid string) bool {
+	for {
+		var join conferenceJoin
+		ok, err := popState("conference:pending", &join)
+		if err != nil {
+			log.Println("Error reading conference joins:", err)
+		}
+		if !ok {
+			return false
+		}
+		if time.Since(join.Created) < conferenceJoinTimeout {
+			if err := setState("conference:"+callSid, join, conferenceJoinTimeout); err != nil {
+				log.Println("Error saving conference join:", err)
+			}
+			return true
+		}
+	}
+}
+
+// joinConference briefs a session that was dialled into a conference with
+// the summary from the call that started it.
+func (s *callSession) joinConference(callSid string) {
+	var join conferenceJoin
+	ok, err := takeState("conference:"+callSid, &join)
+	if err != nil {
+		log.Println("Error reading conference join:", err)
+	}
+	if !ok {
+		return
+	}
+
+	instructions := config.SystemMessage + "\n\nYou have joined a conference call between a caller and a specialist. " +
+		"Only speak when addressed or when you can help. Summary of the call so far: " + join.Summary
+	s.mu.Lock()
+	s.instructions = instructions
+	s.mu.Unlock()
+	update := sessionUpdate(s.modelName(), map[string]interface{}{"instructions": instructions})
+	if err := s.sendOpenAI(update); err != nil {
+		log.Println("Error sending conference instructions:", err)
+	}
+	s.record("conference.join", join.Room)
+}
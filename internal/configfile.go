@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// fileConfig holds the structured settings read from CONFIG_FILE that don't
+// fit comfortably in environment variables.
+type fileConfig struct {
+	Admin    adminAuthConfig            `json:"admin"`
+	Secrets  secretsConfig              `json:"secrets"`
+	Webhooks map[string][]webhookTarget `json:"webhooks"`
+	Tenants  map[string]*tenantConfig   `json:"tenants"`
+	Pricing  pricingConfig              `json:"pricing"`
+	Jobs     map[string]string          `json:"jobs"`
+	// ErrorMessages are what callers hear when something fails, by error
+	// class.
+	ErrorMessages map[string]string `json:"error_messages"`
+	Escalation    []escalationRule  `json:"escalation"`
+}
+
+func readConfigFile(path string) (fileConfig, error) {
+	var file fileConfig
+	if path == "" {
+		return file, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return file, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return file, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	for id, tenant := range file.Tenants {
+		tenant.ID = id
+	}
+
+	return file, nil
+}
@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// configureConn applies the message size limit and read deadline to a call
+// leg. The deadline is pushed forward by every message and pong, and
+// keepAlive pings quiet peers so that only a stalled one trips it.
+func configureConn(conn *websocket.Conn) {
+	if config.WSMaxMessageBytes > 0 {
+		conn.SetReadLimit(config.WSMaxMessageBytes)
+	}
+	if config.WSReadTimeout > 0 {
+		extendReadDeadline(conn)
+		conn.SetPongHandler(func(string) error {
+			extendReadDeadline(conn)
+			return nil
+		})
+	}
+}
+
+func extendReadDeadline(conn *websocket.Conn) {
+	if config.WSReadTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(config.WSReadTimeout))
+	}
+}
+
+func setWriteDeadline(conn *websocket.Conn) {
+	if config.WSWriteTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(config.WSWriteTimeout))
+	}
+}
+
+func keepAlive(conn *websocket.Conn, done <-chan struct{}) {
+	if config.WSReadTimeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(config.WSReadTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// checkOrigin applies WS_ORIGIN_POLICY to media stream upgrades. Twilio
+// never sends an Origin header, so "twilio-only" rejects any browser origin,
+// "allowlist" additionally accepts WS_ALLOWED_ORIGINS and "any" accepts
+// everything, for local development.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	switch config.WSOriginPolicy {
+	case "any":
+		return true
+	case "allowlist":
+		if origin == "" || slices.Contains(config.WSAllowedOrigins, origin) {
+			return true
+		}
+	case "twilio-only":
+		if origin == "" {
+			return true
+		}
+	}
+
+	log.Printf("Rejected websocket upgrade from origin %q\n", origin)
+	return false
+}
@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+)
+
+var contextCompactionsTotal = newCounter("openai_context_compactions_total", "Older conversation items replaced by a summary to keep long calls within the context window, by result.", "result")
+
+// contextSummary marks the out-of-band response that summarizes older
+// conversation items, in its metadata.
+const contextSummary = "context_summary"
+
+const contextSummaryPrompt = "Summarize this earlier part of the phone call for your own later reference: " +
+	"who the caller is, what they want, what has been said, agreed and done, including the results of any tool calls. " +
+	"Keep names, numbers, dates and the caller's language. Answer in plain text."
+
+// A long call's conversation eventually outgrows the model's context
+// window. Once a response's context reaches OPENAI_CONTEXT_LIMIT tokens,
+// all but the last OPENAI_CONTEXT_KEEP_ITEMS items are summarized in an
+// out-of-band text response, and then replaced by the summary: it is added
+// at the start of the conversation and the items are deleted.
+
+// conversationItem is an item of the call's OpenAI conversation.
+type conversationItem struct {
+	id       string
+	itemType string
+}
+
+// trackConversationItem keeps s.items in step with the conversation, from
+// conversation.item.created and conversation.item.deleted events.
+func (s *callSession) trackConversationItem(eventType string, event map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if eventType == "conversation.item.deleted" {
+		id, _ := event["item_id"].(string)
+		for i, item := range s.items {
+			if item.id == id {
+				s.items = append(s.items[:i], s.items[i+1:]...)
+				break
+			}
+		}
+		return
+	}
+
+	item, _ := event["item"].(map[string]interface{})
+	id, _ := item["id"].(string)
+	itemType, _ := item["type"].(string)
+	for _, existing := range s.items {
+		if existing.id == id {
+			return
+		}
+	}
+	// Items go after their previous item, or first without one.
+	at := 0
+	if previous, _ := event["previous_item_id"].(string); previous != "" {
+		at = len(s.items)
+		for i, existing := range s.items {
+			if existing.id == previous {
+				at = i + 1
+				break
+			}
+		}
+	}
+	s.items = append(s.items[:at], append([]conversationItem{{id, itemType}}, s.items[at:]...)...)
+}
+
+// compactContext handles a finished response: it replaces the older items
+// once their summary is in, and asks for that summary once the context of a
+// response nears the limit.
+func (s *callSession) compactContext(response map[string]interface{}) {
+	if config.OpenAIContextLimit <= 0 {
+		return
+	}
+	metadata, _ := response["metadata"].(map[string]interface{})
+	if metadata["purpose"] == contextSummary {
+		s.replaceOlderItems(response)
+		return
+	}
+	if metadata["purpose"] != nil {
+		return
+	}
+	usage, _ := response["usage"].(map[string]interface{})
+	tokens, _ := usage["total_tokens"].(float64)
+
+	s.mu.Lock()
+	if int(tokens) < config.OpenAIContextLimit || s.compacting != nil || s.renewing {
+		s.mu.Unlock()
+		return
+	}
+	// Tool outputs stay with their calls.
+	keep := len(s.items) - config.OpenAIContextKeepItems
+	for keep > 0 && s.items[keep].itemType == "function_call_output" {
+		keep--
+	}
+	if keep < 2 {
+		s.mu.Unlock()
+		return
+	}
+	var input []map[string]string
+	for _, item := range s.items[:keep] {
+		s.compacting = append(s.compacting, item.id)
+		input = append(input, map[string]string{"type": "item_reference", "id": item.id})
+	}
+	model := s.model
+	s.mu.Unlock()
+
+	summaryRequest := map[string]interface{}{
+		"conversation": "none",
+		"metadata":     map[string]string{"purpose": contextSummary},
+		"tool_choice":  "none",
+		"instructions": contextSummaryPrompt,
+		"input":        input,
+	}
+	if realtimeGA(model) {
+		summaryRequest["output_modalities"] = []string{"text"}
+	} else {
+		summaryRequest["modalities"] = []string{"text"}
+	}
+	if err := s.sendOpenAI(map[string]interface{}{"type": "response.create", "response": summaryRequest}); err != nil {
+		log.Println("Error requesting context summary:", err)
+		s.mu.Lock()
+		s.compacting = nil
+		s.mu.Unlock()
+	}
+}
+
+// replaceOlderItems puts the summary of the items being compacted in their
+// place.
+func (s *callSession) replaceOlderItems(response map[string]interface{}) {
+	s.mu.Lock()
+	older := s.compacting
+	s.compacting = nil
+	s.mu.Unlock()
+	if older == nil {
+		return
+	}
+
+	summary := responseText(response)
+	if status, _ := response["status"].(string); status != "completed" || summary == "" {
+		log.Printf("Context summary response %s without text, keeping the conversation as is\n", status)
+		contextCompactionsTotal.add(1, "failed")
+		return
+	}
+
+	note := systemNote("Summary of the earlier part of this call:\n\n" + summary)
+	note["previous_item_id"] = "root"
+	messages := []map[string]interface{}{note}
+	for _, id := range older {
+		messages = append(messages, map[string]interface{}{"type": "conversation.item.delete", "item_id": id})
+	}
+	for _, msg := range messages {
+		if err := s.sendOpenAI(msg); err != nil {
+			log.Println("Error compacting the conversation:", err)
+			contextCompactionsTotal.add(1, "failed")
+			return
+		}
+	}
+
+	contextCompactionsTotal.add(1, "compacted")
+	s.record("context.compacted", fmt.Sprintf("%d items into a %d-character summary", len(older), len(summary)))
+}
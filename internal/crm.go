@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+var customerContextFetchesTotal = newCounter("customer_context_fetches_total", "Customer context fetched from the CRM before calls are configured, by result (found, empty or error).", "result")
+
+// Before a call's OpenAI session is configured, the caller's account can be
+// fetched from a customer_context webhook (CUSTOMER_CONTEXT_URL): open
+// orders, outstanding balance, last ticket and so on. The answer is added
+// to the instructions under a "Customer context" heading, one line per
+// field, after sensitive fields are redacted and within
+// CUSTOMER_CONTEXT_MAX_CHARS. The fetch starts once the caller lookup is
+// done, so it can use what that found, and the call waits for it for up to
+// CUSTOMER_CONTEXT_TIMEOUT.
+
+const customerContextHeading = "# Customer context\n\n" +
+	"Our records show the following about the caller. Use it to help them, but don't read it out unless they ask.\n\n"
+
+func customerContextConfigured(tenant *tenantConfig) bool {
+	return len(callWebhookTargets("customer_context", map[string]interface{}{"tenant": tenant.id()})) > 0
+}
+
+// startCustomerContext fetches the caller's context in the background, if
+// a customer_context webhook is configured.
+func (s *callSession) startCustomerContext() {
+	if !customerContextConfigured(s.tenant) {
+		return
+	}
+	s.customerContextFetched = make(chan struct{})
+	go func() {
+		defer close(s.customerContextFetched)
+		defer s.recoverPanic("customer_context")
+		s.awaitCallerLookup()
+
+		fields, err := s.fetchCustomerContext()
+		var section string
+		switch {
+		case err != nil:
+			log.Println("Error fetching customer context:", err)
+			customerContextFetchesTotal.addFor(s, 1, "error")
+			s.record("customer.context", "error")
+		case len(fields) == 0:
+			customerContextFetchesTotal.addFor(s, 1, "empty")
+			s.record("customer.context", "empty")
+		default:
+			section = customerContextSection(fields, config.CustomerContextMaxChars)
+			customerContextFetchesTotal.addFor(s, 1, "found")
+			s.record("customer.context", fmt.Sprintf("%d fields, %d characters", len(fields), len(section)))
+		}
+		s.mu.Lock()
+		s.customerContext = section
+		s.mu.Unlock()
+	}()
+}
+
+// fetchCustomerContext asks the customer_context webhook about the caller,
+// passing along the call metadata so far, such as a customer ID from the
+// caller lookup. A 404 means there is nothing on record.
+func (s *callSession) fetchCustomerContext() (map[string]interface{}, error) {
+	s.mu.Lock()
+	payload := map[string]interface{}{"phone_number": s.phoneNumber, "tenant": s.tenant.id(), "metadata": s.callMetadata()}
+	s.mu.Unlock()
+
+	type answer struct {
+		fields map[string]interface{}
+		err    error
+	}
+	answered := make(chan answer, 1)
+	go func() {
+		var a answer
+		a.err = queryWebhook("customer_context", s.summary(), payload, &a.fields)
+		var statusErr *webhookStatusError
+		if errors.As(a.err, &statusErr) && statusErr.code == http.StatusNotFound {
+			a.err = nil
+		}
+		answered <- a
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), config.CustomerContextTimeout)
+	defer cancel()
+	select {
+	case a := <-answered:
+		return a.fields, a.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("customer_context webhook: %v", ctx.Err())
+	}
+}
+
+// customerContextSection renders fields as the instructions section, one
+// "name: value" line each in name order, with nested values as JSON.
+// Sensitive fields are redacted, and lines stop at maxChars.
+func customerContextSection(fields map[string]interface{}, maxChars int) string {
+	fields = redactCustomerContext(fields).(map[string]interface{})
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(customerContextHeading)
+	for _, name := range names {
+		value, ok := fields[name].(string)
+		if !ok {
+			data, err := json.Marshal(fields[name])
+			if err != nil {
+				continue
+			}
+			value = string(data)
+		}
+		line := redact(name + ": " + value)
+		if maxChars > 0 && b.Len()+len(line) > maxChars {
+			b.WriteString("(The rest of the record was left out for length.)\n")
+			break
+		}
+		b.WriteString(line + "\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// redactCustomerContext blanks the fields the log never shows, and those
+// named in CUSTOMER_CONTEXT_REDACT, at any depth.
+func redactCustomerContext(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, field := range v {
+			if sensitiveFields[strings.ToLower(key)] || slices.Contains(config.CustomerContextRedact, strings.ToLower(key)) {
+				copied[key] = "[redacted]"
+			} else {
+				copied[key] = redactCustomerContext(field)
+			}
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = redactCustomerContext(item)
+		}
+		return copied
+	default:
+		return value
+	}
+}
+
+// withCustomerContext appends the caller's context, once fetched, to
+// instructions.
+func (s *callSession) withCustomerContext(instructions string) string {
+	if s.customerContextFetched == nil {
+		return instructions
+	}
+	select {
+	case <-s.customerContextFetched:
+	case <-time.After(config.CallerLookupTimeout + config.CustomerContextTimeout + time.Second):
+	}
+	s.mu.Lock()
+	section := s.customerContext
+	s.mu.Unlock()
+	if section == "" {
+		return instructions
+	}
+	return instructions + "\n\n" + section
+}
@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// A destructive tool, because destructive is set or it is named in
+// DESTRUCTIVE_TOOLS, cancels or deletes something that can't be undone.
+// The model's first call only gets "confirmation required" and a one-time
+// token for those details. The tool runs when the model calls it again with
+// the same details and the token within destructiveConfirmationTTL, and
+// only once the caller has spoken since the token was issued. The model
+// sees the token, so what is enforced is that the caller got a turn to
+// answer, not what they said.
+
+const destructiveConfirmationTTL = 2 * time.Minute
+
+// destructiveConfirmation is a token issued for a destructive tool call,
+// after the caller's turn-th turn.
+type destructiveConfirmation struct {
+	token   string
+	details string
+	expires time.Time
+	turn    int
+}
+
+func (t *tool) isDestructive() bool {
+	return t.form == nil && (t.destructive || slices.Contains(config.DestructiveTools, t.name))
+}
+
+// confirmDestructive checks a call of a destructive tool. It returns the
+// arguments without the token, and whether the call carries the token
+// issued for exactly these details before the caller's last turn. Otherwise
+// it issues a new token, which replaces any earlier one for the tool, and
+// returns what the model is to ask the caller.
+func (s *callSession) confirmDestructive(t *tool, arguments string) (string, string, bool, error) {
+	args, details, value, err := toolDetails(arguments, "confirmation_token")
+	if err != nil {
+		return "", "", false, err
+	}
+	token, _ := value.(string)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.destructiveConfirmations == nil {
+		s.destructiveConfirmations = map[string]destructiveConfirmation{}
+	}
+	pending, ok := s.destructiveConfirmations[t.name]
+	if ok && token != "" && token == pending.token && details == pending.details && time.Now().Before(pending.expires) && s.callerTurns > pending.turn {
+		delete(s.destructiveConfirmations, t.name)
+		return details, "", true, nil
+	}
+	pending = destructiveConfirmation{token: randomHex(8), details: details, expires: time.Now().Add(destructiveConfirmationTTL), turn: s.callerTurns}
+	s.destructiveConfirmations[t.name] = pending
+
+	prompt := fmt.Sprintf("Confirmation required, nothing was done. %s can't be undone. Tell the caller exactly what will happen (%s) and ask them to say yes to go ahead. "+
+		"Wait for their answer. Only if they clearly agree, call %s again with exactly the same details and confirmation_token %q. "+
+		"If they don't agree, don't call it again.", t.name, detailLines(args), t.name, pending.token)
+	return details, prompt, false, nil
+}
@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sync"
+)
+
+var publishVars sync.Once
+
+// registerDiagnosticsRoutes serves net/http/pprof under /debug/pprof/ and
+// expvar at /debug/vars when PPROF_ENABLED is set. Profiles show goroutine
+// stacks and can be slow to collect, so they need the configure scope and
+// are audited like other admin actions.
+func registerDiagnosticsRoutes(mux *http.ServeMux) {
+	if !config.PprofEnabled {
+		return
+	}
+
+	// expvar panics if a name is published twice, and newHandler can be
+	// called more than once.
+	publishVars.Do(func() {
+		expvar.Publish("active_calls", expvar.Func(func() interface{} { return len(listSessions()) }))
+		expvar.Publish("goroutines", expvar.Func(func() interface{} { return runtime.NumGoroutine() }))
+	})
+
+	mux.HandleFunc("GET /debug/pprof/", requireScope(scopeConfigure, pprof.Index))
+	mux.HandleFunc("GET /debug/pprof/cmdline", requireScope(scopeConfigure, pprof.Cmdline))
+	mux.HandleFunc("GET /debug/pprof/profile", requireScope(scopeConfigure, pprof.Profile))
+	mux.HandleFunc("GET /debug/pprof/symbol", requireScope(scopeConfigure, pprof.Symbol))
+	mux.HandleFunc("POST /debug/pprof/symbol", requireScope(scopeConfigure, pprof.Symbol))
+	mux.HandleFunc("GET /debug/pprof/trace", requireScope(scopeConfigure, pprof.Trace))
+	mux.HandleFunc("GET /debug/vars", requireScope(scopeConfigure, expvar.Handler().ServeHTTP))
+}
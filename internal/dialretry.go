@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var openAIDialRetriesTotal = newCounter("openai_dial_retries_total", "OpenAI Realtime connection attempts retried after a transient failure.")
+
+// openAIDialError is a Realtime dial that OpenAI answered with an HTTP
+// status instead of upgrading the connection.
+type openAIDialError struct {
+	err    error
+	status int
+}
+
+func (e *openAIDialError) Error() string {
+	return fmt.Sprintf("%v (status %d)", e.err, e.status)
+}
+
+// transientDialError reports whether a failed dial is worth retrying:
+// network errors, rate limits and server errors are, a rejected API key
+// isn't.
+func transientDialError(err error) bool {
+	var dialErr *openAIDialError
+	if !errors.As(err, &dialErr) {
+		return true
+	}
+	return dialErr.status == http.StatusTooManyRequests || dialErr.status >= 500
+}
+
+// dialBackoff is the wait before retry attempt+1: OPENAI_DIAL_BACKOFF
+// doubled with every attempt, jittered by up to half either way.
+func dialBackoff(attempt int) time.Duration {
+	base := float64(config.OpenAIDialBackoff) * math.Pow(2, float64(attempt))
+	return time.Duration(base * (0.5 + rand.Float64()))
+}
+
+// dialOpenAIForStream dials OpenAI for a media stream, retrying transient
+// failures up to OPENAI_DIAL_RETRIES times, within OPENAI_CONNECT_TIMEOUT
+// in all. While it waits the caller hears a comfort tone, which needs the
+// stream's start event; the Twilio messages read to get it are returned for
+// the session to handle. The tone is μ-law, so G.722 streams go without.
+func dialOpenAIForStream(tenant *tenantConfig, ws *websocket.Conn) (*websocket.Conn, string, []map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.OpenAIConnectTimeout)
+	defer cancel()
+
+	var backlog []map[string]interface{}
+	var start map[string]interface{}
+	for attempt := 0; ; attempt++ {
+		conn, model, err := dialOpenAIContext(ctx, tenant)
+		if err == nil {
+			return conn, model, backlog, nil
+		}
+		if ctx.Err() != nil {
+			return nil, "", backlog, fmt.Errorf("no connection within %s: %v", config.OpenAIConnectTimeout, err)
+		}
+		if attempt >= config.OpenAIDialRetries || !transientDialError(err) {
+			return nil, "", backlog, err
+		}
+
+		delay := dialBackoff(attempt)
+		log.Printf("Error connecting to OpenAI WebSocket, retrying in %s: %v\n", delay.Round(time.Millisecond), err)
+		openAIDialRetriesTotal.add(1)
+		if start == nil {
+			backlog, start = readStreamStart(ws, backlog)
+		}
+		streamSid, _ := start["streamSid"].(string)
+		if leg, err := streamLeg(start); streamSid != "" && leg == nil && err == nil {
+			playComfortTone(ws, streamSid)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// readStreamStart reads Twilio messages up to the start event and returns
+// them with the event's start object, which is nil if the stream didn't
+// start.
+func readStreamStart(ws *websocket.Conn, backlog []map[string]interface{}) ([]map[string]interface{}, map[string]interface{}) {
+	for {
+		var data map[string]interface{}
+		if err := ws.ReadJSON(&data); err != nil {
+			log.Println("Error reading from Twilio WebSocket:", err)
+			return backlog, nil
+		}
+		extendReadDeadline(ws)
+		// Audio from before the assistant is connected is of no use.
+		if data["event"] == "media" {
+			continue
+		}
+		backlog = append(backlog, data)
+		if data["event"] == "start" {
+			start, _ := data["start"].(map[string]interface{})
+			if start == nil {
+				start = map[string]interface{}{}
+			}
+			return backlog, start
+		}
+	}
+}
+
+// comfortTone is two soft 440 Hz beeps, μ-law encoded.
+func comfortTone() []byte {
+	const beep, gap = twilioSampleRate / 8, twilioSampleRate / 10
+	samples := make([]int16, 0, 2*beep+gap)
+	for i := 0; i < 2*beep+gap; i++ {
+		if i >= beep && i < beep+gap {
+			samples = append(samples, 0)
+			continue
+		}
+		samples = append(samples, int16(2000*math.Sin(2*math.Pi*440*float64(i)/twilioSampleRate)))
+	}
+	return encodeMulaw(samples)
+}
+
+func playComfortTone(ws *websocket.Conn, streamSid string) {
+	setWriteDeadline(ws)
+	err := ws.WriteJSON(map[string]interface{}{
+		"event":     "media",
+		"streamSid": streamSid,
+		"media":     map[string]string{"payload": base64.StdEncoding.EncodeToString(comfortTone())},
+	})
+	if err != nil {
+		log.Println("Error sending comfort tone to Twilio:", err)
+	}
+}
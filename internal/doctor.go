@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var errCheckSkipped = errors.New("skipped")
+
+type doctorCheck struct {
+	name string
+	run  func() (string, error)
+}
+
+func Doctor() bool {
+	loadConfig()
+
+	checks := []doctorCheck{
+		{"OpenAI Realtime session", checkOpenAISession},
+		{"Twilio credentials", checkTwilioCredentials},
+		{"Public hostname", checkPublicHostname},
+		{"Tool webhook", checkToolWebhook},
+	}
+
+	passed := true
+	for _, check := range checks {
+		detail, err := check.run()
+		switch {
+		case errors.Is(err, errCheckSkipped):
+			fmt.Printf("[SKIP] %s: %s\n", check.name, detail)
+		case err != nil:
+			passed = false
+			fmt.Printf("[FAIL] %s: %v\n", check.name, err)
+		default:
+			fmt.Printf("[PASS] %s: %s\n", check.name, detail)
+		}
+	}
+
+	return passed
+}
+
+func checkOpenAISession() (string, error) {
+	conn, _, err := dialOpenAI(nil)
+	if err != nil {
+		return "", fmt.Errorf("error connecting to OpenAI WebSocket: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for {
+		var event map[string]interface{}
+		if err := conn.ReadJSON(&event); err != nil {
+			return "", fmt.Errorf("error reading from OpenAI WebSocket: %v", err)
+		}
+
+		switch event["type"] {
+		case "session.created":
+			session, _ := event["session"].(map[string]interface{})
+			model, _ := session["model"].(string)
+			return "session created with model " + model, nil
+		case "error":
+			return "", fmt.Errorf("OpenAI error: %v", event["error"])
+		}
+	}
+}
+
+func checkTwilioCredentials() (string, error) {
+	if secret("TWILIO_ACCOUNT_SID") == "" || secret("TWILIO_AUTH_TOKEN") == "" {
+		return "TWILIO_ACCOUNT_SID or TWILIO_AUTH_TOKEN not set", errCheckSkipped
+	}
+
+	var account struct {
+		FriendlyName string `json:"friendly_name"`
+		Status       string `json:"status"`
+	}
+	if err := twilioRequest(http.MethodGet, ".json", nil, &account); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("account %q is %s at %s", account.FriendlyName, account.Status, config.TwilioAPIURL), nil
+}
+
+func checkPublicHostname() (string, error) {
+	if config.ExternalURL == "" {
+		return "EXTERNAL_URL and PUBLIC_HOSTNAME not set", errCheckSkipped
+	}
+
+	u, _ := url.Parse(config.ExternalURL)
+	addrs, err := net.LookupHost(u.Hostname())
+	if err != nil {
+		return "", fmt.Errorf("error resolving %s: %v", u.Hostname(), err)
+	}
+
+	return fmt.Sprintf("%s resolves to %s", u.Hostname(), strings.Join(addrs, ", ")), nil
+}
+
+func checkToolWebhook() (string, error) {
+	var responded []string
+	for _, target := range webhookTargets("schedule") {
+		if err := target.post("ping", []byte(`{"type":"ping"}`)); err != nil {
+			return "", fmt.Errorf("%s: %v", target.URL, err)
+		}
+		responded = append(responded, target.URL)
+	}
+
+	return strings.Join(responded, ", ") + " responded", nil
+}
@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// errorReportBuffer is how many reports wait to be sent before new ones are
+// dropped, so an error storm can't build up memory or slow calls down.
+const errorReportBuffer = 100
+
+// errorReport is an error worth surfacing outside the log: a panic, an
+// OpenAI error event, a failed webhook or a websocket that closed
+// abnormally. Reports go to Sentry with SENTRY_DSN and to the "error"
+// webhook event, whose default target is ERROR_WEBHOOK_URL.
+type errorReport struct {
+	Time    time.Time              `json:"time"`
+	Kind    string                 `json:"kind"`
+	Message string                 `json:"message"`
+	Tags    map[string]string      `json:"tags"`
+	Extra   map[string]interface{} `json:"extra,omitempty"`
+}
+
+var errorReports = make(chan errorReport, errorReportBuffer)
+
+func errorReportingEnabled() bool {
+	return secret("SENTRY_DSN") != "" || len(webhookTargets("error")) > 0
+}
+
+// reportError queues a report without blocking. tags usually come from
+// callErrorTags, so reports can be found by CallSid.
+func reportError(kind, message string, tags map[string]string, extra map[string]interface{}) {
+	if !errorReportingEnabled() {
+		return
+	}
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	select {
+	case errorReports <- errorReport{Time: time.Now().UTC(), Kind: kind, Message: redact(message), Tags: tags, Extra: extra}:
+	default:
+		log.Println("Error report dropped, queue full:", kind)
+	}
+}
+
+func (s *callSession) errorTags() map[string]string {
+	tags := map[string]string{"call_id": s.id}
+	if callSid := s.callSid(); callSid != "" {
+		tags["call_sid"] = callSid
+	}
+	if s.tenant != nil {
+		tags["tenant"] = s.tenant.ID
+	}
+	return tags
+}
+
+// callErrorTags tags a report from a call summary, for code that only has
+// the summary, such as webhook delivery.
+func callErrorTags(call map[string]interface{}) map[string]string {
+	tags := map[string]string{}
+	for _, key := range []string{"call_sid", "tenant"} {
+		if value, _ := call[key].(string); value != "" {
+			tags[key] = value
+		}
+	}
+	if id, _ := call["id"].(string); id != "" {
+		tags["call_id"] = id
+	}
+	return tags
+}
+
+// reportClosure reports a websocket read error if the peer went away
+// without a clean close. Errors from closing the connection ourselves are
+// not close errors and are ignored.
+func (s *callSession) reportClosure(peer string, err error) {
+	if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		reportError("websocket_closure", fmt.Sprintf("%s websocket closed abnormally: %v", peer, err), s.errorTags(), nil)
+	}
+}
+
+func (s *callSession) reportOpenAIError(event map[string]interface{}) {
+	details, _ := event["error"].(map[string]interface{})
+	code, _ := details["code"].(string)
+	// Cancelling a response that already finished is expected, not an error.
+	if code == "response_cancel_not_active" {
+		return
+	}
+	message, _ := details["message"].(string)
+	tags := s.errorTags()
+	if code != "" {
+		tags["openai_code"] = code
+	}
+	reportError("openai_error", "OpenAI error: "+message, tags, map[string]interface{}{"event": details})
+}
+
+func runErrorReports() {
+	for report := range errorReports {
+		if secret("SENTRY_DSN") != "" {
+			if err := sendSentryEvent(report); err != nil {
+				log.Println("Error sending error report to Sentry:", err)
+			}
+		}
+		// Failures of the error webhook itself are not reported again.
+		if err := deliverWebhook("error", nil, report); err != nil {
+			log.Println("Error delivering error webhook:", err)
+		}
+	}
+}
+
+// sendSentryEvent posts a report to Sentry's envelope endpoint, as found
+// from a DSN of the form https://<key>@<host>/<project>.
+func sendSentryEvent(report errorReport) error {
+	rawDSN := secret("SENTRY_DSN")
+	dsn, err := url.Parse(rawDSN)
+	if err != nil || dsn.User == nil {
+		return fmt.Errorf("invalid SENTRY_DSN")
+	}
+	key := dsn.User.Username()
+	project := strings.TrimPrefix(dsn.Path, "/")
+	endpoint := fmt.Sprintf("%s://%s/api/%s/envelope/", dsn.Scheme, dsn.Host, project)
+
+	eventID := randomHex(16)
+	hostname, _ := os.Hostname()
+	event := map[string]interface{}{
+		"event_id":    eventID,
+		"timestamp":   report.Time.Format(time.RFC3339Nano),
+		"level":       "error",
+		"platform":    "go",
+		"logger":      report.Kind,
+		"message":     map[string]string{"formatted": report.Message},
+		"tags":        report.Tags,
+		"extra":       report.Extra,
+		"release":     "twilio-voice-openai@" + Version,
+		"environment": config.SentryEnvironment,
+		"server_name": hostname,
+		"fingerprint": []string{report.Kind, report.Message},
+	}
+	header, _ := json.Marshal(map[string]string{"event_id": eventID, "dsn": rawDSN, "sent_at": time.Now().UTC().Format(time.RFC3339)})
+	item, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %v", err)
+	}
+	var body bytes.Buffer
+	body.Write(header)
+	body.WriteString("\n{\"type\":\"event\"}\n")
+	body.Write(item)
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_key=%s, sentry_client=twilio-voice-openai/%s", key, Version))
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+)
+
+var escalationsTotal = newCounter("escalations_total", "Calls escalated by escalation rules, by rule and action.", "rule", "action")
+
+// Escalation rules, under "escalation" in CONFIG_FILE or a tenant's own,
+// act on what the caller says rather than waiting for the model to decide.
+// Each rule lists phrases, matched as whole words in the transcript of each
+// caller turn regardless of case and punctuation. It fires on the turn that
+// brings its matches to repeat, 1 by default, so a rule for frustration can
+// wait for the second "this is ridiculous". What happens depends on the
+// action:
+//
+//   - transfer: the model is made to call transfer_to_human at once, so
+//     the agent still gets its handoff summary.
+//   - callback: the caller is told a call back will be arranged and moved
+//     to the callback prompt, which asks when suits them.
+//   - priority: the call is only tagged.
+//
+// Every rule that fires sets "escalation" and "priority" in the call
+// metadata. A rule fires at most once per call.
+type escalationRule struct {
+	Name    string   `json:"name"`
+	Phrases []string `json:"phrases"`
+	Repeat  int      `json:"repeat"`
+	Action  string   `json:"action"`
+}
+
+func validateEscalationRules(rules []escalationRule) error {
+	names := map[string]bool{}
+	for _, rule := range rules {
+		if rule.Name == "" || names[rule.Name] {
+			return fmt.Errorf("each rule needs a name of its own")
+		}
+		names[rule.Name] = true
+		if len(rule.Phrases) == 0 {
+			return fmt.Errorf("rule %s has no phrases", rule.Name)
+		}
+		switch rule.Action {
+		case "transfer":
+			if config.TransferPhoneNumber == "" {
+				return fmt.Errorf("rule %s: transfer needs TRANSFER_PHONE_NUMBER", rule.Name)
+			}
+		case "callback":
+			if !config.CallbackEnabled || config.ExternalURL == "" {
+				return fmt.Errorf("rule %s: callback needs CALLBACK_ENABLED and EXTERNAL_URL or PUBLIC_HOSTNAME", rule.Name)
+			}
+		case "priority":
+		default:
+			return fmt.Errorf("rule %s: unknown action %q: use transfer, callback or priority", rule.Name, rule.Action)
+		}
+	}
+	return nil
+}
+
+// escalationConfigured reports whether any calls have escalation rules,
+// which need the caller's speech transcribed.
+func escalationConfigured() bool {
+	if len(config.File.Escalation) > 0 {
+		return true
+	}
+	for _, tenant := range config.File.Tenants {
+		if len(tenant.Escalation) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *tenantConfig) escalationRules() []escalationRule {
+	if t != nil && len(t.Escalation) > 0 {
+		return t.Escalation
+	}
+	return config.File.Escalation
+}
+
+// normalizeSpeech lowercases text and turns punctuation into spaces, with a
+// space at each end so phrases only match whole words.
+func normalizeSpeech(text string) string {
+	text = strings.ReplaceAll(strings.ToLower(text), "’", "'")
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+	})
+	return " " + strings.Join(words, " ") + " "
+}
+
+// checkEscalation matches a caller turn's transcript against the call's
+// rules and escalates on the first rule that fires.
+func (s *callSession) checkEscalation(transcript string) {
+	rules := s.tenant.escalationRules()
+	if len(rules) == 0 || transcript == "" {
+		return
+	}
+	text := normalizeSpeech(transcript)
+
+	var fired *escalationRule
+	s.mu.Lock()
+	if s.escalationMatches == nil {
+		s.escalationMatches = map[string]int{}
+	}
+	for i, rule := range rules {
+		if s.escalationMatches[rule.Name] < 0 {
+			continue
+		}
+		for _, phrase := range rule.Phrases {
+			if strings.Contains(text, normalizeSpeech(phrase)) {
+				s.escalationMatches[rule.Name]++
+				break
+			}
+		}
+		if fired == nil && s.escalationMatches[rule.Name] >= max(rule.Repeat, 1) {
+			// A negative count marks the rule as fired.
+			s.escalationMatches[rule.Name] = -1
+			fired = &rules[i]
+		}
+	}
+	s.mu.Unlock()
+	if fired != nil {
+		s.escalate(fired)
+	}
+}
+
+func (s *callSession) escalate(rule *escalationRule) {
+	escalationsTotal.addFor(s, 1, rule.Name, rule.Action)
+	s.record("escalation", rule.Name+": "+rule.Action)
+	s.setMetadata(map[string]interface{}{"escalation": rule.Name, "priority": "high"}, "escalation")
+	if rule.Action == "priority" || (rule.Action == "callback" && s.sip()) {
+		return
+	}
+	// validateTenants refuses transfer rules for calls without the transfer
+	// tool, but should one get through, forcing a tool the session doesn't
+	// have would fail the response, so the call is only tagged.
+	if rule.Action == "transfer" && (findTool(transferTool.name) == nil || !s.tenant.allowsTool(transferTool.name)) {
+		log.Printf("Escalation rule %s can't transfer without %s, tagging the call only\n", rule.Name, transferTool.name)
+		return
+	}
+
+	// Whatever the model is saying is cut short, and the escalation starts
+	// once that response is done.
+	s.mu.Lock()
+	s.pendingEscalation = rule
+	responding := s.responding
+	s.mu.Unlock()
+	if !responding {
+		go s.startPendingEscalation()
+		return
+	}
+	s.interrupt()
+	if err := s.sendOpenAI(map[string]interface{}{"type": "response.cancel"}); err != nil {
+		log.Println("Error sending response cancel:", err)
+	}
+}
+
+// startPendingEscalation has the model hand the caller on for an
+// escalation waiting for the response in progress to finish.
+func (s *callSession) startPendingEscalation() {
+	s.mu.Lock()
+	rule := s.pendingEscalation
+	s.pendingEscalation = nil
+	s.mu.Unlock()
+	if rule == nil {
+		return
+	}
+
+	var note string
+	response := map[string]interface{}{}
+	switch rule.Action {
+	case "transfer":
+		note = "The caller needs a member of the team. Call transfer_to_human now, with a summary of the call so far."
+		response["tool_choice"] = map[string]string{"type": "function", "name": transferTool.name}
+	case "callback":
+		note = "The caller needs a member of the team. Tell them, in one sentence, that you'll arrange for someone to call them back, then stop talking."
+		response["tool_choice"] = "none"
+		s.redirectAfterResponse(&pendingRedirect{twiml: escalationCallbackTwiML(), event: "escalation.callback", detail: rule.Name})
+	}
+	s.awaitRateLimit()
+	for _, msg := range []map[string]interface{}{systemNote(note), {"type": "response.create", "response": response}} {
+		if err := s.sendOpenAI(msg); err != nil {
+			log.Println("Error sending escalation to OpenAI:", err)
+		}
+	}
+}
+
+// escalationCallbackTwiML asks the caller when to call them back, as the
+// busy message does.
+func escalationCallbackTwiML() string {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><Response>`)
+	writeCallbackGather(&b, "", config.ExternalURL+"/callback-request")
+	b.WriteString("<Say>Sorry, I didn't get that. Please call us again. Goodbye.</Say><Hangup/></Response>")
+	return b.String()
+}
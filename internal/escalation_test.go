@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateTenantsTransferRules(t *testing.T) {
+	file, transfer := config.File, config.TransferPhoneNumber
+	t.Cleanup(func() { config.File, config.TransferPhoneNumber = file, transfer })
+	config.TransferPhoneNumber = "+15555550199"
+
+	transferRule := []escalationRule{{Name: "agent", Phrases: []string{"a human"}, Action: "transfer"}}
+	priorityRule := []escalationRule{{Name: "angry", Phrases: []string{"ridiculous"}, Action: "priority"}}
+	tests := []struct {
+		name    string
+		global  []escalationRule
+		tenant  []escalationRule
+		tools   []string
+		wantErr string
+	}{
+		{name: "global rule with the tool listed", global: transferRule, tools: []string{transferTool.name}},
+		{name: "transfer tool listed", tenant: transferRule, tools: []string{transferTool.name}},
+		{name: "own rule without the tool", tenant: transferRule, tools: []string{saveDataTool.name}, wantErr: "escalation rule agent transfers"},
+		{name: "global rule without the tool", global: transferRule, tools: []string{saveDataTool.name}, wantErr: "escalation rule agent transfers"},
+		{name: "own rules replace the global ones", global: transferRule, tenant: priorityRule, tools: []string{saveDataTool.name}},
+	}
+	for _, tt := range tests {
+		config.File.Escalation = tt.global
+		config.File.Tenants = map[string]*tenantConfig{"acme": {ID: "acme", Tools: tt.tools, Escalation: tt.tenant}}
+		err := validateTenants()
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: got error %v", tt.name, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: got error %v, want one containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// eventSubscriberBuffer is how many events a consumer can fall behind
+// before it misses some.
+const eventSubscriberBuffer = 1024
+
+var (
+	eventsDroppedTotal     = newCounter("events_dropped_total", "Call events dropped because a consumer fell behind.", "consumer")
+	eventSinkFailuresTotal = newCounter("event_sink_failures_total", "Call events an event sink failed to publish.", "sink")
+)
+
+// callEvent is something that happened on a call, published as it happens
+// to the consumers of live call data such as the gRPC API and the event
+// sinks. Type is
+// call.started, call.ended, timeline, transcript.delta or transcript.done.
+type callEvent struct {
+	ID         string           `json:"id"`
+	Type       string           `json:"type"`
+	Time       time.Time        `json:"time"`
+	Call       callEventCall    `json:"call"`
+	Timeline   *timelineEvent   `json:"timeline,omitempty"`
+	Transcript *transcriptDelta `json:"transcript,omitempty"`
+	Ended      *callEnded       `json:"ended,omitempty"`
+}
+
+var callEventTypes = []string{"call.started", "call.ended", "timeline", "transcript.delta", "transcript.done"}
+
+type callEventCall struct {
+	ID          string `json:"id"`
+	CallSid     string `json:"call_sid"`
+	StreamSid   string `json:"stream_sid"`
+	PhoneNumber string `json:"phone_number"`
+	Line        string `json:"line,omitempty"`
+	Tenant      string `json:"tenant,omitempty"`
+	Model       string `json:"model"`
+}
+
+// transcriptDelta is new transcript text: a delta as the model
+// transcribes, or the whole turn once it is done.
+type transcriptDelta struct {
+	Role   string `json:"role"`
+	ItemID string `json:"item_id,omitempty"`
+	Text   string `json:"text"`
+}
+
+type callEnded struct {
+	Status          string                 `json:"status,omitempty"`
+	DurationSeconds float64                `json:"duration_seconds"`
+	InputTokens     int64                  `json:"input_tokens"`
+	OutputTokens    int64                  `json:"output_tokens"`
+	ToolCalls       int                    `json:"tool_calls"`
+	EstimatedCost   float64                `json:"estimated_cost"`
+	Metadata        map[string]interface{} `json:"metadata,omitempty"`
+}
+
+type eventSubscriber struct {
+	name   string
+	events chan callEvent
+}
+
+var eventSubscribers = struct {
+	sync.Mutex
+	list []*eventSubscriber
+}{}
+
+// subscribeEvents starts a consumer's stream of events. name labels the
+// events it drops for falling behind.
+func subscribeEvents(name string) *eventSubscriber {
+	sub := &eventSubscriber{name: name, events: make(chan callEvent, eventSubscriberBuffer)}
+	eventSubscribers.Lock()
+	defer eventSubscribers.Unlock()
+	eventSubscribers.list = append(eventSubscribers.list, sub)
+	return sub
+}
+
+func unsubscribeEvents(sub *eventSubscriber) {
+	eventSubscribers.Lock()
+	defer eventSubscribers.Unlock()
+	for i, other := range eventSubscribers.list {
+		if other == sub {
+			eventSubscribers.list = append(eventSubscribers.list[:i:i], eventSubscribers.list[i+1:]...)
+			return
+		}
+	}
+}
+
+func hasEventSubscribers() bool {
+	eventSubscribers.Lock()
+	defer eventSubscribers.Unlock()
+	return len(eventSubscribers.list) > 0
+}
+
+// publishEvent never blocks: a consumer whose buffer is full misses the
+// event.
+func publishEvent(event callEvent) {
+	eventSubscribers.Lock()
+	defer eventSubscribers.Unlock()
+	for _, sub := range eventSubscribers.list {
+		select {
+		case sub.events <- event:
+		default:
+			eventsDroppedTotal.add(1, sub.name)
+		}
+	}
+}
+
+// publish fills in the call and time of an event and publishes it. It
+// must be called without s.mu held.
+func (s *callSession) publish(event callEvent) {
+	if !hasEventSubscribers() {
+		return
+	}
+	event.ID = randomHex(8)
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	s.mu.Lock()
+	event.Call = callEventCall{
+		ID:          s.id,
+		CallSid:     s.call,
+		StreamSid:   s.stream,
+		PhoneNumber: s.phoneNumber,
+		Line:        s.line,
+		Tenant:      s.tenant.id(),
+		Model:       s.model,
+	}
+	s.mu.Unlock()
+	publishEvent(event)
+}
+
+// publishTranscriptDelta publishes new transcript text while the call is on the
+// record.
+func (s *callSession) publishTranscriptDelta(role, itemID, delta string) {
+	if delta == "" || s.recorder.offTheRecord() {
+		return
+	}
+	s.publish(callEvent{Type: "transcript.delta", Transcript: &transcriptDelta{Role: role, ItemID: itemID, Text: delta}})
+}
+
+// addTranscript adds a finished turn to the recorded transcript and
+// publishes it.
+func (s *callSession) addTranscript(role, itemID, text string) {
+	s.recorder.addTranscript(role, text)
+	if text == "" || s.recorder.offTheRecord() {
+		return
+	}
+	s.publish(callEvent{Type: "transcript.done", Transcript: &transcriptDelta{Role: role, ItemID: itemID, Text: text}})
+}
+
+// runEventSink hands every event of the given types, or of every type when
+// types is empty, to publish, one at a time, until the process exits.
+func runEventSink(name string, types []string, publish func(callEvent) error) {
+	sub := subscribeEvents(name)
+	for event := range sub.events {
+		if len(types) > 0 && !slices.Contains(types, event.Type) {
+			continue
+		}
+		if err := publish(event); err != nil {
+			log.Printf("Error publishing %s event to %s: %v\n", event.Type, name, err)
+			eventSinkFailuresTotal.add(1, name)
+		}
+	}
+}
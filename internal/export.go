@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+type ExportOptions struct {
+	Tenant      string
+	PhoneNumber string
+	Since       string
+	Limit       int
+	Output      string
+	NoSystem    bool
+	Metadata    bool
+}
+
+// Export writes stored calls as JSONL in OpenAI's chat format, one call per
+// line with its messages and tools, so transcripts can go straight into
+// fine-tuning and evals. Calls are written oldest first; those without a
+// saved transcript, or with nothing said, are skipped. The system prompt
+// and tools are the current configuration's, not necessarily the ones the
+// call had.
+func Export(opts ExportOptions) error {
+	filter := callLogFilter{Tenant: opts.Tenant, PhoneNumber: opts.PhoneNumber, Limit: opts.Limit}
+	if opts.Since != "" {
+		since, err := time.Parse(time.DateOnly, opts.Since)
+		if err != nil {
+			return fmt.Errorf("invalid date %q, expected YYYY-MM-DD", opts.Since)
+		}
+		filter.Since = since
+	}
+
+	store, err := openCallLogForCLI()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	calls, err := store.ListCalls(filter)
+	if err != nil {
+		return err
+	}
+	slices.Reverse(calls)
+
+	var out io.Writer = os.Stdout
+	if opts.Output != "" && opts.Output != "-" {
+		f, err := os.Create(opts.Output)
+		if err != nil {
+			return fmt.Errorf("error creating %s: %v", opts.Output, err)
+		}
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+
+	exported := 0
+	for _, call := range calls {
+		tenant := config.File.Tenants[call.Tenant]
+		name := call.CallSid
+		if name == "" {
+			name = call.ID
+		}
+		entries, err := store.Transcript(tenant.storageName(name))
+		if err != nil {
+			return fmt.Errorf("error reading transcript of %s: %v", name, err)
+		}
+		example := exportExample(call, tenant, entries, opts)
+		if example == nil {
+			continue
+		}
+		if err := enc.Encode(example); err != nil {
+			return fmt.Errorf("error writing export: %v", err)
+		}
+		exported++
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing export: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d of %d calls\n", exported, len(calls))
+	return nil
+}
+
+// exportExample turns a transcript into chat messages: the caller is the
+// user, and consecutive tool calls become one assistant message with
+// several tool_calls, followed by their tool results. System notes, such
+// as recording being paused, are left out.
+func exportExample(call callLogEntry, tenant *tenantConfig, entries []transcriptEntry, opts ExportOptions) map[string]interface{} {
+	messages := []map[string]interface{}{}
+	spoken := false
+	for _, entry := range entries {
+		switch entry.Role {
+		case "caller", "assistant":
+			role := "assistant"
+			if entry.Role == "caller" {
+				role = "user"
+			}
+			messages = append(messages, map[string]interface{}{"role": role, "content": entry.Text})
+			spoken = true
+		case "tool_call":
+			toolCall := map[string]interface{}{
+				"id":   entry.CallID,
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":      entry.Name,
+					"arguments": entry.Arguments,
+				},
+			}
+			if last := len(messages) - 1; last >= 0 && messages[last]["tool_calls"] != nil {
+				calls := messages[last]["tool_calls"].([]map[string]interface{})
+				messages[last]["tool_calls"] = append(calls, toolCall)
+				continue
+			}
+			messages = append(messages, map[string]interface{}{"role": "assistant", "tool_calls": []map[string]interface{}{toolCall}})
+		case "tool":
+			messages = append(messages, map[string]interface{}{"role": "tool", "tool_call_id": entry.CallID, "content": entry.Text})
+		}
+	}
+	if !spoken {
+		return nil
+	}
+
+	if !opts.NoSystem {
+		instructions, _ := tenant.prompts()
+		messages = append([]map[string]interface{}{{"role": "system", "content": instructions}}, messages...)
+	}
+	example := map[string]interface{}{"messages": messages}
+
+	// The Realtime API's tool definitions are flat; chat nests them under
+	// "function".
+	tools := []map[string]interface{}{}
+	for _, definition := range tenant.toolDefinitions() {
+		tools = append(tools, map[string]interface{}{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        definition["name"],
+				"description": definition["description"],
+				"parameters":  definition["parameters"],
+			},
+		})
+	}
+	if len(tools) > 0 {
+		example["tools"] = tools
+	}
+
+	if opts.Metadata {
+		example["metadata"] = map[string]interface{}{
+			"call_id":      call.ID,
+			"call_sid":     call.CallSid,
+			"phone_number": call.PhoneNumber,
+			"tenant":       call.Tenant,
+			"status":       call.Status,
+			"started_at":   call.StartedAt,
+		}
+	}
+	return example
+}
@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// failedWebhookMaxAge is how long a failed delivery is retried by the
+// redeliver_webhooks job before it is dropped.
+const failedWebhookMaxAge = 7 * 24 * time.Hour
+
+// failedWebhook is a delivery to a required target that failed every
+// attempt. Body is the payload before the target's template is applied.
+type failedWebhook struct {
+	ID       string                 `json:"id"`
+	Event    string                 `json:"event"`
+	URL      string                 `json:"url"`
+	Call     map[string]interface{} `json:"call,omitempty"`
+	Body     json.RawMessage        `json:"body"`
+	FailedAt time.Time              `json:"failed_at"`
+	Attempts int                    `json:"attempts"`
+	Error    string                 `json:"error"`
+}
+
+// failedWebhooks are kept for the redeliver_webhooks job, saved to
+// WEBHOOK_FAILURES_FILE on every change when that is set so they survive
+// restarts and can be redelivered from the CLI.
+var failedWebhooks = struct {
+	sync.Mutex
+	list []*failedWebhook
+}{}
+
+func loadFailedWebhooks() error {
+	if config.WebhookFailuresFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(config.WebhookFailuresFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", config.WebhookFailuresFile, err)
+	}
+
+	var list []*failedWebhook
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("error parsing %s: %v", config.WebhookFailuresFile, err)
+	}
+	failedWebhooks.Lock()
+	failedWebhooks.list = list
+	failedWebhooks.Unlock()
+	return nil
+}
+
+// saveFailedWebhooks must be called with failedWebhooks locked.
+func saveFailedWebhooks() {
+	if config.WebhookFailuresFile == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(failedWebhooks.list, "", "  ")
+	if err != nil {
+		log.Println("Error marshaling failed webhooks:", err)
+		return
+	}
+	if err := os.WriteFile(config.WebhookFailuresFile+".tmp", data, 0o600); err != nil {
+		log.Println("Error saving failed webhooks:", err)
+		return
+	}
+	if err := os.Rename(config.WebhookFailuresFile+".tmp", config.WebhookFailuresFile); err != nil {
+		log.Println("Error saving failed webhooks:", err)
+	}
+}
+
+func recordFailedWebhook(event string, call map[string]interface{}, url string, body []byte, err error) {
+	failedWebhooks.Lock()
+	defer failedWebhooks.Unlock()
+	failedWebhooks.list = append(failedWebhooks.list, &failedWebhook{
+		ID:       randomHex(8),
+		Event:    event,
+		URL:      url,
+		Call:     call,
+		Body:     body,
+		FailedAt: time.Now().UTC(),
+		Attempts: 1,
+		Error:    err.Error(),
+	})
+	saveFailedWebhooks()
+}
+
+func countFailedWebhooks() int {
+	failedWebhooks.Lock()
+	defer failedWebhooks.Unlock()
+	return len(failedWebhooks.list)
+}
+
+// redeliverFailedWebhooks retries every failed delivery whose target is
+// still configured. Deliveries that succeed, whose target is gone, or that
+// have failed for failedWebhookMaxAge are dropped.
+func redeliverFailedWebhooks() (string, error) {
+	failedWebhooks.Lock()
+	pending := append([]*failedWebhook{}, failedWebhooks.list...)
+	failedWebhooks.Unlock()
+
+	done := map[string]bool{}
+	delivered, failed := 0, 0
+	for _, f := range pending {
+		var target *webhookTarget
+		for _, t := range callWebhookTargets(f.Event, f.Call) {
+			if t.URL == f.URL {
+				target = &t
+				break
+			}
+		}
+		if target == nil {
+			log.Printf("Dropping failed %s webhook %s: %s is no longer a target\n", f.Event, f.ID, f.URL)
+			done[f.ID] = true
+			continue
+		}
+
+		// WEBHOOK_FAILURES_FILE holds the body indented.
+		var body bytes.Buffer
+		if err := json.Compact(&body, f.Body); err != nil {
+			body.Reset()
+			body.Write(f.Body)
+		}
+		err := target.deliver(f.Event, f.Call, body.Bytes())
+		failedWebhooks.Lock()
+		f.Attempts++
+		if err != nil {
+			f.Error = err.Error()
+		}
+		failedWebhooks.Unlock()
+		if err == nil {
+			delivered++
+			done[f.ID] = true
+			continue
+		}
+		failed++
+		if time.Since(f.FailedAt) > failedWebhookMaxAge {
+			log.Printf("Dropping failed %s webhook %s to %s after %d attempts: %v\n", f.Event, f.ID, f.URL, f.Attempts, err)
+			done[f.ID] = true
+		}
+	}
+
+	// Deliveries that failed meanwhile are kept.
+	failedWebhooks.Lock()
+	remaining := []*failedWebhook{}
+	for _, f := range failedWebhooks.list {
+		if !done[f.ID] {
+			remaining = append(remaining, f)
+		}
+	}
+	failedWebhooks.list = remaining
+	saveFailedWebhooks()
+	failedWebhooks.Unlock()
+
+	result := fmt.Sprintf("redelivered %d of %d failed webhooks", delivered, len(pending))
+	if failed > 0 {
+		return result, fmt.Errorf("%d still failing", failed)
+	}
+	return result, nil
+}
@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var failoversTotal = newCounter("failovers_total", "Calls redirected to FALLBACK_PHONE_NUMBER after an OpenAI failure.", "reason")
+
+// fatalOpenAIError reports whether an OpenAI error event means the session
+// can't continue, as opposed to a rejected client event.
+func fatalOpenAIError(event map[string]interface{}) bool {
+	details, _ := event["error"].(map[string]interface{})
+	errorType, _ := details["type"].(string)
+	code, _ := details["code"].(string)
+	return errorType == "server_error" || code == "session_expired" || code == "insufficient_quota" || code == "invalid_api_key"
+}
+
+// fallbackTwiML dials FALLBACK_PHONE_NUMBER, after FALLBACK_MESSAGE if set.
+func fallbackTwiML() string {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><Response>`)
+	if config.FallbackMessage != "" {
+		b.WriteString("<Say>")
+		xml.EscapeText(&b, []byte(config.FallbackMessage))
+		b.WriteString("</Say>")
+	}
+	b.WriteString("<Dial>")
+	xml.EscapeText(&b, []byte(config.FallbackPhoneNumber))
+	b.WriteString("</Dial></Response>")
+	return b.String()
+}
+
+// failover redirects the live call to unavailableTwiML instead of letting
+// it drop when the OpenAI side fails, so the caller is told and then put
+// through to the fallback number if there is one. Twilio then closes the
+// media stream, ending the session.
+func (s *callSession) failover(reason string) {
+	if err := failoverCall(s.callSid(), reason, s.tenant); err != nil {
+		log.Println("Error redirecting call after OpenAI failure:", err)
+		return
+	}
+	s.record("failover", reason)
+}
+
+func failoverCall(callSid, reason string, tenant *tenantConfig) error {
+	if callSid == "" {
+		return fmt.Errorf("call has not started")
+	}
+	if err := updateCallTwiML(callSid, unavailableTwiML(tenant)); err != nil {
+		return err
+	}
+	if config.FallbackPhoneNumber == "" {
+		log.Printf("Told caller on %s the assistant is unavailable after %s\n", callSid, reason)
+		return nil
+	}
+	failoversTotal.add(1, reason)
+	log.Printf("Redirected call %s to fallback number after %s\n", callSid, reason)
+	return nil
+}
+
+// unavailableTwiML apologizes to a caller whose assistant can't be reached,
+// with OPENAI_UNAVAILABLE_AUDIO_URL or else the tenant's openai_down
+// message, then goes on like fallbackTwiML if FALLBACK_PHONE_NUMBER is set
+// or hangs up.
+func unavailableTwiML(tenant *tenantConfig) string {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><Response>`)
+	if config.OpenAIUnavailableAudioURL != "" {
+		b.WriteString("<Play>")
+		xml.EscapeText(&b, []byte(config.OpenAIUnavailableAudioURL))
+		b.WriteString("</Play>")
+	} else if message := errorMessage(tenant, "openai_down", errorMessageData{Transfer: config.FallbackPhoneNumber != ""}); message != "" {
+		b.WriteString("<Say>")
+		xml.EscapeText(&b, []byte(message))
+		b.WriteString("</Say>")
+	}
+	if config.FallbackPhoneNumber == "" {
+		b.WriteString("<Hangup/></Response>")
+		return b.String()
+	}
+	// The rest is fallbackTwiML's.
+	b.WriteString(strings.TrimPrefix(fallbackTwiML(), `<?xml version="1.0" encoding="UTF-8"?><Response>`))
+	return b.String()
+}
+
+// failoverUnstartedStream handles OpenAI being unreachable when a media
+// stream connects, so that the caller doesn't sit in silence: it waits for
+// Twilio's start event to learn the CallSid and then redirects the call to
+// unavailableTwiML. backlog holds the messages already read.
+func failoverUnstartedStream(ws *websocket.Conn, backlog []map[string]interface{}, tenant *tenantConfig) {
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var data map[string]interface{}
+		if len(backlog) > 0 {
+			data, backlog = backlog[0], backlog[1:]
+		} else if err := ws.ReadJSON(&data); err != nil {
+			log.Println("Error reading from Twilio WebSocket:", err)
+			return
+		}
+		if data["event"] != "start" {
+			continue
+		}
+
+		start, _ := data["start"].(map[string]interface{})
+		callSid, _ := start["callSid"].(string)
+		if err := failoverCall(callSid, "openai_unavailable", tenant); err != nil {
+			log.Println("Error redirecting call after OpenAI failure:", err)
+		}
+		return
+	}
+}
@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"encoding/base64"
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// audioForkBuffer is how many messages wait for the fork's websocket before
+// frames are dropped, ten seconds of both tracks.
+const audioForkBuffer = 1000
+
+// audioFork mirrors a call's raw audio to AUDIO_FORK_URL, for example a
+// compliance recorder or a second transcription service. Messages follow
+// Twilio's stream protocol: a start event, media events tagged with an
+// "inbound" (caller) or "outbound" (assistant) track, then a stop event.
+// The fork never blocks the call; if its peer is slow or unreachable,
+// frames are dropped. Admins listening in get the same stream.
+type audioFork struct {
+	name      string
+	dial      func() (*websocket.Conn, error)
+	startedAt time.Time
+	frames    chan interface{}
+	done      chan struct{}
+	closeOnce sync.Once
+	failed    atomic.Bool
+}
+
+func newAudioFork() *audioFork {
+	if config.AudioForkURL == "" {
+		return nil
+	}
+	return startAudioFork("fork", func() (*websocket.Conn, error) {
+		dialer := &websocket.Dialer{HandshakeTimeout: 10 * time.Second}
+		conn, _, err := dialer.Dial(config.AudioForkURL, nil)
+		return conn, err
+	})
+}
+
+// newAudioListener forks a call's audio to an admin's websocket.
+func newAudioListener(conn *websocket.Conn) *audioFork {
+	return startAudioFork("listener", func() (*websocket.Conn, error) { return conn, nil })
+}
+
+func startAudioFork(name string, dial func() (*websocket.Conn, error)) *audioFork {
+	f := &audioFork{
+		name:      name,
+		dial:      dial,
+		startedAt: time.Now(),
+		frames:    make(chan interface{}, audioForkBuffer),
+		done:      make(chan struct{}),
+	}
+	go f.run()
+	return f
+}
+
+func (f *audioFork) start(s *callSession) {
+	if f == nil {
+		return
+	}
+	f.enqueue(map[string]interface{}{
+		"event": "start",
+		"start": map[string]interface{}{
+			"streamSid":   s.streamSid(),
+			"callSid":     s.callSid(),
+			"from":        s.phoneNumber,
+			"tracks":      []string{"inbound", "outbound"},
+			"mediaFormat": map[string]interface{}{"encoding": "audio/x-mulaw", "sampleRate": twilioSampleRate, "channels": 1},
+		},
+	})
+}
+
+func (f *audioFork) send(track string, audio []byte) {
+	if f == nil {
+		return
+	}
+	f.enqueue(map[string]interface{}{
+		"event": "media",
+		"media": map[string]interface{}{
+			"track":     track,
+			"timestamp": time.Since(f.startedAt).Milliseconds(),
+			"payload":   base64.StdEncoding.EncodeToString(audio),
+		},
+	})
+}
+
+func (f *audioFork) enqueue(msg interface{}) {
+	if f.failed.Load() {
+		return
+	}
+	select {
+	case f.frames <- msg:
+	default:
+		droppedFrames.add(1, f.name)
+	}
+}
+
+// close sends whatever is still queued, then the stop event.
+func (f *audioFork) close() {
+	if f == nil {
+		return
+	}
+	f.closeOnce.Do(func() { close(f.done) })
+}
+
+func (f *audioFork) run() {
+	conn, err := f.dial()
+	if err != nil {
+		log.Printf("Error connecting to audio %s: %v\n", f.name, err)
+		f.failed.Store(true)
+		return
+	}
+	defer conn.Close()
+
+	write := func(msg interface{}) bool {
+		setWriteDeadline(conn)
+		if err := conn.WriteJSON(msg); err != nil {
+			log.Printf("Error writing to audio %s: %v\n", f.name, err)
+			f.failed.Store(true)
+			return false
+		}
+		return true
+	}
+
+	for {
+		select {
+		case msg := <-f.frames:
+			if !write(msg) {
+				return
+			}
+		case <-f.done:
+			for {
+				select {
+				case msg := <-f.frames:
+					if !write(msg) {
+						return
+					}
+				default:
+					write(map[string]interface{}{"event": "stop"})
+					conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+					return
+				}
+			}
+		}
+	}
+}
+
+// teeAudio copies a frame of the call's audio to the fork and to admins
+// listening in.
+func (s *callSession) teeAudio(track string, audio []byte) {
+	s.fork.send(track, audio)
+	s.mu.Lock()
+	listeners := s.listeners
+	s.mu.Unlock()
+	for _, listener := range listeners {
+		listener.send(track, audio)
+	}
+}
+
+func (s *callSession) listen(conn *websocket.Conn) *audioFork {
+	listener := newAudioListener(conn)
+	listener.start(s)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.endedAt.IsZero() {
+		listener.close()
+		return listener
+	}
+	s.listeners = append(s.listeners, listener)
+	return listener
+}
+
+func (s *callSession) unlisten(listener *audioFork) {
+	s.mu.Lock()
+	s.listeners = slices.DeleteFunc(slices.Clone(s.listeners), func(l *audioFork) bool { return l == listener })
+	s.mu.Unlock()
+	listener.close()
+}
+
+func (s *callSession) closeListeners() {
+	s.mu.Lock()
+	listeners := s.listeners
+	s.listeners = nil
+	s.mu.Unlock()
+	for _, listener := range listeners {
+		listener.close()
+	}
+}
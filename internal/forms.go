@@ -0,0 +1,184 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// A form is a tool that gathers its arguments over several turns rather
+// than trusting the model to collect them all for one call. The model calls
+// it with whatever the caller has said so far. The server validates and
+// keeps each slot's value for the rest of the call, and answers with what
+// was saved, what was rejected and why, and what to ask next. Once every
+// required slot is filled, the details are read back to the caller, and
+// the backing action only runs when the model calls again with confirmed
+// set and nothing changed.
+type form struct {
+	name        string
+	description string
+	slots       []formSlot
+	// confirmation is a template over the slot values, read back to the
+	// caller before the form is submitted.
+	confirmation string
+	submit       func(s *callSession, values map[string]string) (string, error)
+}
+
+// formSlot is one value a form gathers, asked for with prompt in order.
+// validate rejects a value with the reason to give the caller, or returns
+// it normalized. A slot with readBack is repeated to the caller to check as
+// soon as it is given, as for spelled-out email addresses.
+type formSlot struct {
+	name        string
+	description string
+	prompt      string
+	optional    bool
+	readBack    bool
+	validate    func(value string) (string, error)
+}
+
+func newFormTool(f *form) *tool {
+	properties := map[string]interface{}{
+		"confirmed": map[string]string{"type": "boolean", "description": "True once the caller has confirmed the details read back to them"},
+	}
+	for _, slot := range f.slots {
+		properties[slot.name] = map[string]string{"type": "string", "description": slot.description}
+	}
+	return &tool{
+		name:        f.name,
+		description: f.description + " Call it with whatever details the caller has given so far, even just one; it keeps them and says what to ask next.",
+		parameters:  map[string]interface{}{"type": "object", "properties": properties},
+		run:         f.run,
+		form:        f,
+	}
+}
+
+func (f *form) run(s *callSession, arguments string) (string, error) {
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", fmt.Errorf("error parsing JSON: %v", err)
+	}
+
+	var saved, rejected, readBack []string
+	changed := false
+	s.mu.Lock()
+	if s.forms == nil {
+		s.forms = map[string]map[string]string{}
+	}
+	if s.forms[f.name] == nil {
+		s.forms[f.name] = map[string]string{}
+	}
+	values := s.forms[f.name]
+	for _, slot := range f.slots {
+		raw, _ := args[slot.name].(string)
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		value := raw
+		if slot.validate != nil {
+			var err error
+			if value, err = slot.validate(raw); err != nil {
+				rejected = append(rejected, fmt.Sprintf("%s %q: %v", slot.name, raw, err))
+				continue
+			}
+		}
+		changed = changed || values[slot.name] != value
+		values[slot.name] = value
+		saved = append(saved, slot.name)
+		if slot.readBack {
+			readBack = append(readBack, fmt.Sprintf("%s: %s", slot.name, value))
+		}
+	}
+	var next *formSlot
+	for i := range f.slots {
+		if !f.slots[i].optional && values[f.slots[i].name] == "" {
+			next = &f.slots[i]
+			break
+		}
+	}
+	filled := make(map[string]string, len(values))
+	for name, value := range values {
+		filled[name] = value
+	}
+	confirmed, _ := args["confirmed"].(bool)
+	submit := next == nil && confirmed && !changed && len(rejected) == 0
+	s.mu.Unlock()
+
+	if submit {
+		output, err := f.submit(s, filled)
+		if err != nil {
+			return "", err
+		}
+		s.mu.Lock()
+		delete(s.forms, f.name)
+		s.mu.Unlock()
+		s.record("form.submitted", f.name)
+		return output, nil
+	}
+	s.record("form.updated", fmt.Sprintf("%s: %d saved, %d rejected", f.name, len(saved), len(rejected)))
+
+	var b strings.Builder
+	if len(saved) > 0 {
+		fmt.Fprintf(&b, "Saved %s. ", strings.Join(saved, ", "))
+	}
+	if len(rejected) > 0 {
+		fmt.Fprintf(&b, "Not saved, ask the caller again: %s. ", strings.Join(rejected, "; "))
+	}
+	if len(readBack) > 0 {
+		fmt.Fprintf(&b, "Read this back to the caller to check it: %s. ", strings.Join(readBack, "; "))
+	}
+	if next != nil {
+		fmt.Fprintf(&b, "Next, ask the caller: %s", next.prompt)
+	} else {
+		fmt.Fprintf(&b, "All details are in. Read them back and ask the caller to confirm: %s. If they confirm, call %s again with confirmed set to true. If they correct something, call it with the corrected details.", f.confirmationText(filled), f.name)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+func (f *form) confirmationText(values map[string]string) string {
+	tmpl, err := template.New(f.name).Option("missingkey=zero").Parse(f.confirmation)
+	if err != nil {
+		return f.confirmation
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, values); err != nil {
+		return f.confirmation
+	}
+	return b.String()
+}
+
+func validateEmail(value string) (string, error) {
+	address, err := mail.ParseAddress(strings.ReplaceAll(value, " ", ""))
+	if err != nil || !strings.Contains(address.Address[strings.Index(address.Address, "@")+1:], ".") {
+		return "", fmt.Errorf("not a valid email address")
+	}
+	return strings.ToLower(address.Address), nil
+}
+
+// parseDateTime reads a date and time given as RFC 3339 or without a zone,
+// in local time.
+func parseDateTime(value string) (time.Time, error) {
+	var at time.Time
+	var err error
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04"} {
+		if at, err = time.ParseInLocation(layout, value, time.Local); err == nil {
+			return at, nil
+		}
+	}
+	return at, err
+}
+
+func validateFutureDateTime(value string) (string, error) {
+	at, err := parseDateTime(value)
+	if err != nil {
+		return "", fmt.Errorf("not a date and time in YYYY-MM-DDTHH:MM form")
+	}
+	if at.Before(time.Now()) {
+		return "", fmt.Errorf("in the past")
+	}
+	return at.Format(time.RFC3339), nil
+}
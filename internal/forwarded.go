@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// trustedProxies are the TRUSTED_PROXIES networks whose X-Forwarded-*
+// headers are believed.
+var trustedProxies []*net.IPNet
+
+func loadTrustedProxies() error {
+	networks, err := parseNetworks(config.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("error parsing TRUSTED_PROXIES: %v", err)
+	}
+	trustedProxies = networks
+	return nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP is the address of whoever made the request. Behind trusted
+// proxies, or LISTEN_SOCKET, it is the last address in X-Forwarded-For
+// that isn't one of them, since the addresses before it could have been
+// sent by the client.
+func clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if parsed := net.ParseIP(ip); !fromUnixSocket(r) && (parsed == nil || !isTrustedProxy(parsed)) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		parsed := net.ParseIP(hop)
+		if parsed == nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(parsed) {
+			break
+		}
+	}
+	return ip
+}
+
+// forwardedHeader returns the first value of an X-Forwarded-* header, the
+// one set by the proxy closest to the client. As with clientIP, it is only
+// believed from TRUSTED_PROXIES or LISTEN_SOCKET, so a client can't pick
+// the URL its signature is checked against.
+func forwardedHeader(r *http.Request, name string) string {
+	if !fromUnixSocket(r) {
+		ip := net.ParseIP(remoteIP(r))
+		if ip == nil || !isTrustedProxy(ip) {
+			return ""
+		}
+	}
+	value, _, _ := strings.Cut(r.Header.Get(name), ",")
+	return strings.TrimSpace(value)
+}
+
+// validTwilioSignature checks the X-Twilio-Signature header: an HMAC-SHA1,
+// keyed with the auth token, of the URL Twilio requested followed by the
+// sorted POST parameters. The URL is rebuilt from EXTERNAL_URL or the
+// forwarded headers, as Twilio saw it rather than as it reached us; media
+// streams are signed with their wss:// URL.
+func validTwilioSignature(r *http.Request) bool {
+	signature := r.Header.Get("X-Twilio-Signature")
+	authToken := secret("TWILIO_AUTH_TOKEN")
+	if signature == "" || authToken == "" {
+		return false
+	}
+	if err := r.ParseForm(); err != nil {
+		return false
+	}
+
+	var data strings.Builder
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		data.WriteString(streamURL(r, r.URL.RequestURI()))
+	} else {
+		data.WriteString(externalURL(r) + r.URL.RequestURI())
+	}
+	keys := make([]string, 0, len(r.PostForm))
+	for key := range r.PostForm {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values := append([]string{}, r.PostForm[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			data.WriteString(key + value)
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(data.String()))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return subtle.ConstantTimeCompare([]byte(signature), []byte(expected)) == 1
+}
@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// The parameters of Twilio's request validation example, with the
+// signatures worked out independently of validTwilioSignature.
+const (
+	exampleAuthToken       = "12345"
+	exampleURL             = "https://mycompany.com/myapp.php?foo=1&bar=2"
+	exampleSignature       = "hQB5VTHIpMUO6TFoLtwSh6arFMk="
+	exampleStreamPath      = "/media-stream/+18005551212"
+	exampleStreamSignature = "lWJSSYtTZISwUkx/qFdJXbODJMc="
+)
+
+var exampleParams = url.Values{
+	"CallSid": {"CA1234567890ABCDE"},
+	"Caller":  {"+12349013030"},
+	"Digits":  {"1234"},
+	"From":    {"+14158675309"},
+	"To":      {"+18005551212"},
+}
+
+func exampleRequest(target string, params url.Values) *http.Request {
+	r := httptest.NewRequest("POST", target, strings.NewReader(params.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Twilio-Signature", exampleSignature)
+	return r
+}
+
+func exampleStreamRequest(host string) *http.Request {
+	r := httptest.NewRequest("GET", "http://"+host+exampleStreamPath, nil)
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("X-Twilio-Signature", exampleStreamSignature)
+	return r
+}
+
+// fromUnix marks a request as having come in on LISTEN_SOCKET.
+func fromUnix(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, &net.UnixAddr{Name: "/run/voice.sock", Net: "unix"}))
+}
+
+func TestValidTwilioSignature(t *testing.T) {
+	external, proxies := config.ExternalURL, trustedProxies
+	t.Cleanup(func() { config.ExternalURL, trustedProxies = external, proxies })
+	config.ExternalURL = ""
+	_, proxy, _ := net.ParseCIDR("10.0.0.0/8")
+	trustedProxies = []*net.IPNet{proxy}
+	t.Setenv("TWILIO_AUTH_TOKEN", exampleAuthToken)
+
+	tampered := url.Values{}
+	for key, values := range exampleParams {
+		tampered[key] = values
+	}
+	tampered.Set("Digits", "4321")
+
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		want    bool
+	}{
+		{"https example", func() *http.Request { return exampleRequest(exampleURL, exampleParams) }, true},
+		{"changed parameter", func() *http.Request { return exampleRequest(exampleURL, tampered) }, false},
+		{"changed query", func() *http.Request {
+			return exampleRequest("https://mycompany.com/myapp.php?foo=1&bar=3", exampleParams)
+		}, false},
+		{"no signature", func() *http.Request {
+			r := exampleRequest(exampleURL, exampleParams)
+			r.Header.Del("X-Twilio-Signature")
+			return r
+		}, false},
+		{"wss example", func() *http.Request { return exampleStreamRequest("mycompany.com") }, true},
+		{"wss example at another host", func() *http.Request { return exampleStreamRequest("internal:8080") }, false},
+		{
+			// A client can't pick the URL its signature is checked against.
+			"forwarded host from an untrusted peer", func() *http.Request {
+				r := exampleRequest("http://internal:8080/myapp.php?foo=1&bar=2", exampleParams)
+				r.RemoteAddr = "203.0.113.7:4242"
+				r.Header.Set("X-Forwarded-Host", "mycompany.com")
+				return r
+			}, false,
+		},
+		{
+			"forwarded proto from an untrusted peer", func() *http.Request {
+				r := exampleRequest(exampleURL, exampleParams)
+				r.RemoteAddr = "203.0.113.7:4242"
+				r.Header.Set("X-Forwarded-Proto", "http")
+				return r
+			}, true,
+		},
+		{
+			"forwarded host from a trusted proxy", func() *http.Request {
+				r := exampleRequest("http://internal:8080/myapp.php?foo=1&bar=2", exampleParams)
+				r.RemoteAddr = "10.1.2.3:4242"
+				r.Header.Set("X-Forwarded-Host", "mycompany.com, internal:8080")
+				return r
+			}, true,
+		},
+		{
+			"forwarded host over a unix socket", func() *http.Request {
+				r := exampleStreamRequest("internal")
+				r.RemoteAddr = "@"
+				r.Header.Set("X-Forwarded-Host", "mycompany.com")
+				return fromUnix(r)
+			}, true,
+		},
+	}
+	for _, tt := range tests {
+		if got := validTwilioSignature(tt.request()); got != tt.want {
+			t.Errorf("%s: valid %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	config.ExternalURL = "https://mycompany.com"
+	r := exampleRequest("http://internal:8080/myapp.php?foo=1&bar=2", exampleParams)
+	if !validTwilioSignature(r) {
+		t.Errorf("with EXTERNAL_URL the signature isn't checked against it")
+	}
+}
+
+func TestForwardedHeader(t *testing.T) {
+	proxies := trustedProxies
+	t.Cleanup(func() { trustedProxies = proxies })
+	_, proxy, _ := net.ParseCIDR("10.0.0.0/8")
+	trustedProxies = []*net.IPNet{proxy}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		unix       bool
+		value      string
+		want       string
+	}{
+		{"untrusted peer", "203.0.113.7:4242", false, "mycompany.com", ""},
+		{"trusted proxy", "10.1.2.3:4242", false, "mycompany.com", "mycompany.com"},
+		{"closest to the client first", "10.1.2.3:4242", false, " mycompany.com , internal", "mycompany.com"},
+		{"unix socket", "@", true, "mycompany.com", "mycompany.com"},
+		{"unparseable peer", "not an address", false, "mycompany.com", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		r.Header.Set("X-Forwarded-Host", tt.value)
+		if tt.unix {
+			r = fromUnix(r)
+		}
+		if got := forwardedHeader(r, "X-Forwarded-Host"); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
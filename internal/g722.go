@@ -0,0 +1,271 @@
+package internal
+
+import "encoding/binary"
+
+// g722SampleRate is the rate of the audio G.722 carries, whatever clock
+// rate SDP gives it.
+const g722SampleRate = 16000
+
+// G.722 at 64 kbit/s, as in ITU-T G.722: a QMF splits 16kHz audio into a
+// lower and a higher band, coded with 6 and 2 bit ADPCM, so each pair of
+// samples becomes one byte. Both sides carry predictor state from byte to
+// byte, so each direction of a stream needs its own encoder or decoder for
+// the whole call.
+
+var (
+	g722QMF = [12]int{3, -11, 12, 32, -210, 951, 3876, -805, 362, -156, 53, -11}
+
+	g722Q6  = [32]int{0, 35, 72, 110, 150, 190, 233, 276, 323, 370, 422, 473, 530, 587, 650, 714, 786, 858, 940, 1023, 1121, 1219, 1339, 1458, 1612, 1765, 1980, 2195, 2557, 2919, 0, 0}
+	g722ILN = [32]int{0, 63, 62, 31, 30, 29, 28, 27, 26, 25, 24, 23, 22, 21, 20, 19, 18, 17, 16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 0}
+	g722ILP = [32]int{0, 61, 60, 59, 58, 57, 56, 55, 54, 53, 52, 51, 50, 49, 48, 47, 46, 45, 44, 43, 42, 41, 40, 39, 38, 37, 36, 35, 34, 33, 32, 0}
+	g722WL  = [8]int{-60, -30, 58, 172, 334, 538, 1198, 3042}
+	g722RL4 = [16]int{0, 7, 6, 5, 4, 3, 2, 1, 7, 6, 5, 4, 3, 2, 1, 0}
+	g722ILB = [32]int{2048, 2093, 2139, 2186, 2233, 2282, 2332, 2383, 2435, 2489, 2543, 2599, 2656, 2714, 2774, 2834, 2896, 2960, 3025, 3091, 3158, 3228, 3298, 3371, 3444, 3520, 3597, 3676, 3756, 3838, 3922, 4008}
+	g722QM4 = [16]int{0, -20456, -12896, -8968, -6288, -4240, -2584, -1200, 20456, 12896, 8968, 6288, 4240, 2584, 1200, 0}
+	g722QM6 = [64]int{
+		-136, -136, -136, -136, -24808, -21904, -19008, -16704,
+		-14984, -13512, -12280, -11192, -10232, -9360, -8576, -7856,
+		-7192, -6576, -6000, -5456, -4944, -4464, -4008, -3576,
+		-3168, -2776, -2400, -2032, -1688, -1360, -1040, -728,
+		24808, 21904, 19008, 16704, 14984, 13512, 12280, 11192,
+		10232, 9360, 8576, 7856, 7192, 6576, 6000, 5456,
+		4944, 4464, 4008, 3576, 3168, 2776, 2400, 2032,
+		1688, 1360, 1040, 728, 432, 136, -432, -136,
+	}
+	g722QM2 = [4]int{-7408, -1616, 7408, 1616}
+	g722IHN = [3]int{0, 1, 0}
+	g722IHP = [3]int{0, 3, 2}
+	g722WH  = [3]int{0, -214, 798}
+	g722RH2 = [4]int{2, 1, 2, 1}
+)
+
+func saturate16(v int) int {
+	return min(max(v, -32768), 32767)
+}
+
+// g722Band is the ADPCM state of one band.
+type g722Band struct {
+	s, sp, sz int
+	r, a, ap  [3]int
+	p         [3]int
+	d, b, bp  [7]int
+	sg        [7]int
+	nb, det   int
+}
+
+// scale updates the band's step size from its log scale factor nb.
+func (b *g722Band) scale(shift int) {
+	wd1 := (b.nb >> 6) & 31
+	wd2 := shift - (b.nb >> 11)
+	var wd3 int
+	if wd2 < 0 {
+		wd3 = g722ILB[wd1] << -wd2
+	} else {
+		wd3 = g722ILB[wd1] >> wd2
+	}
+	b.det = wd3 << 2
+}
+
+// adapt updates the band's predictor with a quantized difference, as
+// block 4 of the standard.
+func (b *g722Band) adapt(d int) {
+	b.d[0] = d
+	b.r[0] = saturate16(b.s + d)
+	b.p[0] = saturate16(b.sz + d)
+
+	// Second pole coefficient.
+	for i := 0; i < 3; i++ {
+		b.sg[i] = b.p[i] >> 15
+	}
+	wd1 := saturate16(b.a[1] << 2)
+	wd2 := wd1
+	if b.sg[0] == b.sg[1] {
+		wd2 = -wd1
+	}
+	wd2 = min(wd2, 32767)
+	wd3 := wd2 >> 7
+	if b.sg[0] == b.sg[2] {
+		wd3 += 128
+	} else {
+		wd3 -= 128
+	}
+	wd3 += (b.a[2] * 32512) >> 15
+	b.ap[2] = min(max(wd3, -12288), 12288)
+
+	// First pole coefficient.
+	b.sg[0] = b.p[0] >> 15
+	b.sg[1] = b.p[1] >> 15
+	wd1 = -192
+	if b.sg[0] == b.sg[1] {
+		wd1 = 192
+	}
+	wd2 = (b.a[1] * 32640) >> 15
+	b.ap[1] = saturate16(wd1 + wd2)
+	wd3 = saturate16(15360 - b.ap[2])
+	b.ap[1] = min(max(b.ap[1], -wd3), wd3)
+
+	// Zero coefficients.
+	wd1 = 128
+	if d == 0 {
+		wd1 = 0
+	}
+	b.sg[0] = d >> 15
+	for i := 1; i < 7; i++ {
+		b.sg[i] = b.d[i] >> 15
+		wd2 = -wd1
+		if b.sg[i] == b.sg[0] {
+			wd2 = wd1
+		}
+		wd3 = (b.b[i] * 32640) >> 15
+		b.bp[i] = saturate16(wd2 + wd3)
+	}
+
+	for i := 6; i > 0; i-- {
+		b.d[i] = b.d[i-1]
+		b.b[i] = b.bp[i]
+	}
+	for i := 2; i > 0; i-- {
+		b.r[i] = b.r[i-1]
+		b.p[i] = b.p[i-1]
+		b.a[i] = b.ap[i]
+	}
+
+	// Pole and zero predictor outputs.
+	wd1 = (b.a[1] * saturate16(b.r[1]+b.r[1])) >> 15
+	wd2 = (b.a[2] * saturate16(b.r[2]+b.r[2])) >> 15
+	b.sp = saturate16(wd1 + wd2)
+	b.sz = 0
+	for i := 6; i > 0; i-- {
+		b.sz += (b.b[i] * saturate16(b.d[i]+b.d[i])) >> 15
+	}
+	b.sz = saturate16(b.sz)
+	b.s = saturate16(b.sp + b.sz)
+}
+
+// adaptLow updates the lower band's scale and predictor for a 4 bit code.
+func (b *g722Band) adaptLow(code int) {
+	d := (b.det * g722QM4[code]) >> 15
+	b.nb = min(max((b.nb*127)>>7+g722WL[g722RL4[code]], 0), 18432)
+	b.scale(8)
+	b.adapt(d)
+}
+
+// adaptHigh updates the higher band's scale and predictor for its code,
+// returning the quantized difference.
+func (b *g722Band) adaptHigh(code int) int {
+	d := (b.det * g722QM2[code]) >> 15
+	b.nb = min(max((b.nb*127)>>7+g722WH[g722RH2[code]], 0), 22528)
+	b.scale(10)
+	b.adapt(d)
+	return d
+}
+
+func newG722Bands() [2]g722Band {
+	return [2]g722Band{{det: 32}, {det: 8}}
+}
+
+// g722Encoder codes 16kHz little-endian PCM16 as G.722. A sample that
+// doesn't complete a pair waits for the next chunk.
+type g722Encoder struct {
+	band    [2]g722Band
+	x       [24]int
+	pending []byte
+}
+
+func newG722Encoder() *g722Encoder {
+	return &g722Encoder{band: newG722Bands()}
+}
+
+func (e *g722Encoder) encode(pcm []byte) []byte {
+	data := append(e.pending, pcm...)
+	n := len(data) / 4
+	out := make([]byte, n)
+	for k := 0; k < n; k++ {
+		copy(e.x[:], e.x[2:])
+		e.x[22] = int(int16(binary.LittleEndian.Uint16(data[k*4:])))
+		e.x[23] = int(int16(binary.LittleEndian.Uint16(data[k*4+2:])))
+
+		sumEven, sumOdd := 0, 0
+		for i := 0; i < 12; i++ {
+			sumOdd += e.x[2*i] * g722QMF[i]
+			sumEven += e.x[2*i+1] * g722QMF[11-i]
+		}
+		xLow := (sumEven + sumOdd) >> 14
+		xHigh := (sumEven - sumOdd) >> 14
+
+		low := &e.band[0]
+		el := saturate16(xLow - low.s)
+		wd := el
+		if el < 0 {
+			wd = -(el + 1)
+		}
+		i := 1
+		for ; i < 30; i++ {
+			if wd < (g722Q6[i]*low.det)>>12 {
+				break
+			}
+		}
+		iLow := g722ILP[i]
+		if el < 0 {
+			iLow = g722ILN[i]
+		}
+		low.adaptLow(iLow >> 2)
+
+		high := &e.band[1]
+		eh := saturate16(xHigh - high.s)
+		wd = eh
+		if eh < 0 {
+			wd = -(eh + 1)
+		}
+		mih := 1
+		if wd >= (564*high.det)>>12 {
+			mih = 2
+		}
+		iHigh := g722IHP[mih]
+		if eh < 0 {
+			iHigh = g722IHN[mih]
+		}
+		high.adaptHigh(iHigh)
+
+		out[k] = byte(iHigh<<6 | iLow)
+	}
+	e.pending = append([]byte(nil), data[n*4:]...)
+	return out
+}
+
+// g722Decoder decodes G.722 to 16kHz little-endian PCM16.
+type g722Decoder struct {
+	band [2]g722Band
+	x    [24]int
+}
+
+func newG722Decoder() *g722Decoder {
+	return &g722Decoder{band: newG722Bands()}
+}
+
+func (d *g722Decoder) decode(g722 []byte) []byte {
+	out := make([]byte, 0, len(g722)*4)
+	for _, code := range g722 {
+		iLow, iHigh := int(code&0x3F), int(code>>6)
+
+		low := &d.band[0]
+		rLow := min(max(low.s+(low.det*g722QM6[iLow])>>15, -16384), 16383)
+		low.adaptLow(iLow >> 2)
+
+		high := &d.band[1]
+		rHigh := high.s
+		rHigh = min(max(rHigh+high.adaptHigh(iHigh), -16384), 16383)
+
+		copy(d.x[:], d.x[2:])
+		d.x[22] = rLow + rHigh
+		d.x[23] = rLow - rHigh
+		out1, out2 := 0, 0
+		for i := 0; i < 12; i++ {
+			out2 += d.x[2*i] * g722QMF[i]
+			out1 += d.x[2*i+1] * g722QMF[11-i]
+		}
+		out = binary.LittleEndian.AppendUint16(out, uint16(int16(saturate16(out1>>11))))
+		out = binary.LittleEndian.AppendUint16(out, uint16(int16(saturate16(out2>>11))))
+	}
+	return out
+}
@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+// snrAt is the signal to noise ratio of got against want delayed by lag
+// samples, in dB, skipping the first tenth while the codec adapts.
+func snrAt(want, got []byte, lag int) float64 {
+	var signal, noise float64
+	n := min(len(want), len(got))/2 - lag
+	for i := n / 10; i < n; i++ {
+		w := float64(int16(binary.LittleEndian.Uint16(want[i*2:])))
+		g := float64(int16(binary.LittleEndian.Uint16(got[(i+lag)*2:])))
+		signal += w * w
+		noise += (g - w) * (g - w)
+	}
+	return 10 * math.Log10(signal/noise)
+}
+
+func TestG722RoundTrip(t *testing.T) {
+	// The QMFs of the encoder and decoder together delay the audio by 22
+	// samples.
+	const lag = 22
+	tests := []struct {
+		freq   float64
+		minSNR float64
+	}{
+		{300, 40},
+		{1000, 30},
+		{3000, 30},
+		// The higher band only gets 2 bits a sample.
+		{5000, 15},
+		{7000, 15},
+	}
+	for _, tt := range tests {
+		pcm := tone(g722SampleRate, tt.freq, time.Second)
+		code := newG722Encoder().encode(pcm)
+		if len(code) != len(pcm)/4 {
+			t.Errorf("%vHz: encoded %d bytes of PCM to %d bytes, want %d", tt.freq, len(pcm), len(code), len(pcm)/4)
+		}
+		decoded := newG722Decoder().decode(code)
+		if len(decoded) != len(pcm) {
+			t.Errorf("%vHz: decoded %d bytes, want %d", tt.freq, len(decoded), len(pcm))
+		}
+		if snr := snrAt(pcm, decoded, lag); snr < tt.minSNR {
+			t.Errorf("%vHz: SNR %.1fdB, want at least %.0fdB", tt.freq, snr, tt.minSNR)
+		}
+	}
+}
+
+// chunked runs convert over data in chunks of the given sizes in turn, as
+// audio arrives in deltas and frames of any length.
+func chunked(data []byte, sizes []int, convert func([]byte) []byte) []byte {
+	var out []byte
+	for i := 0; len(data) > 0; i++ {
+		n := min(sizes[i%len(sizes)], len(data))
+		out = append(out, convert(data[:n])...)
+		data = data[n:]
+	}
+	return out
+}
+
+func TestConvertersCarryStateAcrossChunks(t *testing.T) {
+	// Odd sizes split samples, pairs and groups of samples between chunks.
+	sizes := []int{1, 7, 160, 3, 640, 5, 2}
+	wide := tone(g722SampleRate, 1000, 200*time.Millisecond)
+	full := tone(openAISampleRate, 1000, 200*time.Millisecond)
+	code := newG722Encoder().encode(wide)
+	mulaw := encodeMulaw(decodePCM16(tone(twilioSampleRate, 1000, 200*time.Millisecond)))
+
+	tests := []struct {
+		name    string
+		input   []byte
+		convert func() func([]byte) []byte
+	}{
+		{"g722 encoder", wide, func() func([]byte) []byte { return newG722Encoder().encode }},
+		{"g722 decoder", code, func() func([]byte) []byte { return newG722Decoder().decode }},
+		{"16kHz to 8kHz μ-law", wide, func() func([]byte) []byte { return (&wideNarrower{}).convert }},
+		{"8kHz μ-law to 16kHz", mulaw, func() func([]byte) []byte { return (&wideWidener{}).convert }},
+		{"16kHz to 24kHz", wide, func() func([]byte) []byte { return (&wideUpsampler{}).convert }},
+		{"24kHz to 16kHz", full, func() func([]byte) []byte { return (&wideDownsampler{}).convert }},
+	}
+	for _, tt := range tests {
+		whole := tt.convert()(tt.input)
+		if got := chunked(tt.input, sizes, tt.convert()); !bytes.Equal(got, whole) {
+			t.Errorf("%s: converting in chunks gave %d bytes that differ from the %d of converting at once", tt.name, len(got), len(whole))
+		}
+	}
+}
+
+func TestWideDownsamplerKeepsStepWithDownsampler(t *testing.T) {
+	// The bridge pairs each byte of μ-law with wideBytesPerByte bytes of
+	// 16kHz audio, however OpenAI's deltas are split.
+	var narrow downsampler
+	var wide wideDownsampler
+	audio := tone(openAISampleRate, 440, 100*time.Millisecond)
+	for _, n := range []int{1, 5, 6, 799, 800, 11} {
+		chunk := audio[:min(n, len(audio))]
+		audio = audio[len(chunk):]
+		m, w := narrow.convert(chunk), wide.convert(chunk)
+		if len(w) != len(m)*wideBytesPerByte {
+			t.Errorf("chunk of %d bytes gave %d bytes of μ-law and %d of 16kHz audio", n, len(m), len(w))
+		}
+	}
+}
+
+func TestStreamLeg(t *testing.T) {
+	tests := []struct {
+		encoding string
+		wideband bool
+		wantErr  bool
+	}{
+		{"", false, false},
+		{"audio/x-mulaw", false, false},
+		{"audio/G722", true, false},
+		{"audio/g722", true, false},
+		{"audio/opus", false, true},
+	}
+	for _, tt := range tests {
+		start := map[string]interface{}{"mediaFormat": map[string]interface{}{"encoding": tt.encoding}}
+		leg, err := streamLeg(start)
+		if (err != nil) != tt.wantErr || (leg != nil) != tt.wideband {
+			t.Errorf("streamLeg(%q) = %v, %v; want wideband %v, error %v", tt.encoding, leg, err, tt.wideband, tt.wantErr)
+		}
+	}
+}
+
+func decodePCM16(pcm []byte) []int16 {
+	samples := make([]int16, len(pcm)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(pcm[i*2:]))
+	}
+	return samples
+}
@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// greetingChunkBytes is how much cached greeting audio goes to Twilio per
+// media message, 500ms, so marks track playback closely enough to cut off.
+const greetingChunkBytes = twilioSampleRate / 2
+
+// With GREETING_CACHE the model's first response to the default
+// instructions and greeting is synthesized once at startup. Calls then play
+// it the moment the stream starts and add what it said to the conversation,
+// rather than waiting on a response from OpenAI.
+
+type cachedGreeting struct {
+	audio      []byte
+	transcript string
+}
+
+var greetingCache struct {
+	sync.Mutex
+	greeting *cachedGreeting
+}
+
+func getCachedGreeting() *cachedGreeting {
+	greetingCache.Lock()
+	defer greetingCache.Unlock()
+	return greetingCache.greeting
+}
+
+// warmGreetingCache synthesizes the greeting, retrying until it succeeds.
+// Calls answered in the meantime get a live greeting.
+func warmGreetingCache() {
+	for {
+		greeting, err := synthesizeGreeting()
+		if err == nil {
+			greetingCache.Lock()
+			greetingCache.greeting = greeting
+			greetingCache.Unlock()
+			log.Printf("Cached a %s greeting\n", time.Duration(len(greeting.audio))*time.Second/twilioSampleRate)
+			return
+		}
+		log.Println("Error synthesizing greeting:", err)
+		time.Sleep(time.Minute)
+	}
+}
+
+func synthesizeGreeting() (*cachedGreeting, error) {
+	conn, model, err := dialOpenAI(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to OpenAI: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Minute))
+
+	messages := append(greetingMessages(model, sessionConfig(config.SystemMessage), config.XMLResponse), map[string]interface{}{"type": "response.create"})
+	for _, msg := range messages {
+		if err := conn.WriteJSON(msg); err != nil {
+			return nil, fmt.Errorf("error sending message: %v", err)
+		}
+	}
+
+	greeting := &cachedGreeting{}
+	var ds downsampler
+	for {
+		var event map[string]interface{}
+		if err := conn.ReadJSON(&event); err != nil {
+			return nil, fmt.Errorf("error reading from OpenAI: %v", err)
+		}
+		switch normalizeRealtimeEvent(event) {
+		case "response.audio.delta":
+			delta, _ := event["delta"].(string)
+			audio, err := base64.StdEncoding.DecodeString(delta)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding audio delta: %v", err)
+			}
+			if transcodingAudio() {
+				audio = ds.convert(audio)
+			}
+			greeting.audio = append(greeting.audio, audio...)
+		case "response.audio_transcript.done":
+			greeting.transcript, _ = event["transcript"].(string)
+		case "error":
+			return nil, fmt.Errorf("error from OpenAI: %v", event["error"])
+		case "response.done":
+			response, _ := event["response"].(map[string]interface{})
+			if status, _ := response["status"].(string); status != "completed" {
+				return nil, fmt.Errorf("greeting response %s", status)
+			}
+			if len(greeting.audio) == 0 {
+				return nil, fmt.Errorf("greeting response had no audio")
+			}
+			return greeting, nil
+		}
+	}
+}
+
+// greetingStrategies are the ways a call can be greeted, chosen with
+// GREETING_STRATEGY or a tenant's greeting_strategy:
+//
+//   - message adds the greeting to the conversation as something the
+//     assistant said and asks for a response.
+//   - instruct asks for a response with the greeting as an instruction, so
+//     the model greets in its own words.
+//   - audio plays a clip from AUDIO_CLIPS_DIR.
+//   - say has Twilio say the greeting before the stream connects.
+//
+// With audio and say the model is only told the caller has been greeted.
+var greetingStrategies = []string{"message", "instruct", "audio", "say"}
+
+func validateGreetingStrategy(strategy, clip string) error {
+	if !slices.Contains(greetingStrategies, strategy) {
+		return fmt.Errorf("unknown greeting strategy %s: use %s", strategy, strings.Join(greetingStrategies, ", "))
+	}
+	if strategy == "audio" && (clip == "" || config.AudioClipsDir == "") {
+		return fmt.Errorf("the audio greeting strategy needs AUDIO_CLIPS_DIR and a greeting audio clip")
+	}
+	return nil
+}
+
+// instructedGreeting asks for the first response with the greeting as an
+// instruction. A response's instructions replace the session's, so they
+// are repeated.
+func instructedGreeting(instructions, greeting string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "response.create",
+		"response": map[string]interface{}{
+			"instructions": instructions + "\n\nStart the call by greeting the caller in your own words, along the lines of: " + greeting,
+		},
+	}
+}
+
+func greetedNote(greeting string) string {
+	return fmt.Sprintf("The caller has already been greeted with: %q. Don't greet them again; wait for them to speak.", greeting)
+}
+
+// greetingMessages configures the session and adds the greeting to the
+// conversation, leaving the response to the caller.
+func greetingMessages(model string, session map[string]interface{}, greeting string) []map[string]interface{} {
+	return []map[string]interface{}{
+		sessionUpdate(model, session),
+		assistantMessage(model, "greeting_01", greeting),
+	}
+}
+
+func assistantMessage(model, id, text string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "conversation.item.create",
+		"item": map[string]interface{}{
+			"id":   id,
+			"type": "message",
+			"role": "assistant",
+			"content": []map[string]interface{}{
+				{"type": assistantTextType(model), "text": text},
+			},
+		},
+	}
+}
+
+// playCachedGreeting plays the cached greeting like a response. It is
+// queued with no item ID, as there is no assistant audio item to truncate
+// if the caller talks over it.
+func (s *callSession) playCachedGreeting(greeting *cachedGreeting) {
+	s.record("greeting.cached", "")
+	s.addTranscript("assistant", "", greeting.transcript)
+	for offset := 0; offset < len(greeting.audio); offset += greetingChunkBytes {
+		chunk := greeting.audio[offset:min(offset+greetingChunkBytes, len(greeting.audio))]
+		if err := s.queueAudio("", chunk, nil); err != nil {
+			log.Println("Error sending cached greeting:", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/shakibhasan09/twilio-voice-openai/internal/callevents"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer and grpcListener are kept for handing the listener over to
+// a restarted process.
+var (
+	grpcServer   *grpc.Server
+	grpcListener net.Listener
+)
+
+// startGRPC serves the CallEvents gRPC API on GRPC_ADDR, with TLS when
+// GRPC_TLS_CERT and GRPC_TLS_KEY are set.
+func startGRPC() error {
+	lis := takePassedListener("grpc")
+	if config.GRPCAddr == "" && lis == nil {
+		return nil
+	}
+
+	var opts []grpc.ServerOption
+	if config.GRPCTLSCert != "" {
+		creds, err := credentials.NewServerTLSFromFile(config.GRPCTLSCert, config.GRPCTLSKey)
+		if err != nil {
+			return fmt.Errorf("error loading gRPC TLS certificate: %v", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	if lis == nil {
+		var err error
+		if lis, err = net.Listen("tcp", config.GRPCAddr); err != nil {
+			return fmt.Errorf("error listening on %s: %v", config.GRPCAddr, err)
+		}
+	}
+
+	server := grpc.NewServer(opts...)
+	callevents.RegisterCallEventsServer(server, callEventsServer{})
+	go func() {
+		if err := server.Serve(lis); err != nil {
+			log.Println("Error serving gRPC:", err)
+		}
+	}()
+	grpcServer, grpcListener = server, lis
+	log.Printf("gRPC API is listening on %s\n", lis.Addr())
+	return nil
+}
+
+type callEventsServer struct {
+	callevents.UnimplementedCallEventsServer
+}
+
+// StreamEvents needs the read scope, and the transcripts scope for
+// transcript events. Without it a stream of every type leaves them out.
+func (callEventsServer) StreamEvents(req *callevents.StreamEventsRequest, stream callevents.CallEvents_StreamEventsServer) error {
+	ctx := stream.Context()
+	actor, transcripts := "anonymous", true
+	if adminAuthEnabled() {
+		header := http.Header{}
+		md, _ := metadata.FromIncomingContext(ctx)
+		for key, values := range md {
+			for _, value := range values {
+				header.Add(key, value)
+			}
+		}
+		id, err := authenticateHeader(header)
+		if err != nil {
+			log.Println("Rejected gRPC StreamEvents:", err)
+			return status.Error(codes.Unauthenticated, "unauthorized")
+		}
+		if !hasScope(id.Scopes, scopeRead) {
+			return status.Error(codes.PermissionDenied, "missing scope "+scopeRead)
+		}
+		actor, transcripts = id.Actor, hasScope(id.Scopes, scopeTranscripts)
+	}
+
+	wantsTranscripts := false
+	for _, t := range req.Types {
+		if t == "transcript.delta" || t == "transcript.done" {
+			wantsTranscripts = true
+		}
+	}
+	if wantsTranscripts && !transcripts {
+		return status.Error(codes.PermissionDenied, "missing scope "+scopeTranscripts)
+	}
+
+	// Like a listen-in, a transcript stream is audited when it ends.
+	if transcripts && (wantsTranscripts || len(req.Types) == 0) {
+		remoteIP := ""
+		if p, ok := peer.FromContext(ctx); ok {
+			remoteIP = p.Addr.String()
+			if host, _, err := net.SplitHostPort(remoteIP); err == nil {
+				remoteIP = host
+			}
+		}
+		defer func() {
+			appendAudit(auditEntry{
+				Time:     time.Now().UTC(),
+				Actor:    actor,
+				Action:   "grpc StreamEvents",
+				Call:     req.Call,
+				Status:   http.StatusOK,
+				RemoteIP: remoteIP,
+			})
+		}()
+	}
+
+	sub := subscribeEvents("grpc")
+	defer unsubscribeEvents(sub)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-sub.events:
+			if req.Tenant != "" && event.Call.Tenant != req.Tenant {
+				continue
+			}
+			if req.Call != "" && event.Call.CallSid != req.Call && event.Call.ID != req.Call {
+				continue
+			}
+			if len(req.Types) > 0 && !slices.Contains(req.Types, event.Type) {
+				continue
+			}
+			if event.Transcript != nil && !transcripts {
+				continue
+			}
+			if err := stream.Send(callEventProto(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func callEventProto(event callEvent) *callevents.CallEvent {
+	msg := &callevents.CallEvent{
+		Type: event.Type,
+		Time: timestamppb.New(event.Time),
+		Call: &callevents.Call{
+			Id:          event.Call.ID,
+			CallSid:     event.Call.CallSid,
+			StreamSid:   event.Call.StreamSid,
+			PhoneNumber: event.Call.PhoneNumber,
+			Line:        event.Call.Line,
+			Tenant:      event.Call.Tenant,
+			Model:       event.Call.Model,
+		},
+	}
+	switch {
+	case event.Timeline != nil:
+		msg.Payload = &callevents.CallEvent_Timeline{Timeline: &callevents.TimelineEntry{
+			Event:    event.Timeline.Event,
+			Detail:   event.Timeline.Detail,
+			OffsetMs: event.Timeline.OffsetMs,
+		}}
+	case event.Transcript != nil:
+		msg.Payload = &callevents.CallEvent_Transcript{Transcript: &callevents.Transcript{
+			Role:   event.Transcript.Role,
+			ItemId: event.Transcript.ItemID,
+			Text:   event.Transcript.Text,
+		}}
+	case event.Ended != nil:
+		msg.Payload = &callevents.CallEvent_Ended{Ended: &callevents.CallEnded{
+			Status:          event.Ended.Status,
+			DurationSeconds: event.Ended.DurationSeconds,
+			InputTokens:     event.Ended.InputTokens,
+			OutputTokens:    event.Ended.OutputTokens,
+			ToolCalls:       int32(event.Ended.ToolCalls),
+			EstimatedCost:   event.Ended.EstimatedCost,
+			Metadata:        metadataStruct(event.Ended.Metadata),
+		}}
+	}
+	return msg
+}
+
+// metadataStruct converts call metadata by way of its JSON, as sent in
+// webhooks, since it can hold any value a tool or admin set.
+func metadataStruct(metadata map[string]interface{}) *structpb.Struct {
+	if len(metadata) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		log.Println("Error encoding call metadata for gRPC:", err)
+		return nil
+	}
+	var msg structpb.Struct
+	if err := msg.UnmarshalJSON(data); err != nil {
+		log.Println("Error encoding call metadata for gRPC:", err)
+		return nil
+	}
+	return &msg
+}
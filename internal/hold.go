@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const maxHoldSeconds = 300
+
+var holdAudio struct {
+	once  sync.Once
+	audio []byte
+}
+
+// holdMusic returns HOLD_AUDIO as μ-law, or a frame of silence when it isn't
+// set or can't be read.
+func holdMusic() []byte {
+	holdAudio.once.Do(func() {
+		holdAudio.audio = silenceFrame()
+		if config.HoldAudio == "" {
+			return
+		}
+		samples, err := readWAV(config.HoldAudio, twilioSampleRate)
+		if err != nil {
+			log.Println("Error reading hold audio:", err)
+			return
+		}
+		if len(samples) > 0 {
+			holdAudio.audio = encodeMulaw(samples)
+		}
+	})
+	return holdAudio.audio
+}
+
+var holdTool = &tool{
+	name:        "hold_call",
+	description: "Put the caller on hold with hold music, when they ask you to wait or need a moment. Tell the caller before calling this.",
+	parameters: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"seconds": map[string]interface{}{"type": "integer", "description": "How long to hold, up to 300 seconds."},
+		},
+		"required": []string{"seconds"},
+	},
+	enabled: func() bool { return !sipMode() },
+	silent:  true,
+	run: func(s *callSession, arguments string) (string, error) {
+		var args struct {
+			Seconds int `json:"seconds"`
+		}
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return "", fmt.Errorf("error parsing JSON: %v", err)
+		}
+		seconds := min(max(args.Seconds, 1), maxHoldSeconds)
+
+		s.hold("tool")
+		time.AfterFunc(time.Duration(seconds)*time.Second, func() { s.resume(true) })
+		return fmt.Sprintf("The caller is on hold for %d seconds.", seconds), nil
+	},
+}
+
+// hold pauses the bridge: the model's current response is cut off, caller
+// audio stops going to OpenAI and hold music plays until resume.
+func (s *callSession) hold(reason string) {
+	if s.sip() {
+		return
+	}
+
+	s.mu.Lock()
+	if s.holdStop != nil {
+		s.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	s.holdStop = stop
+	responding := s.responding
+	s.mu.Unlock()
+
+	s.interrupt()
+	if responding {
+		if err := s.sendOpenAI(map[string]interface{}{"type": "response.cancel"}); err != nil {
+			log.Println("Error sending response cancel:", err)
+		}
+	}
+	s.record("hold", reason)
+
+	go s.playHoldMusic(stop)
+}
+
+// resume takes the caller off hold. With prompt set the model is told and
+// asked to respond; otherwise the caller is handed back silently, e.g. to a
+// tool result that is already on its way.
+func (s *callSession) resume(prompt bool) {
+	s.mu.Lock()
+	stop := s.holdStop
+	s.holdStop = nil
+	s.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+
+	s.twilioOut.dropAudio()
+	if err := s.sendTwilio(map[string]interface{}{"event": "clear", "streamSid": s.streamSid()}); err != nil {
+		log.Println("Error sending clear to Twilio:", err)
+	}
+	if err := s.sendOpenAI(map[string]interface{}{"type": "input_audio_buffer.clear"}); err != nil {
+		log.Println("Error sending input audio buffer clear:", err)
+	}
+	s.record("resume", "")
+
+	if !prompt {
+		return
+	}
+	s.awaitRateLimit()
+	note := systemNote("The caller has been taken off hold. Thank them for holding and continue.")
+	for _, msg := range []map[string]interface{}{note, {"type": "response.create"}} {
+		if err := s.sendOpenAI(msg); err != nil {
+			log.Println("Error sending resume message:", err)
+		}
+	}
+}
+
+func (s *callSession) onHold() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.holdStop != nil
+}
+
+// playHoldMusic loops the hold audio to Twilio in real time.
+func (s *callSession) playHoldMusic(stop <-chan struct{}) {
+	defer s.recoverPanic("hold_music")
+	s.streamAudio(holdMusic(), true, stop)
+}
+
+// streamAudio sends μ-law audio to Twilio in real time, one frame every
+// 20ms, until it ends (or forever with loop), stop is closed or the call
+// ends. It reports whether all of the audio was sent.
+func (s *callSession) streamAudio(audio []byte, loop bool, stop <-chan struct{}) bool {
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+
+	for offset := 0; loop || offset < len(audio); {
+		select {
+		case <-stop:
+			return false
+		case <-s.done:
+			return false
+		case <-ticker.C:
+		}
+
+		frame := make([]byte, 0, twilioFrameBytes)
+		for len(frame) < twilioFrameBytes && (loop || offset < len(audio)) {
+			end := min(offset+twilioFrameBytes-len(frame), len(audio))
+			frame = append(frame, audio[offset:end]...)
+			offset = end
+			if loop {
+				offset %= len(audio)
+			}
+		}
+		if err := s.twilioOut.sendAudio(frame); err != nil {
+			return false
+		}
+	}
+	return true
+}
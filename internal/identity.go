@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const identityMaxAttempts = 3
+
+var identityTool = &tool{
+	name:        "verify_identity",
+	description: "Check the caller's identity details against our records. Ask the caller for each detail before calling this.",
+	parameters: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name":           map[string]string{"type": "string", "description": "The caller's full name"},
+			"date_of_birth":  map[string]string{"type": "string", "format": "date", "description": "The caller's date of birth"},
+			"account_number": map[string]string{"type": "string", "description": "The caller's account number"},
+		},
+	},
+	enabled: identityConfigured,
+	run: func(s *callSession, arguments string) (string, error) {
+		var data map[string]string
+		if err := json.Unmarshal([]byte(arguments), &data); err != nil {
+			return "", fmt.Errorf("error parsing JSON: %v", err)
+		}
+		return s.verifyIdentity(data)
+	},
+}
+
+func identityConfigured() bool {
+	return len(webhookTargets("verify_identity")) > 0
+}
+
+// verifyIdentity sends the VERIFICATION_FIELDS the caller gave to the
+// verify_identity webhook, which answers {"verified": true} on a match.
+func (s *callSession) verifyIdentity(data map[string]string) (string, error) {
+	if s.verifiedBy() != "" {
+		return "The caller is already verified.", nil
+	}
+
+	var missing []string
+	payload := map[string]string{"call_sid": s.callSid(), "phone_number": s.phoneNumber}
+	for _, field := range config.VerificationFields {
+		value := strings.TrimSpace(data[field])
+		if value == "" {
+			missing = append(missing, strings.ReplaceAll(field, "_", " "))
+		}
+		payload[field] = value
+	}
+	if len(missing) > 0 {
+		return "Ask the caller for their " + strings.Join(missing, " and ") + ", then try again.", nil
+	}
+
+	s.mu.Lock()
+	if s.identityAttempts >= identityMaxAttempts {
+		s.mu.Unlock()
+		return "Too many failed attempts. The caller cannot be verified on this call.", nil
+	}
+	s.identityAttempts++
+	attemptsLeft := identityMaxAttempts - s.identityAttempts
+	s.mu.Unlock()
+
+	var result struct {
+		Verified bool                   `json:"verified"`
+		Metadata map[string]interface{} `json:"metadata"`
+	}
+	if err := queryWebhook("verify_identity", s.summary(), payload, &result); err != nil {
+		verificationsTotal.addFor(s, 1, "identity", "error")
+		return "", fmt.Errorf("error verifying identity: %v", err)
+	}
+	s.setMetadata(result.Metadata, "verify_identity webhook")
+
+	if !result.Verified {
+		verificationsTotal.addFor(s, 1, "identity", "failure")
+		s.record("verification.failure", "identity")
+		if attemptsLeft <= 0 {
+			return "The details don't match our records and no attempts are left. The caller cannot be verified on this call.", nil
+		}
+		return fmt.Sprintf("The details don't match our records. The caller has %d more attempts.", attemptsLeft), nil
+	}
+
+	s.mu.Lock()
+	s.verified = "identity"
+	s.mu.Unlock()
+	verificationsTotal.addFor(s, 1, "identity", "success")
+	s.record("verification.success", "identity")
+	return "The details match. The caller is verified.", nil
+}
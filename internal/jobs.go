@@ -0,0 +1,246 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+var jobRunsTotal = newCounter("job_runs_total", "Background job runs.", "job", "result")
+
+// job is a batch post-processing task over stored calls. It runs on a cron
+// schedule from "jobs" in CONFIG_FILE, from POST /admin/jobs/{name}/run, or
+// once with the jobs run command. run is given the calls to look at by
+// start time; jobs that don't work on calls ignore it.
+type job struct {
+	name        string
+	description string
+	run         func(from, to time.Time) (string, error)
+}
+
+var jobs = []*job{summarizeJob, sentimentJob, redeliverWebhooksJob}
+
+var redeliverWebhooksJob = &job{
+	name:        "redeliver_webhooks",
+	description: "Retry webhook deliveries that failed every attempt.",
+	run: func(from, to time.Time) (string, error) {
+		return redeliverFailedWebhooks()
+	},
+}
+
+type jobStatus struct {
+	Name         string     `json:"name"`
+	Description  string     `json:"description"`
+	Schedule     string     `json:"schedule,omitempty"`
+	NextRun      *time.Time `json:"next_run,omitempty"`
+	Running      bool       `json:"running"`
+	Runs         int        `json:"runs"`
+	Failures     int        `json:"failures"`
+	LastStarted  *time.Time `json:"last_started,omitempty"`
+	LastFinished *time.Time `json:"last_finished,omitempty"`
+	LastResult   string     `json:"last_result,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+var jobStatuses = struct {
+	sync.Mutex
+	byName map[string]*jobStatus
+}{byName: map[string]*jobStatus{}}
+
+func findJob(name string) *job {
+	for _, j := range jobs {
+		if j.name == name {
+			return j
+		}
+	}
+	return nil
+}
+
+func validateJobSchedules() error {
+	for name, spec := range config.File.Jobs {
+		if findJob(name) == nil {
+			return fmt.Errorf("unknown job %s", name)
+		}
+		if _, err := parseCron(spec); err != nil {
+			return fmt.Errorf("job %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func jobStatusFor(j *job) *jobStatus {
+	status, ok := jobStatuses.byName[j.name]
+	if !ok {
+		status = &jobStatus{Name: j.name, Description: j.description, Schedule: config.File.Jobs[j.name]}
+		jobStatuses.byName[j.name] = status
+	}
+	return status
+}
+
+// startJobSchedules runs each scheduled job at its times. A run that is
+// still going when the next is due skips that one.
+func startJobSchedules() {
+	for name, spec := range config.File.Jobs {
+		j := findJob(name)
+		schedule, _ := parseCron(spec)
+		go func() {
+			for {
+				next := schedule.next(time.Now())
+				jobStatuses.Lock()
+				jobStatusFor(j).NextRun = &next
+				jobStatuses.Unlock()
+
+				time.Sleep(time.Until(next))
+				if _, err := runJob(j, time.Time{}); err != nil {
+					log.Printf("Error running job %s: %v\n", j.name, err)
+				}
+			}
+		}()
+		log.Printf("Scheduled job %s at %q\n", name, spec)
+	}
+}
+
+// defaultJobWindow is yesterday, UTC.
+func defaultJobWindow() (time.Time, time.Time) {
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	return to.AddDate(0, 0, -1), to
+}
+
+// runJob runs j over calls started since from, or yesterday's calls when
+// from is zero, and records the outcome.
+func runJob(j *job, from time.Time) (string, error) {
+	jobStatuses.Lock()
+	status := jobStatusFor(j)
+	if status.Running {
+		jobStatuses.Unlock()
+		return "", fmt.Errorf("job %s is already running", j.name)
+	}
+	started := time.Now().UTC()
+	status.Running, status.LastStarted = true, &started
+	jobStatuses.Unlock()
+
+	to := time.Now().UTC()
+	if from.IsZero() {
+		from, to = defaultJobWindow()
+	}
+	log.Printf("Running job %s\n", j.name)
+	result, err := j.run(from, to)
+
+	jobStatuses.Lock()
+	defer jobStatuses.Unlock()
+	finished := time.Now().UTC()
+	status.Running, status.LastFinished, status.LastResult, status.LastError = false, &finished, result, ""
+	status.Runs++
+	if err != nil {
+		status.Failures++
+		status.LastError = err.Error()
+		jobRunsTotal.add(1, j.name, "error")
+		reportError("job", fmt.Sprintf("job %s failed: %v", j.name, err), map[string]string{"job": j.name}, nil)
+		return result, err
+	}
+	jobRunsTotal.add(1, j.name, "ok")
+	log.Printf("Job %s finished: %s\n", j.name, result)
+	return result, nil
+}
+
+func listJobStatuses() []jobStatus {
+	jobStatuses.Lock()
+	defer jobStatuses.Unlock()
+	list := []jobStatus{}
+	for _, j := range jobs {
+		list = append(list, *jobStatusFor(j))
+	}
+	return list
+}
+
+func handleAdminListJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"jobs": listJobStatuses(), "failed_webhooks": countFailedWebhooks()})
+}
+
+// handleAdminRunJob starts a job in the background. An optional since
+// query parameter (YYYY-MM-DD) widens it from yesterday's calls to every
+// call since that day.
+func handleAdminRunJob(w http.ResponseWriter, r *http.Request) {
+	j := findJob(r.PathValue("name"))
+	if j == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no such job"})
+		return
+	}
+	var from time.Time
+	if since := r.URL.Query().Get("since"); since != "" {
+		var err error
+		if from, err = time.Parse(time.DateOnly, since); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "since must be YYYY-MM-DD"})
+			return
+		}
+	}
+
+	jobStatuses.Lock()
+	running := jobStatusFor(j).Running
+	jobStatuses.Unlock()
+	if running {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "job is already running"})
+		return
+	}
+	go func() {
+		if _, err := runJob(j, from); err != nil {
+			log.Printf("Error running job %s: %v\n", j.name, err)
+		}
+	}()
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "started"})
+}
+
+// ListJobs prints the jobs and their schedules from CONFIG_FILE.
+func ListJobs() error {
+	readConfig()
+	if err := validateJobSchedules(); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(jobs))
+	for _, j := range jobs {
+		names = append(names, j.name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Job\tSchedule\tDescription")
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, orDash(config.File.Jobs[name]), findJob(name).description)
+	}
+	return w.Flush()
+}
+
+// RunJob runs a job once from the CLI, over calls started on or after since
+// (YYYY-MM-DD), or yesterday's calls when it is empty.
+func RunJob(name, since string) error {
+	readConfig()
+	j := findJob(name)
+	if j == nil {
+		return fmt.Errorf("unknown job %s", name)
+	}
+	var from time.Time
+	if since != "" {
+		var err error
+		if from, err = time.Parse(time.DateOnly, since); err != nil {
+			return fmt.Errorf("invalid date %q, expected YYYY-MM-DD", since)
+		}
+	}
+	if err := initStorage(); err != nil {
+		return err
+	}
+	defer storage.Close()
+	if err := loadFailedWebhooks(); err != nil {
+		return err
+	}
+
+	result, err := runJob(j, from)
+	if result != "" {
+		fmt.Println(result)
+	}
+	return err
+}
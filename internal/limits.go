@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// talkTimeWarning is the share of MAX_TALK_TIME after which the model is
+// asked to start wrapping up.
+const talkTimeWarning = 0.8
+
+// Output limits keep the assistant from talking too long. MAX_RESPONSE_TOKENS
+// caps each response in the session config. MAX_RESPONSE_AUDIO cuts a
+// response off once that much of its audio has been sent, and MAX_TALK_TIME
+// caps the assistant's audio over the whole call. The model is steered to be
+// brief when a response is cut off and as talk time runs low; once it is
+// used up the assistant says goodbye and the call ends.
+
+type outputLimits struct {
+	item      string
+	itemBytes int
+	cutItem   string
+	talkBytes int
+	warned    bool
+	// goodbye is set when talk time runs out, until the goodbye response
+	// is created; goodbyeID is then that response's ID.
+	goodbye   bool
+	goodbyeID string
+}
+
+func outputLimitsEnabled() bool {
+	return config.MaxResponseAudio > 0 || config.MaxTalkTime > 0
+}
+
+// limitAudio counts a chunk of assistant audio against the limits and
+// reports whether it may be played.
+func (s *callSession) limitAudio(itemID string, audio []byte) bool {
+	if !outputLimitsEnabled() {
+		return true
+	}
+	maxResponse := int(config.MaxResponseAudio.Seconds() * twilioSampleRate)
+	maxTalk := int(config.MaxTalkTime.Seconds() * twilioSampleRate)
+
+	s.mu.Lock()
+	limits := &s.limits
+	if itemID != "" && itemID == limits.cutItem {
+		s.mu.Unlock()
+		return false
+	}
+	if itemID != limits.item {
+		limits.item, limits.itemBytes = itemID, 0
+	}
+	sentMs := int64(limits.itemBytes / (twilioSampleRate / 1000))
+	talkUp := maxTalk > 0 && !limits.goodbye && limits.goodbyeID == "" && limits.talkBytes+len(audio) > maxTalk
+	cut := talkUp || (maxResponse > 0 && limits.itemBytes+len(audio) > maxResponse)
+	warn := maxTalk > 0 && !limits.warned && limits.talkBytes+len(audio) > int(float64(maxTalk)*talkTimeWarning)
+	if cut {
+		limits.cutItem = itemID
+		limits.goodbye = limits.goodbye || talkUp
+	} else {
+		limits.itemBytes += len(audio)
+		limits.talkBytes += len(audio)
+	}
+	limits.warned = limits.warned || warn
+	s.mu.Unlock()
+
+	var messages []map[string]interface{}
+	if cut {
+		messages = append(messages, map[string]interface{}{"type": "response.cancel"})
+		if itemID != "" {
+			messages = append(messages, map[string]interface{}{
+				"type":          "conversation.item.truncate",
+				"item_id":       itemID,
+				"content_index": 0,
+				"audio_end_ms":  sentMs,
+			})
+		}
+	}
+	switch {
+	case talkUp:
+		s.record("talk_time.limit", config.MaxTalkTime.String())
+		messages = append(messages,
+			systemNote("This call has used up its talk time. Say a brief goodbye now, in one sentence."),
+			map[string]interface{}{"type": "response.create"})
+	case cut:
+		s.record("response.limit", fmt.Sprintf("%s at %dms", itemID, sentMs))
+		messages = append(messages, systemNote(fmt.Sprintf(
+			"Your last answer was cut off after %s because it ran too long. Keep answers well under that.", config.MaxResponseAudio)))
+	case warn:
+		s.record("talk_time.warning", "")
+		messages = append(messages, systemNote("This call is close to its talk-time limit. Keep answers short and start wrapping up."))
+	}
+	for _, msg := range messages {
+		if err := s.sendOpenAI(msg); err != nil {
+			log.Println("Error sending output limit message:", err)
+		}
+	}
+	return !cut
+}
+
+// trackGoodbye follows the goodbye response once talk time is up and ends
+// the call when it has finished playing.
+func (s *callSession) trackGoodbye(eventType string, response map[string]interface{}) {
+	if config.MaxTalkTime <= 0 || (eventType != "response.created" && eventType != "response.done") {
+		return
+	}
+	resp, _ := response["response"].(map[string]interface{})
+	id, _ := resp["id"].(string)
+
+	s.mu.Lock()
+	limits := &s.limits
+	done := false
+	switch {
+	case eventType == "response.created" && limits.goodbye:
+		limits.goodbye, limits.goodbyeID = false, id
+	case eventType == "response.done" && id != "" && id == limits.goodbyeID:
+		done = true
+	}
+	s.mu.Unlock()
+
+	if done {
+		go s.hangupAfterPlayback()
+	}
+}
+
+func (s *callSession) hangupAfterPlayback() {
+	deadline := time.Now().Add(15 * time.Second)
+	for s.isPlaying() && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	s.record("call.hangup", "talk_time")
+	s.hangup()
+}
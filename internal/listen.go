@@ -0,0 +1,269 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// routeGroups are what a listener can be restricted to serving with
+// PORT_ROUTES, TLS_PORT_ROUTES and LISTEN_SOCKET_ROUTES.
+var routeGroups = []string{"twilio", "openai", "realtime", "health", "metrics", "admin"}
+
+// routeGroup is the group a request path belongs to.
+func routeGroup(path string) string {
+	switch {
+	case path == "/incoming-call" || path == "/call-status" || path == "/callback-request",
+		strings.HasPrefix(path, "/transfer-whisper/"),
+		strings.HasPrefix(path, "/media-stream/"),
+		strings.HasPrefix(path, "/tenants/"):
+		return "twilio"
+	case strings.HasPrefix(path, "/openai/"):
+		return "openai"
+	case strings.HasPrefix(path, "/realtime/"):
+		return "realtime"
+	case path == "/metrics":
+		return "metrics"
+	case strings.HasPrefix(path, "/admin/"), strings.HasPrefix(path, "/debug/"):
+		return "admin"
+	default:
+		return "health"
+	}
+}
+
+func validateRouteGroups(name string, groups []string) error {
+	for _, group := range groups {
+		if !slices.Contains(routeGroups, group) {
+			return fmt.Errorf("unknown %s entry %s: use %s", name, group, strings.Join(routeGroups, ", "))
+		}
+	}
+	return nil
+}
+
+// serverListener is a listener and the route groups it serves, all of
+// them when routes is empty. name and socket, the listener before TLS,
+// are what is handed over to a restarted process.
+type serverListener struct {
+	net.Listener
+	routes []string
+	name   string
+	socket net.Listener
+}
+
+// passedListener is a socket this process was started with, by systemd
+// socket activation or by the process it replaced in a restart.
+type passedListener struct {
+	net.Listener
+	name string
+}
+
+var passedListeners []passedListener
+
+// hasPassedSockets reports whether this process was started with sockets
+// to serve on, which replace PORT, TLS_PORT and LISTEN_SOCKET.
+func hasPassedSockets() bool {
+	return systemdSocketActivated() || os.Getenv("RESTART_FDS") != ""
+}
+
+// loadPassedListeners takes over the passed sockets, which start at file
+// descriptor 3.
+func loadPassedListeners() error {
+	count, names := systemdSockets()
+	if count == 0 {
+		count, names = restartSockets()
+	}
+	for i := 0; i < count; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(3+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("error using passed socket %d (%s): %v", i, name, err)
+		}
+		passedListeners = append(passedListeners, passedListener{l, name})
+	}
+	return nil
+}
+
+// takePassedListener returns the first passed socket with the given name,
+// or nil.
+func takePassedListener(name string) net.Listener {
+	for i, p := range passedListeners {
+		if p.name == name {
+			passedListeners = append(passedListeners[:i:i], passedListeners[i+1:]...)
+			return p.Listener
+		}
+	}
+	return nil
+}
+
+// listen opens the server's listeners: TCP on PORT, TLS on TLS_PORT and a
+// unix socket at LISTEN_SOCKET, whichever are set.
+func listen() ([]serverListener, error) {
+	if len(passedListeners) > 0 {
+		return listenPassed()
+	}
+
+	var listeners []serverListener
+	fail := func(err error) ([]serverListener, error) {
+		for _, l := range listeners {
+			l.Close()
+		}
+		return nil, err
+	}
+
+	if config.Port != "" {
+		l, err := net.Listen("tcp", ":"+config.Port)
+		if err != nil {
+			return fail(fmt.Errorf("error listening on port %s: %v", config.Port, err))
+		}
+		log.Printf("Server is listening on port %s\n", config.Port)
+		listeners = append(listeners, serverListener{l, config.PortRoutes, "http", l})
+	}
+	if config.TLSPort != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+		if err != nil {
+			return fail(fmt.Errorf("error loading TLS certificate: %v", err))
+		}
+		l, err := net.Listen("tcp", ":"+config.TLSPort)
+		if err != nil {
+			return fail(fmt.Errorf("error listening on port %s: %v", config.TLSPort, err))
+		}
+		tl := tls.NewListener(l, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+		log.Printf("Server is listening with TLS on port %s\n", config.TLSPort)
+		listeners = append(listeners, serverListener{tl, config.TLSPortRoutes, "https", l})
+	}
+	if config.ListenSocket != "" {
+		mode, _ := parseFileMode(config.ListenSocketMode)
+		l, err := listenUnix(config.ListenSocket, mode)
+		if err != nil {
+			return fail(err)
+		}
+		log.Printf("Server is listening on %s\n", config.ListenSocket)
+		listeners = append(listeners, serverListener{l, config.ListenSocketRoutes, "socket", l})
+	}
+	return listeners, nil
+}
+
+// listenPassed serves on the passed sockets by name: https sockets serve
+// TLS with TLS_CERT and TLS_KEY and the routes in TLS_PORT_ROUTES, socket
+// ones LISTEN_SOCKET_ROUTES and the rest plain HTTP with PORT_ROUTES. A
+// socket named grpc is left to the gRPC API.
+func listenPassed() ([]serverListener, error) {
+	var listeners []serverListener
+	for _, p := range passedListeners {
+		l := serverListener{p.Listener, config.PortRoutes, p.name, p.Listener}
+		switch p.name {
+		case "grpc":
+			continue
+		case "https":
+			cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+			if err != nil {
+				return nil, fmt.Errorf("error loading TLS certificate: %v", err)
+			}
+			l.Listener = tls.NewListener(p.Listener, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+			l.routes = config.TLSPortRoutes
+		case "socket":
+			l.routes = config.ListenSocketRoutes
+		}
+		log.Printf("Server is listening on passed socket %s (%s)\n", p.Addr(), p.name)
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// listenUnix listens on a unix socket at path, replacing a socket left
+// behind by an earlier run, and sets its permissions to mode so that only
+// the proxy in front can connect.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("LISTEN_SOCKET %s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("error removing old socket %s: %v", path, err)
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %v", path, err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("error setting permissions of %s: %v", path, err)
+	}
+	return l, nil
+}
+
+// parseFileMode parses an octal permission such as 0660.
+func parseFileMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid file mode %q", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// stopServing is closed to stop accepting connections once a restarted
+// process has taken over the listeners.
+var stopServing = make(chan struct{})
+
+// serve serves handler on every listener, limited to its route groups,
+// until one fails or they stop serving for a restart. Websocket calls in
+// progress are not waited for.
+func serve(listeners []serverListener, handler http.Handler) error {
+	errs := make(chan error, len(listeners))
+	var servers []*http.Server
+	for _, l := range listeners {
+		server := &http.Server{Handler: restrictRoutes(l.routes, handler)}
+		servers = append(servers, server)
+		go func() {
+			errs <- server.Serve(l)
+		}()
+	}
+	go func() {
+		<-stopServing
+		for _, server := range servers {
+			server.Shutdown(context.Background())
+		}
+	}()
+
+	for range listeners {
+		if err := <-errs; err != http.ErrServerClosed {
+			return err
+		}
+	}
+	return nil
+}
+
+// restrictRoutes answers 404 Not Found for paths outside groups.
+func restrictRoutes(groups []string, next http.Handler) http.Handler {
+	if len(groups) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(groups, routeGroup(r.URL.Path)) {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// fromUnixSocket reports whether a request came in on LISTEN_SOCKET. Only
+// local processes allowed by its permissions can connect there, so such
+// requests are treated like those from a trusted proxy.
+func fromUnixSocket(r *http.Request) bool {
+	_, ok := r.Context().Value(http.LocalAddrContextKey).(*net.UnixAddr)
+	return ok
+}
@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shakibhasan09/twilio-voice-openai/internal/realtimetest"
+)
+
+type LoadTestOptions struct {
+	SimulateCallOptions
+	Calls  int
+	RampUp time.Duration
+	Mock   bool
+}
+
+type loadTestReport struct {
+	calls      int
+	failures   []error
+	greeting   []time.Duration
+	reply      []time.Duration
+	framesSent int
+	framesLate int
+	// serverDropped is how many frames the in-process server's outbound
+	// queues dropped, known only with --mock.
+	serverDropped int
+	mock          bool
+	peakHeap      uint64
+	peakSys       uint64
+	peakRoutines  int
+}
+
+// LocalStreamURL is the media-stream URL of the server on PORT, 1313 by
+// default, for a caller. It carries a stream token when STREAM_TOKEN_SECRET
+// is set.
+func LocalStreamURL(number string) string {
+	readConfig()
+	port := config.Port
+	if port == "" {
+		port = "1313"
+	}
+	return "ws://localhost:" + port + mediaStreamPath(nil, number)
+}
+
+func LoadTest(opts LoadTestOptions) error {
+	samples, err := readWAV(opts.AudioPath, twilioSampleRate)
+	if err != nil {
+		return fmt.Errorf("error reading audio: %v", err)
+	}
+	question := encodeMulaw(samples)
+
+	if opts.Mock {
+		readConfig()
+		mock := realtimetest.NewServer(realtimetest.Conversation(2 * time.Second)...)
+		defer mock.Close()
+		config.OpenAIRealtimeURL = mock.WebsocketURL()
+
+		server := httptest.NewServer(newHandler())
+		defer server.Close()
+		opts.URL = "ws" + strings.TrimPrefix(server.URL, "http") + mediaStreamPath(nil, "+15555550100")
+	}
+
+	droppedBefore := droppedFrames.value("twilio") + droppedFrames.value("openai")
+	report := &loadTestReport{calls: opts.Calls, mock: opts.Mock}
+	stopSampling := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		report.sampleMemory(stopSampling)
+	}()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := runSimulatedCall(opts.SimulateCallOptions, question)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				report.failures = append(report.failures, err)
+			}
+			if result == nil {
+				return
+			}
+			if result.GreetingLatency > 0 {
+				report.greeting = append(report.greeting, result.GreetingLatency)
+			}
+			if result.ReplyLatency > 0 {
+				report.reply = append(report.reply, result.ReplyLatency)
+			}
+			report.framesSent += result.FramesSent
+			report.framesLate += result.FramesLate
+		}()
+
+		if opts.Calls > 1 {
+			time.Sleep(opts.RampUp / time.Duration(opts.Calls-1))
+		}
+	}
+	wg.Wait()
+
+	close(stopSampling)
+	<-sampled
+	report.serverDropped = int(droppedFrames.value("twilio") + droppedFrames.value("openai") - droppedBefore)
+	report.print()
+
+	return nil
+}
+
+func (r *loadTestReport) sampleMemory(stop <-chan struct{}) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		r.peakHeap = max(r.peakHeap, stats.HeapAlloc)
+		r.peakSys = max(r.peakSys, stats.Sys)
+		r.peakRoutines = max(r.peakRoutines, runtime.NumGoroutine())
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *loadTestReport) print() {
+	fmt.Printf("Calls:            %d (%d succeeded, %d failed)\n", r.calls, r.calls-len(r.failures), len(r.failures))
+	fmt.Printf("Greeting latency: %s\n", percentiles(r.greeting))
+	fmt.Printf("Reply latency:    %s\n", percentiles(r.reply))
+	fmt.Printf("Late frames:      %d of %d (sent after their 20ms slot had passed)\n", r.framesLate, r.framesSent+r.framesLate)
+	if r.mock {
+		fmt.Printf("Dropped frames:   %d (by the server's outbound queues)\n", r.serverDropped)
+	}
+	fmt.Printf("Memory:           peak heap %.1f MiB, peak sys %.1f MiB, peak goroutines %d\n",
+		float64(r.peakHeap)/(1<<20), float64(r.peakSys)/(1<<20), r.peakRoutines)
+
+	for i, err := range r.failures {
+		if i == 5 {
+			fmt.Printf("  ... and %d more failures\n", len(r.failures)-i)
+			break
+		}
+		fmt.Println("  failure:", err)
+	}
+}
+
+func percentiles(durations []time.Duration) string {
+	if len(durations) == 0 {
+		return "n/a"
+	}
+
+	slices.Sort(durations)
+	at := func(p float64) time.Duration {
+		return durations[int(p*float64(len(durations)-1))].Round(time.Millisecond)
+	}
+
+	return fmt.Sprintf("p50=%s p90=%s p99=%s max=%s", at(0.5), at(0.9), at(0.99), at(1))
+}
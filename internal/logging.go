@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	logLevelQuiet = "quiet"
+	logLevelInfo  = "info"
+	logLevelDebug = "debug"
+)
+
+// defaultLogEventTypes are the OpenAI events logged when LOG_EVENT_TYPES is
+// unset. Twilio events are named with a "twilio." prefix, as in
+// "twilio.start".
+var defaultLogEventTypes = []string{
+	"response.content.done",
+	"rate_limits.updated",
+	"response.done",
+	"input_audio_buffer.committed",
+	"input_audio_buffer.speech_stopped",
+	"input_audio_buffer.speech_started",
+	"session.created",
+}
+
+// logSettings control which received events are logged. At the info level
+// the names of the listed event types are logged; debug logs every event
+// with its payload, and quiet none. They can be changed at runtime through
+// the admin API, and debug can also be turned on for a single call.
+var logSettings = struct {
+	sync.RWMutex
+	level      string
+	eventTypes map[string]struct{}
+}{}
+
+func validLogLevel(level string) bool {
+	return slices.Contains([]string{logLevelQuiet, logLevelInfo, logLevelDebug}, level)
+}
+
+func setLogSettings(level string, eventTypes []string) {
+	types := map[string]struct{}{}
+	for _, eventType := range eventTypes {
+		types[eventType] = struct{}{}
+	}
+	logSettings.Lock()
+	logSettings.level, logSettings.eventTypes = level, types
+	logSettings.Unlock()
+}
+
+func currentLogSettings() (string, []string) {
+	logSettings.RLock()
+	defer logSettings.RUnlock()
+	types := make([]string, 0, len(logSettings.eventTypes))
+	for eventType := range logSettings.eventTypes {
+		types = append(types, eventType)
+	}
+	sort.Strings(types)
+	return logSettings.level, types
+}
+
+// logEvent logs an event received from OpenAI or, with source "twilio",
+// from Twilio, as the log settings and the call's debug flag say.
+func (s *callSession) logEvent(source, eventType string, event map[string]interface{}) {
+	name, from := eventType, "OpenAI"
+	if source == "twilio" {
+		name, from = "twilio."+eventType, "Twilio"
+	}
+	logSettings.RLock()
+	level := logSettings.level
+	_, listed := logSettings.eventTypes[name]
+	logSettings.RUnlock()
+
+	switch {
+	case level == logLevelDebug || s.debug.Load():
+		log.Printf("Received %s message on call %s: %s\n", from, s.id, debugPayload(eventType, event))
+	case level == logLevelInfo && listed:
+		log.Printf("Received %s message: %s\n", from, eventType)
+	}
+}
+
+// debugPayload renders an event as JSON with its secrets and audio
+// redacted, which would otherwise swamp the log.
+func debugPayload(eventType string, event map[string]interface{}) string {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(redactFields(eventType, event)); err != nil {
+		return fmt.Sprintf("<%s event>", eventType)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// setDebug turns full event logging on or off for the call.
+func (s *callSession) setDebug(on bool) {
+	s.debug.Store(on)
+	if on {
+		s.record("debug.start", "")
+	} else {
+		s.record("debug.stop", "")
+	}
+}
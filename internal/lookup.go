@@ -0,0 +1,201 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var callerLookupsTotal = newCounter("caller_lookups_total", "Caller lookups at the start of calls, by result (found, not_found or error).", "result")
+
+// callerLookupDB is the database CALLER_LOOKUP_SQL runs against.
+var callerLookupDB *sqlStorage
+
+// When a call starts, its caller's number can be looked up with
+// CALLER_LOOKUP_SQL or a caller_lookup webhook (CALLER_LOOKUP_URL). The
+// record found, a row or a JSON object, is stored in the call metadata and
+// its fields become template variables of the instructions and greeting,
+// so the model can greet a known customer by name without a tool call. The
+// call waits for the lookup for up to CALLER_LOOKUP_TIMEOUT before it
+// greets the caller.
+
+func callerLookupConfigured(tenant *tenantConfig) bool {
+	return config.CallerLookupSQL != "" || len(callWebhookTargets("caller_lookup", map[string]interface{}{"tenant": tenant.id()})) > 0
+}
+
+// openCallerLookupDB opens CALLER_LOOKUP_DB: a postgres:// URL, or else the
+// path of a sqlite file.
+func openCallerLookupDB() error {
+	if config.CallerLookupSQL == "" {
+		return nil
+	}
+	driver, postgres := "sqlite", strings.HasPrefix(config.CallerLookupDB, "postgres://") || strings.HasPrefix(config.CallerLookupDB, "postgresql://")
+	dsn := "file:" + config.CallerLookupDB + "?mode=ro&_pragma=busy_timeout(5000)"
+	if postgres {
+		driver, dsn = "postgres", config.CallerLookupDB
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return fmt.Errorf("error opening CALLER_LOOKUP_DB: %v", err)
+	}
+	callerLookupDB = &sqlStorage{db: db, postgres: postgres}
+	return nil
+}
+
+// startCallerLookup looks the caller up in the background, if a lookup is
+// configured.
+func (s *callSession) startCallerLookup() {
+	if !callerLookupConfigured(s.tenant) {
+		return
+	}
+	s.callerLookup = make(chan struct{})
+	go func() {
+		defer close(s.callerLookup)
+		defer s.recoverPanic("caller_lookup")
+		ctx, cancel := context.WithTimeout(context.Background(), config.CallerLookupTimeout)
+		defer cancel()
+
+		record, err := s.lookUpCaller(ctx)
+		variables := map[string]string{}
+		switch {
+		case err != nil:
+			log.Println("Error looking up caller:", err)
+			callerLookupsTotal.addFor(s, 1, "error")
+			s.record("caller.lookup", "error")
+		case len(record) == 0:
+			callerLookupsTotal.addFor(s, 1, "not_found")
+			s.record("caller.lookup", "not found")
+		default:
+			callerLookupsTotal.addFor(s, 1, "found")
+			s.record("caller.lookup", "found")
+			for key, value := range record {
+				if value != nil {
+					variables[key] = fmt.Sprint(value)
+				}
+			}
+			s.setMetadata(record, "caller lookup")
+		}
+		s.mu.Lock()
+		s.callerVariables = variables
+		s.mu.Unlock()
+	}()
+}
+
+// lookUpCaller returns the caller's record, or nil if they aren't known.
+func (s *callSession) lookUpCaller(ctx context.Context) (map[string]interface{}, error) {
+	if config.CallerLookupSQL != "" {
+		return queryCaller(ctx, s.phoneNumber)
+	}
+
+	type answer struct {
+		record map[string]interface{}
+		err    error
+	}
+	answered := make(chan answer, 1)
+	go func() {
+		var a answer
+		payload := map[string]string{"phone_number": s.phoneNumber, "tenant": s.tenant.id()}
+		a.err = queryWebhook("caller_lookup", s.summary(), payload, &a.record)
+		var statusErr *webhookStatusError
+		if errors.As(a.err, &statusErr) && statusErr.code == http.StatusNotFound {
+			a.err = nil
+		}
+		answered <- a
+	}()
+	select {
+	case a := <-answered:
+		return a.record, a.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("caller_lookup webhook: %v", ctx.Err())
+	}
+}
+
+// queryCaller runs CALLER_LOOKUP_SQL for a number and returns the first
+// row by column name.
+func queryCaller(ctx context.Context, number string) (map[string]interface{}, error) {
+	rows, err := callerLookupDB.db.QueryContext(ctx, callerLookupDB.rebind(config.CallerLookupSQL), number)
+	if err != nil {
+		return nil, fmt.Errorf("error running CALLER_LOOKUP_SQL: %v", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("error reading columns: %v", err)
+	}
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	if err := rows.Scan(pointers...); err != nil {
+		return nil, fmt.Errorf("error reading row: %v", err)
+	}
+	record := map[string]interface{}{}
+	for i, column := range columns {
+		if b, ok := values[i].([]byte); ok {
+			values[i] = string(b)
+		}
+		record[column] = values[i]
+	}
+	return record, nil
+}
+
+// awaitCallerLookup waits for the caller lookup to finish and returns the
+// template variables it found. Without a lookup configured it returns nil.
+func (s *callSession) awaitCallerLookup() map[string]string {
+	if s.callerLookup == nil {
+		return nil
+	}
+	select {
+	case <-s.callerLookup:
+	case <-time.After(config.CallerLookupTimeout + time.Second):
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.callerVariables == nil {
+		return map[string]string{}
+	}
+	return s.callerVariables
+}
+
+// renderPrompt renders instructions or a greeting as a template over the
+// caller's variables. Missing variables are empty.
+func renderPrompt(text string, variables map[string]string) string {
+	tmpl, err := template.New("").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		log.Println("Error parsing prompt template:", err)
+		return text
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, variables); err != nil {
+		log.Println("Error rendering prompt template:", err)
+		return text
+	}
+	return b.String()
+}
+
+// prompts returns the call's instructions and greeting, rendered over what
+// the caller lookup found when one is configured, with the customer context
+// added to the instructions.
+func (s *callSession) prompts() (instructions, greeting string) {
+	instructions, greeting = callPrompts(s.tenant, s.callSid())
+	s.mu.Lock()
+	if s.instructions != "" {
+		instructions = s.instructions
+	}
+	s.mu.Unlock()
+	if variables := s.awaitCallerLookup(); variables != nil {
+		instructions, greeting = renderPrompt(instructions, variables), renderPrompt(greeting, variables)
+	}
+	return s.withCustomerContext(instructions), greeting
+}
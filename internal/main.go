@@ -1,13 +1,19 @@
 package internal
 
 import (
-	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
@@ -15,71 +21,812 @@ import (
 
 var (
 	config struct {
-		Port          string
-		OpenAIAPIKey  string
-		SystemMessage string
-		XMLResponse   string
-		WebhookURL    string
-	}
-	upgrader      = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
-	logEventTypes = map[string]struct{}{
-		"response.content.done":             {},
-		"rate_limits.updated":               {},
-		"response.done":                     {},
-		"input_audio_buffer.committed":      {},
-		"input_audio_buffer.speech_stopped": {},
-		"input_audio_buffer.speech_started": {},
-		"session.created":                   {},
+		Port                string
+		PortRoutes          []string
+		TLSPort             string
+		TLSPortRoutes       []string
+		TLSCert             string
+		TLSKey              string
+		ListenSocket        string
+		ListenSocketMode    string
+		ListenSocketRoutes  []string
+		RestartDrainTimeout time.Duration
+		OpenAIAPIKey        string
+		SystemMessage       string
+		XMLResponse         string
+		WebhookURL          string
+
+		WebhookClientCert string
+		WebhookClientKey  string
+		WebhookCACert     string
+
+		PublicHostname string
+		ExternalURL    string
+		TwilioAPIURL   string
+		TwilioRegion   string
+		TwilioEdge     string
+
+		FallbackPhoneNumber string
+		FallbackMessage     string
+
+		OpenAIUnavailableMessage  string
+		OpenAIUnavailableAudioURL string
+
+		TransferPhoneNumber     string
+		TransferSummaryDelivery []string
+		TransferSMSFrom         string
+		TransferSMSTo           string
+
+		ConferenceCallerID         string
+		ConferenceSpecialistNumber string
+		ConferenceAIMode           string
+		ConferenceAINumber         string
+
+		HoldAudio string
+		HoldAfter time.Duration
+
+		MaxConcurrentCalls int
+		ReadinessMaxCalls  int
+		BusyMessage        string
+		CallbackEnabled    bool
+		CallerLock         string
+		CallerLockTTL      time.Duration
+
+		AMDEnabled        bool
+		AMDMachineAction  string
+		VoicemailMessage  string
+		VoicemailAudioURL string
+
+		OTPSMSFrom       string
+		OTPMessage       string
+		SensitiveTools   []string
+		ReadBackTools    []string
+		DestructiveTools []string
+		ApprovalTools    []string
+		ApprovalURL      string
+		ApprovalTimeout  time.Duration
+
+		VerificationWebhookURL string
+		VerificationFields     []string
+
+		CallerLookupURL     string
+		CallerLookupDB      string
+		CallerLookupSQL     string
+		CallerLookupTimeout time.Duration
+
+		CustomerContextURL      string
+		CustomerContextTimeout  time.Duration
+		CustomerContextMaxChars int
+		CustomerContextRedact   []string
+
+		RemindersFile        string
+		ReminderCallerID     string
+		ReminderLeadTime     time.Duration
+		ReminderInstructions string
+		ReminderGreeting     string
+
+		WebhookFailuresFile string
+		JobsModel           string
+
+		OpenAIRealtimeURL       string
+		OpenAIRealtimeAPI       string
+		OpenAIRealtimeModels    []string
+		OpenAIDialRetries       int
+		OpenAIDialBackoff       time.Duration
+		OpenAIConnectTimeout    time.Duration
+		OpenAIRateLimitPercent  int
+		OpenAIRateLimitDelay    time.Duration
+		OpenAISessionRenewAfter time.Duration
+		OpenAIContextLimit      int
+		OpenAIContextKeepItems  int
+		OpenAIOrganization      string
+		OpenAIProject           string
+		OpenAISIPProjectID      string
+
+		RealtimeClientSecretTTL time.Duration
+		OpenAIProxyURL          string
+		AudioForkURL            string
+		TenantUsageFile         string
+		UsageFile               string
+		StorageBackend          string
+		CallLogDB               string
+		StorageS3Bucket         string
+		StorageS3Region         string
+		StorageS3Prefix         string
+		StorageS3Endpoint       string
+		RecordCalls             bool
+		AuditLogFile            string
+		ComplianceSTT           string
+		ComplianceSTTURL        string
+		TranscribeURL           string
+		TranscribeModel         string
+		RecordingDir            string
+		AudioClipsDir           string
+		AudioClipOnStart        string
+		GreetingCache           bool
+		GreetingStrategy        string
+		GreetingAudioClip       string
+		MaxResponseTokens       int
+		MaxResponseAudio        time.Duration
+		MaxTalkTime             time.Duration
+		OpenAIAudioFormat       string
+		RecordingChannels       string
+		RecordingStartPaused    bool
+		RecordingExcludeNumbers []string
+
+		AudioPacing            bool
+		AudioPacingPrebufferMs int
+
+		OutboundQueueSize int
+		TwilioQueuePolicy string
+		OpenAIQueuePolicy string
+
+		InputAudioBatchMs int
+
+		WSReadTimeout     time.Duration
+		WSWriteTimeout    time.Duration
+		WSMaxMessageBytes int64
+		WSOriginPolicy    string
+		WSAllowedOrigins  []string
+
+		AccessLog             bool
+		AccessLogExcludePaths map[string]struct{}
+		LogLevel              string
+		LogEventTypes         []string
+
+		PprofEnabled bool
+
+		StatsDAddr   string
+		StatsDPrefix string
+		StatsDFlavor string
+		StatsDTags   []string
+
+		SentryEnvironment string
+		ErrorWebhookURL   string
+
+		TwilioIPAllowlist     []string
+		TwilioIPRangesURL     string
+		TwilioIPRangesRefresh time.Duration
+		TwilioValidateSigs    bool
+		TrustedProxies        []string
+
+		StreamTokenSecret string
+		StreamTokenTTL    time.Duration
+
+		GRPCAddr    string
+		GRPCTLSCert string
+		GRPCTLSKey  string
+
+		NATSURL        string
+		NATSCreds      string
+		NATSStream     string
+		NATSSubject    string
+		NATSMaxAge     time.Duration
+		NATSConsumers  []string
+		NATSEventTypes []string
+
+		SQSQueueURL       string
+		EventBridgeBus    string
+		EventBridgeSource string
+		AWSEventTypes     []string
+
+		PubSubTopic        string
+		PubSubTopicPerType bool
+		PubSubEndpoint     string
+		PubSubEventTypes   []string
+
+		AMQPURL          string
+		AMQPExchange     string
+		AMQPExchangeType string
+		AMQPRoutingKey   string
+		AMQPEventTypes   []string
+
+		RedisURL       string
+		RedisKeyPrefix string
+
+		File fileConfig
 	}
+	upgrader = websocket.Upgrader{CheckOrigin: checkOrigin}
 )
 
 func Run() {
 	loadConfig()
 
+	if err := loadPassedListeners(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadTrustedProxies(); err != nil {
+		log.Fatal(err)
+	}
+	if err := openStateStore(); err != nil {
+		log.Fatal(err)
+	}
+	if err := startTwilioAllowlist(); err != nil {
+		log.Fatal(err)
+	}
+	startSecretsRefresh()
+	if config.CallbackEnabled {
+		go runCallbacks()
+	}
+	if err := loadReminders(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadFailedWebhooks(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadAudioClips(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadTenantUsage(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadUsage(); err != nil {
+		log.Fatal(err)
+	}
+	if err := initStorage(); err != nil {
+		log.Fatal(err)
+	}
+	if err := openCallerLookupDB(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadAuditLog(); err != nil {
+		log.Fatal(err)
+	}
+	if err := startStatsD(); err != nil {
+		log.Fatal(err)
+	}
+	if err := startGRPC(); err != nil {
+		log.Fatal(err)
+	}
+	if err := startNATS(); err != nil {
+		log.Fatal(err)
+	}
+	if err := startAWSEvents(); err != nil {
+		log.Fatal(err)
+	}
+	if err := startPubSub(); err != nil {
+		log.Fatal(err)
+	}
+	if err := startAMQP(); err != nil {
+		log.Fatal(err)
+	}
+	go runAutoscaleSampler()
+	go runReminders()
+	startJobSchedules()
+	if errorReportingEnabled() {
+		go runErrorReports()
+	}
+	if config.GreetingCache && !sipMode() {
+		go warmGreetingCache()
+	}
+	if !adminAuthEnabled() {
+		log.Println("Warning: no admin API keys or JWT settings configured, admin and metrics endpoints are unauthenticated")
+	}
+
+	listeners, err := listen()
+	if err != nil {
+		log.Fatal(err)
+	}
+	sdNotify("READY=1")
+	notifyRestarted()
+	startSystemdWatchdog()
+	handleRestarts(listeners)
+	if err := serve(listeners, newHandler()); err != nil {
+		log.Fatal(err)
+	}
+	drainCalls()
+}
+
+func newHandler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", handleRoot)
-	mux.HandleFunc("/incoming-call", handleIncomingCall)
-	mux.HandleFunc("/media-stream/{number}", handleMediaStream)
-
-	log.Printf("Server is listening on port %s\n", config.Port)
-	log.Fatal(http.ListenAndServe(":"+config.Port, mux))
+	mux.HandleFunc("/incoming-call", twilioOnly(handleIncomingCall))
+	mux.HandleFunc("POST /call-status", twilioOnly(handleCallStatus))
+	mux.HandleFunc("POST /callback-request", twilioOnly(handleCallbackRequest))
+	mux.HandleFunc("/transfer-whisper/{id}", twilioOnly(handleTransferWhisper))
+	mux.HandleFunc("/media-stream/{number}", twilioOnly(handleMediaStream))
+	mux.HandleFunc("/media-stream/{number}/{token}", twilioOnly(handleMediaStream))
+	mux.HandleFunc("/tenants/{tenant}/media-stream/{number}", twilioOnly(handleMediaStream))
+	mux.HandleFunc("/tenants/{tenant}/media-stream/{number}/{token}", twilioOnly(handleMediaStream))
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /readyz", handleReadyz)
+	mux.HandleFunc("GET /metrics", requireScope(scopeRead, handleMetrics))
+	if sipMode() {
+		mux.HandleFunc("POST /openai/webhook", handleOpenAIWebhook)
+	}
+	mux.HandleFunc("POST /realtime/client-secret", requireScope(scopeRealtime, handleRealtimeClientSecret))
+	registerAdminRoutes(mux)
+	registerDiagnosticsRoutes(mux)
+	return accessLogMiddleware(recoverMiddleware(mux))
 }
 
 func loadConfig() {
+	readConfig()
+
+	if config.OpenAIAPIKey == "" || config.SystemMessage == "" || (config.Port == "" && config.TLSPort == "" && config.ListenSocket == "" && !hasPassedSockets()) || config.XMLResponse == "" || len(webhookTargets("schedule")) == 0 {
+		log.Fatal("Missing required environment variables. Please check your .env file.")
+	}
+	if config.CallerLock != "off" && config.CallerLock != "number" && config.CallerLock != "tenant" {
+		log.Fatal("CALLER_LOCK must be off, number or tenant")
+	}
+	if config.CallerLockTTL <= 0 {
+		log.Fatal("CALLER_LOCK_TTL must be positive")
+	}
+	if config.OpenAIRealtimeAPI != "auto" && config.OpenAIRealtimeAPI != "beta" && config.OpenAIRealtimeAPI != "ga" {
+		log.Fatal("OPENAI_REALTIME_API must be auto, beta or ga")
+	}
+	if config.WSOriginPolicy != "twilio-only" && config.WSOriginPolicy != "allowlist" && config.WSOriginPolicy != "any" {
+		log.Fatal("WS_ORIGIN_POLICY must be twilio-only, allowlist or any")
+	}
+	if config.OpenAIAudioFormat != "g711_ulaw" && config.OpenAIAudioFormat != "pcm16" {
+		log.Fatal("OPENAI_AUDIO_FORMAT must be g711_ulaw or pcm16")
+	}
+	if config.RecordingChannels != "mono" && config.RecordingChannels != "stereo" && config.RecordingChannels != "separate" {
+		log.Fatal("RECORDING_CHANNELS must be mono, stereo or separate")
+	}
+	if !validLogLevel(config.LogLevel) {
+		log.Fatal("LOG_LEVEL must be quiet, info or debug")
+	}
+	if config.StatsDFlavor != "dogstatsd" && config.StatsDFlavor != "statsd" {
+		log.Fatal("STATSD_FLAVOR must be dogstatsd or statsd")
+	}
+	if config.ComplianceSTT != "" && complianceSTTURLs[config.ComplianceSTT] == "" {
+		log.Fatal("COMPLIANCE_STT must be deepgram or assemblyai")
+	}
+	if config.ComplianceSTT != "" && (!recordingEnabled() || secret("COMPLIANCE_STT_API_KEY") == "") {
+		log.Fatal("COMPLIANCE_STT needs RECORDING_DIR or RECORD_CALLS, and COMPLIANCE_STT_API_KEY")
+	}
+	if err := validateAdminAuth(); err != nil {
+		log.Fatal("Error in admin config: ", err)
+	}
+	if err := validateErrorMessages(config.File.ErrorMessages); err != nil {
+		log.Fatal("Invalid error_messages in CONFIG_FILE: ", err)
+	}
+	if err := validateEscalationRules(config.File.Escalation); err != nil {
+		log.Fatal("Invalid escalation in CONFIG_FILE: ", err)
+	}
+	if err := validateTenants(); err != nil {
+		log.Fatal("Error in tenant config: ", err)
+	}
+	// A media stream's tenant comes from its path, so without a token or
+	// signature anyone could open one billed to any tenant.
+	if len(config.File.Tenants) > 0 && config.StreamTokenSecret == "" && !config.TwilioValidateSigs {
+		log.Fatal("Tenants need STREAM_TOKEN_SECRET or TWILIO_VALIDATE_SIGNATURES, as media streams pick their tenant by path")
+	}
+	if err := validateJobSchedules(); err != nil {
+		log.Fatal("Error in job config: ", err)
+	}
+	if _, err := parseFileMode(config.ListenSocketMode); err != nil {
+		log.Fatal("LISTEN_SOCKET_MODE: ", err)
+	}
+	if config.TLSPort != "" && (config.TLSCert == "" || config.TLSKey == "") {
+		log.Fatal("TLS_PORT needs TLS_CERT and TLS_KEY")
+	}
+	for name, groups := range map[string][]string{"PORT_ROUTES": config.PortRoutes, "TLS_PORT_ROUTES": config.TLSPortRoutes, "LISTEN_SOCKET_ROUTES": config.ListenSocketRoutes} {
+		if err := validateRouteGroups(name, groups); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if config.TwilioValidateSigs && secret("TWILIO_AUTH_TOKEN") == "" {
+		log.Fatal("TWILIO_VALIDATE_SIGNATURES needs TWILIO_AUTH_TOKEN")
+	}
+	if config.ExternalURL != "" {
+		externalURL, err := parseExternalURL(config.ExternalURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.ExternalURL = externalURL
+	}
+	if (config.GRPCTLSCert == "") != (config.GRPCTLSKey == "") {
+		log.Fatal("GRPC_TLS_CERT and GRPC_TLS_KEY must be set together")
+	}
+	for name, types := range map[string][]string{"NATS_EVENT_TYPES": config.NATSEventTypes, "AWS_EVENT_TYPES": config.AWSEventTypes, "PUBSUB_EVENT_TYPES": config.PubSubEventTypes, "AMQP_EVENT_TYPES": config.AMQPEventTypes} {
+		for _, t := range types {
+			if !slices.Contains(callEventTypes, t) {
+				log.Fatalf("Unknown %s entry %s: use %s", name, t, strings.Join(callEventTypes, ", "))
+			}
+		}
+	}
+	if err := validateGreetingStrategy(config.GreetingStrategy, config.GreetingAudioClip); err != nil {
+		log.Fatal("Invalid GREETING_STRATEGY: ", err)
+	}
+	if sipMode() && config.GreetingStrategy != "message" && config.GreetingStrategy != "instruct" {
+		log.Fatalf("GREETING_STRATEGY %s needs media streams and is not supported in SIP mode", config.GreetingStrategy)
+	}
+	if sipMode() && len(config.File.Tenants) > 0 {
+		log.Fatal("Tenants are not supported in SIP mode")
+	}
+	if sipMode() && (secret("OPENAI_WEBHOOK_SECRET") == "" || !realtimeGA(realtimeModels()[0])) {
+		log.Fatal("OPENAI_SIP_PROJECT_ID needs OPENAI_WEBHOOK_SECRET and a GA realtime model such as gpt-realtime")
+	}
+	for _, name := range config.SensitiveTools {
+		if findTool(name) == nil {
+			log.Fatalf("SENSITIVE_TOOLS names %s, which is not an enabled tool", name)
+		}
+	}
+	for _, name := range config.ReadBackTools {
+		if findTool(name) == nil {
+			log.Fatalf("READ_BACK_TOOLS names %s, which is not an enabled tool", name)
+		}
+	}
+	for _, name := range config.DestructiveTools {
+		if findTool(name) == nil {
+			log.Fatalf("DESTRUCTIVE_TOOLS names %s, which is not an enabled tool", name)
+		}
+	}
+	for _, name := range config.ApprovalTools {
+		if findTool(name) == nil {
+			log.Fatalf("APPROVAL_TOOLS names %s, which is not an enabled tool", name)
+		}
+	}
+	if len(config.ApprovalTools) > 0 && len(webhookTargets("approval_request")) == 0 {
+		log.Fatal("APPROVAL_TOOLS needs somewhere to send approval requests. Set APPROVAL_WEBHOOK_URL or configure approval_request webhooks.")
+	}
+	if len(config.SensitiveTools) > 0 && !otpConfigured() && !identityConfigured() {
+		log.Fatal("SENSITIVE_TOOLS needs a way to verify callers. Set OTP_SMS_FROM or VERIFICATION_WEBHOOK_URL.")
+	}
+	for _, field := range config.VerificationFields {
+		if field != "name" && field != "date_of_birth" && field != "account_number" {
+			log.Fatalf("Unknown VERIFICATION_FIELDS entry %s: use name, date_of_birth or account_number", field)
+		}
+	}
+	if config.CallerLookupSQL != "" && config.CallerLookupDB == "" {
+		log.Fatal("CALLER_LOOKUP_SQL needs CALLER_LOOKUP_DB")
+	}
+}
+
+func readConfig() {
 	if os.Getenv("GO_ENV") == "development" {
 		if err := godotenv.Load(); err != nil {
 			log.Fatal("Error loading .env file")
 		}
 	}
+	installLogRedaction()
 
 	config.OpenAIAPIKey = os.Getenv("OPENAI_API_KEY")
 	config.SystemMessage = os.Getenv("SYSTEM_MESSAGE")
 	config.Port = os.Getenv("PORT")
+	config.PortRoutes = getEnvList("PORT_ROUTES")
+	config.TLSPort = os.Getenv("TLS_PORT")
+	config.TLSPortRoutes = getEnvList("TLS_PORT_ROUTES")
+	config.TLSCert = os.Getenv("TLS_CERT")
+	config.TLSKey = os.Getenv("TLS_KEY")
+	config.ListenSocket = os.Getenv("LISTEN_SOCKET")
+	config.ListenSocketMode = getEnv("LISTEN_SOCKET_MODE", "0660")
+	config.ListenSocketRoutes = getEnvList("LISTEN_SOCKET_ROUTES")
+	config.RestartDrainTimeout = getEnvDuration("RESTART_DRAIN_TIMEOUT", time.Hour)
 	config.XMLResponse = os.Getenv("GREETINGS_RESPONSE")
 	config.WebhookURL = os.Getenv("WEBHOOK_URL")
+	config.WebhookClientCert = os.Getenv("WEBHOOK_CLIENT_CERT")
+	config.WebhookClientKey = os.Getenv("WEBHOOK_CLIENT_KEY")
+	config.WebhookCACert = os.Getenv("WEBHOOK_CA_CERT")
+	config.PublicHostname = os.Getenv("PUBLIC_HOSTNAME")
+	config.ExternalURL = os.Getenv("EXTERNAL_URL")
+	if config.ExternalURL == "" && config.PublicHostname != "" {
+		config.ExternalURL = "https://" + config.PublicHostname
+	}
+	config.FallbackPhoneNumber = os.Getenv("FALLBACK_PHONE_NUMBER")
+	config.FallbackMessage = os.Getenv("FALLBACK_MESSAGE")
+	config.OpenAIUnavailableMessage = getEnv("OPENAI_UNAVAILABLE_MESSAGE", "Sorry, our assistant can't take your call right now.")
+	config.OpenAIUnavailableAudioURL = os.Getenv("OPENAI_UNAVAILABLE_AUDIO_URL")
+	config.TransferPhoneNumber = os.Getenv("TRANSFER_PHONE_NUMBER")
+	config.TransferSummaryDelivery = getEnvList("TRANSFER_SUMMARY_DELIVERY")
+	if len(config.TransferSummaryDelivery) == 0 {
+		config.TransferSummaryDelivery = []string{"whisper"}
+	}
+	config.TransferSMSFrom = os.Getenv("TRANSFER_SMS_FROM")
+	config.TransferSMSTo = os.Getenv("TRANSFER_SMS_TO")
+	config.ConferenceCallerID = os.Getenv("CONFERENCE_CALLER_ID")
+	config.ConferenceSpecialistNumber = os.Getenv("CONFERENCE_SPECIALIST_NUMBER")
+	config.ConferenceAIMode = getEnv("CONFERENCE_AI_MODE", "off")
+	config.ConferenceAINumber = os.Getenv("CONFERENCE_AI_NUMBER")
+	config.HoldAudio = os.Getenv("HOLD_AUDIO")
+	config.HoldAfter = getEnvDuration("HOLD_AFTER", 0)
+	config.MaxConcurrentCalls = getEnvInt("MAX_CONCURRENT_CALLS", 0)
+	config.ReadinessMaxCalls = getEnvInt("READINESS_MAX_CALLS", 0)
+	config.BusyMessage = getEnv("BUSY_MESSAGE", "All of our lines are busy right now.")
+	config.CallbackEnabled = getEnvBool("CALLBACK_ENABLED")
+	config.CallerLock = getEnv("CALLER_LOCK", "off")
+	config.CallerLockTTL = getEnvDuration("CALLER_LOCK_TTL", 10*time.Minute)
+	config.AMDEnabled = getEnvBool("AMD_ENABLED")
+	config.AMDMachineAction = getEnv("AMD_MACHINE_ACTION", "hangup")
+	config.VoicemailMessage = os.Getenv("VOICEMAIL_MESSAGE")
+	config.VoicemailAudioURL = os.Getenv("VOICEMAIL_AUDIO_URL")
+	config.OTPSMSFrom = os.Getenv("OTP_SMS_FROM")
+	config.OTPMessage = getEnv("OTP_MESSAGE", "Your verification code is {{.code}}.")
+	config.SensitiveTools = getEnvList("SENSITIVE_TOOLS")
+	config.ReadBackTools = getEnvList("READ_BACK_TOOLS")
+	config.DestructiveTools = getEnvList("DESTRUCTIVE_TOOLS")
+	config.ApprovalTools = getEnvList("APPROVAL_TOOLS")
+	config.ApprovalURL = os.Getenv("APPROVAL_WEBHOOK_URL")
+	config.ApprovalTimeout = getEnvDuration("APPROVAL_TIMEOUT", 2*time.Minute)
+	config.VerificationWebhookURL = os.Getenv("VERIFICATION_WEBHOOK_URL")
+	config.VerificationFields = getEnvList("VERIFICATION_FIELDS")
+	if len(config.VerificationFields) == 0 {
+		config.VerificationFields = []string{"name", "date_of_birth"}
+	}
+	config.CallerLookupURL = os.Getenv("CALLER_LOOKUP_URL")
+	config.CallerLookupDB = os.Getenv("CALLER_LOOKUP_DB")
+	config.CallerLookupSQL = os.Getenv("CALLER_LOOKUP_SQL")
+	config.CallerLookupTimeout = getEnvDuration("CALLER_LOOKUP_TIMEOUT", 2*time.Second)
+	config.CustomerContextURL = os.Getenv("CUSTOMER_CONTEXT_URL")
+	config.CustomerContextTimeout = getEnvDuration("CUSTOMER_CONTEXT_TIMEOUT", 2*time.Second)
+	config.CustomerContextMaxChars = getEnvInt("CUSTOMER_CONTEXT_MAX_CHARS", 4000)
+	for _, field := range getEnvList("CUSTOMER_CONTEXT_REDACT") {
+		config.CustomerContextRedact = append(config.CustomerContextRedact, strings.ToLower(field))
+	}
+	config.RemindersFile = os.Getenv("REMINDERS_FILE")
+	config.ReminderCallerID = os.Getenv("REMINDER_CALLER_ID")
+	config.ReminderLeadTime = getEnvDuration("REMINDER_LEAD_TIME", 0)
+	config.ReminderInstructions = getEnv("REMINDER_INSTRUCTIONS", "You are calling {{.name}} to remind them of their meeting at {{.datetime}} about {{.description}}. Confirm they can still make it, and offer to take a message if they need to reschedule.")
+	config.ReminderGreeting = getEnv("REMINDER_GREETING", "Hi {{.name}}, this is a reminder call about your upcoming meeting.")
+	config.WebhookFailuresFile = os.Getenv("WEBHOOK_FAILURES_FILE")
+	config.JobsModel = getEnv("JOBS_MODEL", "gpt-4o-mini")
+	config.TwilioRegion = strings.ToLower(os.Getenv("TWILIO_REGION"))
+	config.TwilioEdge = strings.ToLower(os.Getenv("TWILIO_EDGE"))
+	config.TwilioAPIURL = getEnv("TWILIO_API_URL", twilioRegionalAPIURL(config.TwilioRegion, config.TwilioEdge))
+	config.OpenAIRealtimeURL = getEnv("OPENAI_REALTIME_URL", "wss://api.openai.com/v1/realtime?model=gpt-4o-realtime-preview-2024-10-01")
+	config.OpenAIRealtimeAPI = getEnv("OPENAI_REALTIME_API", "auto")
+	config.OpenAIRealtimeModels = getEnvList("OPENAI_REALTIME_MODELS")
+	config.OpenAIDialRetries = getEnvInt("OPENAI_DIAL_RETRIES", 2)
+	config.OpenAIDialBackoff = getEnvDuration("OPENAI_DIAL_BACKOFF", 500*time.Millisecond)
+	config.OpenAIConnectTimeout = getEnvDuration("OPENAI_CONNECT_TIMEOUT", 10*time.Second)
+	config.OpenAIRateLimitPercent = getEnvInt("OPENAI_RATE_LIMIT_PERCENT", 10)
+	config.OpenAIRateLimitDelay = getEnvDuration("OPENAI_RATE_LIMIT_DELAY", 5*time.Second)
+	config.OpenAISessionRenewAfter = getEnvDuration("OPENAI_SESSION_RENEW_AFTER", 25*time.Minute)
+	config.OpenAIContextLimit = getEnvInt("OPENAI_CONTEXT_LIMIT", 24000)
+	config.OpenAIContextKeepItems = getEnvInt("OPENAI_CONTEXT_KEEP_ITEMS", 10)
+	config.OpenAIOrganization = os.Getenv("OPENAI_ORGANIZATION")
+	config.OpenAIProject = os.Getenv("OPENAI_PROJECT")
+	config.OpenAISIPProjectID = os.Getenv("OPENAI_SIP_PROJECT_ID")
+	config.RealtimeClientSecretTTL = getEnvDuration("REALTIME_CLIENT_SECRET_TTL", 10*time.Minute)
+	config.OpenAIProxyURL = os.Getenv("OPENAI_PROXY_URL")
+	config.AudioForkURL = os.Getenv("AUDIO_FORK_URL")
+	config.TenantUsageFile = os.Getenv("TENANT_USAGE_FILE")
+	config.UsageFile = os.Getenv("USAGE_FILE")
+	config.StorageBackend = getEnv("STORAGE_BACKEND", "sqlite")
+	config.CallLogDB = getEnv("CALL_LOG_DB", "calls.db")
+	config.StorageS3Bucket = os.Getenv("STORAGE_S3_BUCKET")
+	config.StorageS3Region = os.Getenv("STORAGE_S3_REGION")
+	config.StorageS3Prefix = os.Getenv("STORAGE_S3_PREFIX")
+	config.StorageS3Endpoint = os.Getenv("STORAGE_S3_ENDPOINT")
+	config.RecordCalls = getEnvBool("RECORD_CALLS")
+	config.AuditLogFile = os.Getenv("AUDIT_LOG_FILE")
+	config.PprofEnabled = getEnvBool("PPROF_ENABLED")
+	config.StatsDAddr = os.Getenv("STATSD_ADDR")
+	config.StatsDPrefix = getEnv("STATSD_PREFIX", "twilio_voice_openai.")
+	config.StatsDFlavor = getEnv("STATSD_FLAVOR", "dogstatsd")
+	config.StatsDTags = getEnvList("STATSD_TAGS")
+	config.SentryEnvironment = getEnv("SENTRY_ENVIRONMENT", "production")
+	config.ErrorWebhookURL = os.Getenv("ERROR_WEBHOOK_URL")
+	config.ComplianceSTT = os.Getenv("COMPLIANCE_STT")
+	config.ComplianceSTTURL = os.Getenv("COMPLIANCE_STT_URL")
+	config.TranscribeURL = os.Getenv("TRANSCRIBE_URL")
+	config.TranscribeModel = getEnv("TRANSCRIBE_MODEL", "whisper-1")
+	config.RecordingDir = os.Getenv("RECORDING_DIR")
+	config.AudioClipsDir = os.Getenv("AUDIO_CLIPS_DIR")
+	config.AudioClipOnStart = os.Getenv("AUDIO_CLIP_ON_START")
+	config.GreetingCache = getEnvBool("GREETING_CACHE")
+	config.GreetingStrategy = getEnv("GREETING_STRATEGY", "message")
+	config.GreetingAudioClip = os.Getenv("GREETING_AUDIO_CLIP")
+	config.MaxResponseTokens = getEnvInt("MAX_RESPONSE_TOKENS", 0)
+	config.MaxResponseAudio = getEnvDuration("MAX_RESPONSE_AUDIO", 0)
+	config.MaxTalkTime = getEnvDuration("MAX_TALK_TIME", 0)
+	config.OpenAIAudioFormat = getEnv("OPENAI_AUDIO_FORMAT", "g711_ulaw")
+	config.RecordingChannels = getEnv("RECORDING_CHANNELS", "mono")
+	config.RecordingStartPaused = getEnvBool("RECORDING_START_PAUSED")
+	config.RecordingExcludeNumbers = getEnvList("RECORDING_EXCLUDE_NUMBERS")
+	config.AudioPacing = getEnvBool("AUDIO_PACING")
+	config.AudioPacingPrebufferMs = getEnvInt("AUDIO_PACING_PREBUFFER_MS", 100)
+	config.OutboundQueueSize = getEnvInt("OUTBOUND_QUEUE_SIZE", 250)
+	config.TwilioQueuePolicy = getEnv("TWILIO_QUEUE_POLICY", "drop-oldest")
+	config.OpenAIQueuePolicy = getEnv("OPENAI_QUEUE_POLICY", "merge")
+	config.InputAudioBatchMs = getEnvInt("INPUT_AUDIO_BATCH_MS", 0)
+	config.WSReadTimeout = getEnvDuration("WS_READ_TIMEOUT", time.Minute)
+	config.WSWriteTimeout = getEnvDuration("WS_WRITE_TIMEOUT", 10*time.Second)
+	config.WSMaxMessageBytes = int64(getEnvInt("WS_MAX_MESSAGE_BYTES", 1<<20))
+	config.WSOriginPolicy = getEnv("WS_ORIGIN_POLICY", "twilio-only")
+	config.WSAllowedOrigins = getEnvList("WS_ALLOWED_ORIGINS")
+	config.AccessLog = getEnv("ACCESS_LOG", "true") == "true"
+	config.AccessLogExcludePaths = map[string]struct{}{}
+	for _, path := range getEnvList("ACCESS_LOG_EXCLUDE_PATHS") {
+		config.AccessLogExcludePaths[path] = struct{}{}
+	}
+	config.LogLevel = getEnv("LOG_LEVEL", logLevelInfo)
+	config.LogEventTypes = getEnvList("LOG_EVENT_TYPES")
+	if len(config.LogEventTypes) == 0 {
+		config.LogEventTypes = defaultLogEventTypes
+	}
+	setLogSettings(config.LogLevel, config.LogEventTypes)
+	config.TwilioIPAllowlist = getEnvList("TWILIO_IP_ALLOWLIST")
+	config.TwilioIPRangesURL = os.Getenv("TWILIO_IP_RANGES_URL")
+	config.TwilioIPRangesRefresh = getEnvDuration("TWILIO_IP_RANGES_REFRESH", time.Hour)
+	config.TwilioValidateSigs = getEnvBool("TWILIO_VALIDATE_SIGNATURES")
+	config.TrustedProxies = getEnvList("TRUSTED_PROXIES")
+	config.StreamTokenSecret = os.Getenv("STREAM_TOKEN_SECRET")
+	config.StreamTokenTTL = getEnvDuration("STREAM_TOKEN_TTL", time.Minute)
+	config.GRPCAddr = os.Getenv("GRPC_ADDR")
+	config.GRPCTLSCert = os.Getenv("GRPC_TLS_CERT")
+	config.GRPCTLSKey = os.Getenv("GRPC_TLS_KEY")
+	config.NATSURL = os.Getenv("NATS_URL")
+	config.NATSCreds = os.Getenv("NATS_CREDS")
+	config.NATSStream = getEnv("NATS_STREAM", "CALLS")
+	config.NATSSubject = getEnv("NATS_SUBJECT", "calls")
+	config.NATSMaxAge = getEnvDuration("NATS_MAX_AGE", 7*24*time.Hour)
+	config.NATSConsumers = getEnvList("NATS_CONSUMERS")
+	config.NATSEventTypes = getEnvList("NATS_EVENT_TYPES")
+	config.SQSQueueURL = os.Getenv("SQS_QUEUE_URL")
+	config.EventBridgeBus = os.Getenv("EVENTBRIDGE_BUS")
+	config.EventBridgeSource = getEnv("EVENTBRIDGE_SOURCE", "twilio-voice-openai")
+	config.AWSEventTypes = getEnvList("AWS_EVENT_TYPES")
+	config.PubSubTopic = os.Getenv("PUBSUB_TOPIC")
+	config.PubSubTopicPerType = getEnvBool("PUBSUB_TOPIC_PER_TYPE")
+	config.PubSubEndpoint = getEnv("PUBSUB_ENDPOINT", "https://pubsub.googleapis.com")
+	config.PubSubEventTypes = getEnvList("PUBSUB_EVENT_TYPES")
+	config.AMQPURL = os.Getenv("AMQP_URL")
+	config.AMQPExchange = getEnv("AMQP_EXCHANGE", "calls")
+	config.AMQPExchangeType = getEnv("AMQP_EXCHANGE_TYPE", "topic")
+	config.AMQPRoutingKey = getEnv("AMQP_ROUTING_KEY", "{{.Type}}")
+	config.AMQPEventTypes = getEnvList("AMQP_EVENT_TYPES")
+	config.RedisURL = os.Getenv("REDIS_URL")
+	config.RedisKeyPrefix = getEnv("REDIS_KEY_PREFIX", "twilio-voice-openai:")
 
-	if config.OpenAIAPIKey == "" || config.SystemMessage == "" || config.Port == "" || config.XMLResponse == "" || config.WebhookURL == "" {
-		log.Fatal("Missing required environment variables. Please check your .env file.")
+	client, err := newWebhookClient()
+	if err != nil {
+		log.Fatal(err)
 	}
+	webhookClient = client
+
+	file, err := readConfigFile(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatal("Error loading config file: ", err)
+	}
+	config.File = file
+	if err := parseWebhookTemplates(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := loadSecrets(); err != nil {
+		log.Fatal("Error loading secrets: ", err)
+	}
+	config.OpenAIAPIKey = secret("OPENAI_API_KEY")
+	updateRedactions()
+}
+
+func getEnv(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvBool(name string) bool {
+	value, _ := strconv.ParseBool(os.Getenv(name))
+	return value
+}
+
+func getEnvInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func getEnvDuration(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
 }
 
 func handleRoot(w http.ResponseWriter, r *http.Request) {
-	json.NewEncoder(w).Encode(map[string]string{"message": "Twilio Media Stream Server is running!"})
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":    "Twilio Media Stream Server is running!",
+		"version":    Version,
+		"commit":     Commit,
+		"build_date": BuildDate,
+	})
 }
 
 func handleIncomingCall(w http.ResponseWriter, r *http.Request) {
+	joiningConference := config.ConferenceAINumber != "" && r.FormValue("To") == config.ConferenceAINumber && claimConferenceJoin(r.FormValue("CallSid"))
+	tenant := tenantForCall(r)
+	if !joiningConference && atCapacity() {
+		writeBusyTwiML(w, tenant, true)
+		return
+	}
+
+	if !joiningConference && rateLimited(tenant.openAIKeyName()) {
+		log.Printf("Rejected call %s: OpenAI rate limits nearly used up\n", r.FormValue("CallSid"))
+		rateLimitRejectionsTotal.add(1)
+		writeBusyTwiML(w, tenant, true)
+		return
+	}
+	if quota := tenant.quotaExceeded(); quota != "" && !joiningConference {
+		log.Printf("Rejected call for tenant %s: %s quota reached\n", tenant.ID, quota)
+		quotaRejectionsTotal.add(1, tenant.ID, quota)
+		writeBusyTwiML(w, tenant, quota == "concurrent_calls")
+		return
+	}
+
+	// Calls we place ourselves, such as callbacks, reach the caller on "To".
+	// They also carry the placeholder their lock is held under.
+	number, line, placeholder := r.FormValue("From"), r.FormValue("To"), ""
+	if r.FormValue("Direction") == "outbound-api" {
+		if handleAnsweredByMachine(w, r) {
+			return
+		}
+		number, line, placeholder = line, number, r.URL.Query().Get("lock")
+	}
+	if !joiningConference && !claimCallerLock(callerLockKey(tenant, number), r.FormValue("CallSid"), placeholder) {
+		log.Printf("Rejected call %s: %s is already on a call\n", r.FormValue("CallSid"), number)
+		callerLockRejectionsTotal.add(1, "inbound")
+		writeBusyTwiML(w, tenant, false)
+		return
+	}
+
+	if sipMode() {
+		writeSIPTwiML(w, number, r.FormValue("CallSid"))
+		return
+	}
+
+	// The line we answered on comes back in the start event, for usage, and
+	// so does whether the greeting was said here.
+	var escapedURL, escapedLine, say, greetingParameter strings.Builder
+	xml.EscapeText(&escapedURL, []byte(streamURL(r, mediaStreamPath(tenant, number))))
+	xml.EscapeText(&escapedLine, []byte(line))
+	if strategy, _ := tenant.greetingStrategy(); strategy == "say" {
+		_, greeting := callPrompts(tenant, r.FormValue("CallSid"))
+		// Twilio says the greeting before the caller can be looked up.
+		if callerLookupConfigured(tenant) {
+			greeting = renderPrompt(greeting, nil)
+		}
+		say.WriteString("<Say>")
+		xml.EscapeText(&say, []byte(greeting))
+		say.WriteString("</Say>")
+		greetingParameter.WriteString(`<Parameter name="greeting" value="said" />`)
+	}
 	twimlResponse := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
 		<Response>
+			%s
 			<Connect>
-				<Stream url="wss://%s/media-stream/%s" />
+				<Stream url="%s">
+					<Parameter name="line" value="%s" />
+					%s
+				</Stream>
 			</Connect>
-		</Response>`, r.Host, r.FormValue("From"))
+		</Response>`, say.String(), escapedURL.String(), escapedLine.String(), greetingParameter.String())
 
 	w.Header().Set("Content-Type", "text/xml")
 	w.Write([]byte(twimlResponse))
 }
 
 func handleMediaStream(w http.ResponseWriter, r *http.Request) {
+	var tenant *tenantConfig
+	if id := r.PathValue("tenant"); id != "" {
+		if tenant = config.File.Tenants[id]; tenant == nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	if err := verifyStreamToken(streamTokenSubject(tenant.id(), r.PathValue("number")), r.PathValue("token")); err != nil {
+		log.Println("Rejected media stream:", err)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Error upgrading to WebSocket:", err)
@@ -87,124 +834,268 @@ func handleMediaStream(w http.ResponseWriter, r *http.Request) {
 	}
 	defer ws.Close()
 
-	openAIWs, _, err := websocket.DefaultDialer.Dial("wss://api.openai.com/v1/realtime?model=gpt-4o-realtime-preview-2024-10-01", http.Header{
-		"Authorization": []string{"Bearer " + config.OpenAIAPIKey},
-		"OpenAI-Beta":   []string{"realtime=v1"},
-	})
+	openAIWs, model, backlog, err := dialOpenAIForStream(tenant, ws)
 	if err != nil {
 		log.Println("Error connecting to OpenAI WebSocket:", err)
+		failoverUnstartedStream(ws, backlog, tenant)
 		return
 	}
 	defer openAIWs.Close()
 
-	var streamSid string
+	session := newCallSession(r.PathValue("number"), model, tenant, ws, openAIWs)
+	session.twilioBacklog = backlog
+	defer session.end()
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 
-	go handleOpenAIMessages(openAIWs, ws, &streamSid, r.PathValue("number"), &wg)
-	go handleTwilioMessages(ws, openAIWs, &streamSid, &wg)
+	go handleOpenAIMessages(session, &wg)
+	go handleTwilioMessages(session, &wg)
 
-	if err := sendInitialMessages(openAIWs); err != nil {
-		log.Println("Error sending initial messages:", err)
-		return
+	wg.Wait()
+}
+
+// openAIHeader authenticates requests to OpenAI with the tenant's key, or
+// OPENAI_API_KEY for nil, attributing usage to OPENAI_ORGANIZATION and
+// OPENAI_PROJECT when they are set.
+func openAIHeader(tenant *tenantConfig) http.Header {
+	header := http.Header{"Authorization": []string{"Bearer " + tenant.openAIKey()}}
+	if config.OpenAIOrganization != "" {
+		header.Set("OpenAI-Organization", config.OpenAIOrganization)
 	}
+	if config.OpenAIProject != "" {
+		header.Set("OpenAI-Project", config.OpenAIProject)
+	}
+	return header
+}
 
-	wg.Wait()
+// dialOpenAI connects with the first of realtimeModels that is available,
+// returning the model it connected with.
+func dialOpenAI(tenant *tenantConfig) (*websocket.Conn, string, error) {
+	return dialOpenAIContext(context.Background(), tenant)
+}
+
+func dialOpenAIContext(ctx context.Context, tenant *tenantConfig) (*websocket.Conn, string, error) {
+	dialer := &websocket.Dialer{Proxy: openAIProxy, HandshakeTimeout: 45 * time.Second}
+	models := realtimeModels()
+
+	var err error
+	for i, model := range models {
+		header := openAIHeader(tenant)
+		if !realtimeGA(model) {
+			header.Set("OpenAI-Beta", "realtime=v1")
+		}
+
+		var conn *websocket.Conn
+		var resp *http.Response
+		conn, resp, err = dialer.DialContext(ctx, realtimeURL(model), header)
+		if err == nil {
+			return conn, model, nil
+		}
+		if resp != nil {
+			err = &openAIDialError{err: err, status: resp.StatusCode}
+		}
+		if !retryNextModel(resp) || i == len(models)-1 {
+			break
+		}
+		log.Printf("Error connecting to OpenAI with model %s, falling back to %s: %v\n", model, models[i+1], err)
+		modelFallbacksTotal.add(1, model)
+	}
+	return nil, "", err
+}
+
+func sessionConfig(instructions string) map[string]interface{} {
+	session := map[string]interface{}{
+		"turn_detection":      map[string]string{"type": "server_vad"},
+		"input_audio_format":  config.OpenAIAudioFormat,
+		"output_audio_format": config.OpenAIAudioFormat,
+		"voice":               "alloy",
+		"instructions":        instructions,
+		"modalities":          []string{"text", "audio"},
+		"temperature":         0.8,
+		"tools":               toolDefinitions(),
+	}
+
+	if recordingEnabled() || escalationConfigured() {
+		session["input_audio_transcription"] = map[string]string{"model": "whisper-1"}
+	}
+	if config.MaxResponseTokens > 0 {
+		session["max_response_output_tokens"] = config.MaxResponseTokens
+	}
+
+	return session
+}
+
+// callPrompts returns the instructions and greeting for a call: the
+// tenant's, or the reminder's on a reminder call.
+func callPrompts(tenant *tenantConfig, callSid string) (instructions, greeting string) {
+	if reminderInstructions, reminderGreeting, ok := reminderPrompt(callSid); ok {
+		return reminderInstructions, reminderGreeting
+	}
+	return tenant.prompts()
+}
+
+// sessionConfig returns the OpenAI session configuration of a call, with
+// its instructions and greeting.
+func (s *callSession) sessionConfig() (session map[string]interface{}, instructions, greeting string) {
+	instructions, greeting = s.prompts()
+	session = sessionConfig(instructions)
+	if s.sip() {
+		session = sipSessionConfig(instructions)
+	}
+	if s.tenant != nil {
+		session["tools"] = s.tenant.toolDefinitions()
+	}
+	return session, instructions, greeting
 }
 
-func sendInitialMessages(openAIWs *websocket.Conn) error {
-	messages := []map[string]interface{}{
-		{
-			"type": "session.update",
-			"session": map[string]interface{}{
-				"turn_detection":      map[string]string{"type": "server_vad"},
-				"input_audio_format":  "g711_ulaw",
-				"output_audio_format": "g711_ulaw",
-				"voice":               "alloy",
-				"instructions":        config.SystemMessage,
-				"modalities":          []string{"text", "audio"},
-				"temperature":         0.8,
-				"tools": []map[string]interface{}{
-					{
-						"type":        "function",
-						"name":        "setup_schedule",
-						"description": "Setup business meeting schedule",
-						"parameters": map[string]interface{}{
-							"type": "object",
-							"properties": map[string]interface{}{
-								"name":        map[string]string{"type": "string", "description": "Please tell me your name"},
-								"email":       map[string]string{"format": "email", "type": "string", "description": "please provide your email address"},
-								"datetime":    map[string]string{"type": "string", "format": "date-time", "description": "Please provide the date and time of the meeting"},
-								"description": map[string]string{"type": "string", "description": "what is the purpose of the meeting?"},
-							},
-							"required": []string{"name", "email", "description"},
-						},
-					},
-				},
-			},
-		},
-		{
-			"type": "conversation.item.create",
-			"item": map[string]interface{}{
-				"id":   "greeting_01",
-				"type": "message",
-				"role": "assistant",
-				"content": []map[string]interface{}{
-					{"type": "text", "text": config.XMLResponse},
-				},
-			},
-		},
-		{"type": "response.create"},
+// sendInitialMessages configures the OpenAI session and greets the caller
+// once Twilio's start event has identified the call, so outbound calls such
+// as reminders can use their own instructions and greeting. greetingSaid
+// reports that Twilio has already said the greeting.
+func sendInitialMessages(s *callSession, greetingSaid bool) error {
+	session, instructions, greeting := s.sessionConfig()
+	model := s.modelName()
+
+	strategy, clip := s.tenant.greetingStrategy()
+	if strategy == "say" && !greetingSaid {
+		strategy = "instruct"
+	}
+
+	var messages []map[string]interface{}
+	var cached *cachedGreeting
+	switch strategy {
+	case "instruct":
+		messages = []map[string]interface{}{sessionUpdate(model, session), instructedGreeting(instructions, greeting)}
+	case "audio", "say":
+		messages = []map[string]interface{}{sessionUpdate(model, session), systemNote(greetedNote(greeting))}
+	default:
+		// The cached greeting only matches the default instructions and greeting.
+		if !s.sip() && instructions == config.SystemMessage && greeting == config.XMLResponse {
+			cached = getCachedGreeting()
+		}
+		messages = greetingMessages(model, session, greeting)
+		if cached == nil {
+			messages = append(messages, map[string]interface{}{"type": "response.create"})
+		} else if cached.transcript != "" {
+			messages = append(messages, assistantMessage(model, "greeting_02", cached.transcript))
+		}
 	}
 
 	for _, msg := range messages {
-		if err := openAIWs.WriteJSON(&msg); err != nil {
+		if err := s.sendOpenAI(&msg); err != nil {
 			return fmt.Errorf("error sending message: %v", err)
 		}
 	}
 
+	if cached != nil {
+		s.playCachedGreeting(cached)
+	}
+	if strategy == "audio" {
+		if err := s.playClip(clip, "greeting"); err != nil {
+			return fmt.Errorf("error playing greeting: %v", err)
+		}
+	}
 	return nil
 }
 
-func handleOpenAIMessages(openAIWs, twilioWs *websocket.Conn, streamSid *string, phoneNumber string, wg *sync.WaitGroup) {
+func handleOpenAIMessages(s *callSession, wg *sync.WaitGroup) {
 	defer wg.Done()
+	defer s.hangup()
+	defer s.recoverPanic("openai_reader")
 	for {
 		var response map[string]interface{}
-		if err := openAIWs.ReadJSON(&response); err != nil {
+		if err := s.openAIWs.ReadJSON(&response); err != nil {
 			log.Println("Error reading from OpenAI WebSocket:", err)
+			s.reportClosure("OpenAI", err)
+			select {
+			case <-s.done:
+			default:
+				// A SIP sideband closes when the call ends.
+				if !s.sip() {
+					s.failover("openai_disconnect")
+				}
+			}
 			return
 		}
+		extendReadDeadline(s.openAIWs)
 
-		responseType, _ := response["type"].(string)
-		if _, ok := logEventTypes[responseType]; ok {
-			log.Printf("Received OpenAI message: %s\n", responseType)
-		}
+		responseType := normalizeRealtimeEvent(response)
+		s.logEvent("openai", responseType, response)
+		s.trackOpenAIEvent(responseType)
+		s.trackGoodbye(responseType, response)
 
 		if responseType == "error" {
 			log.Printf("OpenAI error: %v\n", response)
+			s.reportOpenAIError(response)
+			if fatalOpenAIError(response) {
+				s.failover("openai_error")
+				return
+			}
 			continue
 		}
 
 		if responseType == "response.audio.delta" {
 			if delta, ok := response["delta"].(string); ok {
-				audioDelta := map[string]interface{}{
-					"event":     "media",
-					"streamSid": *streamSid,
-					"media":     map[string]string{"payload": delta},
+				itemID, _ := response["item_id"].(string)
+				if responseID, _ := response["response_id"].(string); s.outOfBandResponse(responseID) {
+					itemID = ""
 				}
-				if err := twilioWs.WriteJSON(audioDelta); err != nil {
+				if err := s.forwardAudio(itemID, delta); err != nil {
 					log.Println("Error sending audio delta to Twilio:", err)
 				}
 			}
 		}
 
+		switch responseType {
+		case "response.done":
+			if resp, ok := response["response"].(map[string]interface{}); ok {
+				s.addUsage(resp)
+				s.saveSnapshot()
+				s.compactContext(resp)
+				if summary, ok := s.renewalSummary(resp); ok {
+					s.renewSession(summary)
+				}
+			}
+			if redirect := s.takePendingRedirect(); redirect != nil {
+				go s.completeRedirect(redirect)
+			} else {
+				go s.startPendingEscalation()
+			}
+		case "rate_limits.updated":
+			s.updateRateLimits(response)
+		case "conversation.item.created", "conversation.item.deleted":
+			s.trackConversationItem(responseType, response)
+		case "input_audio_buffer.speech_started":
+			s.abandonRenewal()
+			s.interrupt()
+		case "conversation.item.input_audio_transcription.delta":
+			delta, _ := response["delta"].(string)
+			itemID, _ := response["item_id"].(string)
+			s.publishTranscriptDelta("caller", itemID, delta)
+		case "conversation.item.input_audio_transcription.completed":
+			transcript, _ := response["transcript"].(string)
+			itemID, _ := response["item_id"].(string)
+			s.addTranscript("caller", itemID, transcript)
+			s.checkEscalation(transcript)
+		case "response.audio_transcript.delta":
+			delta, _ := response["delta"].(string)
+			itemID, _ := response["item_id"].(string)
+			s.publishTranscriptDelta("assistant", itemID, delta)
+		case "response.audio_transcript.done":
+			transcript, _ := response["transcript"].(string)
+			itemID, _ := response["item_id"].(string)
+			s.addTranscript("assistant", itemID, transcript)
+		}
+
 		if resp, ok := response["response"].(map[string]interface{}); ok {
-			handleOpenAIResponse(resp, openAIWs, phoneNumber)
+			s.trackOutOfBand(responseType, resp)
+			handleOpenAIResponse(resp, s)
 		}
 	}
 }
 
-func handleOpenAIResponse(response map[string]interface{}, openAIWs *websocket.Conn, phoneNumber string) {
+func handleOpenAIResponse(response map[string]interface{}, s *callSession) {
 	output, ok := response["output"].([]interface{})
 	if !ok || len(output) == 0 {
 		return
@@ -220,69 +1111,94 @@ func handleOpenAIResponse(response map[string]interface{}, openAIWs *websocket.C
 	arguments, _ := firstOutput["arguments"].(string)
 	callID, _ := firstOutput["call_id"].(string)
 
-	if outputType == "function_call" && name == "setup_schedule" {
-		var data map[string]string
-		if err := json.Unmarshal([]byte(arguments), &data); err != nil {
-			log.Println("Error parsing JSON:", err)
-			return
-		}
-
-		if err := setupSchedule(data["name"], data["email"], data["datetime"], data["description"], phoneNumber); err != nil {
-			log.Println("Error setting up schedule:", err)
-			return
-		}
-
-		webhookResponse := map[string]interface{}{
-			"type": "conversation.item.create",
-			"item": map[string]interface{}{
-				"call_id": callID,
-				"type":    "function_call_output",
-				"output":  "Your schedule has been set successfully!",
-			},
-		}
-		if err := openAIWs.WriteJSON(webhookResponse); err != nil {
-			log.Println("Error sending webhook response to OpenAI:", err)
-		}
-
-		responseCreate := map[string]interface{}{"type": "response.create"}
-		if err := openAIWs.WriteJSON(&responseCreate); err != nil {
-			log.Println("Error sending response create:", err)
-		}
+	if outputType == "function_call" {
+		s.record("tool.call", name)
+		s.recorder.addToolCall(callID, name, arguments)
+		s.mu.Lock()
+		s.toolCalls++
+		s.mu.Unlock()
+		go callTool(s, name, callID, arguments)
 	}
 }
 
-func handleTwilioMessages(twilioWs, openAIWs *websocket.Conn, streamSid *string, wg *sync.WaitGroup) {
+func handleTwilioMessages(s *callSession, wg *sync.WaitGroup) {
 	defer wg.Done()
+	defer s.hangup()
+	defer s.recoverPanic("twilio_reader")
 	for {
 		var data map[string]interface{}
-		if err := twilioWs.ReadJSON(&data); err != nil {
+		if len(s.twilioBacklog) > 0 {
+			data, s.twilioBacklog = s.twilioBacklog[0], s.twilioBacklog[1:]
+		} else if err := s.twilioWs.ReadJSON(&data); err != nil {
 			log.Println("Error reading from Twilio WebSocket:", err)
+			s.reportClosure("Twilio", err)
 			return
 		}
+		extendReadDeadline(s.twilioWs)
 
 		event, _ := data["event"].(string)
+		s.logEvent("twilio", event, data)
 		switch event {
 		case "media":
 			media, _ := data["media"].(map[string]interface{})
 			payload, _ := media["payload"].(string)
-			audioAppend := map[string]interface{}{
-				"type":  "input_audio_buffer.append",
-				"audio": payload,
+			audio, err := base64.StdEncoding.DecodeString(payload)
+			if err != nil {
+				log.Println("Error decoding media payload:", err)
+				continue
+			}
+			var wide []byte
+			if s.leg != nil {
+				wide, audio = s.leg.decode(audio)
 			}
-			if err := openAIWs.WriteJSON(audioAppend); err != nil {
+			s.teeAudio("inbound", audio)
+			if err := s.appendInputAudio(audio, wide); err != nil {
 				log.Println("Error sending audio append to OpenAI:", err)
 			}
+			s.recorder.appendAudio(audio)
+			if s.recorder.recording() {
+				s.stt.send(audio)
+			} else {
+				s.stt.send(silence(len(audio)))
+			}
 		case "start":
 			start, _ := data["start"].(map[string]interface{})
-			*streamSid, _ = start["streamSid"].(string)
-			log.Println("Incoming stream has started", *streamSid)
+			streamSid, _ := start["streamSid"].(string)
+			callSid, _ := start["callSid"].(string)
+			parameters, _ := start["customParameters"].(map[string]interface{})
+			line, _ := parameters["line"].(string)
+			greetingSaid := parameters["greeting"] == "said"
+			leg, err := streamLeg(start)
+			if err != nil {
+				log.Println("Error starting stream:", err)
+				return
+			}
+			s.mu.Lock()
+			s.leg = leg
+			s.mu.Unlock()
+			s.start(streamSid, callSid, line)
+			s.fork.start(s)
+			// Clips play in real time, so the reader can't wait for them.
+			if strategy, _ := s.tenant.greetingStrategy(); config.AudioClipOnStart != "" || strategy == "audio" {
+				go s.startCall(greetingSaid)
+			} else {
+				s.startCall(greetingSaid)
+			}
+			log.Println("Incoming stream has started", streamSid)
+		case "mark":
+			mark, _ := data["mark"].(map[string]interface{})
+			name, _ := mark["name"].(string)
+			s.markPlayed(name)
+		case "stop":
+			log.Println("Incoming stream has stopped", s.streamSid())
+			return
 		default:
 			log.Println("Received non-media event:", event)
 		}
 	}
 }
 
-func setupSchedule(name, email, datetime, description, phoneNumber string) error {
+func setupSchedule(s *callSession, name, email, datetime, description string) error {
 	data := struct {
 		Name        string `json:"name"`
 		Email       string `json:"email"`
@@ -291,23 +1207,12 @@ func setupSchedule(name, email, datetime, description, phoneNumber string) error
 		PhoneNumber string `json:"phone_number"`
 	}{
 		Name: name, Email: email, DateTime: datetime,
-		Description: description, PhoneNumber: phoneNumber,
+		Description: description, PhoneNumber: s.phoneNumber,
 	}
 
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return fmt.Errorf("error marshaling JSON: %v", err)
-	}
-
-	resp, err := http.Post(config.WebhookURL, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("error sending request: %v", err)
+	if err := deliverWebhook("schedule", s.summary(), data); err != nil {
+		return err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-
+	scheduleAppointmentReminder(s, name, email, datetime, description)
 	return nil
 }
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+)
+
+// Each call has a metadata scratchpad of JSON values by key, such as
+// verified=true or customer_id=123, for state that outlives a single tool
+// call. Tools read and write it in code, the model through the
+// save_call_data and read_call_data tools, webhooks answering a query
+// through a "metadata" object in their response, and admins through
+// PATCH /admin/calls/{id}/metadata. It is part of the call summary, so
+// every webhook template sees it as .Call.metadata and the call.ended
+// payload carries it.
+
+// setMetadata merges values into the call's metadata. A null value removes
+// its key. source says who set them, for the timeline.
+func (s *callSession) setMetadata(values map[string]interface{}, source string) {
+	if len(values) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(values))
+	s.mu.Lock()
+	if s.metadata == nil {
+		s.metadata = map[string]interface{}{}
+	}
+	for key, value := range values {
+		keys = append(keys, key)
+		if value == nil {
+			delete(s.metadata, key)
+		} else {
+			s.metadata[key] = value
+		}
+	}
+	s.mu.Unlock()
+	sort.Strings(keys)
+	s.record("metadata.set", fmt.Sprintf("%s by %s", strings.Join(keys, ", "), source))
+	s.saveSnapshot()
+}
+
+// callMetadata returns a copy of the call's metadata. It must be called
+// with s.mu locked.
+func (s *callSession) callMetadata() map[string]interface{} {
+	if len(s.metadata) == 0 {
+		return nil
+	}
+	return maps.Clone(s.metadata)
+}
+
+var saveDataTool = &tool{
+	name:        "save_call_data",
+	description: "Save a piece of information about this call under a key, such as the caller's customer number or what they have agreed to, so it can be read back later and is passed on when the call ends.",
+	parameters: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"key":   map[string]string{"type": "string", "description": "A short snake_case name for the information, e.g. customer_id"},
+			"value": map[string]string{"type": "string", "description": "The information to save"},
+		},
+		"required": []string{"key", "value"},
+	},
+	silent: true,
+	run: func(s *callSession, arguments string) (string, error) {
+		var data map[string]string
+		if err := json.Unmarshal([]byte(arguments), &data); err != nil {
+			return "", fmt.Errorf("error parsing JSON: %v", err)
+		}
+		key := strings.TrimSpace(data["key"])
+		if key == "" {
+			return "No key given, nothing was saved.", nil
+		}
+		s.setMetadata(map[string]interface{}{key: data["value"]}, "model")
+		return "Saved.", nil
+	},
+}
+
+var readDataTool = &tool{
+	name:        "read_call_data",
+	description: "Read the information saved about this call so far.",
+	parameters:  map[string]interface{}{"type": "object", "properties": map[string]interface{}{}},
+	run: func(s *callSession, arguments string) (string, error) {
+		s.mu.Lock()
+		metadata := s.callMetadata()
+		s.mu.Unlock()
+		if metadata == nil {
+			return "Nothing has been saved about this call yet.", nil
+		}
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			return "", fmt.Errorf("error marshaling JSON: %v", err)
+		}
+		return string(data), nil
+	},
+}
@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const metricsNamespace = "twilio_voice_openai_"
+
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 0.75, 1, 1.5, 2, 3, 5, 10}
+
+var (
+	metricsRegistry []*metric
+
+	greetingLatency = newHistogram("greeting_latency_seconds", "Time from the media stream connecting to the first greeting audio sent to Twilio.", latencyBuckets)
+	turnLatency     = newHistogram("turn_latency_seconds", "Time from the caller stopping speaking to the first response audio sent to Twilio.", latencyBuckets)
+)
+
+// metric is a Prometheus-style counter, gauge or histogram with optional
+// labels, rendered in the text exposition format by handleMetrics.
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+func newMetric(kind, name, help string, buckets []float64, labels []string) *metric {
+	m := &metric{
+		name:    metricsNamespace + name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+	metricsRegistry = append(metricsRegistry, m)
+	return m
+}
+
+func newCounter(name, help string, labels ...string) *metric {
+	return newMetric("counter", name, help, nil, labels)
+}
+
+func newGauge(name, help string, labels ...string) *metric {
+	return newMetric("gauge", name, help, nil, labels)
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *metric {
+	return newMetric("histogram", name, help, buckets, labels)
+}
+
+func (m *metric) get(labelValues []string) *series {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: labelValues, counts: make([]uint64, len(m.buckets))}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (m *metric) add(v float64, labelValues ...string) {
+	m.addFor(nil, v, labelValues...)
+}
+
+func (m *metric) set(v float64, labelValues ...string) {
+	m.mu.Lock()
+	m.get(labelValues).value = v
+	m.mu.Unlock()
+	sendStatsD(m, v, labelValues, nil)
+}
+
+func (m *metric) observe(v float64, labelValues ...string) {
+	m.observeFor(nil, v, labelValues...)
+}
+
+// value returns a counter or gauge's current value.
+func (m *metric) value(labelValues ...string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.get(labelValues).value
+}
+
+// addFor and observeFor update m on behalf of a call. StatsD samples are
+// tagged with the call's agent and tenant; Prometheus series are not, to
+// keep their number down.
+func (m *metric) addFor(call *callSession, v float64, labelValues ...string) {
+	m.mu.Lock()
+	m.get(labelValues).value += v
+	m.mu.Unlock()
+	sendStatsD(m, v, labelValues, call.statsdTags())
+}
+
+func (m *metric) observeFor(call *callSession, v float64, labelValues ...string) {
+	m.mu.Lock()
+	s := m.get(labelValues)
+	for i, bound := range m.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+	m.mu.Unlock()
+	sendStatsD(m, v, labelValues, call.statsdTags())
+}
+
+func (m *metric) labelPairs(values []string, extra ...string) string {
+	var pairs []string
+	for i, name := range m.labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", extra[i], extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (m *metric) write(b *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		if m.kind != "histogram" {
+			fmt.Fprintf(b, "%s%s %g\n", m.name, m.labelPairs(s.labelValues), s.value)
+			continue
+		}
+		for i, bound := range m.buckets {
+			fmt.Fprintf(b, "%s_bucket%s %d\n", m.name, m.labelPairs(s.labelValues, "le", fmt.Sprint(bound)), s.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", m.name, m.labelPairs(s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(b, "%s_sum%s %g\n", m.name, m.labelPairs(s.labelValues), s.sum)
+		fmt.Fprintf(b, "%s_count%s %d\n", m.name, m.labelPairs(s.labelValues), s.count)
+	}
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	for _, m := range metricsRegistry {
+		m.write(&b)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// natsPublishTimeout bounds how long one event waits for its JetStream ack.
+const natsPublishTimeout = 5 * time.Second
+
+// startNATS publishes call events to NATS_STREAM on NATS_URL, creating or
+// updating the stream and the durable consumers in NATS_CONSUMERS first.
+// The stream keeps events for NATS_MAX_AGE, so a durable consumer that was
+// offline picks up where it left off and any consumer can replay from a
+// point in time.
+func startNATS() error {
+	if config.NATSURL == "" {
+		return nil
+	}
+
+	opts := []nats.Option{nats.Name("twilio-voice-openai"), nats.MaxReconnects(-1)}
+	if config.NATSCreds != "" {
+		opts = append(opts, nats.UserCredentials(config.NATSCreds))
+	}
+	nc, err := nats.Connect(config.NATSURL, opts...)
+	if err != nil {
+		return fmt.Errorf("error connecting to NATS: %v", err)
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return fmt.Errorf("error opening JetStream: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:        config.NATSStream,
+		Description: "Call events from twilio-voice-openai",
+		Subjects:    []string{config.NATSSubject + ".>"},
+		MaxAge:      config.NATSMaxAge,
+		Storage:     jetstream.FileStorage,
+		Duplicates:  time.Minute,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating JetStream stream %s: %v", config.NATSStream, err)
+	}
+	for _, name := range config.NATSConsumers {
+		_, err := js.CreateOrUpdateConsumer(ctx, config.NATSStream, jetstream.ConsumerConfig{
+			Durable:       name,
+			DeliverPolicy: jetstream.DeliverAllPolicy,
+			AckPolicy:     jetstream.AckExplicitPolicy,
+		})
+		if err != nil {
+			return fmt.Errorf("error creating JetStream consumer %s: %v", name, err)
+		}
+	}
+
+	go runEventSink("nats", config.NATSEventTypes, func(event callEvent) error {
+		return publishNATS(js, event)
+	})
+	log.Printf("Publishing call events to JetStream stream %s\n", config.NATSStream)
+	return nil
+}
+
+// publishNATS publishes an event on <NATS_SUBJECT>.<type>. The event ID is
+// the message ID, so JetStream drops a retried publish it already has.
+func publishNATS(js jetstream.JetStream, event callEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error marshaling event: %v", err)
+	}
+	msg := nats.NewMsg(config.NATSSubject + "." + event.Type)
+	msg.Data = data
+	msg.Header.Set("Call-Sid", event.Call.CallSid)
+	if event.Call.Tenant != "" {
+		msg.Header.Set("Tenant", event.Call.Tenant)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), natsPublishTimeout)
+	defer cancel()
+	_, err = js.PublishMsg(ctx, msg, jetstream.WithMsgID(event.ID), jetstream.WithRetryAttempts(3))
+	return err
+}
@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	otpLength      = 6
+	otpTTL         = 5 * time.Minute
+	otpMaxAttempts = 3
+	otpMaxSends    = 3
+)
+
+var errOTPLimit = errors.New("verification code limit reached")
+
+var verificationsTotal = newCounter("verifications_total", "Caller verification attempts.", "method", "result")
+
+// otpChallenge is the one-time code last texted to the caller.
+type otpChallenge struct {
+	code     string
+	expires  time.Time
+	attempts int
+	sends    int
+}
+
+var sendCodeTool = &tool{
+	name:        "send_verification_code",
+	description: "Text a one-time verification code to the number the caller is calling from. Call this when the caller asks for something that requires verification, then ask them to read the code back.",
+	parameters:  map[string]interface{}{"type": "object", "properties": map[string]interface{}{}},
+	enabled:     otpConfigured,
+	run: func(s *callSession, arguments string) (string, error) {
+		if s.verifiedBy() != "" {
+			return "The caller is already verified.", nil
+		}
+		code, err := s.newOTP()
+		if errors.Is(err, errOTPLimit) {
+			return "Too many codes have been sent on this call. Verification is not possible.", nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		body, err := renderOTPMessage(code)
+		if err != nil {
+			return "", err
+		}
+		if err := sendSMS(config.OTPSMSFrom, s.phoneNumber, body); err != nil {
+			return "", fmt.Errorf("error sending verification code: %v", err)
+		}
+		s.record("verification.code_sent", "")
+		return "A verification code has been texted to the caller. Ask them to read it back.", nil
+	},
+}
+
+var verifyCodeTool = &tool{
+	name:        "verify_code",
+	description: "Check the verification code the caller read back after send_verification_code.",
+	parameters: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"code": map[string]string{"type": "string", "description": "The digits the caller read back"},
+		},
+		"required": []string{"code"},
+	},
+	enabled: otpConfigured,
+	run: func(s *callSession, arguments string) (string, error) {
+		var data map[string]string
+		if err := json.Unmarshal([]byte(arguments), &data); err != nil {
+			return "", fmt.Errorf("error parsing JSON: %v", err)
+		}
+		return s.checkOTP(data["code"]), nil
+	},
+}
+
+func otpConfigured() bool {
+	return config.OTPSMSFrom != ""
+}
+
+func renderOTPMessage(code string) (string, error) {
+	tmpl, err := template.New("otp").Parse(config.OTPMessage)
+	if err != nil {
+		return "", fmt.Errorf("error parsing OTP_MESSAGE: %v", err)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, map[string]string{"code": code}); err != nil {
+		return "", fmt.Errorf("error rendering OTP_MESSAGE: %v", err)
+	}
+	return b.String(), nil
+}
+
+// newOTP replaces the caller's code with a fresh one, refusing after
+// otpMaxSends so a call can't be used to flood a number with texts.
+func (s *callSession) newOTP() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sends := 0
+	if s.otp != nil {
+		sends = s.otp.sends
+	}
+	if sends >= otpMaxSends {
+		return "", errOTPLimit
+	}
+
+	digits := make([]byte, otpLength)
+	for i := range digits {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		digits[i] = byte('0' + n.Int64())
+	}
+	s.otp = &otpChallenge{code: string(digits), expires: time.Now().Add(otpTTL), sends: sends + 1}
+	return s.otp.code, nil
+}
+
+func (s *callSession) checkOTP(code string) string {
+	code = strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, code)
+
+	s.mu.Lock()
+	challenge := s.otp
+	result := "failure"
+	switch {
+	case challenge == nil || challenge.code == "":
+		s.mu.Unlock()
+		return "There is no active code. Call send_verification_code to send one."
+	case time.Now().After(challenge.expires):
+		challenge.code = ""
+		result = "expired"
+	case subtle.ConstantTimeCompare([]byte(code), []byte(challenge.code)) == 1:
+		challenge.code = ""
+		s.verified = "sms"
+		result = "success"
+	default:
+		challenge.attempts++
+		if challenge.attempts >= otpMaxAttempts {
+			challenge.code = ""
+		}
+	}
+	attemptsLeft := otpMaxAttempts - challenge.attempts
+	s.mu.Unlock()
+
+	verificationsTotal.addFor(s, 1, "sms", result)
+	s.record("verification."+result, "sms")
+
+	switch {
+	case result == "success":
+		return "The code is correct. The caller is verified."
+	case result == "expired":
+		return "The code has expired. Offer to send a new one."
+	case attemptsLeft <= 0:
+		return "The code is wrong and no attempts are left. Offer to send a new one."
+	default:
+		return fmt.Sprintf("The code is wrong. The caller has %d more attempts.", attemptsLeft)
+	}
+}
+
+// verifiedBy returns how the caller was verified, or "" if they haven't
+// been.
+func (s *callSession) verifiedBy() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.verified
+}
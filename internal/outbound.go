@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var amdResultsTotal = newCounter("amd_results_total", "Answering machine detection results for outbound calls.", "answered_by")
+
+// placeOutboundCall dials to from the given caller ID, connecting the
+// callee to the assistant through /incoming-call under baseURL. With AMD_ENABLED
+// Twilio runs answering machine detection before fetching the TwiML. A
+// lock placeholder, if any, is passed on to /incoming-call.
+func placeOutboundCall(to, from, baseURL, lock string) (string, error) {
+	twimlURL := baseURL + "/incoming-call"
+	if lock != "" {
+		twimlURL += "?lock=" + url.QueryEscape(lock)
+	}
+	params := url.Values{
+		"To":   {to},
+		"From": {from},
+		"Url":  {twimlURL},
+	}
+	if config.AMDEnabled {
+		params.Set("MachineDetection", "DetectMessageEnd")
+	}
+
+	var call struct {
+		Sid string `json:"sid"`
+	}
+	if err := twilioRequest(http.MethodPost, "/Calls.json", params, &call); err != nil {
+		return "", err
+	}
+	return call.Sid, nil
+}
+
+// handleAnsweredByMachine writes the TwiML for an outbound call that AMD
+// says reached a machine: the voicemail message if AMD_MACHINE_ACTION is
+// "voicemail", otherwise a hangup. It reports false for humans, who get
+// the assistant as usual.
+func handleAnsweredByMachine(w http.ResponseWriter, r *http.Request) bool {
+	answeredBy := r.FormValue("AnsweredBy")
+	if answeredBy == "" {
+		return false
+	}
+
+	amdResultsTotal.add(1, answeredBy)
+	payload := map[string]string{"call_sid": r.FormValue("CallSid"), "phone_number": r.FormValue("To"), "answered_by": answeredBy}
+	go func() {
+		if err := deliverWebhook("amd", nil, payload); err != nil {
+			log.Println("Error delivering amd webhook:", err)
+		}
+	}()
+
+	if answeredBy == "human" || answeredBy == "unknown" {
+		return false
+	}
+
+	log.Printf("Outbound call %s answered by %s\n", r.FormValue("CallSid"), answeredBy)
+	w.Header().Set("Content-Type", "text/xml")
+	if config.AMDMachineAction == "voicemail" && strings.HasPrefix(answeredBy, "machine_end") && voicemailConfigured() {
+		w.Write([]byte(voicemailTwiML()))
+		go voicemailDelivered(r.FormValue("CallSid"), r.FormValue("To"), "amd", nil)
+		return true
+	}
+	w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Response><Hangup/></Response>`))
+	return true
+}
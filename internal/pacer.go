@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const pacerInterval = 20 * time.Millisecond
+
+type pacedChunk struct {
+	itemID string
+	audio  []byte
+	wide   []byte
+}
+
+// audioPacer is a small jitter buffer between OpenAI and Twilio. Deltas
+// arrive in bursts; the pacer releases them as 20ms frames in real time,
+// waiting for a prebuffer to fill whenever it has run dry.
+type audioPacer struct {
+	prebuffer int
+
+	mu       sync.Mutex
+	chunks   []pacedChunk
+	buffered int
+	primed   bool
+	lastPush time.Time
+}
+
+func newAudioPacer() *audioPacer {
+	if !config.AudioPacing {
+		return nil
+	}
+	return &audioPacer{prebuffer: config.AudioPacingPrebufferMs * twilioSampleRate / 1000}
+}
+
+func (p *audioPacer) push(itemID string, audio, wide []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.chunks = append(p.chunks, pacedChunk{itemID: itemID, audio: audio, wide: wide})
+	p.buffered += len(audio)
+	p.lastPush = time.Now()
+}
+
+// clear drops the audio not yet released and reports whether there was
+// any.
+func (p *audioPacer) clear() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	dropped := p.buffered > 0
+	p.chunks, p.buffered, p.primed = nil, 0, false
+	return dropped
+}
+
+// next returns the next frame to play, if one is due, its 16kHz PCM16 if
+// every chunk in it had some, and whether it completes a delta so that a
+// mark should follow it. Frames never span two items, and a short frame is
+// only released once the item has stalled.
+func (p *audioPacer) next() (string, []byte, []byte, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.chunks) == 0 {
+		p.primed = false
+		return "", nil, nil, false
+	}
+
+	stalled := time.Since(p.lastPush) >= 2*pacerInterval
+	if !p.primed {
+		if p.buffered < p.prebuffer && !stalled {
+			return "", nil, nil, false
+		}
+		p.primed = true
+	}
+
+	itemID := p.chunks[0].itemID
+	available, itemEnds := 0, false
+	for _, c := range p.chunks {
+		if c.itemID != itemID {
+			itemEnds = true
+			break
+		}
+		available += len(c.audio)
+	}
+	if available < twilioFrameBytes && !itemEnds && !stalled {
+		return "", nil, nil, false
+	}
+
+	var frame, wide []byte
+	completed, hasWide := false, true
+	for len(frame) < twilioFrameBytes && len(p.chunks) > 0 && p.chunks[0].itemID == itemID {
+		c := &p.chunks[0]
+		n := min(twilioFrameBytes-len(frame), len(c.audio))
+		frame = append(frame, c.audio[:n]...)
+		c.audio = c.audio[n:]
+		if c.wide == nil {
+			hasWide = false
+		} else {
+			wide = append(wide, c.wide[:n*wideBytesPerByte]...)
+			c.wide = c.wide[n*wideBytesPerByte:]
+		}
+		if len(c.audio) == 0 {
+			p.chunks = p.chunks[1:]
+			completed = true
+		}
+	}
+	p.buffered -= len(frame)
+	if !hasWide {
+		wide = nil
+	}
+
+	return itemID, frame, wide, completed
+}
+
+func (s *callSession) runPacer() {
+	defer s.recoverPanic("pacer")
+
+	ticker := time.NewTicker(pacerInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		itemID, frame, wide, completed := s.pacer.next()
+		if frame == nil {
+			continue
+		}
+		if err := s.sendAudio(itemID, frame, wide, completed); err != nil {
+			log.Println("Error sending audio delta to Twilio:", err)
+		}
+	}
+}
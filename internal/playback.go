@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"time"
+)
+
+// playbackMark is a Twilio mark sent after an audio chunk. Twilio echoes it
+// back once everything before it has been played to the caller, so the
+// oldest unacknowledged mark tells us how far playback has got.
+type playbackMark struct {
+	name   string
+	itemID string
+	endMs  int64
+}
+
+// itemPlayback is how far a response item's audio has got: how much was
+// sent to Twilio, how much Twilio has acknowledged playing with a mark and
+// when it started playing. The start is estimated from when the audio was
+// sent and corrected by every mark, so that what the caller heard can be
+// worked out between marks and before the first one.
+type itemPlayback struct {
+	itemID    string
+	sentBytes int
+	playedMs  int64
+	startedAt time.Time
+}
+
+func (p *itemPlayback) sentMs() int64 {
+	return int64(p.sentBytes / (twilioSampleRate / 1000))
+}
+
+// heardMs is how much of the item the caller has heard by now: the time it
+// has been playing, at least what was acknowledged and at most what was
+// sent.
+func (p *itemPlayback) heardMs(now time.Time) int64 {
+	return min(max(now.Sub(p.startedAt).Milliseconds(), p.playedMs), p.sentMs())
+}
+
+// forwardAudio sends a response audio delta to Twilio, through the pacer
+// when audio pacing is enabled.
+func (s *callSession) forwardAudio(itemID, delta string) error {
+	audio, err := base64.StdEncoding.DecodeString(delta)
+	if err != nil {
+		return fmt.Errorf("error decoding audio delta: %v", err)
+	}
+	var wide []byte
+	if s.downsampler != nil {
+		if leg := s.widebandLeg(); leg != nil {
+			wide = leg.downsampler.convert(audio)
+		}
+		audio = s.downsampler.convert(audio)
+	}
+	if s.onHold() || s.playingClip() || !s.limitAudio(itemID, audio) {
+		return nil
+	}
+	return s.queueAudio(itemID, audio, wide)
+}
+
+// queueAudio sends assistant audio to Twilio, through the pacer if enabled.
+// wide is the same audio as 16kHz PCM16 for a G.722 stream, or nil.
+func (s *callSession) queueAudio(itemID string, audio, wide []byte) error {
+	if s.pacer != nil {
+		s.pacer.push(itemID, audio, wide)
+		return nil
+	}
+	return s.sendAudio(itemID, audio, wide, true)
+}
+
+// sendAudio sends assistant audio to Twilio, optionally followed by a mark
+// carrying the item's audio position after the chunk.
+func (s *callSession) sendAudio(itemID string, audio, wide []byte, withMark bool) error {
+	if err := s.twilioOut.sendWideAudio(audio, wide); err != nil {
+		return err
+	}
+	s.audioForwarded()
+	s.recorder.appendAssistantAudio(audio)
+
+	s.mu.Lock()
+	now := time.Now()
+	if itemID != s.playback.itemID || s.playback.sentBytes == 0 {
+		// The item starts playing once the audio sent before it has.
+		s.previousPlayback = s.playback
+		s.playback = itemPlayback{itemID: itemID, startedAt: later(now, s.playbackEnd)}
+	}
+	s.playback.sentBytes += len(audio)
+	s.playbackEnd = later(now, s.playbackEnd).Add(time.Duration(len(audio)) * time.Second / twilioSampleRate)
+	s.talk.assistantAudio(itemID, int64(len(audio)/(twilioSampleRate/1000)))
+	mark := playbackMark{itemID: itemID, endMs: s.playback.sentMs()}
+	mark.name = fmt.Sprintf("%s:%d", itemID, mark.endMs)
+	if withMark {
+		s.marks = append(s.marks, mark)
+	}
+	s.mu.Unlock()
+
+	if !withMark {
+		return nil
+	}
+	return s.sendTwilio(map[string]interface{}{
+		"event":     "mark",
+		"streamSid": s.streamSid(),
+		"mark":      map[string]string{"name": mark.name},
+	})
+}
+
+// markPlayed handles a mark echoed by Twilio, dropping it and every older
+// mark from the pending queue.
+func (s *callSession) markPlayed(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, mark := range s.marks {
+		if mark.name == name {
+			if p := s.itemPlayback(mark.itemID); p != nil {
+				p.playedMs = mark.endMs
+				p.startedAt = time.Now().Add(-time.Duration(mark.endMs) * time.Millisecond)
+			}
+			s.marks = s.marks[i+1:]
+			return
+		}
+	}
+}
+
+// itemPlayback returns the playback of the item whose audio was sent last,
+// or of the one before it, with the given ID. It must be called with s.mu
+// held.
+func (s *callSession) itemPlayback(itemID string) *itemPlayback {
+	switch itemID {
+	case s.playback.itemID:
+		return &s.playback
+	case s.previousPlayback.itemID:
+		return &s.previousPlayback
+	}
+	return nil
+}
+
+// playingItem returns the playback of the item the caller is hearing, if
+// any: the oldest one with an unacknowledged mark, else the last one sent
+// if its audio hasn't all played yet or some of it was dropped unsent. It
+// must be called with s.mu held.
+func (s *callSession) playingItem(now time.Time, dropped bool) *itemPlayback {
+	if len(s.marks) > 0 {
+		return s.itemPlayback(s.marks[0].itemID)
+	}
+	if s.playback.sentBytes > 0 && (dropped || now.Before(s.playbackEnd)) {
+		return &s.playback
+	}
+	return nil
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// isPlaying reports whether assistant audio sent to Twilio has not been
+// played yet.
+func (s *callSession) isPlaying() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.marks) > 0
+}
+
+// interrupt stops assistant playback when the caller starts speaking over
+// it: Twilio's buffered audio is cleared and the assistant item is truncated
+// to what the caller actually heard.
+func (s *callSession) interrupt() {
+	dropped := false
+	if s.pacer != nil {
+		dropped = s.pacer.clear()
+	}
+	if s.downsampler != nil {
+		s.downsampler.reset()
+		if leg := s.widebandLeg(); leg != nil {
+			leg.downsampler.reset()
+		}
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	p := s.playingItem(now, dropped)
+	if p == nil && len(s.marks) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	var itemID string
+	var audioEndMs, sentMs int64
+	if p != nil {
+		itemID, audioEndMs, sentMs = p.itemID, p.heardMs(now), p.sentMs()
+		// Nothing more of it plays.
+		p.sentBytes = int(audioEndMs) * (twilioSampleRate / 1000)
+	}
+	s.marks = nil
+	s.playbackEnd = now
+	s.talk.truncate(itemID, audioEndMs)
+	s.mu.Unlock()
+
+	s.recorder.clearAssistantAudio()
+	s.twilioOut.dropAudio()
+	if err := s.sendTwilio(map[string]interface{}{"event": "clear", "streamSid": s.streamSid()}); err != nil {
+		log.Println("Error sending clear to Twilio:", err)
+	}
+
+	if itemID == "" {
+		return
+	}
+	truncate := map[string]interface{}{
+		"type":          "conversation.item.truncate",
+		"item_id":       itemID,
+		"content_index": 0,
+		"audio_end_ms":  audioEndMs,
+	}
+	if err := s.sendOpenAI(truncate); err != nil {
+		log.Println("Error sending truncate to OpenAI:", err)
+	}
+	s.record("truncate", fmt.Sprintf("%s at %dms of %dms sent", itemID, audioEndMs, sentMs))
+}
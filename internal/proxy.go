@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// openAIProxy picks the proxy for connections to OpenAI: OPENAI_PROXY_URL if
+// set, then the usual HTTPS_PROXY/HTTP_PROXY/NO_PROXY variables, then
+// ALL_PROXY (typically socks5://). Credentials go in the URL's userinfo.
+func openAIProxy(req *http.Request) (*url.URL, error) {
+	if config.OpenAIProxyURL != "" {
+		proxy, err := url.Parse(config.OpenAIProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing OPENAI_PROXY_URL: %v", err)
+		}
+		return proxy, nil
+	}
+	return environmentProxy()(req.URL)
+}
+
+// environmentProxy reads the proxy variables once, as
+// http.ProxyFromEnvironment does.
+var environmentProxy = sync.OnceValue(proxyFromEnvironment)
+
+// proxyFromEnvironment falls back to ALL_PROXY for whichever of HTTPS_PROXY
+// and HTTP_PROXY is unset, so NO_PROXY keeps hosts off it too.
+func proxyFromEnvironment() func(*url.URL) (*url.URL, error) {
+	cfg := httpproxy.FromEnvironment()
+	all := getEnv("ALL_PROXY", os.Getenv("all_proxy"))
+	if cfg.HTTPSProxy == "" {
+		cfg.HTTPSProxy = all
+	}
+	if cfg.HTTPProxy == "" {
+		cfg.HTTPProxy = all
+	}
+	return cfg.ProxyFunc()
+}
+
+// openAITransport is http.DefaultTransport, with its timeouts and HTTP/2,
+// going through openAIProxy.
+func openAITransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = openAIProxy
+	return transport
+}
@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// parseExternalURL checks EXTERNAL_URL, an http or https base URL with an
+// optional path prefix, and returns it without a trailing slash.
+func parseExternalURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("EXTERNAL_URL must be an http or https URL such as https://voice.example.com")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("EXTERNAL_URL must not have a query or fragment")
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// externalURL is the base URL Twilio reaches this server at: EXTERNAL_URL,
+// or https://PUBLIC_HOSTNAME. Without either it is worked out from the
+// request, preferring the X-Forwarded-Host and X-Forwarded-Proto headers
+// set by a trusted proxy over the Host header. The scheme is
+// https unless the proxy says http, as Twilio only uses plain HTTP when
+// told to.
+func externalURL(r *http.Request) string {
+	if config.ExternalURL != "" {
+		return config.ExternalURL
+	}
+
+	host := forwardedHeader(r, "X-Forwarded-Host")
+	if host == "" {
+		host = r.Host
+	}
+	return requestScheme(r) + "://" + host
+}
+
+func requestScheme(r *http.Request) string {
+	if strings.EqualFold(forwardedHeader(r, "X-Forwarded-Proto"), "http") {
+		return "http"
+	}
+	return "https"
+}
+
+// streamURL is the websocket URL Twilio opens a media stream to for path.
+func streamURL(r *http.Request, path string) string {
+	base := externalURL(r)
+	if rest, ok := strings.CutPrefix(base, "http://"); ok {
+		return "ws://" + rest + path
+	}
+	return "wss://" + strings.TrimPrefix(base, "https://") + path
+}
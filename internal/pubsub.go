@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var pubsubClient = &http.Client{Timeout: 10 * time.Second}
+
+// startPubSub publishes call events to the Pub/Sub topic PUBSUB_TOPIC, or
+// with PUBSUB_TOPIC_PER_TYPE to one topic per event type, authenticated like
+// the GCP secrets provider.
+func startPubSub() error {
+	if config.PubSubTopic == "" {
+		return nil
+	}
+	if !strings.HasPrefix(config.PubSubTopic, "projects/") || !strings.Contains(config.PubSubTopic, "/topics/") {
+		return fmt.Errorf("PUBSUB_TOPIC must be projects/<project>/topics/<topic>")
+	}
+	if _, err := gcpAccessToken(); err != nil {
+		return err
+	}
+
+	go runEventSink("pubsub", config.PubSubEventTypes, publishPubSub)
+	log.Printf("Publishing call events to Pub/Sub topic %s\n", config.PubSubTopic)
+	return nil
+}
+
+// pubsubTopic is the topic an event goes to: PUBSUB_TOPIC, or with
+// PUBSUB_TOPIC_PER_TYPE that name followed by a dot and the event type,
+// e.g. projects/p/topics/calls.call.ended.
+func pubsubTopic(eventType string) string {
+	if config.PubSubTopicPerType {
+		return config.PubSubTopic + "." + eventType
+	}
+	return config.PubSubTopic
+}
+
+// publishPubSub publishes an event with the CallSid as its ordering key, so
+// a subscription with message ordering gets each call's events in order.
+func publishPubSub(event callEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error marshaling event: %v", err)
+	}
+	attributes := map[string]string{"id": event.ID, "type": event.Type, "call_sid": event.Call.CallSid}
+	if event.Call.Tenant != "" {
+		attributes["tenant"] = event.Call.Tenant
+	}
+	orderingKey := event.Call.CallSid
+	if orderingKey == "" {
+		orderingKey = event.Call.ID
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"messages": []map[string]interface{}{{
+			"data":        data,
+			"attributes":  attributes,
+			"orderingKey": orderingKey,
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %v", err)
+	}
+
+	token, err := gcpAccessToken()
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(config.PubSubEndpoint, "/") + "/v1/" + pubsubTopic(event.Type) + ":publish"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := pubsubClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status code: %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+const maxMergedAudioBytes = twilioSampleRate
+
+var (
+	errQueueClosed = errors.New("outbound queue closed")
+
+	droppedFrames = newCounter("outbound_dropped_frames_total", "Audio frames dropped because a websocket peer could not keep up.", "leg")
+	mergedFrames  = newCounter("outbound_merged_frames_total", "Audio frames merged into an already queued frame because a websocket peer could not keep up.", "leg")
+)
+
+type outboundMessage struct {
+	msg   interface{}
+	audio []byte
+	// wide is the same audio as 16kHz PCM16, for a G.722 stream, when
+	// there is some.
+	wide []byte
+}
+
+// outboundQueue decouples writes to one leg of the call from the goroutine
+// producing them, so a slow peer cannot stall the reader of the other leg.
+// Control messages are always queued; once the queue is full, audio frames
+// are merged into the newest queued frame ("merge") or the oldest queued
+// frame is dropped ("drop-oldest").
+type outboundQueue struct {
+	leg       string
+	policy    string
+	capacity  int
+	wrapAudio func(audio, wide []byte) interface{}
+
+	mu sync.Mutex
+	// conn is replaced when the OpenAI session is renewed.
+	conn    *websocket.Conn
+	pending []*outboundMessage
+	closed  bool
+	wake    chan struct{}
+}
+
+func newOutboundQueue(leg string, conn *websocket.Conn, policy string, wrapAudio func(audio, wide []byte) interface{}) *outboundQueue {
+	return &outboundQueue{
+		leg:       leg,
+		conn:      conn,
+		policy:    policy,
+		capacity:  config.OutboundQueueSize,
+		wrapAudio: wrapAudio,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+func (q *outboundQueue) send(msg interface{}) error {
+	return q.enqueue(&outboundMessage{msg: msg})
+}
+
+func (q *outboundQueue) sendAudio(audio []byte) error {
+	return q.enqueue(&outboundMessage{audio: audio})
+}
+
+// sendWideAudio queues audio along with its 16kHz PCM16, which may be nil.
+func (q *outboundQueue) sendWideAudio(audio, wide []byte) error {
+	return q.enqueue(&outboundMessage{audio: audio, wide: wide})
+}
+
+func (q *outboundQueue) enqueue(m *outboundMessage) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return errQueueClosed
+	}
+
+	if m.audio != nil && len(q.pending) >= q.capacity {
+		if q.policy == "merge" && len(q.pending) > 0 {
+			last := q.pending[len(q.pending)-1]
+			if last.audio != nil && len(last.audio)+len(m.audio) <= maxMergedAudioBytes {
+				last.audio = append(last.audio, m.audio...)
+				if last.wide != nil && m.wide != nil {
+					last.wide = append(last.wide, m.wide...)
+				} else {
+					last.wide = nil
+				}
+				mergedFrames.add(1, q.leg)
+				return nil
+			}
+		}
+
+		dropped := false
+		for i, queued := range q.pending {
+			if queued.audio != nil {
+				q.pending = append(q.pending[:i], q.pending[i+1:]...)
+				dropped = true
+				break
+			}
+		}
+		droppedFrames.add(1, q.leg)
+		if !dropped {
+			return nil
+		}
+	}
+
+	q.pending = append(q.pending, m)
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// dropAudio discards queued audio frames, keeping control messages.
+func (q *outboundQueue) dropAudio() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	kept := q.pending[:0]
+	for _, m := range q.pending {
+		if m.audio == nil {
+			kept = append(kept, m)
+		}
+	}
+	q.pending = kept
+}
+
+// pop returns the next message and the connection to write it to.
+func (q *outboundQueue) pop() (*outboundMessage, *websocket.Conn) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.pending) == 0 {
+		return nil, q.conn
+	}
+	m := q.pending[0]
+	q.pending = q.pending[1:]
+	return m, q.conn
+}
+
+// swapConn makes the queue write to conn from the next message on.
+func (q *outboundQueue) swapConn(conn *websocket.Conn) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.conn = conn
+}
+
+func (q *outboundQueue) currentConn() *websocket.Conn {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.conn
+}
+
+// run writes queued messages until done is closed or a write fails.
+func (q *outboundQueue) run(done <-chan struct{}) error {
+	defer func() {
+		q.mu.Lock()
+		q.closed = true
+		q.mu.Unlock()
+	}()
+
+	for {
+		for {
+			m, conn := q.pop()
+			if m == nil {
+				break
+			}
+			msg := m.msg
+			if m.audio != nil {
+				msg = q.wrapAudio(m.audio, m.wide)
+			}
+			setWriteDeadline(conn)
+			// A write to a connection swapped out meanwhile may fail as it
+			// is closed.
+			if err := conn.WriteJSON(msg); err != nil && conn == q.currentConn() {
+				return err
+			}
+		}
+
+		select {
+		case <-done:
+			return nil
+		case <-q.wake:
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// queued describes the pending messages: control messages by their value
+// and audio by its first byte and length.
+func queued(q *outboundQueue) []interface{} {
+	var list []interface{}
+	for _, m := range q.pending {
+		if m.audio == nil {
+			list = append(list, m.msg)
+		} else {
+			list = append(list, [2]int{int(m.audio[0]), len(m.audio)})
+		}
+	}
+	return list
+}
+
+func frame(b byte, n int) []byte {
+	return bytes.Repeat([]byte{b}, n)
+}
+
+func TestOutboundQueuePolicies(t *testing.T) {
+	tests := []struct {
+		policy   string
+		capacity int
+		send     func(q *outboundQueue)
+		want     []interface{}
+	}{
+		{
+			policy:   "drop-oldest",
+			capacity: 3,
+			send: func(q *outboundQueue) {
+				q.send("mark")
+				q.sendAudio(frame(1, 160))
+				q.sendAudio(frame(2, 160))
+				q.sendAudio(frame(3, 160))
+			},
+			// The oldest audio goes, not the mark before it.
+			want: []interface{}{"mark", [2]int{2, 160}, [2]int{3, 160}},
+		},
+		{
+			policy:   "merge",
+			capacity: 2,
+			send: func(q *outboundQueue) {
+				q.sendAudio(frame(1, 160))
+				q.sendAudio(frame(2, 160))
+				q.sendAudio(frame(3, 160))
+				q.sendAudio(frame(4, 160))
+			},
+			// Once the queue is full, audio grows the newest frame.
+			want: []interface{}{[2]int{1, 160}, [2]int{2, 480}},
+		},
+		{
+			policy:   "merge",
+			capacity: 2,
+			send: func(q *outboundQueue) {
+				q.sendAudio(frame(1, 160))
+				q.sendAudio(frame(2, 160))
+				q.sendAudio(frame(3, maxMergedAudioBytes))
+			},
+			// A merged frame too long to grow further falls back to
+			// dropping the oldest.
+			want: []interface{}{[2]int{2, 160}, [2]int{3, maxMergedAudioBytes}},
+		},
+		{
+			policy:   "drop-oldest",
+			capacity: 2,
+			send: func(q *outboundQueue) {
+				q.sendAudio(frame(1, 160))
+				q.sendAudio(frame(2, 160))
+				q.send("clear")
+				q.send("mark")
+			},
+			// Control messages are queued whatever the capacity.
+			want: []interface{}{[2]int{1, 160}, [2]int{2, 160}, "clear", "mark"},
+		},
+	}
+	for i, tt := range tests {
+		q := &outboundQueue{leg: "test", policy: tt.policy, capacity: tt.capacity, wake: make(chan struct{}, 1)}
+		tt.send(q)
+		got := queued(q)
+		if len(got) != len(tt.want) {
+			t.Errorf("%d %s: queued %v, want %v", i, tt.policy, got, tt.want)
+			continue
+		}
+		for j := range got {
+			if got[j] != tt.want[j] {
+				t.Errorf("%d %s: queued %v, want %v", i, tt.policy, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestOutboundQueueMergesWideAudio(t *testing.T) {
+	q := &outboundQueue{leg: "test", policy: "merge", capacity: 1, wake: make(chan struct{}, 1)}
+	q.sendWideAudio(frame(1, 160), frame(1, 640))
+	q.sendWideAudio(frame(2, 160), frame(2, 640))
+	if m := q.pending[0]; len(q.pending) != 1 || len(m.audio) != 320 || len(m.wide) != 1280 {
+		t.Errorf("merged into %d frames, the first of %d bytes and %d wide, want 1 of 320 and 1280", len(q.pending), len(m.audio), len(m.wide))
+	}
+
+	// Without 16kHz audio for every part, the merged frame has none.
+	q.sendAudio(frame(3, 160))
+	if m := q.pending[0]; len(m.audio) != 480 || m.wide != nil {
+		t.Errorf("merged frame of %d bytes and %d wide, want 480 and none", len(m.audio), len(m.wide))
+	}
+}
+
+func TestOutboundQueueDropAudio(t *testing.T) {
+	q := &outboundQueue{leg: "test", policy: "drop-oldest", capacity: 10, wake: make(chan struct{}, 1)}
+	q.sendAudio(frame(1, 160))
+	q.send("mark")
+	q.sendAudio(frame(2, 160))
+	q.dropAudio()
+	if got := queued(q); len(got) != 1 || got[0] != "mark" {
+		t.Errorf("queued %v after dropping audio, want only the mark", got)
+	}
+
+	q.closed = true
+	if err := q.sendAudio(frame(3, 160)); !errors.Is(err, errQueueClosed) {
+		t.Errorf("sending to a closed queue gave %v, want %v", err, errQueueClosed)
+	}
+}
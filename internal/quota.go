@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var quotaRejectionsTotal = newCounter("tenant_quota_rejections_total", "Calls turned away because a tenant reached a quota, by tenant and quota.", "tenant", "quota")
+
+// tenantUsage is what a tenant has used in the current calendar month
+// (UTC). Seconds and tokens count finished calls and tokens reported so
+// far; calls still in progress are added when usage is read.
+type tenantUsage struct {
+	Month   string  `json:"month"`
+	Calls   int     `json:"calls"`
+	Seconds float64 `json:"seconds"`
+	Tokens  int64   `json:"tokens"`
+}
+
+var tenantUsages = struct {
+	sync.Mutex
+	byID map[string]*tenantUsage
+}{byID: map[string]*tenantUsage{}}
+
+// monthUsageLocked returns the tenant's usage record for this month,
+// starting a new one when the month has turned. tenantUsages must be
+// locked.
+func monthUsageLocked(id string) *tenantUsage {
+	month := time.Now().UTC().Format("2006-01")
+	usage := tenantUsages.byID[id]
+	if usage == nil || usage.Month != month {
+		usage = &tenantUsage{Month: month}
+		tenantUsages.byID[id] = usage
+	}
+	return usage
+}
+
+func loadTenantUsage() error {
+	if config.TenantUsageFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(config.TenantUsageFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", config.TenantUsageFile, err)
+	}
+
+	tenantUsages.Lock()
+	defer tenantUsages.Unlock()
+	if err := json.Unmarshal(data, &tenantUsages.byID); err != nil {
+		return fmt.Errorf("error parsing %s: %v", config.TenantUsageFile, err)
+	}
+	if tenantUsages.byID == nil {
+		tenantUsages.byID = map[string]*tenantUsage{}
+	}
+	return nil
+}
+
+func saveTenantUsage() {
+	if config.TenantUsageFile == "" {
+		return
+	}
+
+	tenantUsages.Lock()
+	data, err := json.MarshalIndent(tenantUsages.byID, "", "  ")
+	tenantUsages.Unlock()
+	if err != nil {
+		log.Println("Error marshaling tenant usage:", err)
+		return
+	}
+	if err := os.WriteFile(config.TenantUsageFile+".tmp", data, 0o600); err != nil {
+		log.Println("Error saving tenant usage:", err)
+		return
+	}
+	if err := os.Rename(config.TenantUsageFile+".tmp", config.TenantUsageFile); err != nil {
+		log.Println("Error saving tenant usage:", err)
+	}
+}
+
+func (t *tenantConfig) addTokens(n int64) {
+	if t == nil || n <= 0 {
+		return
+	}
+	tenantUsages.Lock()
+	defer tenantUsages.Unlock()
+	monthUsageLocked(t.ID).Tokens += n
+}
+
+func (t *tenantConfig) addCall(duration time.Duration) {
+	if t == nil {
+		return
+	}
+	tenantUsages.Lock()
+	usage := monthUsageLocked(t.ID)
+	usage.Calls++
+	usage.Seconds += duration.Seconds()
+	tenantUsages.Unlock()
+	saveTenantUsage()
+}
+
+// activeCalls returns the tenant's calls in progress and how long they have
+// run so far.
+func (t *tenantConfig) activeCalls() (int, time.Duration) {
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	count, elapsed := 0, time.Duration(0)
+	for s := range sessions.active {
+		if s.tenant == t {
+			count++
+			elapsed += time.Since(s.startedAt)
+		}
+	}
+	return count, elapsed
+}
+
+func (t *tenantConfig) usage() tenantUsage {
+	active, elapsed := t.activeCalls()
+	tenantUsages.Lock()
+	usage := *monthUsageLocked(t.ID)
+	tenantUsages.Unlock()
+	usage.Calls += active
+	usage.Seconds += elapsed.Seconds()
+	return usage
+}
+
+// quotaExceeded returns the quota a new call would break: concurrent_calls,
+// monthly_minutes or monthly_tokens, or "" if it may go ahead.
+func (t *tenantConfig) quotaExceeded() string {
+	if t == nil {
+		return ""
+	}
+	if t.atCapacity() {
+		return "concurrent_calls"
+	}
+	usage := t.usage()
+	if t.MonthlyMinutes > 0 && usage.Seconds >= float64(t.MonthlyMinutes*60) {
+		return "monthly_minutes"
+	}
+	if t.MonthlyTokens > 0 && usage.Tokens >= t.MonthlyTokens {
+		return "monthly_tokens"
+	}
+	return ""
+}
+
+func (t *tenantConfig) atCapacity() bool {
+	if t == nil || t.MaxConcurrentCalls <= 0 {
+		return false
+	}
+	active, _ := t.activeCalls()
+	return active >= t.MaxConcurrentCalls
+}
+
+func listTenantUsage() []map[string]interface{} {
+	ids := make([]string, 0, len(config.File.Tenants))
+	for id := range config.File.Tenants {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	list := []map[string]interface{}{}
+	for _, id := range ids {
+		t := config.File.Tenants[id]
+		active, _ := t.activeCalls()
+		usage := t.usage()
+		list = append(list, map[string]interface{}{
+			"id":           id,
+			"active_calls": active,
+			"month":        usage.Month,
+			"calls":        usage.Calls,
+			"minutes":      usage.Seconds / 60,
+			"tokens":       usage.Tokens,
+			"limits": map[string]interface{}{
+				"max_concurrent_calls": t.MaxConcurrentCalls,
+				"monthly_minutes":      t.MonthlyMinutes,
+				"monthly_tokens":       t.MonthlyTokens,
+			},
+		})
+	}
+	return list
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	openAIRateLimitRemaining = newGauge("openai_rate_limit_remaining", "Remaining OpenAI requests or tokens, from the latest rate_limits.updated event, by API key setting and limit.", "key", "limit")
+	rateLimitRejectionsTotal = newCounter("rate_limit_rejections_total", "Calls turned away because OpenAI rate limits were nearly used up.")
+)
+
+// OpenAI reports what is left of the API key's rate limits after every
+// response. Once any of them falls below OPENAI_RATE_LIMIT_PERCENT of its
+// limit the key is throttled until the limit resets: new calls using it get
+// the busy message, non-essential responses wait for up to
+// OPENAI_RATE_LIMIT_DELAY, and an error report goes out. Keys are told
+// apart by the name of their setting, so a tenant with its own key isn't
+// throttled for another's usage.
+var rateLimits = struct {
+	sync.Mutex
+	until map[string]time.Time
+}{until: map[string]time.Time{}}
+
+func rateLimited(key string) bool {
+	if config.OpenAIRateLimitPercent <= 0 {
+		return false
+	}
+	rateLimits.Lock()
+	defer rateLimits.Unlock()
+	return time.Now().Before(rateLimits.until[key])
+}
+
+// updateRateLimits handles a rate_limits.updated event.
+func (s *callSession) updateRateLimits(event map[string]interface{}) {
+	if config.OpenAIRateLimitPercent <= 0 {
+		return
+	}
+	key := s.tenant.openAIKeyName()
+	limits, _ := event["rate_limits"].([]interface{})
+	now := time.Now()
+	var low []string
+	var until time.Time
+	for _, l := range limits {
+		l, _ := l.(map[string]interface{})
+		name, _ := l["name"].(string)
+		limit, _ := l["limit"].(float64)
+		remaining, _ := l["remaining"].(float64)
+		resetSeconds, _ := l["reset_seconds"].(float64)
+		openAIRateLimitRemaining.set(remaining, key, name)
+		if limit > 0 && remaining*100 < limit*float64(config.OpenAIRateLimitPercent) {
+			low = append(low, fmt.Sprintf("%.0f of %.0f %s", remaining, limit, name))
+			until = later(until, now.Add(time.Duration(resetSeconds*float64(time.Second))))
+		}
+	}
+
+	rateLimits.Lock()
+	throttled := now.Before(rateLimits.until[key])
+	rateLimits.until[key] = until
+	rateLimits.Unlock()
+	if len(low) == 0 || throttled {
+		return
+	}
+
+	message := fmt.Sprintf("OpenAI rate limits for %s nearly used up: %s left", key, strings.Join(low, ", "))
+	log.Println(message)
+	s.record("rate_limit", strings.Join(low, ", "))
+	tags := s.errorTags()
+	tags["key"] = key
+	reportError("rate_limit", message, tags, nil)
+}
+
+// awaitRateLimit holds up a non-essential response while the call's API
+// key is throttled, for up to OPENAI_RATE_LIMIT_DELAY.
+func (s *callSession) awaitRateLimit() {
+	key := s.tenant.openAIKeyName()
+	deadline := time.After(config.OpenAIRateLimitDelay)
+	for rateLimited(key) {
+		select {
+		case <-time.After(250 * time.Millisecond):
+		case <-deadline:
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// A tool that needs read-back, because readBack is set or it is named in
+// READ_BACK_TOOLS, doesn't run on the model's first call. The server
+// withholds it and has the model read the details back to the caller. It
+// runs once the model calls it again with the same details and confirmed
+// set, after the caller has confirmed, so a mis-heard email address or time
+// is caught before anything is booked. Forms confirm their own details.
+
+func (t *tool) needsReadBack() bool {
+	return t.form == nil && !t.isDestructive() && (t.readBack || slices.Contains(config.ReadBackTools, t.name))
+}
+
+// withParameter returns a tool's parameters with one more property, such
+// as the "confirmed" flag of read-back tools.
+func withParameter(parameters map[string]interface{}, name string, schema map[string]string) map[string]interface{} {
+	withFlag := make(map[string]interface{}, len(parameters))
+	for key, value := range parameters {
+		withFlag[key] = value
+	}
+	properties := map[string]interface{}{}
+	if existing, ok := parameters["properties"].(map[string]interface{}); ok {
+		for key, value := range existing {
+			properties[key] = value
+		}
+	}
+	properties[name] = schema
+	withFlag["properties"] = properties
+	return withFlag
+}
+
+// toolDetails splits a tool call's arguments into the details, as JSON
+// that is the same for the same details, and the value of the confirmation
+// parameter.
+func toolDetails(arguments, confirmation string) (map[string]interface{}, string, interface{}, error) {
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return nil, "", nil, fmt.Errorf("error parsing JSON: %v", err)
+	}
+	value := args[confirmation]
+	delete(args, confirmation)
+	// Map keys marshal sorted.
+	details, err := json.Marshal(args)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("error marshaling JSON: %v", err)
+	}
+	return args, string(details), value, nil
+}
+
+// detailLines lists a tool call's details for the caller to confirm.
+func detailLines(args map[string]interface{}) string {
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%s: %v", name, args[name]))
+	}
+	return strings.Join(lines, "; ")
+}
+
+// readBack checks a call of a read-back tool. It returns the arguments
+// without the confirmed flag, and whether the caller has confirmed exactly
+// these details. Otherwise it keeps them as the details to confirm and
+// returns what the model is to read back.
+func (s *callSession) readBack(t *tool, arguments string) (string, string, bool, error) {
+	args, details, flag, err := toolDetails(arguments, "confirmed")
+	if err != nil {
+		return "", "", false, err
+	}
+	confirmed, _ := flag.(bool)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.readBacks == nil {
+		s.readBacks = map[string]string{}
+	}
+	if confirmed && s.readBacks[t.name] == details {
+		delete(s.readBacks, t.name)
+		return details, "", true, nil
+	}
+	s.readBacks[t.name] = details
+
+	prompt := fmt.Sprintf("Not done yet. Read these details back to the caller and ask them to confirm: %s. "+
+		"If they confirm, call %s again with exactly the same details and confirmed set to true. "+
+		"If they correct something, call it with the corrected details.", detailLines(args), t.name)
+	return details, prompt, false, nil
+}
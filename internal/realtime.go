@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// The rest of the code speaks the beta Realtime schema. When the GA schema
+// is in use, session updates are converted on the way out and server events
+// renamed back on the way in.
+
+var modelFallbacksTotal = newCounter("openai_model_fallbacks_total", "Realtime connections that fell back past a model, by the model that failed.", "model")
+
+// realtimeModels is the order models are tried in: OPENAI_REALTIME_MODELS,
+// or else the model in OPENAI_REALTIME_URL.
+func realtimeModels() []string {
+	if len(config.OpenAIRealtimeModels) > 0 {
+		return config.OpenAIRealtimeModels
+	}
+	u, err := url.Parse(config.OpenAIRealtimeURL)
+	if err != nil {
+		return []string{""}
+	}
+	return []string{u.Query().Get("model")}
+}
+
+// realtimeURL is OPENAI_REALTIME_URL with its model replaced.
+func realtimeURL(model string) string {
+	u, err := url.Parse(config.OpenAIRealtimeURL)
+	if err != nil || model == "" {
+		return config.OpenAIRealtimeURL
+	}
+	query := u.Query()
+	query.Set("model", model)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// retryNextModel reports whether a failed dial should move on to the next
+// model: the model is unknown, rate limited or the service is unavailable.
+// Other refusals, such as a bad API key, would fail for every model.
+func retryNextModel(resp *http.Response) bool {
+	if resp == nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// realtimeGA reports whether to use the GA Realtime schema with a model.
+// With OPENAI_REALTIME_API=auto it is chosen by model: the gpt-realtime
+// family is GA, the older *-realtime-preview models are beta.
+func realtimeGA(model string) bool {
+	switch config.OpenAIRealtimeAPI {
+	case "ga":
+		return true
+	case "beta":
+		return false
+	}
+	return strings.HasPrefix(model, "gpt-realtime")
+}
+
+// sessionUpdate wraps a beta-shaped session configuration in a
+// session.update event for the schema the model uses.
+func sessionUpdate(model string, session map[string]interface{}) map[string]interface{} {
+	if realtimeGA(model) {
+		session = gaSession(session)
+	}
+	return map[string]interface{}{"type": "session.update", "session": session}
+}
+
+func gaSession(beta map[string]interface{}) map[string]interface{} {
+	session := map[string]interface{}{"type": "realtime"}
+	input := map[string]interface{}{}
+	output := map[string]interface{}{}
+
+	for key, value := range beta {
+		switch key {
+		case "input_audio_format":
+			input["format"] = gaAudioFormat(value)
+		case "output_audio_format":
+			output["format"] = gaAudioFormat(value)
+		case "turn_detection":
+			input["turn_detection"] = value
+		case "input_audio_transcription":
+			input["transcription"] = value
+		case "voice":
+			output["voice"] = value
+		case "modalities":
+			modalities, _ := value.([]string)
+			if slices.Contains(modalities, "audio") {
+				session["output_modalities"] = []string{"audio"}
+			} else {
+				session["output_modalities"] = []string{"text"}
+			}
+		case "max_response_output_tokens":
+			session["max_output_tokens"] = value
+		case "temperature":
+			// Not configurable in the GA API.
+		default:
+			session[key] = value
+		}
+	}
+
+	audio := map[string]interface{}{}
+	if len(input) > 0 {
+		audio["input"] = input
+	}
+	if len(output) > 0 {
+		audio["output"] = output
+	}
+	if len(audio) > 0 {
+		session["audio"] = audio
+	}
+	return session
+}
+
+// systemNote adds a system message to the conversation, to steer the
+// model's next response.
+func systemNote(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "conversation.item.create",
+		"item": map[string]interface{}{
+			"type":    "message",
+			"role":    "system",
+			"content": []map[string]string{{"type": "input_text", "text": text}},
+		},
+	}
+}
+
+func gaAudioFormat(format interface{}) map[string]interface{} {
+	switch format {
+	case "g711_ulaw":
+		return map[string]interface{}{"type": "audio/pcmu"}
+	case "g711_alaw":
+		return map[string]interface{}{"type": "audio/pcma"}
+	default:
+		return map[string]interface{}{"type": "audio/pcm", "rate": 24000}
+	}
+}
+
+// assistantTextType is the content type of text in assistant messages.
+func assistantTextType(model string) string {
+	if realtimeGA(model) {
+		return "output_text"
+	}
+	return "text"
+}
+
+var gaEventTypes = map[string]string{
+	"response.output_audio.delta":            "response.audio.delta",
+	"response.output_audio.done":             "response.audio.done",
+	"response.output_audio_transcript.delta": "response.audio_transcript.delta",
+	"response.output_audio_transcript.done":  "response.audio_transcript.done",
+	"response.output_text.delta":             "response.text.delta",
+	"response.output_text.done":              "response.text.done",
+	"conversation.item.added":                "conversation.item.created",
+}
+
+// normalizeRealtimeEvent renames GA server events to their beta names in
+// place and returns the event type.
+func normalizeRealtimeEvent(event map[string]interface{}) string {
+	eventType, _ := event["type"].(string)
+	if beta, ok := gaEventTypes[eventType]; ok {
+		event["type"] = beta
+		return beta
+	}
+	return eventType
+}
@@ -0,0 +1,316 @@
+// Package realtimetest provides a scripted fake of the OpenAI Realtime
+// websocket API so the bridge can be exercised end to end without network
+// access or an API key.
+package realtimetest
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// SpeechEnded is a pseudo event type that rules can match on. It fires when
+// appended caller audio goes quiet for half a second after containing
+// speech, the way server VAD would end a turn.
+const SpeechEnded = "realtimetest.speech_ended"
+
+// Event is a single Realtime API event as sent over the websocket.
+type Event map[string]interface{}
+
+// Type returns the event's "type" field.
+func (e Event) Type() string {
+	t, _ := e["type"].(string)
+	return t
+}
+
+// A Rule sends Events to the client whenever it receives an event of type
+// On. After skips that many matching events before the rule first fires and
+// Times limits how often it fires (zero means unlimited).
+type Rule struct {
+	On     string
+	After  int
+	Times  int
+	Events []Event
+}
+
+// Server is a fake Realtime endpoint. Every connection gets session.created
+// on connect, session.updated in reply to session.update, and then whatever
+// the scripted rules produce.
+type Server struct {
+	*httptest.Server
+
+	upgrader websocket.Upgrader
+	rules    []Rule
+
+	mu       sync.Mutex
+	received []Event
+	conns    map[*websocket.Conn]*sync.Mutex
+}
+
+// NewServer starts a fake Realtime server running the given script.
+func NewServer(rules ...Rule) *Server {
+	s := NewUnstartedServer(rules...)
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a server that is not yet listening, for callers
+// that want to wrap Handler in their own listener.
+func NewUnstartedServer(rules ...Rule) *Server {
+	s := &Server{rules: rules, conns: map[*websocket.Conn]*sync.Mutex{}}
+	s.Server = httptest.NewUnstartedServer(s)
+	return s
+}
+
+// WebsocketURL returns the ws:// URL to use as the Realtime endpoint.
+func (s *Server) WebsocketURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + "/v1/realtime"
+}
+
+// Received returns every client event received so far, across connections.
+func (s *Server) Received() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.received...)
+}
+
+// ReceivedOfType returns the received client events of the given type.
+func (s *Server) ReceivedOfType(eventType string) []Event {
+	var events []Event
+	for _, e := range s.Received() {
+		if e.Type() == eventType {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// Broadcast sends events to every connected client, for triggering behavior
+// that is not a reply to a client event.
+func (s *Server) Broadcast(events ...Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, writeMu := range s.conns {
+		writeMu.Lock()
+		for _, e := range events {
+			conn.WriteJSON(e)
+		}
+		writeMu.Unlock()
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	writeMu := &sync.Mutex{}
+	s.mu.Lock()
+	s.conns[conn] = writeMu
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	send := func(events ...Event) bool {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		for _, e := range events {
+			if err := conn.WriteJSON(e); err != nil {
+				return false
+			}
+		}
+		return true
+	}
+
+	if !send(SessionCreated()) {
+		return
+	}
+
+	matches := make([]int, len(s.rules))
+	dispatch := func(eventType string) bool {
+		for i, rule := range s.rules {
+			if rule.On != eventType {
+				continue
+			}
+			matches[i]++
+			fired := matches[i] - rule.After
+			if fired <= 0 || (rule.Times > 0 && fired > rule.Times) {
+				continue
+			}
+			if !send(rule.Events...) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var vad speechDetector
+	for {
+		var event Event
+		if err := conn.ReadJSON(&event); err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.received = append(s.received, event)
+		s.mu.Unlock()
+
+		if event.Type() == "session.update" {
+			session, _ := event["session"].(map[string]interface{})
+			if format, ok := session["input_audio_format"].(string); ok {
+				vad.pcm16 = format == "pcm16"
+			}
+			if !send(Event{"type": "session.updated", "session": session}) {
+				return
+			}
+		}
+
+		if !dispatch(event.Type()) {
+			return
+		}
+
+		if event.Type() == "input_audio_buffer.append" {
+			payload, _ := event["audio"].(string)
+			audio, _ := base64.StdEncoding.DecodeString(payload)
+			if vad.feed(audio) && !dispatch(SpeechEnded) {
+				return
+			}
+		}
+	}
+}
+
+type speechDetector struct {
+	pcm16    bool
+	speaking bool
+	quiet    int
+}
+
+// feed consumes 8kHz μ-law audio, or 24kHz PCM16 once the session asked for
+// it, and reports whether a speech turn ended after half a second of quiet.
+func (d *speechDetector) feed(audio []byte) bool {
+	quietSamples, step := 4000, 1
+	if d.pcm16 {
+		quietSamples, step = 12000, 2
+	}
+
+	for i := 0; i+step <= len(audio); i += step {
+		loud := (^audio[i])&0x7F >= 0x10
+		if d.pcm16 {
+			sample := int16(binary.LittleEndian.Uint16(audio[i:]))
+			loud = sample > 500 || sample < -500
+		}
+		if loud {
+			d.speaking, d.quiet = true, 0
+			continue
+		}
+		if d.speaking {
+			d.quiet++
+			if d.quiet >= quietSamples {
+				d.speaking, d.quiet = false, 0
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SessionCreated is the event sent when a client connects.
+func SessionCreated() Event {
+	return Event{
+		"type": "session.created",
+		"session": map[string]interface{}{
+			"id":    "sess_realtimetest",
+			"model": "gpt-4o-realtime-preview-2024-10-01",
+		},
+	}
+}
+
+// Audio returns d worth of 8kHz μ-law silence, for use as canned audio.
+func Audio(d time.Duration) []byte {
+	audio := make([]byte, int(d/time.Millisecond)*8)
+	for i := range audio {
+		audio[i] = 0xFF
+	}
+	return audio
+}
+
+// AudioResponse returns the events of a spoken response: response.created,
+// audio deltas carrying audio in 100ms chunks, and response.done.
+func AudioResponse(responseID string, audio []byte) []Event {
+	const chunk = 800
+
+	itemID := "item_" + responseID
+	events := []Event{{"type": "response.created", "response": map[string]interface{}{"id": responseID, "status": "in_progress"}}}
+	for offset := 0; offset < len(audio); offset += chunk {
+		end := min(offset+chunk, len(audio))
+		events = append(events, Event{
+			"type":        "response.audio.delta",
+			"response_id": responseID,
+			"item_id":     itemID,
+			"delta":       base64.StdEncoding.EncodeToString(audio[offset:end]),
+		})
+	}
+	events = append(events, Event{"type": "response.audio.done", "response_id": responseID, "item_id": itemID})
+
+	return append(events, Event{
+		"type": "response.done",
+		"response": map[string]interface{}{
+			"id":     responseID,
+			"status": "completed",
+			"output": []interface{}{
+				map[string]interface{}{"id": itemID, "type": "message", "role": "assistant"},
+			},
+		},
+	})
+}
+
+// FunctionCall returns a response.done event whose output is a call to the
+// named function with JSON-encoded arguments.
+func FunctionCall(responseID, callID, name, arguments string) Event {
+	return Event{
+		"type": "response.done",
+		"response": map[string]interface{}{
+			"id":     responseID,
+			"status": "completed",
+			"output": []interface{}{
+				map[string]interface{}{
+					"id":        "item_" + callID,
+					"type":      "function_call",
+					"name":      name,
+					"call_id":   callID,
+					"arguments": arguments,
+				},
+			},
+		},
+	}
+}
+
+// SpeechTurn returns the server VAD events for one caller utterance.
+func SpeechTurn(itemID string) []Event {
+	return []Event{
+		{"type": "input_audio_buffer.speech_started", "item_id": itemID},
+		{"type": "input_audio_buffer.speech_stopped", "item_id": itemID},
+		{"type": "input_audio_buffer.committed", "item_id": itemID},
+	}
+}
+
+// Conversation is a script for a simple phone conversation: the greeting is
+// answered with audio and every caller utterance is answered with a spoken
+// reply of the given length.
+func Conversation(reply time.Duration) []Rule {
+	return []Rule{
+		{On: "response.create", Times: 1, Events: AudioResponse("resp_greeting", Audio(reply))},
+		{On: SpeechEnded, Events: append(SpeechTurn("item_caller"), AudioResponse("resp_reply", Audio(reply))...)},
+	}
+}
@@ -0,0 +1,286 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// transcriptEntry is one line of a transcript. Roles are "caller",
+// "assistant" and "system" for notes; tool calls are "tool_call" entries
+// with the name and arguments, answered by a "tool" entry with the output
+// as text and the same call ID.
+type transcriptEntry struct {
+	Time      time.Time `json:"time"`
+	Role      string    `json:"role"`
+	Text      string    `json:"text"`
+	Name      string    `json:"name,omitempty"`
+	Arguments string    `json:"arguments,omitempty"`
+	CallID    string    `json:"call_id,omitempty"`
+}
+
+// callRecorder keeps the audio and the conversation transcript of a call.
+// A nil recorder, used when RECORDING_DIR is unset, records nothing.
+//
+// The caller track grows in real time with Twilio's media frames, so its
+// length is the call clock. Assistant audio arrives in bursts ahead of
+// playback and is laid after whatever was already queued, never before the
+// current caller position; an interruption cuts the unplayed part off.
+// While paused both tracks record silence, so they stay aligned with the
+// call, and transcripts are dropped.
+type callRecorder struct {
+	mu         sync.Mutex
+	paused     bool
+	audio      []byte
+	assistant  []byte
+	transcript []transcriptEntry
+}
+
+var recordingTool = &tool{
+	name:        "set_recording",
+	description: "Stop or restart recording this call, e.g. when the caller asks to go off the record. Tell the caller once it is done.",
+	parameters: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"recording": map[string]string{"type": "boolean", "description": "false to stop recording, true to start again"},
+		},
+		"required": []string{"recording"},
+	},
+	enabled: recordingEnabled,
+	run: func(s *callSession, arguments string) (string, error) {
+		var args struct {
+			Recording bool `json:"recording"`
+		}
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return "", fmt.Errorf("error parsing JSON: %v", err)
+		}
+		s.setRecording(args.Recording, "tool")
+		if args.Recording {
+			return "The call is being recorded again.", nil
+		}
+		return "Recording has stopped.", nil
+	},
+}
+
+// setRecording pauses or resumes the recording, noting the change and what
+// asked for it (tool, admin or rule) in the transcript and timeline.
+func (s *callSession) setRecording(on bool, source string) {
+	r := s.recorder
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	changed := r.paused == on
+	r.paused = !on
+	event, text := "recording.paused", "Recording paused"
+	if on {
+		event, text = "recording.resumed", "Recording resumed"
+	}
+	if changed {
+		r.transcript = append(r.transcript, transcriptEntry{Time: time.Now(), Role: "system", Text: text + " (" + source + ")"})
+	}
+	r.mu.Unlock()
+
+	if changed {
+		s.record(event, source)
+	}
+}
+
+// applyRecordingRules pauses recording from the start of a call when
+// RECORDING_START_PAUSED is set or the caller is in
+// RECORDING_EXCLUDE_NUMBERS.
+func (s *callSession) applyRecordingRules() {
+	if config.RecordingStartPaused || slices.Contains(config.RecordingExcludeNumbers, s.phoneNumber) {
+		s.setRecording(false, "rule")
+	}
+}
+
+func (r *callRecorder) recording() bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.paused
+}
+
+// offTheRecord reports whether recording has been stopped on the call.
+func (r *callRecorder) offTheRecord() bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.paused
+}
+
+func newCallRecorder() *callRecorder {
+	if !recordingEnabled() {
+		return nil
+	}
+	return &callRecorder{}
+}
+
+func (r *callRecorder) appendAudio(audio []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.paused {
+		audio = silence(len(audio))
+	}
+	r.audio = append(r.audio, audio...)
+}
+
+func (r *callRecorder) appendAssistantAudio(audio []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.assistant) < len(r.audio) {
+		r.assistant = append(r.assistant, silence(len(r.audio)-len(r.assistant))...)
+	}
+	if r.paused {
+		audio = silence(len(audio))
+	}
+	r.assistant = append(r.assistant, audio...)
+}
+
+// clearAssistantAudio drops assistant audio the caller has not heard yet.
+func (r *callRecorder) clearAssistantAudio() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.assistant) > len(r.audio) {
+		r.assistant = r.assistant[:len(r.audio)]
+	}
+}
+
+func (r *callRecorder) addTranscript(role, text string) {
+	if text == "" {
+		return
+	}
+	r.addEntry(transcriptEntry{Role: role, Text: text})
+}
+
+func (r *callRecorder) addToolCall(callID, name, arguments string) {
+	r.addEntry(transcriptEntry{Role: "tool_call", Name: name, Arguments: arguments, CallID: callID})
+}
+
+func (r *callRecorder) addToolOutput(callID, output string) {
+	r.addEntry(transcriptEntry{Role: "tool", Text: output, CallID: callID})
+}
+
+func (r *callRecorder) addEntry(entry transcriptEntry) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.paused {
+		return
+	}
+	entry.Time = time.Now()
+	r.transcript = append(r.transcript, entry)
+}
+
+func (r *callRecorder) transcriptEntries() []transcriptEntry {
+	if r == nil {
+		return []transcriptEntry{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]transcriptEntry{}, r.transcript...)
+}
+
+func (r *callRecorder) save(name string) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	caller, assistant := decodeMulaw(r.audio), decodeMulaw(r.assistant)
+	tracks := map[string][]int16{}
+	switch config.RecordingChannels {
+	case "stereo":
+		tracks[name] = interleave(caller, assistant)
+	case "separate":
+		tracks[name+"-caller"] = caller
+		tracks[name+"-assistant"] = assistant
+	default:
+		tracks[name] = caller
+	}
+	for track, samples := range tracks {
+		channels := 1
+		if config.RecordingChannels == "stereo" {
+			channels = 2
+		}
+		if err := saveRecording(track, encodeWAV(samples, twilioSampleRate, channels)); err != nil {
+			return fmt.Errorf("error saving recording %s: %v", track, err)
+		}
+	}
+
+	if err := saveTranscript(name, r.transcript); err != nil {
+		return fmt.Errorf("error saving transcript %s: %v", name, err)
+	}
+	return nil
+}
+
+// interleave makes a stereo track with left on the first channel, padding
+// the shorter side with silence.
+func interleave(left, right []int16) []int16 {
+	n := max(len(left), len(right))
+	samples := make([]int16, 2*n)
+	for i := 0; i < n; i++ {
+		if i < len(left) {
+			samples[2*i] = left[i]
+		}
+		if i < len(right) {
+			samples[2*i+1] = right[i]
+		}
+	}
+	return samples
+}
+
+// encodeTranscript renders a transcript as JSONL, one entry per line.
+func encodeTranscript(entries []transcriptEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func readTranscript(path string) ([]transcriptEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeTranscript(data)
+}
+
+func decodeTranscript(data []byte) ([]transcriptEntry, error) {
+	var entries []transcriptEntry
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var entry transcriptEntry
+		if err := dec.Decode(&entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+var panicsTotal = newCounter("panics_total", "Panics recovered in HTTP handlers and call goroutines.", "where")
+
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			stack := debug.Stack()
+			log.Printf("Panic in %s %s: %v\n%s", r.Method, r.URL.Path, err, stack)
+			panicsTotal.add(1, "http")
+			reportError("panic", fmt.Sprintf("panic in %s %s: %v", r.Method, r.URL.Path, err), map[string]string{"path": r.URL.Path}, map[string]interface{}{"stack": string(stack)})
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recoverPanic must be deferred directly by a call goroutine. It logs the
+// stack and ends the call instead of letting the panic take down every call
+// on the server.
+func (s *callSession) recoverPanic(where string) {
+	err := recover()
+	if err == nil {
+		return
+	}
+	stack := debug.Stack()
+	log.Printf("Panic in %s for call %s: %v\n%s", where, s.id, err, stack)
+	panicsTotal.add(1, where)
+	reportError("panic", fmt.Sprintf("panic in %s: %v", where, err), s.errorTags(), map[string]interface{}{"stack": string(stack)})
+	s.record("panic", fmt.Sprint(err))
+	s.hangup()
+}
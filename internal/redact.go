@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// minRedactedLength keeps short settings such as "true" from being treated
+// as secrets and blanked out all over the log.
+const minRedactedLength = 8
+
+// base64Run matches base64 long enough to be audio or a credential rather
+// than an ordinary word.
+var base64Run = regexp.MustCompile(`[A-Za-z0-9+/]{200,}={0,2}`)
+
+// sensitiveFields are payload keys whose values are never logged.
+var sensitiveFields = map[string]bool{
+	"authorization": true,
+	"api_key":       true,
+	"client_secret": true,
+	"password":      true,
+	"secret":        true,
+	"token":         true,
+}
+
+// audioFields are payload keys holding base64 audio, logged as their size.
+var audioFields = map[string]bool{
+	"audio":   true,
+	"payload": true,
+}
+
+// Everything the standard logger writes passes through redactingWriter, so
+// secrets and audio stay out of the log whichever code path logs them.
+// Structured payloads are cleaned field by field with redactFields first.
+var redactions = struct {
+	sync.RWMutex
+	replacer *strings.Replacer
+}{replacer: strings.NewReplacer()}
+
+type redactingWriter struct {
+	out io.Writer
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.out, redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func installLogRedaction() {
+	if _, ok := log.Writer().(redactingWriter); !ok {
+		log.SetOutput(redactingWriter{out: log.Writer()})
+	}
+}
+
+func redact(s string) string {
+	redactions.RLock()
+	replacer := redactions.replacer
+	redactions.RUnlock()
+	s = replacer.Replace(s)
+	return base64Run.ReplaceAllStringFunc(s, func(run string) string {
+		return fmt.Sprintf("[redacted %d base64 bytes]", len(run))
+	})
+}
+
+// updateRedactions collects the secret values currently in use: settings
+// whose names mark them as keys, tokens, secrets or passwords, everything
+// from the secrets manager, and the credentials in CONFIG_FILE.
+func updateRedactions() {
+	values := map[string]bool{}
+	add := func(value string) {
+		if len(value) >= minRedactedLength {
+			values[value] = true
+		}
+	}
+
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		if sensitiveSetting(name) {
+			add(value)
+		}
+	}
+	secretValues.RLock()
+	for _, value := range secretValues.values {
+		add(value)
+	}
+	secretValues.RUnlock()
+
+	for _, key := range config.File.Admin.APIKeys {
+		add(key.Key)
+	}
+	if jwt := config.File.Admin.JWT; jwt != nil {
+		add(jwt.Secret)
+	}
+	// The Postgres URL usually holds a password.
+	add(secret("POSTGRES_URL"))
+	addHeaders := func(webhooks map[string][]webhookTarget) {
+		for _, targets := range webhooks {
+			for _, target := range targets {
+				for _, value := range target.Headers {
+					add(value)
+				}
+			}
+		}
+	}
+	addHeaders(config.File.Webhooks)
+	for _, tenant := range config.File.Tenants {
+		add(tenant.Token)
+		addHeaders(tenant.Webhooks)
+	}
+
+	// Longest first, so a secret containing another is replaced whole.
+	sorted := make([]string, 0, len(values))
+	for value := range values {
+		sorted = append(sorted, value)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	pairs := make([]string, 0, 2*len(sorted))
+	for _, value := range sorted {
+		pairs = append(pairs, value, "[redacted]")
+	}
+
+	redactions.Lock()
+	redactions.replacer = strings.NewReplacer(pairs...)
+	redactions.Unlock()
+}
+
+func sensitiveSetting(name string) bool {
+	for _, marker := range []string{"KEY", "TOKEN", "SECRET", "PASSWORD", "DSN"} {
+		if strings.Contains(name, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactFields returns a copy of a payload with sensitive fields blanked,
+// whatever they hold, and audio replaced by its size. The delta of an audio event is audio; other
+// deltas are text and kept.
+func redactFields(eventType string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, field := range v {
+			text, isString := field.(string)
+			switch {
+			case sensitiveFields[strings.ToLower(key)]:
+				copied[key] = "[redacted]"
+			case isString && (audioFields[key] || (key == "delta" && eventType == "response.audio.delta")):
+				copied[key] = fmt.Sprintf("<%d base64 bytes>", len(text))
+			default:
+				copied[key] = redactFields(eventType, field)
+			}
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = redactFields(eventType, item)
+		}
+		return copied
+	default:
+		return value
+	}
+}
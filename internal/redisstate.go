@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisState is the stateStore shared by every instance using the same
+// Redis, with keys under REDIS_KEY_PREFIX.
+type redisState struct {
+	client *redis.Client
+}
+
+func newRedisState() (*redisState, error) {
+	opts, err := redis.ParseURL(config.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %v", err)
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("error connecting to Redis: %v", err)
+	}
+	return &redisState{client: client}, nil
+}
+
+func redisKey(key string) string {
+	return config.RedisKeyPrefix + key
+}
+
+func (r *redisState) set(key string, value []byte, ttl time.Duration) error {
+	return r.client.Set(context.Background(), redisKey(key), value, ttl).Err()
+}
+
+func (r *redisState) get(key string) ([]byte, error) {
+	return redisBytes(r.client.Get(context.Background(), redisKey(key)).Bytes())
+}
+
+func (r *redisState) take(key string) ([]byte, error) {
+	return redisBytes(r.client.GetDel(context.Background(), redisKey(key)).Bytes())
+}
+
+func (r *redisState) push(key string, value []byte, ttl time.Duration) error {
+	ctx := context.Background()
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, redisKey(key), value)
+		pipe.PExpire(ctx, redisKey(key), ttl)
+		return nil
+	})
+	return err
+}
+
+func (r *redisState) pop(key string) ([]byte, error) {
+	return redisBytes(r.client.LPop(context.Background(), redisKey(key)).Bytes())
+}
+
+func (r *redisState) takeList(key string) ([][]byte, error) {
+	ctx := context.Background()
+	var values *redis.StringSliceCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		values = pipe.LRange(ctx, redisKey(key), 0, -1)
+		pipe.Del(ctx, redisKey(key))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var list [][]byte
+	for _, v := range values.Val() {
+		list = append(list, []byte(v))
+	}
+	return list, nil
+}
+
+func (r *redisState) delete(key string) error {
+	return r.client.Del(context.Background(), redisKey(key)).Err()
+}
+
+func (r *redisState) setNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(context.Background(), redisKey(key), value, ttl).Result()
+}
+
+var (
+	redisDeleteIf  = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`)
+	redisExpireIf  = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) end return 0`)
+	redisReplaceIf = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3]) return 1 end return 0`)
+)
+
+func (r *redisState) deleteIf(key string, value []byte) error {
+	return redisDeleteIf.Run(context.Background(), r.client, []string{redisKey(key)}, value).Err()
+}
+
+func (r *redisState) expireIf(key string, value []byte, ttl time.Duration) (bool, error) {
+	n, err := redisExpireIf.Run(context.Background(), r.client, []string{redisKey(key)}, value, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+func (r *redisState) replaceIf(key string, old, value []byte, ttl time.Duration) (bool, error) {
+	n, err := redisReplaceIf.Run(context.Background(), r.client, []string{redisKey(key)}, old, value, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+// redisBytes turns a missing key into nil.
+func redisBytes(value []byte, err error) ([]byte, error) {
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return value, err
+}
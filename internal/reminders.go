@@ -0,0 +1,267 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const reminderCheckInterval = 15 * time.Second
+
+// reminder is an outbound call scheduled for a given time, run with its own
+// instructions and greeting. Both are text/templates over Variables, e.g.
+// "Remind {{.name}} about their appointment at {{.datetime}}".
+type reminder struct {
+	ID           string            `json:"id"`
+	PhoneNumber  string            `json:"phone_number"`
+	CallerID     string            `json:"caller_id"`
+	At           time.Time         `json:"at"`
+	Instructions string            `json:"instructions"`
+	Greeting     string            `json:"greeting"`
+	Variables    map[string]string `json:"variables"`
+	Status       string            `json:"status"`
+	CallSid      string            `json:"call_sid,omitempty"`
+	Attempts     int               `json:"attempts"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// reminders is the schedule, saved to REMINDERS_FILE on every change when
+// that is set so it survives restarts.
+var reminders = struct {
+	sync.Mutex
+	byID map[string]*reminder
+}{byID: map[string]*reminder{}}
+
+func loadReminders() error {
+	if config.RemindersFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(config.RemindersFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", config.RemindersFile, err)
+	}
+
+	var list []*reminder
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("error parsing %s: %v", config.RemindersFile, err)
+	}
+
+	reminders.Lock()
+	defer reminders.Unlock()
+	for _, r := range list {
+		// A call in flight when the process stopped won't report back.
+		if r.Status == "calling" {
+			r.Status = "scheduled"
+		}
+		reminders.byID[r.ID] = r
+	}
+	return nil
+}
+
+// saveReminders must be called with reminders locked.
+func saveReminders() {
+	if config.RemindersFile == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(sortedReminders(), "", "  ")
+	if err != nil {
+		log.Println("Error marshaling reminders:", err)
+		return
+	}
+	if err := os.WriteFile(config.RemindersFile+".tmp", data, 0o600); err != nil {
+		log.Println("Error saving reminders:", err)
+		return
+	}
+	if err := os.Rename(config.RemindersFile+".tmp", config.RemindersFile); err != nil {
+		log.Println("Error saving reminders:", err)
+	}
+}
+
+// sortedReminders must be called with reminders locked.
+func sortedReminders() []*reminder {
+	list := make([]*reminder, 0, len(reminders.byID))
+	for _, r := range reminders.byID {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].At.Before(list[j].At) })
+	return list
+}
+
+func scheduleReminder(r *reminder) error {
+	if r.PhoneNumber == "" || r.At.IsZero() {
+		return fmt.Errorf("phone_number and at are required")
+	}
+	if r.CallerID == "" {
+		r.CallerID = config.ReminderCallerID
+	}
+	if r.CallerID == "" {
+		return fmt.Errorf("caller_id is required when REMINDER_CALLER_ID is not set")
+	}
+	for _, text := range []string{r.Instructions, r.Greeting} {
+		if _, err := template.New("").Option("missingkey=zero").Parse(text); err != nil {
+			return fmt.Errorf("error parsing template: %v", err)
+		}
+	}
+
+	r.ID = randomHex(8)
+	r.Status = "scheduled"
+
+	reminders.Lock()
+	defer reminders.Unlock()
+	reminders.byID[r.ID] = r
+	saveReminders()
+
+	log.Printf("Scheduled reminder %s to %s at %s\n", r.ID, r.PhoneNumber, r.At.Format(time.RFC3339))
+	return nil
+}
+
+func cancelReminder(id string) bool {
+	reminders.Lock()
+	defer reminders.Unlock()
+
+	r, ok := reminders.byID[id]
+	if !ok || r.Status != "scheduled" {
+		return false
+	}
+	r.Status = "canceled"
+	saveReminders()
+	return true
+}
+
+func listReminders() []reminder {
+	reminders.Lock()
+	defer reminders.Unlock()
+
+	list := []reminder{}
+	for _, r := range sortedReminders() {
+		list = append(list, *r)
+	}
+	return list
+}
+
+// runReminders places due reminder calls while there is spare capacity,
+// retrying failures on later checks up to three attempts.
+func runReminders() {
+	for range time.Tick(reminderCheckInterval) {
+		reminders.Lock()
+		var due []*reminder
+		for _, r := range sortedReminders() {
+			if r.Status == "scheduled" && time.Now().After(r.At) {
+				due = append(due, r)
+			}
+		}
+		reminders.Unlock()
+
+		for _, r := range due {
+			if atCapacity() {
+				break
+			}
+			placeReminder(r)
+		}
+	}
+}
+
+func placeReminder(r *reminder) {
+	reminders.Lock()
+	r.Status = "calling"
+	r.Attempts++
+	phoneNumber, callerID := r.PhoneNumber, r.CallerID
+	reminders.Unlock()
+
+	callSid, placed, err := placeLockedCall(tenantForNumber(callerID), phoneNumber, callerID, config.ExternalURL)
+
+	reminders.Lock()
+	defer reminders.Unlock()
+	if !placed {
+		// Tried again on the next check.
+		log.Printf("Postponing reminder %s: %s is on a call\n", r.ID, phoneNumber)
+		r.Status = "scheduled"
+		r.Attempts--
+		return
+	}
+	if err != nil {
+		log.Printf("Error placing reminder %s: %v\n", r.ID, err)
+		r.Status, r.Error = "scheduled", err.Error()
+		if r.Attempts >= 3 {
+			r.Status = "failed"
+		}
+	} else {
+		r.Status, r.CallSid, r.Error = "placed", callSid, ""
+		log.Printf("Placed reminder %s to %s\n", r.ID, phoneNumber)
+	}
+	saveReminders()
+}
+
+// reminderPrompt returns the rendered instructions and greeting for a
+// reminder call, given the CallSid Twilio reports when its stream starts.
+func reminderPrompt(callSid string) (instructions, greeting string, ok bool) {
+	reminders.Lock()
+	var found *reminder
+	for _, r := range reminders.byID {
+		if callSid != "" && r.CallSid == callSid {
+			found = r
+			break
+		}
+	}
+	reminders.Unlock()
+	if found == nil {
+		return "", "", false
+	}
+
+	render := func(text string) string {
+		tmpl, err := template.New("").Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return text
+		}
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, found.Variables); err != nil {
+			log.Printf("Error rendering reminder %s: %v\n", found.ID, err)
+			return text
+		}
+		return b.String()
+	}
+	return render(found.Instructions), render(found.Greeting), true
+}
+
+// scheduleAppointmentReminder creates a reminder call REMINDER_LEAD_TIME
+// before a meeting booked with setup_schedule, when that is configured and
+// the date and time could be understood.
+func scheduleAppointmentReminder(s *callSession, name, email, datetime, description string) {
+	if config.ReminderLeadTime <= 0 || config.ExternalURL == "" {
+		return
+	}
+
+	at, err := parseDateTime(datetime)
+	if err != nil {
+		log.Printf("Not scheduling a reminder for %q: unrecognised date and time\n", datetime)
+		return
+	}
+	at = at.Add(-config.ReminderLeadTime)
+	if at.Before(time.Now()) {
+		return
+	}
+
+	r := &reminder{
+		PhoneNumber:  s.phoneNumber,
+		At:           at,
+		Instructions: config.ReminderInstructions,
+		Greeting:     config.ReminderGreeting,
+		Variables:    map[string]string{"name": name, "email": email, "datetime": datetime, "description": description},
+	}
+	if err := scheduleReminder(r); err != nil {
+		log.Println("Error scheduling appointment reminder:", err)
+		return
+	}
+	s.record("reminder.scheduled", r.ID)
+}
@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+var sessionRenewalsTotal = newCounter("openai_session_renewals_total", "OpenAI Realtime sessions replaced by a new one during a long call, by result.", "result")
+
+// sessionRenewal marks the out-of-band response that summarizes a call for
+// its next OpenAI session, in its metadata.
+const sessionRenewal = "session_renewal"
+
+const renewalPrompt = "Summarize this phone call so far for whoever takes it over from you, without the caller noticing: " +
+	"who the caller is, what they want, what has been said, agreed and done, including the results of any tool calls, " +
+	"and what was about to happen next. Keep names, numbers, dates and the caller's language. Answer in plain text."
+
+// renewalRetryInterval is the wait after a failed renewal before the next.
+const renewalRetryInterval = time.Minute
+
+// Realtime sessions only last so long. Once a call's session is older than
+// OPENAI_SESSION_RENEW_AFTER, the next quiet moment (no response, playback,
+// caller speech, tool, hold or clip in progress) is used to replace it: the
+// old session summarizes the call in an out-of-band text response, a new
+// one is opened with the same instructions and tools and the summary, and
+// the bridge switches over to it. Caller speech before the summary is in
+// calls the attempt off until the next quiet moment.
+
+// runSessionRenewal checks every second whether the call's OpenAI session
+// is due for renewal, until the call ends.
+func (s *callSession) runSessionRenewal() {
+	defer s.recoverPanic("session_renewal")
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if s.startRenewal() {
+				s.requestRenewalSummary()
+			}
+		}
+	}
+}
+
+// startRenewal reports whether the session is due for renewal at a quiet
+// moment, and if so marks the renewal as started.
+func (s *callSession) startRenewal() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	due := !s.renewing && now.Sub(s.openAISince) >= config.OpenAISessionRenewAfter && now.Sub(s.renewalAttempt) >= renewalRetryInterval
+	quiet := !s.responding && len(s.marks) == 0 && !now.Before(s.playbackEnd) && s.talk.callerStart.IsZero() &&
+		s.toolsRunning == 0 && s.holdStop == nil && s.clip == "" && s.stream != ""
+	if !due || !quiet {
+		return false
+	}
+	s.renewing, s.renewalAttempt = true, now
+	return true
+}
+
+func (s *callSession) requestRenewalSummary() {
+	response := map[string]interface{}{
+		"conversation": "none",
+		"metadata":     map[string]string{"purpose": sessionRenewal},
+		"tool_choice":  "none",
+		"instructions": renewalPrompt,
+	}
+	if realtimeGA(s.modelName()) {
+		response["output_modalities"] = []string{"text"}
+	} else {
+		response["modalities"] = []string{"text"}
+	}
+	if err := s.sendOpenAI(map[string]interface{}{"type": "response.create", "response": response}); err != nil {
+		log.Println("Error requesting session summary:", err)
+		s.abandonRenewal()
+	}
+}
+
+// abandonRenewal calls off a renewal in progress, as when the caller
+// starts speaking before the summary is in.
+func (s *callSession) abandonRenewal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.renewing = false
+}
+
+// renewalSummary returns the text of a finished summary response, if the
+// renewal it was asked for is still on.
+func (s *callSession) renewalSummary(response map[string]interface{}) (string, bool) {
+	metadata, _ := response["metadata"].(map[string]interface{})
+	if metadata["purpose"] != sessionRenewal {
+		return "", false
+	}
+	s.mu.Lock()
+	renewing := s.renewing
+	s.mu.Unlock()
+	if !renewing {
+		return "", false
+	}
+
+	summary := responseText(response)
+	if status, _ := response["status"].(string); status != "completed" || summary == "" {
+		log.Printf("Session summary response %s without text, renewing later\n", status)
+		sessionRenewalsTotal.add(1, "failed")
+		s.abandonRenewal()
+		return "", false
+	}
+	return summary, true
+}
+
+// renewSession opens the call's next OpenAI session, briefed with summary,
+// and switches the bridge over to it. It runs on the OpenAI reader
+// goroutine, which goes on reading from the new session.
+func (s *callSession) renewSession(summary string) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.OpenAIConnectTimeout)
+	conn, model, err := dialOpenAIContext(ctx, s.tenant)
+	cancel()
+	if err != nil {
+		log.Println("Error connecting to OpenAI to renew the session:", err)
+		sessionRenewalsTotal.add(1, "failed")
+		s.abandonRenewal()
+		return
+	}
+	configureConn(conn)
+
+	session, _, _ := s.sessionConfig()
+	note := systemNote("This call carries on from an earlier session of yours, which has ended. The caller hasn't noticed the switch, so don't greet them again. " +
+		"Here is a summary of the call so far:\n\n" + summary)
+	for _, msg := range []map[string]interface{}{sessionUpdate(model, session), note} {
+		setWriteDeadline(conn)
+		if err := conn.WriteJSON(msg); err != nil {
+			log.Println("Error configuring the renewed OpenAI session:", err)
+			conn.Close()
+			sessionRenewalsTotal.add(1, "failed")
+			s.abandonRenewal()
+			return
+		}
+	}
+
+	s.mu.Lock()
+	old := s.openAIWs
+	s.openAIWs, s.model, s.openAISince = conn, model, time.Now()
+	s.renewing, s.responding, s.awaitingAudio = false, false, false
+	s.outOfBand, s.pendingConfirmation = nil, false
+	s.items, s.compacting = nil, nil
+	s.mu.Unlock()
+	s.openAIOut.swapConn(conn)
+	go keepAlive(conn, s.done)
+	old.Close()
+
+	sessionRenewalsTotal.add(1, "renewed")
+	s.record("session.renewed", fmt.Sprintf("%s, %d-character summary", model, len(summary)))
+	s.saveSnapshot()
+}
+
+// responseText returns the text output of a finished response.
+func responseText(response map[string]interface{}) string {
+	var text string
+	output, _ := response["output"].([]interface{})
+	for _, item := range output {
+		item, _ := item.(map[string]interface{})
+		content, _ := item["content"].([]interface{})
+		for _, c := range content {
+			c, _ := c.(map[string]interface{})
+			t, _ := c["text"].(string)
+			text += t
+		}
+	}
+	return text
+}
+
+func (s *callSession) modelName() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.model
+}
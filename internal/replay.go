@@ -0,0 +1,226 @@
+package internal
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type ReplayOptions struct {
+	TranscriptPath   string
+	AudioPath        string
+	InstructionsPath string
+}
+
+func Replay(opts ReplayOptions) error {
+	loadConfig()
+
+	instructions := config.SystemMessage
+	if opts.InstructionsPath != "" {
+		data, err := os.ReadFile(opts.InstructionsPath)
+		if err != nil {
+			return fmt.Errorf("error reading instructions: %v", err)
+		}
+		instructions = string(data)
+	}
+
+	conn, model, err := dialOpenAI(nil)
+	if err != nil {
+		return fmt.Errorf("error connecting to OpenAI WebSocket: %v", err)
+	}
+	defer conn.Close()
+
+	if opts.AudioPath != "" {
+		return replayAudio(conn, model, instructions, opts.AudioPath)
+	}
+	return replayTranscript(conn, model, instructions, opts.TranscriptPath)
+}
+
+func replayTranscript(conn *websocket.Conn, model, instructions, path string) error {
+	entries, err := readTranscript(path)
+	if err != nil {
+		return fmt.Errorf("error reading transcript: %v", err)
+	}
+
+	session := sessionConfig(instructions)
+	session["modalities"] = []string{"text"}
+	session["turn_detection"] = nil
+	delete(session, "input_audio_transcription")
+	if err := conn.WriteJSON(sessionUpdate(model, session)); err != nil {
+		return fmt.Errorf("error sending session update: %v", err)
+	}
+
+	for i, entry := range entries {
+		if entry.Role != "caller" {
+			continue
+		}
+
+		fmt.Printf("Caller:    %s\n", entry.Text)
+		item := map[string]interface{}{
+			"type": "conversation.item.create",
+			"item": map[string]interface{}{
+				"type":    "message",
+				"role":    "user",
+				"content": []map[string]string{{"type": "input_text", "text": entry.Text}},
+			},
+		}
+		if err := conn.WriteJSON(item); err != nil {
+			return fmt.Errorf("error sending caller turn: %v", err)
+		}
+		if err := conn.WriteJSON(map[string]string{"type": "response.create"}); err != nil {
+			return fmt.Errorf("error sending response create: %v", err)
+		}
+
+		for done := false; !done; {
+			var event map[string]interface{}
+			if err := conn.ReadJSON(&event); err != nil {
+				return fmt.Errorf("error reading from OpenAI WebSocket: %v", err)
+			}
+			normalizeRealtimeEvent(event)
+			if done, err = handleReplayEvent(conn, event); err != nil {
+				return err
+			}
+		}
+
+		for _, original := range entries[i+1:] {
+			if original.Role == "caller" {
+				break
+			}
+			if original.Role != "assistant" {
+				continue
+			}
+			fmt.Printf("  (was:    %s)\n", original.Text)
+		}
+	}
+
+	return nil
+}
+
+func replayAudio(conn *websocket.Conn, model, instructions, path string) error {
+	samples, err := readWAV(path, twilioSampleRate)
+	if err != nil {
+		return fmt.Errorf("error reading audio: %v", err)
+	}
+	audio := encodeMulaw(samples)
+
+	session := sessionConfig(instructions)
+	session["modalities"] = []string{"text"}
+	session["input_audio_transcription"] = map[string]string{"model": "whisper-1"}
+	if err := conn.WriteJSON(sessionUpdate(model, session)); err != nil {
+		return fmt.Errorf("error sending session update: %v", err)
+	}
+
+	events := make(chan map[string]interface{})
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			var event map[string]interface{}
+			if err := conn.ReadJSON(&event); err != nil {
+				readErr <- err
+				return
+			}
+			normalizeRealtimeEvent(event)
+			events <- event
+		}
+	}()
+
+	var up *upsampler
+	if transcodingAudio() {
+		up = &upsampler{}
+	}
+
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+
+	// Keep streaming silence after the recording ends so server VAD closes
+	// the last turn, then stop once no response has been seen for a while.
+	offset, inFlight, lastActivity := 0, false, time.Now()
+	for offset < len(audio) || inFlight || time.Since(lastActivity) < 5*time.Second {
+		select {
+		case err := <-readErr:
+			return fmt.Errorf("error reading from OpenAI WebSocket: %v", err)
+		case event := <-events:
+			lastActivity = time.Now()
+			switch event["type"] {
+			case "response.created":
+				inFlight = true
+			case "conversation.item.input_audio_transcription.completed":
+				transcript, _ := event["transcript"].(string)
+				fmt.Printf("Caller:    %s\n", strings.TrimSpace(transcript))
+			}
+			done, err := handleReplayEvent(conn, event)
+			if err != nil {
+				return err
+			}
+			if done {
+				inFlight = false
+			}
+		case <-ticker.C:
+			frame := silenceFrame()
+			if offset < len(audio) {
+				end := min(offset+twilioFrameBytes, len(audio))
+				copy(frame, audio[offset:end])
+				offset = end
+				lastActivity = time.Now()
+			}
+			if up != nil {
+				frame = up.convert(frame)
+			}
+			audioAppend := map[string]string{"type": "input_audio_buffer.append", "audio": base64.StdEncoding.EncodeToString(frame)}
+			if err := conn.WriteJSON(audioAppend); err != nil {
+				return fmt.Errorf("error sending audio append: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// handleReplayEvent prints assistant output and answers tool calls with a
+// stub result, since replays must never trigger real side effects. It reports
+// whether the model has finished responding to the current turn.
+func handleReplayEvent(conn *websocket.Conn, event map[string]interface{}) (bool, error) {
+	switch event["type"] {
+	case "error":
+		return false, fmt.Errorf("OpenAI error: %v", event["error"])
+	case "response.text.done":
+		text, _ := event["text"].(string)
+		fmt.Printf("Assistant: %s\n", text)
+	case "response.done":
+		response, _ := event["response"].(map[string]interface{})
+		output, _ := response["output"].([]interface{})
+		calledTool := false
+		for _, o := range output {
+			item, _ := o.(map[string]interface{})
+			if item["type"] != "function_call" {
+				continue
+			}
+			calledTool = true
+			fmt.Printf("Tool call: %v(%v)\n", item["name"], item["arguments"])
+			result := map[string]interface{}{
+				"type": "conversation.item.create",
+				"item": map[string]interface{}{
+					"call_id": item["call_id"],
+					"type":    "function_call_output",
+					"output":  "Done (replayed, no action was taken).",
+				},
+			}
+			if err := conn.WriteJSON(result); err != nil {
+				return false, fmt.Errorf("error sending tool result: %v", err)
+			}
+		}
+		if calledTool {
+			if err := conn.WriteJSON(map[string]string{"type": "response.create"}); err != nil {
+				return false, fmt.Errorf("error sending response create: %v", err)
+			}
+			return false, nil
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+type ReportOptions struct {
+	Month  string
+	Tenant string
+	Daily  bool
+	Format string
+}
+
+// Report prints a month's billing summary from USAGE_FILE, one line per
+// tenant and agent, or per day as well with Daily.
+func Report(opts ReportOptions) error {
+	readConfig()
+	if config.UsageFile == "" {
+		return fmt.Errorf("USAGE_FILE is not set")
+	}
+
+	month := opts.Month
+	if month == "" {
+		month = time.Now().UTC().Format("2006-01")
+	}
+	if _, err := time.Parse("2006-01", month); err != nil {
+		return fmt.Errorf("invalid month %q, expected YYYY-MM", month)
+	}
+
+	rows, err := readUsageFile(config.UsageFile)
+	if err != nil {
+		return err
+	}
+	matched := filterUsage(rows, usageFilter{From: month + "-01", To: month + "-31", Tenant: opts.Tenant})
+
+	summary := []usageRow{}
+	index := map[[3]string]int{}
+	total := usageRow{Day: month}
+	for _, row := range matched {
+		total.add(row)
+		if !opts.Daily {
+			row.Day = month
+		}
+		key := [3]string{row.Day, row.Tenant, row.Agent}
+		if i, ok := index[key]; ok {
+			summary[i].add(row)
+			continue
+		}
+		index[key] = len(summary)
+		summary = append(summary, row)
+	}
+	sortUsage(summary)
+
+	switch opts.Format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]interface{}{"month": month, "rows": summary, "total": total})
+	case "csv":
+		total.Tenant = "total"
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"period", "tenant", "agent", "calls", "minutes", "input_tokens", "output_tokens", "tool_calls", "estimated_cost"})
+		for _, row := range append(summary, total) {
+			w.Write([]string{
+				row.Day, row.Tenant, row.Agent,
+				strconv.Itoa(row.Calls),
+				strconv.FormatFloat(row.Seconds/60, 'f', 1, 64),
+				strconv.FormatInt(row.inputTokens(), 10),
+				strconv.FormatInt(row.outputTokens(), 10),
+				strconv.Itoa(row.ToolCalls),
+				strconv.FormatFloat(row.EstimatedCost, 'f', 2, 64),
+			})
+		}
+		w.Flush()
+		return w.Error()
+	case "", "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(w, "Period\tTenant\tAgent\tCalls\tMinutes\tInput tokens\tOutput tokens\tTool calls\tEst. cost\t")
+		for _, row := range summary {
+			printReportRow(w, row)
+		}
+		total.Tenant = "total"
+		printReportRow(w, total)
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown format %q, expected table, csv or json", opts.Format)
+	}
+}
+
+func printReportRow(w *tabwriter.Writer, row usageRow) {
+	tenant, agent := row.Tenant, row.Agent
+	if tenant == "" {
+		tenant = "-"
+	}
+	if agent == "" {
+		agent = "-"
+	}
+	fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%.1f\t%d\t%d\t%d\t%.2f\t\n",
+		row.Day, tenant, agent, row.Calls, row.Seconds/60, row.inputTokens(), row.outputTokens(), row.ToolCalls, row.EstimatedCost)
+}
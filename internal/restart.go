@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// restartTimeout is how long a restarted process has to start serving
+// before the restart is abandoned.
+const restartTimeout = time.Minute
+
+// restartReadyFD is the pipe a restarted process reports on once it is
+// serving, or 0 when this process wasn't started by a restart.
+var restartReadyFD int
+
+// restartSockets returns the number and names of the sockets handed over
+// by the process this one replaced.
+func restartSockets() (int, []string) {
+	count, _ := strconv.Atoi(os.Getenv("RESTART_FDS"))
+	if count <= 0 {
+		return 0, nil
+	}
+	names := strings.Split(os.Getenv("RESTART_FDNAMES"), ":")
+	restartReadyFD = 3 + count
+	os.Unsetenv("RESTART_FDS")
+	os.Unsetenv("RESTART_FDNAMES")
+	return count, names
+}
+
+// notifyRestarted tells the process this one replaced that it is serving,
+// so that it can stop accepting connections.
+func notifyRestarted() {
+	if restartReadyFD == 0 {
+		return
+	}
+	f := os.NewFile(uintptr(restartReadyFD), "restart")
+	if _, err := f.Write([]byte("ready")); err != nil {
+		log.Println("Error notifying the previous process:", err)
+	}
+	f.Close()
+}
+
+// handleRestarts restarts the server on SIGHUP, for instance after the
+// binary was replaced: a new process is started with the listening
+// sockets, and once it is serving this one stops accepting connections.
+// Calls in progress stay on this process until they end.
+func handleRestarts(listeners []serverListener) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			log.Println("Restarting")
+			pid, err := restart(listeners)
+			if err != nil {
+				log.Println("Error restarting:", err)
+				continue
+			}
+			signal.Stop(signals)
+			log.Printf("Process %d took over, waiting for %d calls to end\n", pid, activeSessionCount())
+			sdNotify(fmt.Sprintf("MAINPID=%d", pid))
+
+			// The listeners are the new process's now; don't remove its
+			// unix sockets.
+			for _, l := range listeners {
+				if ul, ok := l.socket.(*net.UnixListener); ok {
+					ul.SetUnlinkOnClose(false)
+				}
+			}
+			if grpcServer != nil {
+				grpcServer.Stop()
+			}
+			close(stopServing)
+			return
+		}
+	}()
+}
+
+// restart starts a new process with the same arguments, handing over the
+// listening sockets, and waits until it is serving.
+func restart(listeners []serverListener) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("error finding executable: %v", err)
+	}
+
+	var files []*os.File
+	var names []string
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	add := func(l net.Listener, name string) error {
+		var f *os.File
+		var err error
+		switch l := l.(type) {
+		case *net.TCPListener:
+			f, err = l.File()
+		case *net.UnixListener:
+			f, err = l.File()
+		default:
+			err = fmt.Errorf("unsupported listener %T", l)
+		}
+		if err != nil {
+			return fmt.Errorf("error handing over %s listener: %v", name, err)
+		}
+		files, names = append(files, f), append(names, name)
+		return nil
+	}
+	for _, l := range listeners {
+		if err := add(l.socket, l.name); err != nil {
+			return 0, err
+		}
+	}
+	if grpcListener != nil {
+		if err := add(grpcListener, "grpc"); err != nil {
+			return 0, err
+		}
+	}
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return 0, fmt.Errorf("error creating pipe: %v", err)
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	for _, env := range os.Environ() {
+		// The watchdog is the new process's to pet.
+		if !strings.HasPrefix(env, "WATCHDOG_PID=") {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("RESTART_FDS=%d", len(files)), "RESTART_FDNAMES="+strings.Join(names, ":"))
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return 0, fmt.Errorf("error starting %s: %v", exe, err)
+	}
+
+	// The pipe closes without a word if the new process exits.
+	result := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 5)
+		_, err := ready.Read(buf)
+		result <- err
+	}()
+	select {
+	case err = <-result:
+	case <-time.After(restartTimeout):
+		err = fmt.Errorf("timed out")
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, fmt.Errorf("new process didn't start serving: %v", err)
+	}
+	return cmd.Process.Pid, nil
+}
+
+// drainCalls waits, after a restart, for the calls still on this process
+// to end, for up to RESTART_DRAIN_TIMEOUT.
+func drainCalls() {
+	deadline := time.Now().Add(config.RestartDrainTimeout)
+	for n := activeSessionCount(); n > 0; n = activeSessionCount() {
+		if time.Now().After(deadline) {
+			log.Printf("Exiting with %d calls in progress\n", n)
+			return
+		}
+		time.Sleep(time.Second)
+	}
+	log.Println("All calls ended, exiting")
+}
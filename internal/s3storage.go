@@ -0,0 +1,255 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Storage keeps everything as objects under STORAGE_S3_PREFIX in
+// STORAGE_S3_BUCKET, signed with the AWS credentials:
+//
+//	calls/<started at>-<id>.json   the call log, listed in time order
+//	calls-by-id/<id>.json          the same entry, by call ID and CallSid
+//	transcripts/<name>.jsonl
+//	recordings/<name>.wav
+//	audit/<time>-<hash>.json
+//
+// Listing calls reads one object per call, so it suits occasional lookups
+// rather than reporting over a large history.
+type s3Storage struct {
+	endpoint string
+	region   string
+	client   *http.Client
+}
+
+func newS3Storage() (*s3Storage, error) {
+	if config.StorageS3Bucket == "" {
+		return nil, fmt.Errorf("STORAGE_BACKEND=s3 needs STORAGE_S3_BUCKET")
+	}
+	creds, err := loadAWSCredentials(config.StorageS3Region)
+	if err != nil {
+		return nil, err
+	}
+	// STORAGE_S3_ENDPOINT is for S3-compatible stores such as MinIO, which
+	// are addressed path-style.
+	endpoint := fmt.Sprintf("https://%s.s3.%s.amazonaws.com", config.StorageS3Bucket, creds.Region)
+	if config.StorageS3Endpoint != "" {
+		endpoint = strings.TrimSuffix(config.StorageS3Endpoint, "/") + "/" + config.StorageS3Bucket
+	}
+	return &s3Storage{endpoint: endpoint, region: creds.Region, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// do sends a request for an object, or for the bucket with an empty key.
+func (s *s3Storage) do(method, key string, query url.Values, body []byte) ([]byte, int, error) {
+	path := "/"
+	if key != "" {
+		// Keys are escaped the way SigV4 expects, since call times hold colons.
+		var segments []string
+		for _, segment := range strings.Split(config.StorageS3Prefix+key, "/") {
+			segments = append(segments, awsEscape(segment))
+		}
+		path += strings.Join(segments, "/")
+	}
+	u, err := url.Parse(s.endpoint + path)
+	if err != nil {
+		return nil, 0, err
+	}
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	creds, err := loadAWSCredentials(s.region)
+	if err != nil {
+		return nil, 0, err
+	}
+	signAWSRequest(req, body, "s3", creds)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return nil, resp.StatusCode, fmt.Errorf("unexpected status code %d from S3: %s", resp.StatusCode, data)
+	}
+	return data, resp.StatusCode, nil
+}
+
+func (s *s3Storage) put(key string, body []byte) error {
+	_, _, err := s.do(http.MethodPut, key, nil, body)
+	return err
+}
+
+// get returns nil for a missing object.
+func (s *s3Storage) get(key string) ([]byte, error) {
+	data, status, err := s.do(http.MethodGet, key, nil, nil)
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	return data, err
+}
+
+// list returns the keys under prefix, relative to STORAGE_S3_PREFIX, in
+// lexical order.
+func (s *s3Storage) list(prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {config.StorageS3Prefix + prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		data, _, err := s.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("error parsing S3 listing: %v", err)
+		}
+		for _, object := range result.Contents {
+			keys = append(keys, strings.TrimPrefix(object.Key, config.StorageS3Prefix))
+		}
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *s3Storage) SaveCall(entry callLogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %v", err)
+	}
+	keys := []string{
+		"calls/" + entry.StartedAt.UTC().Format(storageTime) + "-" + entry.ID + ".json",
+		"calls-by-id/" + entry.ID + ".json",
+	}
+	if entry.CallSid != "" {
+		keys = append(keys, "calls-by-id/"+entry.CallSid+".json")
+	}
+	for _, key := range keys {
+		if err := s.put(key, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *s3Storage) readCall(key string) (*callLogEntry, error) {
+	data, err := s.get(key)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var entry callLogEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", key, err)
+	}
+	return &entry, nil
+}
+
+func (s *s3Storage) ListCalls(f callLogFilter) ([]callLogEntry, error) {
+	keys, err := s.list("calls/")
+	if err != nil {
+		return nil, err
+	}
+	since := "calls/" + f.Since.UTC().Format(storageTime)
+
+	entries := []callLogEntry{}
+	for i := len(keys) - 1; i >= 0 && (f.Limit <= 0 || len(entries) < f.Limit); i-- {
+		if !f.Since.IsZero() && keys[i] < since {
+			break
+		}
+		entry, err := s.readCall(keys[i])
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil && f.matches(*entry) {
+			entry.Summary, entry.Timeline = nil, nil
+			entries = append(entries, *entry)
+		}
+	}
+	return entries, nil
+}
+
+func (s *s3Storage) GetCall(id string) (*callLogEntry, error) {
+	return s.readCall("calls-by-id/" + id + ".json")
+}
+
+func (s *s3Storage) SaveTranscript(name string, entries []transcriptEntry) error {
+	data, err := encodeTranscript(entries)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %v", err)
+	}
+	return s.put("transcripts/"+name+".jsonl", data)
+}
+
+func (s *s3Storage) Transcript(name string) ([]transcriptEntry, error) {
+	data, err := s.get("transcripts/" + name + ".jsonl")
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return decodeTranscript(data)
+}
+
+func (s *s3Storage) SaveRecording(name string, wav []byte) error {
+	return s.put("recordings/"+name+".wav", wav)
+}
+
+func (s *s3Storage) Recording(name string) ([]byte, error) {
+	return s.get("recordings/" + name + ".wav")
+}
+
+func (s *s3Storage) AppendAudit(entry auditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %v", err)
+	}
+	return s.put("audit/"+entry.Time.UTC().Format(storageTime)+"-"+entry.Hash[:16]+".json", data)
+}
+
+func (s *s3Storage) AuditEntries() ([]auditEntry, error) {
+	keys, err := s.list("audit/")
+	if err != nil {
+		return nil, err
+	}
+	var entries []auditEntry
+	for _, key := range keys {
+		data, err := s.get(key)
+		if err != nil {
+			return nil, err
+		}
+		var entry auditEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", key, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (s *s3Storage) Close() error {
+	return nil
+}
@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secretsConfig maps setting names such as OPENAI_API_KEY to references in
+// an external secrets manager. The reference format depends on the provider:
+//
+//	vault: "<kv v2 path>#<field>", e.g. "secret/data/voice#openai_api_key"
+//	aws:   "<secret id>[#<json key>]"
+//	gcp:   "projects/<p>/secrets/<s>/versions/<v>[#<json key>]"
+type secretsConfig struct {
+	Provider string            `json:"provider"`
+	Refresh  string            `json:"refresh"`
+	Values   map[string]string `json:"values"`
+
+	Vault struct {
+		Address   string `json:"address"`
+		Namespace string `json:"namespace"`
+	} `json:"vault"`
+	AWS struct {
+		Region string `json:"region"`
+	} `json:"aws"`
+}
+
+var secretValues struct {
+	sync.RWMutex
+	values map[string]string
+}
+
+// secret returns the current value of a secret setting, preferring the
+// secrets manager over the environment.
+func secret(name string) string {
+	secretValues.RLock()
+	value, ok := secretValues.values[name]
+	secretValues.RUnlock()
+	if ok {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+func loadSecrets() error {
+	cfg := config.File.Secrets
+	if cfg.Provider == "" {
+		return nil
+	}
+
+	values := map[string]string{}
+	for name, ref := range cfg.Values {
+		value, err := fetchSecret(cfg, ref)
+		if err != nil {
+			return fmt.Errorf("error fetching %s from %s: %v", name, cfg.Provider, err)
+		}
+		values[name] = value
+	}
+
+	secretValues.Lock()
+	secretValues.values = values
+	secretValues.Unlock()
+	updateRedactions()
+
+	return nil
+}
+
+func startSecretsRefresh() {
+	cfg := config.File.Secrets
+	if cfg.Provider == "" {
+		return
+	}
+
+	interval, err := time.ParseDuration(cfg.Refresh)
+	if err != nil {
+		interval = 5 * time.Minute
+	}
+
+	go func() {
+		for range time.Tick(interval) {
+			if err := loadSecrets(); err != nil {
+				log.Println("Error refreshing secrets:", err)
+			}
+		}
+	}()
+}
+
+func fetchSecret(cfg secretsConfig, ref string) (string, error) {
+	ref, key, _ := strings.Cut(ref, "#")
+
+	var value string
+	var err error
+	switch cfg.Provider {
+	case "vault":
+		return fetchVaultSecret(cfg, ref, key)
+	case "aws":
+		value, err = fetchAWSSecret(cfg, ref)
+	case "gcp":
+		value, err = fetchGCPSecret(ref)
+	default:
+		return "", fmt.Errorf("unknown secrets provider %q", cfg.Provider)
+	}
+	if err != nil || key == "" {
+		return value, err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("error parsing secret as JSON: %v", err)
+	}
+	field, ok := fields[key].(string)
+	if !ok {
+		return "", fmt.Errorf("secret has no string field %q", key)
+	}
+	return field, nil
+}
+
+func fetchVaultSecret(cfg secretsConfig, path, field string) (string, error) {
+	address := cfg.Vault.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(address, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	if cfg.Vault.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", cfg.Vault.Namespace)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := doSecretRequest(req, &body); err != nil {
+		return "", err
+	}
+
+	value, ok := body.Data.Data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("secret has no string field %q", field)
+	}
+	return value, nil
+}
+
+func fetchAWSSecret(cfg secretsConfig, id string) (string, error) {
+	creds, err := loadAWSCredentials(cfg.AWS.Region)
+	if err != nil {
+		return "", err
+	}
+
+	payload, _ := json.Marshal(map[string]string{"SecretId": id})
+	req, err := http.NewRequest(http.MethodPost, "https://secretsmanager."+creds.Region+".amazonaws.com/", bytes.NewReader(payload))
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	signAWSRequest(req, payload, "secretsmanager", creds)
+
+	var body struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := doSecretRequest(req, &body); err != nil {
+		return "", err
+	}
+	return body.SecretString, nil
+}
+
+func fetchGCPSecret(name string) (string, error) {
+	token, err := gcpAccessToken()
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "https://secretmanager.googleapis.com/v1/"+name+":access", nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	var body struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := doSecretRequest(req, &body); err != nil {
+		return "", err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(body.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("error decoding secret payload: %v", err)
+	}
+	return string(data), nil
+}
+
+// gcpToken caches the metadata server's token until shortly before it
+// expires.
+var gcpToken = struct {
+	sync.Mutex
+	token   string
+	expires time.Time
+}{}
+
+// gcpAccessToken uses GCP_ACCESS_TOKEN when set and otherwise asks the
+// metadata server for the instance service account's token.
+func gcpAccessToken() (string, error) {
+	if token := os.Getenv("GCP_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	gcpToken.Lock()
+	defer gcpToken.Unlock()
+	if time.Until(gcpToken.expires) > time.Minute {
+		return gcpToken.token, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := doSecretRequest(req, &body); err != nil {
+		return "", fmt.Errorf("error fetching GCP access token: %v", err)
+	}
+	gcpToken.token = body.AccessToken
+	gcpToken.expires = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	return body.AccessToken, nil
+}
+
+func doSecretRequest(req *http.Request, v interface{}) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status code: %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("error decoding response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,548 @@
+package internal
+
+import (
+	"encoding/base64"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const endedSessionsKept = 100
+
+type timelineEvent struct {
+	Time     time.Time `json:"time"`
+	OffsetMs int64     `json:"offset_ms"`
+	Event    string    `json:"event"`
+	Detail   string    `json:"detail,omitempty"`
+}
+
+type callSession struct {
+	id          string
+	phoneNumber string
+	model       string
+	tenant      *tenantConfig
+	startedAt   time.Time
+	// twilioWs is nil for calls bridged over SIP, where only the OpenAI
+	// sideband connection runs through this process.
+	twilioWs *websocket.Conn
+	openAIWs *websocket.Conn
+	recorder *callRecorder
+	pacer    *audioPacer
+	fork     *audioFork
+	stt      *complianceSTT
+	done     chan struct{}
+	debug    atomic.Bool
+	// metricTags holds the call's StatsD tags once the stream starts.
+	metricTags atomic.Pointer[[]string]
+
+	mu            sync.Mutex
+	stream        string
+	call          string
+	status        string
+	endedAt       time.Time
+	timeline      []timelineEvent
+	responding    bool
+	awaitingAudio bool
+	greeted       bool
+	speechStopped time.Time
+	callerTurns   int
+	line          string
+	tokens        tokenUsage
+	toolCalls     int
+
+	// playback is the item whose audio was sent to Twilio last and
+	// previousPlayback the one before it, which may still be playing.
+	// playbackEnd is when all the audio sent so far will have played.
+	playback         itemPlayback
+	previousPlayback itemPlayback
+	playbackEnd      time.Time
+	marks            []playbackMark
+
+	redirect *pendingRedirect
+	holdStop chan struct{}
+	clip     string
+	verified string
+	otp      *otpChallenge
+	metadata map[string]interface{}
+	// forms are the values gathered so far by form tools, by form.
+	forms map[string]map[string]string
+	// readBacks are the details of read-back tools awaiting the caller's
+	// confirmation, by tool.
+	readBacks map[string]string
+	// destructiveConfirmations are the tokens issued for destructive tools
+	// awaiting the caller's go-ahead, by tool.
+	destructiveConfirmations map[string]destructiveConfirmation
+	// escalationMatches counts the caller turns matching each escalation
+	// rule, -1 once it has fired, and pendingEscalation waits for the
+	// response in progress to end.
+	escalationMatches map[string]int
+	pendingEscalation *escalationRule
+
+	// callerLookup is closed once the caller lookup is done, and
+	// callerVariables are the template variables it found.
+	callerLookup    chan struct{}
+	callerVariables map[string]string
+
+	// customerContextFetched is closed once the customer context is in, and
+	// customerContext is its section of the instructions.
+	customerContextFetched chan struct{}
+	customerContext        string
+
+	// pendingConfirmation is a tool result to confirm once the response in
+	// progress is done, and outOfBand the out-of-band responses in
+	// progress, by ID.
+	pendingConfirmation bool
+	outOfBand           map[string]bool
+	toolsRunning        int
+
+	// openAISince is when the current OpenAI session started, renewing
+	// whether it is being replaced and renewalAttempt when that last
+	// started. instructions replace the call's own once set, as for a
+	// conference.
+	openAISince    time.Time
+	renewing       bool
+	renewalAttempt time.Time
+	instructions   string
+
+	// items are the OpenAI conversation's items in order, and compacting
+	// those being summarized to make room.
+	items      []conversationItem
+	compacting []string
+
+	identityAttempts int
+	limits           outputLimits
+	talk             talkStats
+
+	// listeners are admins listening in; the slice is replaced, never
+	// modified, so it can be read outside the lock.
+	listeners []*audioFork
+
+	twilioOut  *outboundQueue
+	openAIOut  *outboundQueue
+	hangupOnce sync.Once
+
+	// inputBatch and inputWide are only touched by the Twilio reader
+	// goroutine.
+	inputBatch []byte
+	inputWide  []byte
+	// twilioBacklog holds the Twilio messages read while the OpenAI dial
+	// was retried, for the Twilio reader goroutine to handle first.
+	twilioBacklog []map[string]interface{}
+
+	// With pcm16 audio, upsampler is only touched by the OpenAI writer
+	// goroutine and downsampler by the OpenAI reader goroutine.
+	upsampler   *upsampler
+	downsampler *downsampler
+	// leg is set by the start event of a G.722 stream.
+	leg *widebandLeg
+}
+
+var sessions = struct {
+	sync.Mutex
+	active map[*callSession]struct{}
+	ended  []*callSession
+}{active: map[*callSession]struct{}{}}
+
+func newCallSession(phoneNumber, model string, tenant *tenantConfig, twilioWs, openAIWs *websocket.Conn) *callSession {
+	s := &callSession{
+		id:          randomHex(8),
+		phoneNumber: phoneNumber,
+		model:       model,
+		tenant:      tenant,
+		startedAt:   time.Now(),
+		openAISince: time.Now(),
+		twilioWs:    twilioWs,
+		openAIWs:    openAIWs,
+		recorder:    newCallRecorder(),
+		pacer:       newAudioPacer(),
+		done:        make(chan struct{}),
+	}
+	s.twilioOut = newOutboundQueue("twilio", twilioWs, config.TwilioQueuePolicy, func(audio, wide []byte) interface{} {
+		s.teeAudio("outbound", audio)
+		payload := audio
+		if leg := s.widebandLeg(); leg != nil {
+			payload = leg.encode(audio, wide)
+		}
+		return map[string]interface{}{
+			"event":     "media",
+			"streamSid": s.streamSid(),
+			"media":     map[string]string{"payload": base64.StdEncoding.EncodeToString(payload)},
+		}
+	})
+	if transcodingAudio() {
+		s.upsampler, s.downsampler = &upsampler{}, &downsampler{}
+	}
+	s.openAIOut = newOutboundQueue("openai", openAIWs, config.OpenAIQueuePolicy, func(audio, wide []byte) interface{} {
+		if leg := s.widebandLeg(); leg != nil && wide != nil && s.upsampler != nil {
+			audio = leg.upsampler.convert(wide)
+		} else if s.upsampler != nil {
+			audio = s.upsampler.convert(audio)
+		}
+		return map[string]interface{}{
+			"type":  "input_audio_buffer.append",
+			"audio": base64.StdEncoding.EncodeToString(audio),
+		}
+	})
+	conns := []*websocket.Conn{openAIWs}
+	if twilioWs != nil {
+		conns = append(conns, twilioWs)
+		s.fork = newAudioFork()
+		s.stt = newComplianceSTT()
+		go s.runOutbound(s.twilioOut)
+		if config.OpenAISessionRenewAfter > 0 {
+			go s.runSessionRenewal()
+		}
+	} else {
+		s.twilioOut.closed = true
+		s.pacer = nil
+	}
+	for _, conn := range conns {
+		configureConn(conn)
+		go keepAlive(conn, s.done)
+	}
+	go s.runOutbound(s.openAIOut)
+	if s.pacer != nil {
+		go s.runPacer()
+	}
+
+	sessions.Lock()
+	sessions.active[s] = struct{}{}
+	observeActiveCalls(len(sessions.active))
+	sessions.Unlock()
+	callsStartedTotal.add(1)
+
+	s.applyRecordingRules()
+	s.startCallerLookup()
+	s.startCustomerContext()
+	return s
+}
+
+func lookupSession(id string) *callSession {
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	for s := range sessions.active {
+		if s.matches(id) {
+			return s
+		}
+	}
+	for i := len(sessions.ended) - 1; i >= 0; i-- {
+		if sessions.ended[i].matches(id) {
+			return sessions.ended[i]
+		}
+	}
+	return nil
+}
+
+func listSessions() []*callSession {
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	list := make([]*callSession, 0, len(sessions.active)+len(sessions.ended))
+	for s := range sessions.active {
+		list = append(list, s)
+	}
+	return append(list, sessions.ended...)
+}
+
+func (s *callSession) matches(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return id == s.id || id == s.call || id == s.stream
+}
+
+func (s *callSession) start(streamSid, callSid, line string) {
+	s.mu.Lock()
+	s.stream, s.call, s.line = streamSid, callSid, line
+	s.setStatsDTags(line)
+	_, pending := takePendingCallStatuses(callSid)
+	for _, event := range pending {
+		event.OffsetMs = event.Time.Sub(s.startedAt).Milliseconds()
+		s.timeline = append(s.timeline, event)
+		s.status = event.Detail
+	}
+	s.mu.Unlock()
+	s.saveSnapshot()
+
+	s.publish(callEvent{Type: "call.started"})
+	s.record("stream.start", streamSid)
+	go s.holdCallerLock()
+}
+
+func (s *callSession) widebandLeg() *widebandLeg {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.leg
+}
+
+func (s *callSession) streamSid() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stream
+}
+
+func (s *callSession) callSid() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.call
+}
+
+func (s *callSession) record(event, detail string) {
+	s.mu.Lock()
+	now := time.Now()
+	entry := timelineEvent{
+		Time:     now,
+		OffsetMs: now.Sub(s.startedAt).Milliseconds(),
+		Event:    event,
+		Detail:   detail,
+	}
+	s.timeline = append(s.timeline, entry)
+	s.mu.Unlock()
+
+	s.publish(callEvent{Type: "timeline", Time: now.UTC(), Timeline: &entry})
+}
+
+// trackOpenAIEvent adds the significant OpenAI events to the timeline,
+// including the first audio delta of each response and caller speech that
+// starts while a response is still being generated or played.
+func (s *callSession) trackOpenAIEvent(eventType string) {
+	s.mu.Lock()
+	interrupted := eventType == "input_audio_buffer.speech_started" && (s.responding || len(s.marks) > 0 || time.Now().Before(s.playbackEnd))
+	firstAudio := eventType == "response.audio.delta" && s.awaitingAudio
+	switch eventType {
+	case "response.created":
+		s.responding, s.awaitingAudio = true, true
+	case "response.audio.delta":
+		s.awaitingAudio = false
+	case "response.done":
+		s.responding, s.awaitingAudio = false, false
+	case "input_audio_buffer.speech_started":
+		s.talk.speechStarted(interrupted)
+		if interrupted {
+			interruptionsTotal.addFor(s, 1)
+		}
+	case "input_audio_buffer.speech_stopped":
+		s.speechStopped = time.Now()
+		s.callerTurns++
+		s.talk.speechStopped()
+	}
+	s.mu.Unlock()
+
+	switch {
+	case interrupted:
+		s.record("interruption", "")
+		s.record("speech.start", "")
+	case firstAudio:
+		s.record("response.first_audio", "")
+	case eventType == "input_audio_buffer.speech_started":
+		s.record("speech.start", "")
+	case eventType == "input_audio_buffer.speech_stopped":
+		s.record("speech.stop", "")
+	case eventType == "response.created":
+		s.record("response.start", "")
+	case eventType == "response.done":
+		s.record("response.done", "")
+	}
+}
+
+// audioForwarded observes greeting and turn latency when response audio has
+// been sent to Twilio.
+func (s *callSession) audioForwarded() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.greeted {
+		s.greeted = true
+		greetingLatency.observeFor(s, time.Since(s.startedAt).Seconds())
+	}
+	if !s.speechStopped.IsZero() {
+		turnLatency.observeFor(s, time.Since(s.speechStopped).Seconds())
+		s.speechStopped = time.Time{}
+	}
+}
+
+func (s *callSession) sendTwilio(msg interface{}) error {
+	return s.twilioOut.send(msg)
+}
+
+func (s *callSession) sendOpenAI(msg interface{}) error {
+	return s.openAIOut.send(msg)
+}
+
+// appendInputAudio forwards caller audio to OpenAI, coalescing Twilio's 20ms
+// frames into one input_audio_buffer.append per INPUT_AUDIO_BATCH_MS. wide
+// is the same audio as 16kHz PCM16 from a G.722 stream, or nil.
+func (s *callSession) appendInputAudio(audio, wide []byte) error {
+	if s.onHold() || s.playingClip() {
+		s.inputBatch, s.inputWide = nil, nil
+		return nil
+	}
+
+	batchBytes := config.InputAudioBatchMs * twilioSampleRate / 1000
+	if batchBytes <= len(audio) && len(s.inputBatch) == 0 {
+		return s.openAIOut.sendWideAudio(audio, wide)
+	}
+
+	s.inputBatch = append(s.inputBatch, audio...)
+	if wide != nil {
+		s.inputWide = append(s.inputWide, wide...)
+	}
+	if len(s.inputBatch) < batchBytes {
+		return nil
+	}
+	batch, wideBatch := s.inputBatch, s.inputWide
+	s.inputBatch, s.inputWide = nil, nil
+	return s.openAIOut.sendWideAudio(batch, wideBatch)
+}
+
+func (s *callSession) runOutbound(q *outboundQueue) {
+	defer s.recoverPanic(q.leg + "_writer")
+
+	err := q.run(s.done)
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	if err != nil {
+		log.Printf("Error writing to %s WebSocket: %v\n", q.leg, err)
+		s.hangup()
+	}
+}
+
+// hangup closes both legs so that the goroutine still reading from the other
+// side returns as well.
+func (s *callSession) hangup() {
+	s.hangupOnce.Do(func() {
+		close(s.done)
+		if s.twilioWs != nil {
+			s.twilioWs.Close()
+		}
+		s.mu.Lock()
+		s.openAIWs.Close()
+		s.mu.Unlock()
+	})
+}
+
+func (s *callSession) end() {
+	s.record("call.end", "")
+	s.mu.Lock()
+	s.endedAt = time.Now()
+	s.mu.Unlock()
+	s.deleteSnapshot()
+	s.tenant.addCall(s.endedAt.Sub(s.startedAt))
+	s.recordUsage()
+	s.observeTalkTime()
+	entry := s.logEntry()
+	s.logCall(entry)
+	s.mu.Lock()
+	metadata := s.callMetadata()
+	s.mu.Unlock()
+	s.publish(callEvent{Type: "call.ended", Ended: &callEnded{
+		Status:          entry.Status,
+		DurationSeconds: entry.DurationSeconds,
+		InputTokens:     entry.InputTokens,
+		OutputTokens:    entry.OutputTokens,
+		ToolCalls:       entry.ToolCalls,
+		EstimatedCost:   entry.EstimatedCost,
+		Metadata:        metadata,
+	}})
+
+	archiveSession(s)
+	s.fork.close()
+	s.closeListeners()
+
+	name := s.callSid()
+	if name == "" {
+		name = s.id
+	}
+	name = s.tenant.storageName(name)
+	if err := s.recorder.save(name); err != nil {
+		log.Println("Error saving recording:", err)
+	}
+	s.stt.close()
+	if err := s.stt.save(name); err != nil {
+		log.Println("Error saving compliance transcript:", err)
+	}
+
+	go func() {
+		if err := deliverWebhook("call.ended", s.summary(), s.summary()); err != nil {
+			log.Println("Error delivering call.ended webhook:", err)
+		}
+	}()
+}
+
+func archiveSession(s *callSession) {
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	delete(sessions.active, s)
+	observeActiveCalls(len(sessions.active))
+	sessions.ended = append(sessions.ended, s)
+	if len(sessions.ended) > endedSessionsKept {
+		sessions.ended = sessions.ended[len(sessions.ended)-endedSessionsKept:]
+	}
+}
+
+func (s *callSession) active() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.endedAt.IsZero()
+}
+
+func (s *callSession) summary() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	summary := map[string]interface{}{
+		"id":           s.id,
+		"call_sid":     s.call,
+		"stream_sid":   s.stream,
+		"phone_number": s.phoneNumber,
+		"model":        s.model,
+		"started_at":   s.startedAt,
+		"active":       s.endedAt.IsZero(),
+	}
+	if s.tenant != nil {
+		summary["tenant"] = s.tenant.ID
+	}
+	if s.line != "" {
+		summary["line"] = s.line
+	}
+	if s.status != "" {
+		summary["status"] = s.status
+	}
+	if s.holdStop != nil {
+		summary["on_hold"] = true
+	}
+	if s.clip != "" {
+		summary["playing_clip"] = s.clip
+	}
+	if s.recorder != nil {
+		summary["recording"] = s.recorder.recording()
+	}
+	if s.verified != "" {
+		summary["verified_by"] = s.verified
+	}
+	if metadata := s.callMetadata(); metadata != nil {
+		summary["metadata"] = metadata
+	}
+	if s.debug.Load() {
+		summary["debug"] = true
+	}
+	if !s.endedAt.IsZero() {
+		summary["ended_at"] = s.endedAt
+	}
+	summary["talk_time"] = s.talk.summary()
+	summary["usage"] = s.tokens
+	return summary
+}
+
+func (s *callSession) timelineEvents() []timelineEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]timelineEvent(nil), s.timeline...)
+}
@@ -0,0 +1,284 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type SimulateCallOptions struct {
+	URL             string
+	AudioPath       string
+	OutputPath      string
+	GreetingTimeout time.Duration
+	ReplyTimeout    time.Duration
+	Silence         time.Duration
+}
+
+type simulatedCall struct {
+	conn      *websocket.Conn
+	streamSid string
+	callSid   string
+	chunk     int
+
+	writeMu  sync.Mutex
+	sequence int
+
+	mu          sync.Mutex
+	reply       []byte
+	lastAudio   time.Time
+	firstAudio  time.Time
+	playbackEnd time.Time
+}
+
+type simulationResult struct {
+	Reply           []byte
+	GreetingLatency time.Duration
+	ReplyLatency    time.Duration
+	FramesSent      int
+	// FramesLate counts the 20ms slots the sender fell behind by, when
+	// frames went out late rather than on time.
+	FramesLate int
+}
+
+func SimulateCall(opts SimulateCallOptions) error {
+	samples, err := readWAV(opts.AudioPath, twilioSampleRate)
+	if err != nil {
+		return fmt.Errorf("error reading audio: %v", err)
+	}
+
+	result, err := runSimulatedCall(opts, encodeMulaw(samples))
+	if result != nil {
+		if err := writeWAV(opts.OutputPath, decodeMulaw(result.Reply), twilioSampleRate, 1); err != nil {
+			return fmt.Errorf("error writing %s: %v", opts.OutputPath, err)
+		}
+	}
+
+	return err
+}
+
+func runSimulatedCall(opts SimulateCallOptions, question []byte) (*simulationResult, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(opts.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %v", opts.URL, err)
+	}
+	defer conn.Close()
+
+	call := &simulatedCall{conn: conn, streamSid: "MZ" + randomHex(16), callSid: "CA" + randomHex(16)}
+	if err := call.sendStart(); err != nil {
+		return nil, err
+	}
+	started := time.Now()
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- call.readReplies()
+	}()
+
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+
+	const (
+		waitingForGreeting = iota
+		speaking
+		waitingForReply
+	)
+	phase, phaseStart, offset := waitingForGreeting, started, 0
+	result := &simulationResult{}
+
+	for done := false; !done; {
+		select {
+		case err := <-readErr:
+			return nil, fmt.Errorf("connection closed by server: %v", err)
+		case <-ticker.C:
+		}
+
+		frame := silenceFrame()
+		firstAudio, lastAudio := call.audioTimes()
+		switch phase {
+		case waitingForGreeting:
+			heardGreeting := !firstAudio.IsZero() && time.Since(lastAudio) >= opts.Silence
+			if heardGreeting || time.Since(phaseStart) >= opts.GreetingTimeout {
+				if !firstAudio.IsZero() {
+					result.GreetingLatency = firstAudio.Sub(started)
+				}
+				phase = speaking
+			}
+		case speaking:
+			end := min(offset+twilioFrameBytes, len(question))
+			copy(frame, question[offset:end])
+			offset = end
+			if offset == len(question) {
+				phase, phaseStart = waitingForReply, time.Now()
+				call.startReply()
+			}
+		case waitingForReply:
+			if !firstAudio.IsZero() && time.Since(lastAudio) >= opts.Silence {
+				result.ReplyLatency = firstAudio.Sub(phaseStart)
+				done = true
+			} else if time.Since(phaseStart) >= opts.ReplyTimeout {
+				done = true
+			}
+		}
+
+		if err := call.sendMedia(frame); err != nil {
+			return nil, err
+		}
+	}
+
+	result.FramesSent = call.chunk
+	result.FramesLate = max(int(time.Since(started)/(20*time.Millisecond))-call.chunk, 0)
+
+	call.send(map[string]interface{}{
+		"event":     "stop",
+		"streamSid": call.streamSid,
+		"stop":      map[string]string{"accountSid": "AC" + randomHex(16), "callSid": call.callSid},
+	})
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+
+	call.mu.Lock()
+	result.Reply = call.reply
+	call.mu.Unlock()
+
+	if result.ReplyLatency == 0 {
+		return result, fmt.Errorf("no reply audio received within %s", opts.ReplyTimeout)
+	}
+
+	return result, nil
+}
+
+func (c *simulatedCall) send(msg map[string]interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.sequence++
+	msg["sequenceNumber"] = strconv.Itoa(c.sequence)
+	if err := c.conn.WriteJSON(msg); err != nil {
+		return fmt.Errorf("error sending %s event: %v", msg["event"], err)
+	}
+	return nil
+}
+
+func (c *simulatedCall) sendStart() error {
+	if err := c.send(map[string]interface{}{"event": "connected", "protocol": "Call", "version": "1.0.0"}); err != nil {
+		return err
+	}
+
+	return c.send(map[string]interface{}{
+		"event":     "start",
+		"streamSid": c.streamSid,
+		"start": map[string]interface{}{
+			"streamSid":  c.streamSid,
+			"accountSid": "AC" + randomHex(16),
+			"callSid":    c.callSid,
+			"tracks":     []string{"inbound"},
+			"mediaFormat": map[string]interface{}{
+				"encoding":   "audio/x-mulaw",
+				"sampleRate": twilioSampleRate,
+				"channels":   1,
+			},
+		},
+	})
+}
+
+func (c *simulatedCall) sendMedia(frame []byte) error {
+	c.chunk++
+	return c.send(map[string]interface{}{
+		"event":     "media",
+		"streamSid": c.streamSid,
+		"media": map[string]string{
+			"track":     "inbound",
+			"chunk":     strconv.Itoa(c.chunk),
+			"timestamp": strconv.Itoa(c.chunk * 20),
+			"payload":   base64.StdEncoding.EncodeToString(frame),
+		},
+	})
+}
+
+func (c *simulatedCall) readReplies() error {
+	for {
+		var data map[string]interface{}
+		if err := c.conn.ReadJSON(&data); err != nil {
+			return err
+		}
+
+		switch data["event"] {
+		case "media":
+			media, _ := data["media"].(map[string]interface{})
+			payload, _ := media["payload"].(string)
+			audio, err := base64.StdEncoding.DecodeString(payload)
+			if err != nil {
+				continue
+			}
+
+			c.mu.Lock()
+			c.reply = append(c.reply, audio...)
+			c.lastAudio = time.Now()
+			if c.firstAudio.IsZero() {
+				c.firstAudio = c.lastAudio
+			}
+			c.playbackEnd = maxTime(c.playbackEnd, c.lastAudio).Add(time.Duration(len(audio)) * time.Second / twilioSampleRate)
+			c.mu.Unlock()
+		case "mark":
+			// Like Twilio, echo the mark once the audio sent before it would
+			// have finished playing.
+			c.mu.Lock()
+			delay := time.Until(c.playbackEnd)
+			c.mu.Unlock()
+			mark := data["mark"]
+			time.AfterFunc(delay, func() {
+				c.send(map[string]interface{}{"event": "mark", "streamSid": c.streamSid, "mark": mark})
+			})
+		case "clear":
+			c.mu.Lock()
+			c.playbackEnd = time.Now()
+			c.mu.Unlock()
+		}
+	}
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func (c *simulatedCall) audioTimes() (time.Time, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.firstAudio, c.lastAudio
+}
+
+// startReply drops the audio received so far, such as the greeting, once
+// the question has been sent, so only the reply is kept.
+func (c *simulatedCall) startReply() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reply = nil
+	c.firstAudio = time.Time{}
+}
+
+func silenceFrame() []byte {
+	return silence(twilioFrameBytes)
+}
+
+func silence(n int) []byte {
+	frame := make([]byte, n)
+	for i := range frame {
+		frame[i] = mulawSilence
+	}
+	return frame
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,214 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const openAIWebhookTolerance = 5 * time.Minute
+
+// In SIP mode Twilio dials the call straight into OpenAI's Realtime SIP
+// interface, so audio never passes through this process. OpenAI announces
+// each call with a realtime.call.incoming webhook; we accept it with the
+// session configuration and then follow it over a sideband websocket to
+// run tools, record the timeline and hang up or redirect through Twilio.
+
+func sipMode() bool {
+	return config.OpenAISIPProjectID != ""
+}
+
+// sip reports whether the session is an OpenAI SIP sideband, with no Twilio
+// media stream of its own.
+func (s *callSession) sip() bool {
+	return s.twilioWs == nil
+}
+
+// writeSIPTwiML dials OpenAI over SIP, passing the caller's number and the
+// Twilio CallSid along as SIP headers for the webhook.
+func writeSIPTwiML(w http.ResponseWriter, number, callSid string) {
+	headers := url.Values{"X-Phone-Number": {number}, "X-Twilio-Call-Sid": {callSid}}
+	uri := fmt.Sprintf("sip:%s@sip.api.openai.com;transport=tls?%s", config.OpenAISIPProjectID, headers.Encode())
+
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><Response><Dial><Sip>`)
+	xml.EscapeText(&b, []byte(uri))
+	b.WriteString(`</Sip></Dial></Response>`)
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write(b.Bytes())
+}
+
+type openAIWebhookEvent struct {
+	Type string `json:"type"`
+	Data struct {
+		CallID     string `json:"call_id"`
+		SIPHeaders []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"sip_headers"`
+	} `json:"data"`
+}
+
+func handleOpenAIWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := verifyOpenAIWebhook(r.Header, body); err != nil {
+		log.Println("Rejected OpenAI webhook:", err)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var event openAIWebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if event.Type != "realtime.call.incoming" {
+		return
+	}
+	headers := map[string]string{}
+	for _, h := range event.Data.SIPHeaders {
+		headers[h.Name] = h.Value
+	}
+	go runSIPCall(event.Data.CallID, headers["X-Phone-Number"], headers["X-Twilio-Call-Sid"])
+}
+
+// verifyOpenAIWebhook checks the Standard Webhooks signature OpenAI sends,
+// using OPENAI_WEBHOOK_SECRET.
+func verifyOpenAIWebhook(header http.Header, body []byte) error {
+	id, timestamp := header.Get("webhook-id"), header.Get("webhook-timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid webhook-timestamp")
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > openAIWebhookTolerance || age < -openAIWebhookTolerance {
+		return fmt.Errorf("webhook-timestamp outside tolerance")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret("OPENAI_WEBHOOK_SECRET"), "whsec_"))
+	if err != nil {
+		return fmt.Errorf("error decoding OPENAI_WEBHOOK_SECRET: %v", err)
+	}
+	// An empty key would let anyone sign.
+	if len(key) == 0 {
+		return fmt.Errorf("OPENAI_WEBHOOK_SECRET is not set")
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "." + timestamp + "."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+
+	for _, signature := range strings.Fields(header.Get("webhook-signature")) {
+		version, value, _ := strings.Cut(signature, ",")
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if version == "v1" && err == nil && hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid webhook-signature")
+}
+
+// runSIPCall accepts an incoming SIP call and follows it over the sideband
+// websocket until it ends.
+func runSIPCall(callID, phoneNumber, callSid string) {
+	if atCapacity() {
+		if err := openAICallRequest(callID, "reject", map[string]interface{}{"status_code": 486}); err != nil {
+			log.Println("Error rejecting SIP call:", err)
+		}
+		return
+	}
+
+	model := realtimeModels()[0]
+	session := gaSession(sipSessionConfig(config.SystemMessage))
+	session["model"] = model
+	if err := openAICallRequest(callID, "accept", session); err != nil {
+		log.Println("Error accepting SIP call:", err)
+		if err := failoverCall(callSid, "openai_error", nil); err != nil {
+			log.Println("Error redirecting call after OpenAI failure:", err)
+		}
+		return
+	}
+
+	sideband, err := url.Parse(config.OpenAIRealtimeURL)
+	if err != nil {
+		log.Println("Error parsing OPENAI_REALTIME_URL:", err)
+		return
+	}
+	sideband.RawQuery = url.Values{"call_id": {callID}}.Encode()
+	dialer := &websocket.Dialer{Proxy: openAIProxy, HandshakeTimeout: 45 * time.Second}
+	conn, _, err := dialer.Dial(sideband.String(), openAIHeader(nil))
+	if err != nil {
+		log.Println("Error connecting to OpenAI SIP sideband:", err)
+		return
+	}
+	defer conn.Close()
+
+	s := newCallSession(phoneNumber, model, nil, nil, conn)
+	defer s.end()
+	s.start(callID, callSid, "")
+	if err := sendInitialMessages(s, false); err != nil {
+		log.Println("Error sending initial messages:", err)
+		s.hangup()
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	handleOpenAIMessages(s, &wg)
+}
+
+// sipSessionConfig is sessionConfig without audio formats, which SIP
+// negotiates itself.
+func sipSessionConfig(instructions string) map[string]interface{} {
+	session := sessionConfig(instructions)
+	delete(session, "input_audio_format")
+	delete(session, "output_audio_format")
+	return session
+}
+
+// openAICallRequest posts to /realtime/calls/{callID}/{action}, e.g. accept,
+// reject or hangup.
+func openAICallRequest(callID, action string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, openAIAPIURL("/realtime/calls/"+url.PathEscape(callID)+"/"+action), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header = openAIHeader(nil)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := openAIClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status code: %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
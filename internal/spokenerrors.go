@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"slices"
+	"text/template"
+)
+
+var spokenErrorsTotal = newCounter("spoken_errors_total", "Failures the caller was told about, by error class.", "class")
+
+// Whatever goes wrong, the caller is told rather than left in silence. What
+// they hear depends on the error class:
+//
+//   - openai_down: the assistant can't be reached, when the stream
+//     connects or mid-call. OPENAI_UNAVAILABLE_MESSAGE by default.
+//   - tool_failed: a tool the model called failed. The model says it.
+//   - over_capacity: the call can't be taken, for capacity, quotas, rate
+//     limits or a caller already on a call. BUSY_MESSAGE by default.
+//
+// "error_messages" in CONFIG_FILE, or a tenant's own, override the
+// defaults. Each message is a Go template with .Transfer, true when an
+// openai_down call goes on to FALLBACK_PHONE_NUMBER, and .Tool, the tool
+// that failed.
+var errorClasses = []string{"openai_down", "tool_failed", "over_capacity"}
+
+const defaultToolFailedMessage = "Sorry, I couldn't do that just now because of a technical problem."
+
+type errorMessageData struct {
+	Transfer bool
+	Tool     string
+}
+
+func validateErrorMessages(messages map[string]string) error {
+	for class, text := range messages {
+		if !slices.Contains(errorClasses, class) {
+			return fmt.Errorf("unknown error class %s: use openai_down, tool_failed or over_capacity", class)
+		}
+		if _, err := template.New(class).Parse(text); err != nil {
+			return fmt.Errorf("error parsing %s message: %v", class, err)
+		}
+	}
+	return nil
+}
+
+// errorMessage returns what a caller of the tenant hears for an error class.
+func errorMessage(tenant *tenantConfig, class string, data errorMessageData) string {
+	spokenErrorsTotal.add(1, class)
+	text, ok := "", false
+	if tenant != nil {
+		text, ok = tenant.ErrorMessages[class]
+	}
+	if !ok {
+		text, ok = config.File.ErrorMessages[class]
+	}
+	if !ok {
+		switch class {
+		case "openai_down":
+			text = config.OpenAIUnavailableMessage
+		case "tool_failed":
+			text = defaultToolFailedMessage
+		case "over_capacity":
+			text = config.BusyMessage
+		}
+	}
+
+	tmpl, err := template.New(class).Parse(text)
+	if err != nil {
+		return text
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		log.Printf("Error rendering %s message: %v\n", class, err)
+		return text
+	}
+	return b.String()
+}
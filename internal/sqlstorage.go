@@ -0,0 +1,337 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// storageTime formats times with a fixed width so that, stored as text or
+// in object keys, they sort in time order.
+const storageTime = "2006-01-02T15:04:05.000000000Z07:00"
+
+// sqlStorage is the sqlite and postgres backends. Both use the same schema
+// and queries, written with ? placeholders and rebound for postgres. The
+// sqlite backend writes recordings and transcripts as files instead.
+type sqlStorage struct {
+	db       *sql.DB
+	postgres bool
+}
+
+const sqlStorageSchema = `
+CREATE TABLE IF NOT EXISTS calls (
+	id               TEXT PRIMARY KEY,
+	call_sid         TEXT NOT NULL,
+	phone_number     TEXT NOT NULL,
+	line             TEXT NOT NULL,
+	tenant           TEXT NOT NULL,
+	model            TEXT NOT NULL,
+	status           TEXT NOT NULL,
+	started_at       TEXT NOT NULL,
+	ended_at         TEXT NOT NULL,
+	duration_seconds DOUBLE PRECISION NOT NULL,
+	input_tokens     BIGINT NOT NULL,
+	output_tokens    BIGINT NOT NULL,
+	tool_calls       INTEGER NOT NULL,
+	estimated_cost   DOUBLE PRECISION NOT NULL,
+	summary          TEXT NOT NULL,
+	timeline         TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS calls_call_sid ON calls (call_sid);
+CREATE INDEX IF NOT EXISTS calls_started_at ON calls (started_at);
+CREATE TABLE IF NOT EXISTS audit (
+	seq       %s,
+	time      TEXT NOT NULL,
+	actor     TEXT NOT NULL,
+	action    TEXT NOT NULL,
+	call_sid  TEXT NOT NULL,
+	status    INTEGER NOT NULL,
+	remote_ip TEXT NOT NULL,
+	prev_hash TEXT NOT NULL,
+	hash      TEXT NOT NULL
+);
+`
+
+const sqlStorageBlobSchema = `
+CREATE TABLE IF NOT EXISTS transcripts (
+	name    TEXT PRIMARY KEY,
+	entries TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS recordings (
+	name TEXT PRIMARY KEY,
+	wav  BYTEA NOT NULL
+);
+`
+
+// openSQLiteStorage opens CALL_LOG_DB. With CALL_LOG_DB=off there is no
+// database, and calls and audit entries are not stored.
+func openSQLiteStorage() (*sqlStorage, error) {
+	if !callLogEnabled() {
+		return &sqlStorage{}, nil
+	}
+	// WAL and a busy timeout let the CLI read while the server writes.
+	db, err := sql.Open("sqlite", "file:"+config.CallLogDB+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", config.CallLogDB, err)
+	}
+	if _, err := db.Exec(fmt.Sprintf(sqlStorageSchema, "INTEGER PRIMARY KEY AUTOINCREMENT")); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating schema in %s: %v", config.CallLogDB, err)
+	}
+	return &sqlStorage{db: db}, nil
+}
+
+func openPostgresStorage() (*sqlStorage, error) {
+	postgresURL := secret("POSTGRES_URL")
+	if postgresURL == "" {
+		return nil, fmt.Errorf("STORAGE_BACKEND=postgres needs POSTGRES_URL")
+	}
+	db, err := sql.Open("postgres", postgresURL)
+	if err != nil {
+		return nil, fmt.Errorf("error opening Postgres: %v", err)
+	}
+	if _, err := db.Exec(fmt.Sprintf(sqlStorageSchema, "BIGSERIAL PRIMARY KEY") + sqlStorageBlobSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating Postgres schema: %v", err)
+	}
+	return &sqlStorage{db: db, postgres: true}, nil
+}
+
+func callLogEnabled() bool {
+	return config.CallLogDB != "" && config.CallLogDB != "off"
+}
+
+// rebind turns ? placeholders into Postgres's $1, $2 and so on.
+func (s *sqlStorage) rebind(query string) string {
+	if !s.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (s *sqlStorage) exec(query string, args ...interface{}) error {
+	_, err := s.db.Exec(s.rebind(query), args...)
+	return err
+}
+
+func (s *sqlStorage) SaveCall(entry callLogEntry) error {
+	if s.db == nil {
+		return nil
+	}
+	summary, timeline, err := callLogJSON(entry)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %v", err)
+	}
+	return s.exec(`INSERT INTO calls VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET status = excluded.status, ended_at = excluded.ended_at,
+			duration_seconds = excluded.duration_seconds, input_tokens = excluded.input_tokens,
+			output_tokens = excluded.output_tokens, tool_calls = excluded.tool_calls,
+			estimated_cost = excluded.estimated_cost, summary = excluded.summary, timeline = excluded.timeline`,
+		entry.ID, entry.CallSid, entry.PhoneNumber, entry.Line, entry.Tenant, entry.Model, entry.Status,
+		entry.StartedAt.UTC().Format(storageTime), entry.EndedAt.UTC().Format(storageTime), entry.DurationSeconds,
+		entry.InputTokens, entry.OutputTokens, entry.ToolCalls, entry.EstimatedCost, string(summary), string(timeline))
+}
+
+const callLogColumns = `id, call_sid, phone_number, line, tenant, model, status, started_at, ended_at,
+	duration_seconds, input_tokens, output_tokens, tool_calls, estimated_cost`
+
+func scanCallLogEntry(row interface{ Scan(...interface{}) error }, extra ...interface{}) (callLogEntry, error) {
+	var entry callLogEntry
+	var startedAt, endedAt string
+	dest := append([]interface{}{
+		&entry.ID, &entry.CallSid, &entry.PhoneNumber, &entry.Line, &entry.Tenant, &entry.Model, &entry.Status,
+		&startedAt, &endedAt, &entry.DurationSeconds, &entry.InputTokens, &entry.OutputTokens, &entry.ToolCalls, &entry.EstimatedCost,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return entry, err
+	}
+	entry.StartedAt, _ = time.Parse(storageTime, startedAt)
+	entry.EndedAt, _ = time.Parse(storageTime, endedAt)
+	return entry, nil
+}
+
+func (s *sqlStorage) ListCalls(f callLogFilter) ([]callLogEntry, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("the call log is off")
+	}
+
+	query := `SELECT ` + callLogColumns + ` FROM calls WHERE 1 = 1`
+	var args []interface{}
+	if !f.Since.IsZero() {
+		query += ` AND started_at >= ?`
+		args = append(args, f.Since.UTC().Format(storageTime))
+	}
+	if f.Tenant != "" {
+		query += ` AND tenant = ?`
+		args = append(args, f.Tenant)
+	}
+	if f.PhoneNumber != "" {
+		query += ` AND phone_number = ?`
+		args = append(args, f.PhoneNumber)
+	}
+	query += ` ORDER BY started_at DESC`
+	if f.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, f.Limit)
+	}
+
+	rows, err := s.db.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying call log: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []callLogEntry{}
+	for rows.Next() {
+		entry, err := scanCallLogEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading call log: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *sqlStorage) GetCall(id string) (*callLogEntry, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("the call log is off")
+	}
+
+	var summary, timeline string
+	row := s.db.QueryRow(s.rebind(`SELECT `+callLogColumns+`, summary, timeline FROM calls WHERE call_sid = ? OR id = ? ORDER BY started_at DESC LIMIT 1`), id, id)
+	entry, err := scanCallLogEntry(row, &summary, &timeline)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading call log: %v", err)
+	}
+	if err := json.Unmarshal([]byte(summary), &entry.Summary); err != nil {
+		return nil, fmt.Errorf("error parsing call summary: %v", err)
+	}
+	if err := json.Unmarshal([]byte(timeline), &entry.Timeline); err != nil {
+		return nil, fmt.Errorf("error parsing call timeline: %v", err)
+	}
+	return &entry, nil
+}
+
+func (s *sqlStorage) SaveTranscript(name string, entries []transcriptEntry) error {
+	data, err := encodeTranscript(entries)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %v", err)
+	}
+	if !s.postgres {
+		return writeRecordingFile(name+".jsonl", data)
+	}
+	return s.exec(`INSERT INTO transcripts VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET entries = excluded.entries`, name, string(data))
+}
+
+func (s *sqlStorage) Transcript(name string) ([]transcriptEntry, error) {
+	if !s.postgres {
+		entries, err := readTranscript(filepath.Join(recordingDir(), name+".jsonl"))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return entries, err
+	}
+	var data string
+	err := s.db.QueryRow(s.rebind(`SELECT entries FROM transcripts WHERE name = ?`), name).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading transcript: %v", err)
+	}
+	return decodeTranscript([]byte(data))
+}
+
+func (s *sqlStorage) SaveRecording(name string, wav []byte) error {
+	if !s.postgres {
+		return writeRecordingFile(name+".wav", wav)
+	}
+	return s.exec(`INSERT INTO recordings VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET wav = excluded.wav`, name, wav)
+}
+
+func (s *sqlStorage) Recording(name string) ([]byte, error) {
+	if !s.postgres {
+		wav, err := os.ReadFile(filepath.Join(recordingDir(), name+".wav"))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return wav, err
+	}
+	var wav []byte
+	err := s.db.QueryRow(s.rebind(`SELECT wav FROM recordings WHERE name = ?`), name).Scan(&wav)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading recording: %v", err)
+	}
+	return wav, nil
+}
+
+func writeRecordingFile(file string, data []byte) error {
+	path := filepath.Join(recordingDir(), file)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("error creating recording directory: %v", err)
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func (s *sqlStorage) AppendAudit(entry auditEntry) error {
+	if s.db == nil {
+		return nil
+	}
+	return s.exec(`INSERT INTO audit (time, actor, action, call_sid, status, remote_ip, prev_hash, hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.Time.UTC().Format(storageTime), entry.Actor, entry.Action, entry.Call, entry.Status, entry.RemoteIP, entry.PrevHash, entry.Hash)
+}
+
+func (s *sqlStorage) AuditEntries() ([]auditEntry, error) {
+	if s.db == nil {
+		return nil, nil
+	}
+
+	rows, err := s.db.Query(`SELECT time, actor, action, call_sid, status, remote_ip, prev_hash, hash FROM audit ORDER BY seq`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying audit log: %v", err)
+	}
+	defer rows.Close()
+
+	var entries []auditEntry
+	for rows.Next() {
+		var entry auditEntry
+		var t string
+		if err := rows.Scan(&t, &entry.Actor, &entry.Action, &entry.Call, &entry.Status, &entry.RemoteIP, &entry.PrevHash, &entry.Hash); err != nil {
+			return nil, fmt.Errorf("error reading audit log: %v", err)
+		}
+		entry.Time, _ = time.Parse(storageTime, t)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *sqlStorage) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
@@ -0,0 +1,344 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// stateStore keeps the call state that has to be found again by a later
+// request, which with several replicas behind a load balancer may arrive
+// at another instance: status callbacks for calls whose media stream
+// hasn't started, transfer summaries, conference joins and the calls in
+// progress. It is kept in memory, or in Redis when REDIS_URL is set.
+// Values are JSON; get, take and pop return nil for a missing key.
+type stateStore interface {
+	set(key string, value []byte, ttl time.Duration) error
+	get(key string) ([]byte, error)
+	// take returns the value under key and removes it.
+	take(key string) ([]byte, error)
+	// push appends value to the list under key and keeps the list for ttl.
+	push(key string, value []byte, ttl time.Duration) error
+	// pop removes and returns the first value of the list under key.
+	pop(key string) ([]byte, error)
+	// takeList returns the whole list under key and removes it.
+	takeList(key string) ([][]byte, error)
+	delete(key string) error
+	// setNX stores value under key for ttl unless the key is taken, and
+	// reports whether it did.
+	setNX(key string, value []byte, ttl time.Duration) (bool, error)
+	// deleteIf removes key if it holds value.
+	deleteIf(key string, value []byte) error
+	// expireIf keeps key for ttl from now if it holds value, and reports
+	// whether it does.
+	expireIf(key string, value []byte, ttl time.Duration) (bool, error)
+	// replaceIf stores value under key for ttl if the key holds old, and
+	// reports whether it did.
+	replaceIf(key string, old, value []byte, ttl time.Duration) (bool, error)
+}
+
+// callState is replaced in Run when REDIS_URL is set.
+var callState stateStore = newMemoryState()
+
+func openStateStore() error {
+	if config.RedisURL == "" {
+		return nil
+	}
+	s, err := newRedisState()
+	if err != nil {
+		return err
+	}
+	callState = s
+	return nil
+}
+
+func setState(key string, v interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %v", err)
+	}
+	return callState.set(key, data, ttl)
+}
+
+// getState decodes the value under key into v and reports whether there
+// was one.
+func getState(key string, v interface{}) (bool, error) {
+	data, err := callState.get(key)
+	return decodeState(data, err, v)
+}
+
+func takeState(key string, v interface{}) (bool, error) {
+	data, err := callState.take(key)
+	return decodeState(data, err, v)
+}
+
+func pushState(key string, v interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %v", err)
+	}
+	return callState.push(key, data, ttl)
+}
+
+func popState(key string, v interface{}) (bool, error) {
+	data, err := callState.pop(key)
+	return decodeState(data, err, v)
+}
+
+func decodeState(data []byte, err error, v interface{}) (bool, error) {
+	if err != nil || data == nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("error decoding state: %v", err)
+	}
+	return true, nil
+}
+
+// memoryState is the stateStore of a single instance. A plain value is a
+// list of one.
+type memoryState struct {
+	mu      sync.Mutex
+	entries map[string]*memoryStateEntry
+}
+
+type memoryStateEntry struct {
+	values  [][]byte
+	expires time.Time
+}
+
+func newMemoryState() *memoryState {
+	return &memoryState{entries: map[string]*memoryStateEntry{}}
+}
+
+// entry must be called with m.mu held.
+func (m *memoryState) entry(key string) *memoryStateEntry {
+	e, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(e.expires) {
+		delete(m.entries, key)
+		return nil
+	}
+	return e
+}
+
+// sweep drops expired entries. It must be called with m.mu held.
+func (m *memoryState) sweep() {
+	now := time.Now()
+	for key, e := range m.entries {
+		if now.After(e.expires) {
+			delete(m.entries, key)
+		}
+	}
+}
+
+func (m *memoryState) set(key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep()
+	m.entries[key] = &memoryStateEntry{values: [][]byte{value}, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *memoryState) get(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.entry(key); e != nil && len(e.values) > 0 {
+		return e.values[0], nil
+	}
+	return nil, nil
+}
+
+func (m *memoryState) take(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.entry(key)
+	delete(m.entries, key)
+	if e != nil && len(e.values) > 0 {
+		return e.values[0], nil
+	}
+	return nil, nil
+}
+
+func (m *memoryState) push(key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep()
+	e := m.entry(key)
+	if e == nil {
+		e = &memoryStateEntry{}
+		m.entries[key] = e
+	}
+	e.values = append(e.values, value)
+	e.expires = time.Now().Add(ttl)
+	return nil
+}
+
+func (m *memoryState) pop(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.entry(key)
+	if e == nil || len(e.values) == 0 {
+		return nil, nil
+	}
+	value := e.values[0]
+	e.values = e.values[1:]
+	if len(e.values) == 0 {
+		delete(m.entries, key)
+	}
+	return value, nil
+}
+
+func (m *memoryState) takeList(key string) ([][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.entry(key)
+	delete(m.entries, key)
+	if e == nil {
+		return nil, nil
+	}
+	return e.values, nil
+}
+
+func (m *memoryState) delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *memoryState) setNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entry(key) != nil {
+		return false, nil
+	}
+	m.entries[key] = &memoryStateEntry{values: [][]byte{value}, expires: time.Now().Add(ttl)}
+	return true, nil
+}
+
+// holds must be called with m.mu held.
+func (m *memoryState) holds(key string, value []byte) *memoryStateEntry {
+	e := m.entry(key)
+	if e == nil || len(e.values) != 1 || !bytes.Equal(e.values[0], value) {
+		return nil
+	}
+	return e
+}
+
+func (m *memoryState) deleteIf(key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.holds(key, value) != nil {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+func (m *memoryState) expireIf(key string, value []byte, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.holds(key, value)
+	if e == nil {
+		return false, nil
+	}
+	e.expires = time.Now().Add(ttl)
+	return true, nil
+}
+
+func (m *memoryState) replaceIf(key string, old, value []byte, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.holds(key, old)
+	if e == nil {
+		return false, nil
+	}
+	e.values = [][]byte{value}
+	e.expires = time.Now().Add(ttl)
+	return true, nil
+}
+
+// callSnapshotTTL bounds how long the snapshot of a call outlives an
+// instance that died during the call.
+const callSnapshotTTL = 6 * time.Hour
+
+// callSnapshot is what the state store keeps of a call in progress under
+// "call:" and its CallSid: who it is with, which agent answers and its
+// counters, so that another instance can tell the call is live and pick
+// up its bookkeeping.
+type callSnapshot struct {
+	ID           string                 `json:"id"`
+	CallSid      string                 `json:"call_sid"`
+	StreamSid    string                 `json:"stream_sid"`
+	PhoneNumber  string                 `json:"phone_number"`
+	Line         string                 `json:"line,omitempty"`
+	Tenant       string                 `json:"tenant,omitempty"`
+	Model        string                 `json:"model"`
+	Status       string                 `json:"status,omitempty"`
+	VerifiedBy   string                 `json:"verified_by,omitempty"`
+	StartedAt    time.Time              `json:"started_at"`
+	InputTokens  int64                  `json:"input_tokens"`
+	OutputTokens int64                  `json:"output_tokens"`
+	ToolCalls    int                    `json:"tool_calls"`
+	Metadata     map[string]interface{} `json:"metadata,omitempty"`
+	Host         string                 `json:"host"`
+	PID          int                    `json:"pid"`
+}
+
+// saveSnapshot stores the call's snapshot once its CallSid is known.
+func (s *callSession) saveSnapshot() {
+	s.mu.Lock()
+	snapshot := callSnapshot{
+		ID:           s.id,
+		CallSid:      s.call,
+		StreamSid:    s.stream,
+		PhoneNumber:  s.phoneNumber,
+		Line:         s.line,
+		Tenant:       s.tenant.id(),
+		Model:        s.model,
+		Status:       s.status,
+		VerifiedBy:   s.verified,
+		StartedAt:    s.startedAt,
+		InputTokens:  s.tokens.inputTokens(),
+		OutputTokens: s.tokens.outputTokens(),
+		ToolCalls:    s.toolCalls,
+		Metadata:     s.callMetadata(),
+		PID:          os.Getpid(),
+	}
+	s.mu.Unlock()
+	if snapshot.CallSid == "" {
+		return
+	}
+	snapshot.Host, _ = os.Hostname()
+	if err := setState("call:"+snapshot.CallSid, snapshot, callSnapshotTTL); err != nil {
+		log.Println("Error saving call state:", err)
+	}
+}
+
+func (s *callSession) deleteSnapshot() {
+	if callSid := s.callSid(); callSid != "" {
+		if err := callState.delete("call:" + callSid); err != nil {
+			log.Println("Error deleting call state:", err)
+		}
+	}
+}
+
+// callSnapshotFor returns the snapshot of a call in progress, here or on another
+// instance, or nil.
+func callSnapshotFor(callSid string) *callSnapshot {
+	var snapshot callSnapshot
+	ok, err := getState("call:"+callSid, &snapshot)
+	if err != nil {
+		log.Println("Error reading call state:", err)
+	}
+	if !ok {
+		return nil
+	}
+	return &snapshot
+}
@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// statsdPacketSize keeps batched lines within one unfragmented UDP packet.
+const statsdPacketSize = 1432
+
+// statsdBuffer is how many samples wait to be sent before new ones are
+// dropped, so a slow or missing agent never holds up a call.
+const statsdBuffer = 1000
+
+// StatsD export mirrors every metric update to STATSD_ADDR over UDP, next to
+// the Prometheus endpoint. With the default dogstatsd flavor labels become
+// tags, and updates made for a call are also tagged with its agent (the
+// number answered or called from) and tenant. Plain statsd has no tags, so
+// label values are appended to the name and the call tags are left out.
+var statsdLines chan string
+
+func startStatsD() error {
+	if config.StatsDAddr == "" {
+		return nil
+	}
+
+	conn, err := net.Dial("udp", config.StatsDAddr)
+	if err != nil {
+		return fmt.Errorf("error connecting to StatsD: %v", err)
+	}
+	statsdLines = make(chan string, statsdBuffer)
+	go runStatsD(conn)
+	return nil
+}
+
+func runStatsD(conn net.Conn) {
+	var packet []byte
+	flush := func() {
+		if len(packet) == 0 {
+			return
+		}
+		if _, err := conn.Write(packet); err != nil {
+			log.Println("Error sending StatsD metrics:", err)
+		}
+		packet = packet[:0]
+	}
+
+	for line := range statsdLines {
+		packet = append(packet, line...)
+		// Batch whatever else is already queued, then send.
+	batch:
+		for {
+			select {
+			case line := <-statsdLines:
+				if len(packet)+1+len(line) > statsdPacketSize {
+					flush()
+				} else {
+					packet = append(packet, '\n')
+				}
+				packet = append(packet, line...)
+			default:
+				break batch
+			}
+		}
+		flush()
+	}
+}
+
+// sendStatsD queues one sample of m. tags are the call's tags, if any.
+func sendStatsD(m *metric, v float64, labelValues, tags []string) {
+	if statsdLines == nil {
+		return
+	}
+
+	name := config.StatsDPrefix + strings.TrimPrefix(m.name, metricsNamespace)
+	kind := map[string]string{"counter": "c", "gauge": "g", "histogram": "h"}[m.kind]
+	dogstatsd := config.StatsDFlavor == "dogstatsd"
+	if !dogstatsd {
+		for _, value := range labelValues {
+			name += "." + statsdSafe(value)
+		}
+		// Plain StatsD times in milliseconds; other histograms, such as
+		// shares, stay histograms.
+		if kind == "h" && strings.HasSuffix(m.name, "_seconds") {
+			kind = "ms"
+			v *= 1000
+		}
+	}
+	line := name + ":" + strconv.FormatFloat(v, 'f', -1, 64) + "|" + kind
+
+	if dogstatsd {
+		all := append([]string{}, config.StatsDTags...)
+		for i, label := range m.labels {
+			all = append(all, label+":"+statsdSafe(labelValues[i]))
+		}
+		all = append(all, tags...)
+		if len(all) > 0 {
+			line += "|#" + strings.Join(all, ",")
+		}
+	}
+
+	select {
+	case statsdLines <- line:
+	default:
+	}
+}
+
+// statsdSafe replaces the characters that delimit StatsD lines and tags.
+func statsdSafe(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', ',', '#', '\n', ' ', '.':
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+// statsdTags are the tags for metrics updated on behalf of the call. They are
+// set when the stream starts and nil before then.
+func (s *callSession) statsdTags() []string {
+	if s == nil {
+		return nil
+	}
+	if tags := s.metricTags.Load(); tags != nil {
+		return *tags
+	}
+	return nil
+}
+
+func (s *callSession) setStatsDTags(line string) {
+	var tags []string
+	if line != "" {
+		tags = append(tags, "agent:"+statsdSafe(line))
+	}
+	if s.tenant != nil {
+		tags = append(tags, "tenant:"+statsdSafe(s.tenant.ID))
+	}
+	s.metricTags.Store(&tags)
+}
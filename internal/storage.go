@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+)
+
+// Storage keeps what outlives a call: the call log, transcripts, recordings
+// and audit entries. STORAGE_BACKEND picks the implementation:
+//
+//   - sqlite, the default, keeps the call log and audit entries in
+//     CALL_LOG_DB and writes recordings and transcripts as files under
+//     RECORDING_DIR, where replay expects them.
+//   - postgres keeps everything in the POSTGRES_URL database, so several
+//     instances can share it.
+//   - s3 keeps everything as objects in STORAGE_S3_BUCKET.
+//
+// Names of recordings and transcripts are relative paths such as
+// "tenant/CA123", without an extension.
+type Storage interface {
+	// SaveCall adds a call to the call log, or replaces it.
+	SaveCall(entry callLogEntry) error
+	// ListCalls returns matching calls, newest first, without their summary
+	// and timeline.
+	ListCalls(f callLogFilter) ([]callLogEntry, error)
+	// GetCall finds a call by CallSid or call ID, with its summary and
+	// timeline. It returns nil if there is no such call.
+	GetCall(id string) (*callLogEntry, error)
+	SaveTranscript(name string, entries []transcriptEntry) error
+	// Transcript returns a saved transcript, or nil if there is none.
+	Transcript(name string) ([]transcriptEntry, error)
+	SaveRecording(name string, wav []byte) error
+	// Recording returns a saved recording's WAV file, or nil if there is
+	// none.
+	Recording(name string) ([]byte, error)
+	AppendAudit(entry auditEntry) error
+	// AuditEntries returns every audit entry, oldest first.
+	AuditEntries() ([]auditEntry, error)
+	Close() error
+}
+
+// storage is opened in Run; it stays nil in the CLI commands that don't need
+// it, and nil-safe helpers below skip it then.
+var storage Storage
+
+func openStorage() (Storage, error) {
+	switch config.StorageBackend {
+	case "sqlite":
+		return openSQLiteStorage()
+	case "postgres":
+		return openPostgresStorage()
+	case "s3":
+		return newS3Storage()
+	default:
+		return nil, fmt.Errorf("STORAGE_BACKEND must be sqlite, postgres or s3")
+	}
+}
+
+func initStorage() error {
+	s, err := openStorage()
+	if err != nil {
+		return err
+	}
+	storage = s
+	return nil
+}
+
+// recordingEnabled reports whether calls are recorded and transcribed:
+// with RECORDING_DIR, or with RECORD_CALLS for backends that don't write
+// to local files.
+func recordingEnabled() bool {
+	return config.RecordingDir != "" || config.RecordCalls
+}
+
+// recordingDir is where the sqlite backend writes recordings.
+func recordingDir() string {
+	if config.RecordingDir != "" {
+		return config.RecordingDir
+	}
+	return "recordings"
+}
+
+func saveTranscript(name string, entries []transcriptEntry) error {
+	if storage == nil {
+		return nil
+	}
+	return storage.SaveTranscript(name, entries)
+}
+
+func saveRecording(name string, wav []byte) error {
+	if storage == nil {
+		return nil
+	}
+	return storage.SaveRecording(name, wav)
+}
+
+func storeAudit(entry auditEntry) {
+	if storage == nil {
+		return
+	}
+	if err := storage.AppendAudit(entry); err != nil {
+		log.Println("Error storing audit entry:", err)
+	}
+}
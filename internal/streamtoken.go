@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mediaStreamPath returns the Stream URL path for a caller, under
+// /tenants/<id> for a tenant's calls. When STREAM_TOKEN_SECRET is set it
+// carries a signed token as an extra path segment, since Twilio drops query
+// strings from Stream URLs. The number is escaped, as a SIP caller's may
+// hold a slash or question mark; the handler's path values are unescaped.
+func mediaStreamPath(tenant *tenantConfig, number string) string {
+	path := "/media-stream/" + url.PathEscape(number)
+	if tenant != nil {
+		path = "/tenants/" + url.PathEscape(tenant.ID) + path
+	}
+	if config.StreamTokenSecret == "" {
+		return path
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(config.StreamTokenTTL).Unix(), 10)
+	return path + "/" + expires + "." + signStreamToken(streamTokenSubject(tenant.id(), number), expires)
+}
+
+// streamTokenSubject is what a stream token is signed over, so a token
+// can't be moved to another number or tenant.
+func streamTokenSubject(tenantID, number string) string {
+	if tenantID == "" {
+		return number
+	}
+	return tenantID + "/" + number
+}
+
+func signStreamToken(number, expires string) string {
+	mac := hmac.New(sha256.New, []byte(config.StreamTokenSecret))
+	mac.Write([]byte(number + "|" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifyStreamToken(subject, token string) error {
+	if config.StreamTokenSecret == "" {
+		return nil
+	}
+	if token == "" {
+		return fmt.Errorf("missing stream token")
+	}
+
+	expires, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return fmt.Errorf("malformed stream token")
+	}
+	if !hmac.Equal([]byte(signature), []byte(signStreamToken(subject, expires))) {
+		return fmt.Errorf("invalid stream token signature")
+	}
+
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed stream token expiry: %v", err)
+	}
+	if time.Now().Unix() > unix {
+		return fmt.Errorf("stream token expired")
+	}
+
+	return nil
+}
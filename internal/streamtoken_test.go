@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyStreamToken(t *testing.T) {
+	secret, ttl := config.StreamTokenSecret, config.StreamTokenTTL
+	t.Cleanup(func() { config.StreamTokenSecret, config.StreamTokenTTL = secret, ttl })
+	config.StreamTokenSecret, config.StreamTokenTTL = "stream-secret", time.Minute
+
+	acme := &tenantConfig{ID: "acme"}
+	token := path.Base(mediaStreamPath(acme, "+15555550100"))
+	expired := strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10)
+	expiredToken := expired + "." + signStreamToken(streamTokenSubject("acme", "+15555550100"), expired)
+	signature := token[strings.Index(token, ".")+1:]
+
+	tests := []struct {
+		name    string
+		subject string
+		token   string
+		wantErr string
+	}{
+		{name: "valid", subject: streamTokenSubject("acme", "+15555550100"), token: token},
+		{name: "another number", subject: streamTokenSubject("acme", "+15555550101"), token: token, wantErr: "invalid stream token signature"},
+		{name: "another tenant", subject: streamTokenSubject("globex", "+15555550100"), token: token, wantErr: "invalid stream token signature"},
+		{name: "no tenant", subject: streamTokenSubject("", "+15555550100"), token: token, wantErr: "invalid stream token signature"},
+		{name: "expired", subject: streamTokenSubject("acme", "+15555550100"), token: expiredToken, wantErr: "stream token expired"},
+		{name: "expiry moved", subject: streamTokenSubject("acme", "+15555550100"), token: "9999999999." + signature, wantErr: "invalid stream token signature"},
+		{name: "missing", subject: streamTokenSubject("acme", "+15555550100"), wantErr: "missing stream token"},
+		{name: "no separator", subject: streamTokenSubject("acme", "+15555550100"), token: signature, wantErr: "malformed stream token"},
+		{
+			name: "expiry not a number", subject: streamTokenSubject("acme", "+15555550100"),
+			token:   "soon." + signStreamToken(streamTokenSubject("acme", "+15555550100"), "soon"),
+			wantErr: "malformed stream token expiry",
+		},
+	}
+	for _, tt := range tests {
+		err := verifyStreamToken(tt.subject, tt.token)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: got error %v", tt.name, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// Without a secret there is no token to check.
+	config.StreamTokenSecret = ""
+	if got := mediaStreamPath(acme, "+15555550100"); got != "/tenants/acme/media-stream/+15555550100" {
+		t.Errorf("path without a secret %s, want no token", got)
+	}
+	if err := verifyStreamToken(streamTokenSubject("acme", "+15555550100"), ""); err != nil {
+		t.Errorf("verifying without a secret: %v", err)
+	}
+}
+
+func TestMediaStreamPathEscapesNumber(t *testing.T) {
+	secret := config.StreamTokenSecret
+	t.Cleanup(func() { config.StreamTokenSecret = secret })
+	config.StreamTokenSecret = "stream-secret"
+
+	var tenant, number, token string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenants/{tenant}/media-stream/{number}/{token}", func(w http.ResponseWriter, r *http.Request) {
+		tenant, number, token = r.PathValue("tenant"), r.PathValue("number"), r.PathValue("token")
+	})
+
+	const caller = "sip:alice/desk?x=1#2@example.com"
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", mediaStreamPath(&tenantConfig{ID: "acme"}, caller), nil))
+	if w.Code != http.StatusOK || tenant != "acme" || number != caller {
+		t.Fatalf("routed to tenant %q and number %q with status %d, want acme and %q", tenant, number, w.Code, caller)
+	}
+	if err := verifyStreamToken(streamTokenSubject(tenant, number), token); err != nil {
+		t.Errorf("token for an escaped number: %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// complianceSTTChunkBytes batches Twilio's 20ms frames into 100ms
+	// messages, within what both providers accept.
+	complianceSTTChunkBytes = twilioSampleRate / 10
+	complianceSTTBuffer     = 3000
+	complianceSTTDrain      = 10 * time.Second
+)
+
+var complianceSTTURLs = map[string]string{
+	"deepgram":   "wss://api.deepgram.com/v1/listen?encoding=mulaw&sample_rate=8000&channels=1&punctuate=true&smart_format=true",
+	"assemblyai": "wss://streaming.assemblyai.com/v3/ws?encoding=pcm_mulaw&sample_rate=8000&format_turns=true",
+}
+
+// complianceSTT streams the caller's audio to Deepgram or AssemblyAI for a
+// transcript of record that doesn't depend on the model. It is saved next to
+// the recording as <CallSid>-compliance.jsonl. While recording is paused the
+// service gets silence, as the recording does.
+type complianceSTT struct {
+	frames    chan []byte
+	done      chan struct{}
+	finished  chan struct{}
+	closeOnce sync.Once
+	failed    atomic.Bool
+
+	mu         sync.Mutex
+	transcript []transcriptEntry
+}
+
+func newComplianceSTT() *complianceSTT {
+	if config.ComplianceSTT == "" {
+		return nil
+	}
+	t := &complianceSTT{
+		frames:   make(chan []byte, complianceSTTBuffer),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *complianceSTT) send(audio []byte) {
+	if t == nil || t.failed.Load() {
+		return
+	}
+	select {
+	case t.frames <- audio:
+	default:
+		droppedFrames.add(1, "compliance_stt")
+	}
+}
+
+// close flushes the remaining audio and waits for the final transcripts.
+func (t *complianceSTT) close() {
+	if t == nil {
+		return
+	}
+	t.closeOnce.Do(func() { close(t.done) })
+	<-t.finished
+}
+
+func (t *complianceSTT) save(name string) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := saveTranscript(name+"-compliance", t.transcript); err != nil {
+		return fmt.Errorf("error saving transcript %s-compliance: %v", name, err)
+	}
+	return nil
+}
+
+func (t *complianceSTT) run() {
+	defer close(t.finished)
+
+	url := config.ComplianceSTTURL
+	if url == "" {
+		url = complianceSTTURLs[config.ComplianceSTT]
+	}
+	header := http.Header{}
+	if config.ComplianceSTT == "deepgram" {
+		header.Set("Authorization", "Token "+secret("COMPLIANCE_STT_API_KEY"))
+	} else {
+		header.Set("Authorization", secret("COMPLIANCE_STT_API_KEY"))
+	}
+	dialer := &websocket.Dialer{HandshakeTimeout: 10 * time.Second}
+	conn, _, err := dialer.Dial(url, header)
+	if err != nil {
+		log.Println("Error connecting to compliance STT:", err)
+		t.failed.Store(true)
+		return
+	}
+	defer conn.Close()
+
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		t.readResults(conn)
+	}()
+
+	var pending []byte
+	for {
+		select {
+		case audio := <-t.frames:
+			pending = append(pending, audio...)
+			if len(pending) < complianceSTTChunkBytes {
+				continue
+			}
+			if !t.write(conn, websocket.BinaryMessage, pending) {
+				return
+			}
+			pending = nil
+		case <-read:
+			t.failed.Store(true)
+			return
+		case <-t.done:
+			for drained := false; !drained; {
+				select {
+				case audio := <-t.frames:
+					pending = append(pending, audio...)
+				default:
+					drained = true
+				}
+			}
+			if len(pending) > 0 && !t.write(conn, websocket.BinaryMessage, pending) {
+				return
+			}
+			// Ask the service to finalize, then wait for it to close the stream.
+			end := `{"type":"Terminate"}`
+			if config.ComplianceSTT == "deepgram" {
+				end = `{"type":"CloseStream"}`
+			}
+			if !t.write(conn, websocket.TextMessage, []byte(end)) {
+				return
+			}
+			select {
+			case <-read:
+			case <-time.After(complianceSTTDrain):
+				log.Println("Timed out waiting for the final compliance transcript")
+			}
+			return
+		}
+	}
+}
+
+func (t *complianceSTT) write(conn *websocket.Conn, messageType int, data []byte) bool {
+	setWriteDeadline(conn)
+	if err := conn.WriteMessage(messageType, data); err != nil {
+		log.Println("Error writing to compliance STT:", err)
+		t.failed.Store(true)
+		return false
+	}
+	return true
+}
+
+// readResults keeps final transcripts: Deepgram's final Results and
+// AssemblyAI's formatted end-of-turn Turns.
+func (t *complianceSTT) readResults(conn *websocket.Conn) {
+	for {
+		var result struct {
+			Type    string `json:"type"`
+			IsFinal bool   `json:"is_final"`
+			Channel struct {
+				Alternatives []struct {
+					Transcript string `json:"transcript"`
+				} `json:"alternatives"`
+			} `json:"channel"`
+			Transcript      string `json:"transcript"`
+			EndOfTurn       bool   `json:"end_of_turn"`
+			TurnIsFormatted bool   `json:"turn_is_formatted"`
+			Error           string `json:"error"`
+		}
+		if err := conn.ReadJSON(&result); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				log.Println("Error reading from compliance STT:", err)
+			}
+			return
+		}
+
+		var text string
+		switch {
+		case result.Error != "":
+			log.Println("Compliance STT error:", result.Error)
+		case result.Type == "Results" && result.IsFinal && len(result.Channel.Alternatives) > 0:
+			text = result.Channel.Alternatives[0].Transcript
+		case result.Type == "Turn" && result.EndOfTurn && result.TurnIsFormatted:
+			text = result.Transcript
+		}
+		if text == "" {
+			continue
+		}
+		t.mu.Lock()
+		t.transcript = append(t.transcript, transcriptEntry{Time: time.Now(), Role: "caller", Text: text})
+		t.mu.Unlock()
+	}
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// systemdSockets returns the number and names of the sockets passed by
+// systemd socket activation, named with FileDescriptorName=.
+func systemdSockets() (int, []string) {
+	if !systemdSocketActivated() {
+		return 0, nil
+	}
+	count, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	// A restarted server must not take these over.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	return count, names
+}
+
+func systemdSocketActivated() bool {
+	pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	return pid == os.Getpid() && os.Getenv("LISTEN_FDS") != ""
+}
+
+// sdNotify sends a state such as READY=1 to systemd. It does nothing
+// unless systemd runs the service with Type=notify or a watchdog.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.Println("Error notifying systemd:", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Println("Error notifying systemd:", err)
+	}
+}
+
+// startSystemdWatchdog pets the watchdog at half of WatchdogSec=. Each pet
+// first takes the session registry lock, so a server wedged on it gets
+// restarted.
+func startSystemdWatchdog() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	interval := time.Duration(usec) * time.Microsecond / 2
+	go func() {
+		for range time.Tick(interval) {
+			activeSessionCount()
+			sdNotify("WATCHDOG=1")
+		}
+	}()
+	log.Printf("Petting the systemd watchdog every %s\n", interval)
+}
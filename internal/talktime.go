@@ -0,0 +1,103 @@
+package internal
+
+import "time"
+
+var (
+	talkSecondsTotal     = newCounter("talk_seconds_total", "Speaking time on finished calls, by speaker (caller or assistant).", "speaker")
+	interruptionsTotal   = newCounter("interruptions_total", "Times a caller started speaking over the assistant.")
+	assistantTalkShare   = newHistogram("call_assistant_talk_share", "Share of a finished call's speaking time taken by the assistant.", []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9})
+	longestMonologueTime = newHistogram("call_longest_monologue_seconds", "Longest single assistant turn on a finished call.", []float64{5, 10, 15, 20, 30, 45, 60, 90, 120})
+)
+
+// talkStats measures who talks how much on a call. Caller turns run from
+// speech_started to speech_stopped. Assistant turns are the audio of each
+// response item sent to Twilio, cut back to what was heard when the caller
+// interrupts. It is guarded by the session's mutex.
+type talkStats struct {
+	callerMs           int64
+	assistantMs        int64
+	interruptions      int
+	longestCallerMs    int64
+	longestAssistantMs int64
+
+	callerStart time.Time
+	item        string
+	itemMs      int64
+}
+
+func (t *talkStats) speechStarted(interrupted bool) {
+	if interrupted {
+		t.interruptions++
+	}
+	t.callerStart = time.Now()
+}
+
+func (t *talkStats) speechStopped() {
+	if t.callerStart.IsZero() {
+		return
+	}
+	turn := time.Since(t.callerStart).Milliseconds()
+	t.callerMs += turn
+	t.longestCallerMs = max(t.longestCallerMs, turn)
+	t.callerStart = time.Time{}
+}
+
+func (t *talkStats) assistantAudio(itemID string, ms int64) {
+	if itemID != t.item {
+		t.finishItem()
+		t.item = itemID
+	}
+	t.itemMs += ms
+}
+
+// truncate cuts the assistant's current turn back to what the caller heard.
+func (t *talkStats) truncate(itemID string, audioEndMs int64) {
+	if itemID == t.item {
+		t.itemMs = min(t.itemMs, audioEndMs)
+	}
+}
+
+func (t *talkStats) finishItem() {
+	t.assistantMs += t.itemMs
+	t.longestAssistantMs = max(t.longestAssistantMs, t.itemMs)
+	t.itemMs = 0
+}
+
+// totals returns the stats including the turn in progress.
+func (t talkStats) totals() talkStats {
+	t.finishItem()
+	t.speechStopped()
+	return t
+}
+
+func (t talkStats) assistantShare() float64 {
+	if t.callerMs+t.assistantMs == 0 {
+		return 0
+	}
+	return float64(t.assistantMs) / float64(t.callerMs+t.assistantMs)
+}
+
+func (t talkStats) summary() map[string]interface{} {
+	t = t.totals()
+	return map[string]interface{}{
+		"caller_seconds":                 float64(t.callerMs) / 1000,
+		"assistant_seconds":              float64(t.assistantMs) / 1000,
+		"assistant_share":                t.assistantShare(),
+		"interruptions":                  t.interruptions,
+		"longest_caller_turn_seconds":    float64(t.longestCallerMs) / 1000,
+		"longest_assistant_turn_seconds": float64(t.longestAssistantMs) / 1000,
+	}
+}
+
+func (s *callSession) observeTalkTime() {
+	s.mu.Lock()
+	t := s.talk.totals()
+	s.mu.Unlock()
+
+	talkSecondsTotal.addFor(s, float64(t.callerMs)/1000, "caller")
+	talkSecondsTotal.addFor(s, float64(t.assistantMs)/1000, "assistant")
+	if t.callerMs+t.assistantMs > 0 {
+		assistantTalkShare.observeFor(s, t.assistantShare())
+	}
+	longestMonologueTime.observeFor(s, float64(t.longestAssistantMs)/1000)
+}
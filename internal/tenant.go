@@ -0,0 +1,194 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+)
+
+// tenantConfig is one tenant of a multi-tenant deployment, configured under
+// "tenants" in CONFIG_FILE. Calls are assigned to a tenant by the number
+// that was called or by a ?tenant=<token> query on the incoming-call URL.
+// Unset prompts fall back to SYSTEM_MESSAGE and GREETINGS_RESPONSE, but
+// webhooks never fall back: a tenant's events only go to its own targets.
+type tenantConfig struct {
+	ID string `json:"-"`
+
+	Numbers []string `json:"numbers"`
+	Token   string   `json:"token"`
+
+	// OpenAIAPIKeySecret names the setting holding the tenant's OpenAI key,
+	// read from the secrets manager or the environment like OPENAI_API_KEY.
+	OpenAIAPIKeySecret string `json:"openai_api_key_secret"`
+
+	SystemMessage string `json:"system_message"`
+	Greeting      string `json:"greeting"`
+	// GreetingStrategy and GreetingAudioClip default to GREETING_STRATEGY
+	// and GREETING_AUDIO_CLIP.
+	GreetingStrategy  string                     `json:"greeting_strategy"`
+	GreetingAudioClip string                     `json:"greeting_audio_clip"`
+	Tools             []string                   `json:"tools"`
+	Webhooks          map[string][]webhookTarget `json:"webhooks"`
+	StoragePrefix     string                     `json:"storage_prefix"`
+	ErrorMessages     map[string]string          `json:"error_messages"`
+	// Escalation replaces the escalation rules in CONFIG_FILE when set.
+	Escalation []escalationRule `json:"escalation"`
+
+	// Quotas; zero means unlimited. Calls over MaxConcurrentCalls get the
+	// busy message, with a callback offer when CALLBACK_ENABLED is set.
+	MaxConcurrentCalls int   `json:"max_concurrent_calls"`
+	MonthlyMinutes     int   `json:"monthly_minutes"`
+	MonthlyTokens      int64 `json:"monthly_tokens"`
+}
+
+func validateTenants() error {
+	numbers, tokens := map[string]string{}, map[string]string{}
+	for id, t := range config.File.Tenants {
+		for _, number := range t.Numbers {
+			if other, ok := numbers[number]; ok {
+				return fmt.Errorf("number %s belongs to both tenants %s and %s", number, other, id)
+			}
+			numbers[number] = id
+		}
+		if t.Token != "" {
+			if other, ok := tokens[t.Token]; ok {
+				return fmt.Errorf("tenants %s and %s share a token", other, id)
+			}
+			tokens[t.Token] = id
+		}
+		if t.OpenAIAPIKeySecret != "" && secret(t.OpenAIAPIKeySecret) == "" {
+			return fmt.Errorf("tenant %s: %s is not set", id, t.OpenAIAPIKeySecret)
+		}
+		strategy, clip := t.greetingStrategy()
+		if err := validateGreetingStrategy(strategy, clip); err != nil {
+			return fmt.Errorf("tenant %s: %v", id, err)
+		}
+		if err := validateErrorMessages(t.ErrorMessages); err != nil {
+			return fmt.Errorf("tenant %s: %v", id, err)
+		}
+		if err := validateEscalationRules(t.Escalation); err != nil {
+			return fmt.Errorf("tenant %s escalation: %v", id, err)
+		}
+		for _, rule := range t.escalationRules() {
+			if rule.Action == "transfer" && !t.allowsTool(transferTool.name) {
+				return fmt.Errorf("tenant %s: escalation rule %s transfers, but the tools list leaves out %s", id, rule.Name, transferTool.name)
+			}
+		}
+		for _, name := range t.Tools {
+			if findTool(name) == nil {
+				return fmt.Errorf("tenant %s: %s is not an enabled tool", id, name)
+			}
+		}
+		if t.allowsTool(scheduleTool.name) && len(t.Webhooks["schedule"]) == 0 {
+			return fmt.Errorf("tenant %s needs a schedule webhook, or a tools list without %s", id, scheduleTool.name)
+		}
+	}
+	return nil
+}
+
+// tenantForCall picks the tenant for an incoming-call request, or nil to
+// use the default configuration.
+func tenantForCall(r *http.Request) *tenantConfig {
+	if token := r.URL.Query().Get("tenant"); token != "" {
+		for _, t := range config.File.Tenants {
+			if t.Token == token {
+				return t
+			}
+		}
+	}
+
+	// Our own number is "To" on inbound calls and "From" on calls we place.
+	if r.FormValue("Direction") == "outbound-api" {
+		return tenantForNumber(r.FormValue("From"))
+	}
+	return tenantForNumber(r.FormValue("To"))
+}
+
+func tenantForNumber(number string) *tenantConfig {
+	for _, t := range config.File.Tenants {
+		if slices.Contains(t.Numbers, number) {
+			return t
+		}
+	}
+	return nil
+}
+
+func (t *tenantConfig) id() string {
+	if t == nil {
+		return ""
+	}
+	return t.ID
+}
+
+func (t *tenantConfig) openAIKey() string {
+	return secret(t.openAIKeyName())
+}
+
+// openAIKeyName is the name of the setting holding the tenant's OpenAI key.
+func (t *tenantConfig) openAIKeyName() string {
+	if t == nil || t.OpenAIAPIKeySecret == "" {
+		return "OPENAI_API_KEY"
+	}
+	return t.OpenAIAPIKeySecret
+}
+
+func (t *tenantConfig) prompts() (instructions, greeting string) {
+	instructions, greeting = config.SystemMessage, config.XMLResponse
+	if t == nil {
+		return instructions, greeting
+	}
+	if t.SystemMessage != "" {
+		instructions = t.SystemMessage
+	}
+	if t.Greeting != "" {
+		greeting = t.Greeting
+	}
+	return instructions, greeting
+}
+
+func (t *tenantConfig) greetingStrategy() (strategy, clip string) {
+	strategy, clip = config.GreetingStrategy, config.GreetingAudioClip
+	if t == nil {
+		return strategy, clip
+	}
+	if t.GreetingStrategy != "" {
+		strategy = t.GreetingStrategy
+	}
+	if t.GreetingAudioClip != "" {
+		clip = t.GreetingAudioClip
+	}
+	return strategy, clip
+}
+
+func (t *tenantConfig) allowsTool(name string) bool {
+	return t == nil || len(t.Tools) == 0 || slices.Contains(t.Tools, name)
+}
+
+func (t *tenantConfig) toolDefinitions() []map[string]interface{} {
+	definitions := []map[string]interface{}{}
+	for _, definition := range toolDefinitions() {
+		if name, _ := definition["name"].(string); t.allowsTool(name) {
+			definitions = append(definitions, definition)
+		}
+	}
+	return definitions
+}
+
+// storageName places a recording's files under the tenant's prefix in
+// RECORDING_DIR.
+func (t *tenantConfig) storageName(name string) string {
+	if t == nil || t.StoragePrefix == "" {
+		return name
+	}
+	return filepath.Join(t.StoragePrefix, name)
+}
+
+// callTenant finds the tenant of a call from its summary.
+func callTenant(call map[string]interface{}) *tenantConfig {
+	id, _ := call["tenant"].(string)
+	if id == "" {
+		return nil
+	}
+	return config.File.Tenants[id]
+}
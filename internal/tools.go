@@ -0,0 +1,296 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// tool is a function the model can call during a call. run returns the
+// output handed back to the model, which then responds to it unless the
+// tool is silent. Sensitive tools, and those named in SENSITIVE_TOOLS, only
+// run once the caller has been verified. Read-back tools only run once the
+// caller has confirmed the details, destructive tools only with a
+// confirmation token and approval tools only once a person approves. Form
+// tools are built by newFormTool.
+type tool struct {
+	name        string
+	description string
+	parameters  map[string]interface{}
+	enabled     func() bool
+	silent      bool
+	sensitive   bool
+	readBack    bool
+	destructive bool
+	approval    bool
+	form        *form
+	run         func(s *callSession, arguments string) (string, error)
+}
+
+var tools = []*tool{scheduleTool, transferTool, conferenceTool, holdTool, voicemailTool, sendCodeTool, verifyCodeTool, identityTool, recordingTool, saveDataTool, readDataTool}
+
+var scheduleTool = newFormTool(&form{
+	name:        "setup_schedule",
+	description: "Setup business meeting schedule.",
+	slots: []formSlot{
+		{name: "name", description: "The caller's name", prompt: "May I have your name?"},
+		{name: "email", description: "The caller's email address", prompt: "What email address should the invitation go to?", readBack: true, validate: validateEmail},
+		{name: "datetime", description: "The date and time of the meeting, as YYYY-MM-DDTHH:MM", prompt: "When would you like to meet?", optional: true, validate: validateFutureDateTime},
+		{name: "description", description: "What the meeting is about", prompt: "What is the meeting about?"},
+	},
+	confirmation: "a meeting for {{.name}} ({{.email}}){{with .datetime}} at {{.}}{{end}} about {{.description}}",
+	submit: func(s *callSession, values map[string]string) (string, error) {
+		if err := setupSchedule(s, values["name"], values["email"], values["datetime"], values["description"]); err != nil {
+			return "", fmt.Errorf("error setting up schedule: %v", err)
+		}
+		return "Your schedule has been set successfully!", nil
+	},
+})
+
+func toolDefinitions() []map[string]interface{} {
+	definitions := []map[string]interface{}{}
+	for _, t := range tools {
+		if t.enabled != nil && !t.enabled() {
+			continue
+		}
+		description := t.description
+		if t.isSensitive() {
+			description += " Only available once the caller has been verified."
+		}
+		parameters := t.parameters
+		if t.needsReadBack() {
+			description += " Only runs once the caller has confirmed the details read back to them."
+			parameters = withParameter(parameters, "confirmed", map[string]string{"type": "boolean", "description": "True once the caller has confirmed the details read back to them"})
+		}
+		if t.isDestructive() {
+			description += " Can't be undone: the first call only returns a confirmation token, and it runs when called again with the token after the caller agrees."
+			parameters = withParameter(parameters, "confirmation_token", map[string]string{"type": "string", "description": "The token from the first call, once the caller has agreed"})
+		}
+		if t.needsApproval() {
+			description += " Needs a person's approval, so the outcome comes later in the call."
+		}
+		definitions = append(definitions, map[string]interface{}{
+			"type":        "function",
+			"name":        t.name,
+			"description": description,
+			"parameters":  parameters,
+		})
+	}
+	return definitions
+}
+
+func (t *tool) isSensitive() bool {
+	return t.sensitive || slices.Contains(config.SensitiveTools, t.name)
+}
+
+func findTool(name string) *tool {
+	for _, t := range tools {
+		if t.name == name && (t.enabled == nil || t.enabled()) {
+			return t
+		}
+	}
+	return nil
+}
+
+// callTool runs a function call from the model and, if it succeeds, returns
+// its output to the model and asks for the next response. It runs on its own
+// goroutine; a tool still running after HOLD_AFTER puts the caller on hold
+// until it finishes.
+func callTool(s *callSession, name, callID, arguments string) {
+	defer s.recoverPanic("tool")
+	s.mu.Lock()
+	s.toolsRunning++
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.toolsRunning--
+		s.mu.Unlock()
+	}()
+
+	t := findTool(name)
+	if t == nil || !s.tenant.allowsTool(name) {
+		log.Println("Unknown tool called:", name)
+		s.record("tool.error", "unknown tool "+name)
+		s.toolFailed(callID, name)
+		return
+	}
+
+	if t.isSensitive() && s.verifiedBy() == "" {
+		s.record("tool.refused", name)
+		s.sendToolOutput(callID, "The caller has not been verified yet. Verify them before calling "+name+".", true)
+		return
+	}
+
+	if t.needsReadBack() {
+		details, prompt, confirmed, err := s.readBack(t, arguments)
+		if err != nil {
+			log.Printf("Error reading back tool %s: %v\n", name, err)
+			s.record("tool.error", err.Error())
+			s.toolFailed(callID, name)
+			return
+		}
+		if !confirmed {
+			s.record("tool.read_back", name)
+			s.sendToolOutput(callID, prompt, true)
+			return
+		}
+		s.record("tool.confirmed", name)
+		arguments = details
+	}
+
+	if t.isDestructive() {
+		details, prompt, confirmed, err := s.confirmDestructive(t, arguments)
+		if err != nil {
+			log.Printf("Error confirming tool %s: %v\n", name, err)
+			s.record("tool.error", err.Error())
+			s.toolFailed(callID, name)
+			return
+		}
+		if !confirmed {
+			s.record("tool.confirmation_required", name)
+			s.sendToolOutput(callID, prompt, true)
+			return
+		}
+		s.record("tool.confirmed", name)
+		arguments = details
+	}
+
+	if t.needsApproval() {
+		s.requestApproval(t, callID, arguments)
+		return
+	}
+
+	finished := make(chan struct{})
+	held := make(chan bool, 1)
+	go func() {
+		if config.HoldAfter <= 0 {
+			held <- false
+			return
+		}
+		select {
+		case <-finished:
+			held <- false
+		case <-time.After(config.HoldAfter):
+			s.hold("tool " + name)
+			held <- true
+		}
+	}()
+
+	output, err := t.run(s, arguments)
+	close(finished)
+	if <-held {
+		s.resume(false)
+	}
+	if err != nil {
+		log.Printf("Error running tool %s: %v\n", name, err)
+		s.record("tool.error", err.Error())
+		s.toolFailed(callID, name)
+		return
+	}
+	s.record("tool.result", name)
+	s.sendToolOutput(callID, output, !t.silent)
+}
+
+func (s *callSession) sendToolOutput(callID, output string, respond bool) {
+	toolResponse := map[string]interface{}{
+		"type": "conversation.item.create",
+		"item": map[string]interface{}{
+			"call_id": callID,
+			"type":    "function_call_output",
+			"output":  output,
+		},
+	}
+	if err := s.sendOpenAI(toolResponse); err != nil {
+		log.Println("Error sending tool response to OpenAI:", err)
+	}
+	s.recorder.addToolOutput(callID, output)
+	if respond {
+		s.respondToToolOutput()
+	}
+}
+
+// respondToToolOutput has the model tell the caller about a tool result,
+// once the response in progress, if any, is done.
+func (s *callSession) respondToToolOutput() {
+	s.mu.Lock()
+	responding := s.responding
+	s.pendingConfirmation = s.pendingConfirmation || responding
+	s.mu.Unlock()
+	if !responding {
+		s.confirmToolOutput()
+	}
+}
+
+// toolFailed answers a function call that failed with the tenant's
+// tool_failed message for the model to pass on, so the caller isn't left
+// waiting in silence.
+func (s *callSession) toolFailed(callID, name string) {
+	message := errorMessage(s.tenant, "tool_failed", errorMessageData{Tool: name})
+	s.sendToolOutput(callID, "The tool failed. Tell the caller this, in their language: "+message, true)
+}
+
+// toolConfirmation marks the out-of-band responses that confirm tool
+// results, in their metadata.
+const toolConfirmation = "tool_confirmation"
+
+// confirmToolOutput asks the model to tell the caller how a tool call went,
+// in an out-of-band response: one that reads the conversation but isn't
+// added to it, so it neither collides with the turn in progress nor starts a
+// new one. A tool that finishes while a response is in progress is
+// confirmed once that response is done, so the two don't talk over each
+// other.
+func (s *callSession) confirmToolOutput() {
+	s.awaitRateLimit()
+	instructions, _ := s.prompts()
+	responseCreate := map[string]interface{}{
+		"type": "response.create",
+		"response": map[string]interface{}{
+			"conversation": "none",
+			"metadata":     map[string]string{"purpose": toolConfirmation},
+			"tool_choice":  "none",
+			// A response's instructions replace the session's.
+			"instructions": instructions + "\n\nBriefly tell the caller the outcome of your last tool call, from its output.",
+		},
+	}
+	if err := s.sendOpenAI(&responseCreate); err != nil {
+		log.Println("Error sending response create:", err)
+	}
+}
+
+// trackOutOfBand notes the out-of-band responses in progress, from their
+// response.created and response.done events. Their audio isn't in the
+// conversation, so there is no item to truncate when the caller talks over
+// it. After a response is done it confirms the tools that finished during
+// it.
+func (s *callSession) trackOutOfBand(eventType string, response map[string]interface{}) {
+	id, _ := response["id"].(string)
+	metadata, _ := response["metadata"].(map[string]interface{})
+	s.mu.Lock()
+	switch eventType {
+	case "response.created":
+		if metadata["purpose"] == toolConfirmation {
+			if s.outOfBand == nil {
+				s.outOfBand = map[string]bool{}
+			}
+			s.outOfBand[id] = true
+		}
+		s.mu.Unlock()
+	case "response.done":
+		delete(s.outOfBand, id)
+		confirm := s.pendingConfirmation
+		s.pendingConfirmation = false
+		s.mu.Unlock()
+		if confirm {
+			go s.confirmToolOutput()
+		}
+	default:
+		s.mu.Unlock()
+	}
+}
+
+func (s *callSession) outOfBandResponse(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.outOfBand[id]
+}
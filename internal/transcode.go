@@ -0,0 +1,210 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// openAISampleRate is the rate of pcm16 audio in the Realtime API.
+const openAISampleRate = 24000
+
+// With OPENAI_AUDIO_FORMAT=pcm16 the bridge transcodes between Twilio's
+// 8kHz μ-law and OpenAI's 24kHz PCM16. Both directions are streaming
+// converters that carry state across chunks, so chunk boundaries don't
+// click.
+
+// upsampler converts 8kHz μ-law to 24kHz little-endian PCM16, interpolating
+// linearly from the last sample of the previous chunk.
+type upsampler struct {
+	last int16
+}
+
+func (u *upsampler) convert(mulaw []byte) []byte {
+	const factor = openAISampleRate / twilioSampleRate
+
+	out := make([]byte, 0, len(mulaw)*factor*2)
+	for _, b := range mulaw {
+		sample := mulawDecode(b)
+		for i := 1; i <= factor; i++ {
+			v := int(u.last) + (int(sample)-int(u.last))*i/factor
+			out = binary.LittleEndian.AppendUint16(out, uint16(int16(v)))
+		}
+		u.last = sample
+	}
+	return out
+}
+
+// downsampler converts 24kHz little-endian PCM16 to 8kHz μ-law, averaging
+// each group of three samples. Bytes that don't complete a group wait for
+// the next chunk.
+type downsampler struct {
+	pending []byte
+}
+
+func (d *downsampler) convert(pcm []byte) []byte {
+	const factor = openAISampleRate / twilioSampleRate
+	const group = factor * 2
+
+	data := append(d.pending, pcm...)
+	n := len(data) / group
+	out := make([]byte, n)
+	for i := 0; i < n; i++ {
+		sum := 0
+		for j := 0; j < factor; j++ {
+			sum += int(int16(binary.LittleEndian.Uint16(data[i*group+j*2:])))
+		}
+		out[i] = mulawEncode(int16(sum / factor))
+	}
+	d.pending = append([]byte(nil), data[n*group:]...)
+	return out
+}
+
+func (d *downsampler) reset() {
+	d.pending = nil
+}
+
+func transcodingAudio() bool {
+	return config.OpenAIAudioFormat == "pcm16"
+}
+
+// A media stream whose start event gives audio/G722 as its encoding, as a
+// SIP gateway or browser client speaking the media stream protocol can,
+// carries 16kHz G.722 rather than 8kHz μ-law. Everything the bridge does
+// with the audio itself, such as recording, forks, pacing and limits, still
+// works on an 8kHz μ-law copy. With pcm16, though, audio between the stream
+// and OpenAI keeps its full band: the caller's audio is resampled from 16kHz
+// to 24kHz, and OpenAI's from 24kHz to 16kHz, next to the copy. Audio that
+// only exists at 8kHz, such as hold music, is resampled up for the stream.
+
+// wideBytesPerByte is how many bytes of 16kHz PCM16 hold the same audio as
+// one byte of 8kHz μ-law.
+const wideBytesPerByte = g722SampleRate / twilioSampleRate * 2
+
+// widebandLeg holds the converters of a G.722 stream. decoder and narrower
+// are only touched by the Twilio reader goroutine, encoder and widener by
+// the Twilio writer, upsampler by the OpenAI writer and downsampler by the
+// OpenAI reader.
+type widebandLeg struct {
+	decoder     *g722Decoder
+	narrower    wideNarrower
+	encoder     *g722Encoder
+	widener     wideWidener
+	upsampler   wideUpsampler
+	downsampler wideDownsampler
+}
+
+// streamLeg returns the converters for the mediaFormat of a stream's start
+// event, or nil for μ-law.
+func streamLeg(start map[string]interface{}) (*widebandLeg, error) {
+	format, _ := start["mediaFormat"].(map[string]interface{})
+	encoding, _ := format["encoding"].(string)
+	switch strings.ToLower(encoding) {
+	case "", "audio/x-mulaw":
+		return nil, nil
+	case "audio/g722":
+		return &widebandLeg{decoder: newG722Decoder(), encoder: newG722Encoder()}, nil
+	}
+	return nil, fmt.Errorf("unsupported media format %s: use audio/x-mulaw or audio/G722", encoding)
+}
+
+// decode turns a G.722 payload into 16kHz PCM16 and its 8kHz μ-law copy.
+func (l *widebandLeg) decode(payload []byte) ([]byte, []byte) {
+	wide := l.decoder.decode(payload)
+	return wide, l.narrower.convert(wide)
+}
+
+// encode codes audio for the stream, from its 16kHz PCM16 when there is
+// some.
+func (l *widebandLeg) encode(audio, wide []byte) []byte {
+	if wide == nil {
+		wide = l.widener.convert(audio)
+	} else if len(audio) > 0 {
+		l.widener.last = mulawDecode(audio[len(audio)-1])
+	}
+	return l.encoder.encode(wide)
+}
+
+// wideNarrower converts 16kHz PCM16 to 8kHz μ-law, averaging each pair of
+// samples.
+type wideNarrower struct {
+	pending []byte
+}
+
+func (n *wideNarrower) convert(pcm []byte) []byte {
+	data := append(n.pending, pcm...)
+	count := len(data) / 4
+	out := make([]byte, count)
+	for i := 0; i < count; i++ {
+		a := int(int16(binary.LittleEndian.Uint16(data[i*4:])))
+		b := int(int16(binary.LittleEndian.Uint16(data[i*4+2:])))
+		out[i] = mulawEncode(int16((a + b) / 2))
+	}
+	n.pending = append([]byte(nil), data[count*4:]...)
+	return out
+}
+
+// wideWidener converts 8kHz μ-law to 16kHz PCM16, as upsampler does.
+type wideWidener struct {
+	last int16
+}
+
+func (w *wideWidener) convert(mulaw []byte) []byte {
+	out := make([]byte, 0, len(mulaw)*wideBytesPerByte)
+	for _, b := range mulaw {
+		sample := mulawDecode(b)
+		out = binary.LittleEndian.AppendUint16(out, uint16(int16((int(w.last)+int(sample))/2)))
+		out = binary.LittleEndian.AppendUint16(out, uint16(sample))
+		w.last = sample
+	}
+	return out
+}
+
+// wideUpsampler converts 16kHz PCM16 to 24kHz, interpolating linearly
+// between each pair of samples and the last sample before them.
+type wideUpsampler struct {
+	last    int
+	pending []byte
+}
+
+func (u *wideUpsampler) convert(pcm []byte) []byte {
+	data := append(u.pending, pcm...)
+	pairs := len(data) / 4
+	out := make([]byte, 0, pairs*6)
+	for i := 0; i < pairs; i++ {
+		a := int(int16(binary.LittleEndian.Uint16(data[i*4:])))
+		b := int(int16(binary.LittleEndian.Uint16(data[i*4+2:])))
+		for _, v := range []int{u.last + (a-u.last)*2/3, a + (b-a)/3, b} {
+			out = binary.LittleEndian.AppendUint16(out, uint16(int16(v)))
+		}
+		u.last = b
+	}
+	u.pending = append([]byte(nil), data[pairs*4:]...)
+	return out
+}
+
+// wideDownsampler converts 24kHz PCM16 to 16kHz, turning each group of
+// three samples into two. It consumes the same groups as downsampler, so
+// the two stay in step.
+type wideDownsampler struct {
+	pending []byte
+}
+
+func (d *wideDownsampler) convert(pcm []byte) []byte {
+	data := append(d.pending, pcm...)
+	groups := len(data) / 6
+	out := make([]byte, 0, groups*4)
+	for i := 0; i < groups; i++ {
+		a := int(int16(binary.LittleEndian.Uint16(data[i*6:])))
+		b := int(int16(binary.LittleEndian.Uint16(data[i*6+2:])))
+		c := int(int16(binary.LittleEndian.Uint16(data[i*6+4:])))
+		out = binary.LittleEndian.AppendUint16(out, uint16(int16((2*a+b)/3)))
+		out = binary.LittleEndian.AppendUint16(out, uint16(int16((b+2*c)/3)))
+	}
+	d.pending = append([]byte(nil), data[groups*6:]...)
+	return out
+}
+
+func (d *wideDownsampler) reset() {
+	d.pending = nil
+}
@@ -0,0 +1,277 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// transcribeChunkSeconds splits long recordings into uploads well under
+// the Whisper API's 25 MB limit.
+const transcribeChunkSeconds = 10 * 60
+
+var transcribeClient = &http.Client{
+	Transport: openAITransport(),
+	Timeout:   5 * time.Minute,
+}
+
+type TranscribeOptions struct {
+	Language string
+	Force    bool
+}
+
+type recordingTrack struct {
+	role    string
+	samples []int16
+}
+
+type transcriptionSegment struct {
+	Start float64 `json:"start"`
+	Text  string  `json:"text"`
+}
+
+// Transcribe runs a recording through the Whisper API, or the
+// OpenAI-compatible endpoint at TRANSCRIBE_URL, and saves the result as the
+// call's transcript, for calls recorded before they were transcribed live.
+// recording is a WAV or raw μ-law file, or the name of a recording in
+// storage such as CA123 or tenant/CA123. Stereo and separate recordings are
+// transcribed per side, so the caller and assistant are told apart; a mono
+// recording is the caller only.
+func Transcribe(recording string, opts TranscribeOptions) error {
+	readConfig()
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	name, tracks, err := recordingTracks(store, recording)
+	if err != nil {
+		return err
+	}
+	existing, err := store.Transcript(name)
+	if err != nil {
+		return err
+	}
+	if existing != nil && !opts.Force {
+		return fmt.Errorf("%s already has a transcript, use --force to replace it", name)
+	}
+
+	// Entries are timed from the start of the call when it is in the call
+	// log, and from now otherwise.
+	startedAt := time.Now().UTC()
+	if call, _ := store.GetCall(path.Base(name)); call != nil {
+		startedAt = call.StartedAt
+	} else {
+		fmt.Fprintf(os.Stderr, "%s is not in the call log, timing the transcript from now\n", name)
+	}
+
+	var entries []transcriptEntry
+	for _, track := range tracks {
+		segments, err := transcribeAudio(track.samples, opts.Language)
+		if err != nil {
+			return fmt.Errorf("error transcribing %s audio: %v", track.role, err)
+		}
+		for _, segment := range segments {
+			entries = append(entries, transcriptEntry{
+				Time: startedAt.Add(time.Duration(segment.Start * float64(time.Second))),
+				Role: track.role,
+				Text: segment.Text,
+			})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+
+	if err := store.SaveTranscript(name, entries); err != nil {
+		return fmt.Errorf("error saving transcript %s: %v", name, err)
+	}
+	for _, entry := range entries {
+		label := "Caller:   "
+		if entry.Role == "assistant" {
+			label = "Assistant:"
+		}
+		fmt.Printf("%s %s\n", label, entry.Text)
+	}
+	fmt.Fprintf(os.Stderr, "Saved transcript %s with %d entries\n", name, len(entries))
+	return nil
+}
+
+// recordingTracks loads a recording from a file, or from storage by name,
+// along with the other side of a separate recording. It returns the name
+// the call's transcript is saved under.
+func recordingTracks(store Storage, recording string) (string, []recordingTrack, error) {
+	if data, err := os.ReadFile(recording); err == nil {
+		ext := filepath.Ext(recording)
+		stem := strings.TrimSuffix(recording, ext)
+		name, role := splitTrackName(stem)
+		tracks, err := decodeRecording(data, role)
+		if err != nil {
+			return "", nil, fmt.Errorf("error reading %s: %v", recording, err)
+		}
+		if role != "" {
+			other := "assistant"
+			if role == "assistant" {
+				other = "caller"
+			}
+			if data, err := os.ReadFile(strings.TrimSuffix(stem, role) + other + ext); err == nil {
+				more, err := decodeRecording(data, other)
+				if err != nil {
+					return "", nil, fmt.Errorf("error reading the %s side of %s: %v", other, recording, err)
+				}
+				tracks = append(tracks, more...)
+			}
+		}
+
+		// Files under RECORDING_DIR keep their tenant prefix.
+		if rel, err := filepath.Rel(recordingDir(), name); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel), tracks, nil
+		}
+		return filepath.Base(name), tracks, nil
+	}
+
+	name, _ := splitTrackName(strings.TrimSuffix(recording, ".wav"))
+	var tracks []recordingTrack
+	for _, track := range []struct{ suffix, role string }{{"", ""}, {"-caller", "caller"}, {"-assistant", "assistant"}} {
+		wav, err := store.Recording(name + track.suffix)
+		if err != nil {
+			return "", nil, fmt.Errorf("error reading recording %s: %v", name+track.suffix, err)
+		}
+		if wav == nil {
+			continue
+		}
+		more, err := decodeRecording(wav, track.role)
+		if err != nil {
+			return "", nil, fmt.Errorf("error reading recording %s: %v", name+track.suffix, err)
+		}
+		tracks = append(tracks, more...)
+		if track.suffix == "" {
+			break
+		}
+	}
+	if len(tracks) == 0 {
+		return "", nil, fmt.Errorf("no recording %s in storage or on disk", recording)
+	}
+	return name, tracks, nil
+}
+
+// splitTrackName strips the -caller or -assistant suffix of a separate
+// recording, returning the side it was.
+func splitTrackName(stem string) (string, string) {
+	for _, role := range []string{"caller", "assistant"} {
+		if name, ok := strings.CutSuffix(stem, "-"+role); ok {
+			return name, role
+		}
+	}
+	return stem, ""
+}
+
+// decodeRecording splits a stereo WAV into the caller, on the left, and the
+// assistant. Anything else is one track of role, the caller by default;
+// data that isn't WAV is taken as raw 8 kHz μ-law.
+func decodeRecording(data []byte, role string) ([]recordingTrack, error) {
+	if role == "" {
+		role = "caller"
+	}
+	if !isWAV(data) {
+		return []recordingTrack{{role: role, samples: decodeMulaw(data)}}, nil
+	}
+	interleaved, channels, rate, err := decodeWAV(data)
+	if err != nil {
+		return nil, err
+	}
+	if channels == 2 {
+		left, right := make([]int16, len(interleaved)/2), make([]int16, len(interleaved)/2)
+		for i := range left {
+			left[i], right[i] = interleaved[2*i], interleaved[2*i+1]
+		}
+		return []recordingTrack{
+			{role: "caller", samples: resample(left, rate, twilioSampleRate)},
+			{role: "assistant", samples: resample(right, rate, twilioSampleRate)},
+		}, nil
+	}
+	return []recordingTrack{{role: role, samples: resample(mixDown(interleaved, channels), rate, twilioSampleRate)}}, nil
+}
+
+func transcribeAudio(samples []int16, language string) ([]transcriptionSegment, error) {
+	var segments []transcriptionSegment
+	chunk := transcribeChunkSeconds * twilioSampleRate
+	for offset := 0; offset < len(samples); offset += chunk {
+		part, err := transcribeChunk(encodeWAV(samples[offset:min(offset+chunk, len(samples))], twilioSampleRate, 1), language)
+		if err != nil {
+			return nil, err
+		}
+		for _, segment := range part {
+			segment.Start += float64(offset) / twilioSampleRate
+			segments = append(segments, segment)
+		}
+	}
+	return segments, nil
+}
+
+func transcribeChunk(wav []byte, language string) ([]transcriptionSegment, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	file, err := form.CreateFormFile("file", "audio.wav")
+	if err != nil {
+		return nil, err
+	}
+	file.Write(wav)
+	form.WriteField("model", config.TranscribeModel)
+	form.WriteField("response_format", "verbose_json")
+	if language != "" {
+		form.WriteField("language", language)
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	url := config.TranscribeURL
+	if url == "" {
+		url = openAIAPIURL("/audio/transcriptions")
+	}
+	req, err := http.NewRequest(http.MethodPost, url, &body)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header = openAIHeader(nil)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := transcribeClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("unexpected status code: %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	var result struct {
+		Text     string                 `json:"text"`
+		Segments []transcriptionSegment `json:"segments"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error decoding response: %v", err)
+	}
+	// Endpoints without segments return the text alone.
+	if len(result.Segments) == 0 {
+		result.Segments = []transcriptionSegment{{Text: result.Text}}
+	}
+	var segments []transcriptionSegment
+	for _, segment := range result.Segments {
+		if segment.Text = strings.TrimSpace(segment.Text); segment.Text != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments, nil
+}
@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const transferSummaryTTL = 10 * time.Minute
+
+var transferTool = &tool{
+	name:        "transfer_to_human",
+	description: "Transfer the caller to a human agent, when they ask for a person or you cannot help them. Tell the caller you are connecting them before calling this.",
+	parameters: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"summary": map[string]string{"type": "string", "description": "One paragraph for the agent taking the call: who the caller is, what they need and anything already tried or agreed, so they don't have to repeat themselves."},
+			"reason":  map[string]string{"type": "string", "description": "Short reason for the transfer."},
+		},
+		"required": []string{"summary"},
+	},
+	enabled: func() bool { return config.TransferPhoneNumber != "" },
+	run: func(s *callSession, arguments string) (string, error) {
+		var args struct {
+			Summary string `json:"summary"`
+			Reason  string `json:"reason"`
+		}
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return "", fmt.Errorf("error parsing JSON: %v", err)
+		}
+
+		twiml, err := s.prepareTransfer(args.Summary, args.Reason)
+		if err != nil {
+			return "", err
+		}
+		s.redirectAfterResponse(&pendingRedirect{twiml: twiml, event: "transfer", detail: config.TransferPhoneNumber})
+
+		return "The transfer is ready. Briefly tell the caller you're connecting them to a member of the team now, then stop talking.", nil
+	},
+}
+
+// prepareTransfer delivers the handoff summary through each configured
+// channel and returns the TwiML that bridges the caller to the agent.
+func (s *callSession) prepareTransfer(summary, reason string) (string, error) {
+	delivery := config.TransferSummaryDelivery
+	var whisperURL string
+
+	if slices.Contains(delivery, "whisper") {
+		if config.ExternalURL == "" {
+			return "", fmt.Errorf("EXTERNAL_URL or PUBLIC_HOSTNAME must be set for whisper transfer summaries")
+		}
+		// The summary is kept under "transfer:" in the state store until
+		// the receiving agent's leg fetches it.
+		id := randomHex(16)
+		if err := setState("transfer:"+id, summary, transferSummaryTTL); err != nil {
+			return "", fmt.Errorf("error saving transfer summary: %v", err)
+		}
+		whisperURL = config.ExternalURL + "/transfer-whisper/" + id
+	}
+
+	if slices.Contains(delivery, "sms") {
+		to := config.TransferSMSTo
+		if to == "" {
+			to = config.TransferPhoneNumber
+		}
+		body := fmt.Sprintf("Incoming transfer from %s: %s", s.phoneNumber, summary)
+		if err := sendSMS(config.TransferSMSFrom, to, body); err != nil {
+			log.Println("Error sending transfer summary SMS:", err)
+		}
+	}
+
+	if slices.Contains(delivery, "webhook") {
+		payload := map[string]string{"summary": summary, "reason": reason, "phone_number": s.phoneNumber, "transfer_to": config.TransferPhoneNumber}
+		if err := deliverWebhook("transfer", s.summary(), payload); err != nil {
+			log.Println("Error delivering transfer webhook:", err)
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><Response><Dial><Number`)
+	if whisperURL != "" {
+		fmt.Fprintf(&b, ` url="%s"`, whisperURL)
+	}
+	b.WriteString(">")
+	xml.EscapeText(&b, []byte(config.TransferPhoneNumber))
+	b.WriteString("</Number></Dial></Response>")
+
+	s.record("transfer.prepared", reason)
+	return b.String(), nil
+}
+
+// pendingRedirect is TwiML to move the call to once the model has finished
+// speaking, so it can tell the caller what is about to happen. after runs
+// once the redirect has succeeded.
+type pendingRedirect struct {
+	twiml  string
+	event  string
+	detail string
+	after  func()
+}
+
+func (s *callSession) redirectAfterResponse(r *pendingRedirect) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.redirect = r
+}
+
+func (s *callSession) takePendingRedirect() *pendingRedirect {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.redirect
+	s.redirect = nil
+	return r
+}
+
+// completeRedirect runs a pending redirect after the model's response has
+// finished playing. Twilio then closes the media stream, ending the session.
+func (s *callSession) completeRedirect(r *pendingRedirect) {
+	deadline := time.Now().Add(15 * time.Second)
+	for s.isPlaying() && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err := updateCallTwiML(s.callSid(), r.twiml); err != nil {
+		log.Printf("Error redirecting call for %s: %v\n", r.event, err)
+		s.record("tool.error", err.Error())
+		return
+	}
+	s.record(r.event, r.detail)
+	if r.after != nil {
+		r.after()
+	}
+}
+
+// handleTransferWhisper is fetched by Twilio when the agent answers, and
+// reads them the handoff summary before the caller is connected.
+func handleTransferWhisper(w http.ResponseWriter, r *http.Request) {
+	var summary string
+	ok, err := getState("transfer:"+r.PathValue("id"), &summary)
+	if err != nil {
+		log.Println("Error reading transfer summary:", err)
+	}
+
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><Response>`)
+	if ok {
+		b.WriteString("<Say>")
+		xml.EscapeText(&b, []byte(summary))
+		b.WriteString("</Say>")
+	}
+	b.WriteString("</Response>")
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write(b.Bytes())
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var twilioClient = &http.Client{Timeout: 15 * time.Second}
+
+// twilioRegionalAPIURL is the REST API base URL for a Twilio Region such as
+// ie1 or au1 and an edge location such as dublin or sydney. Calls created
+// there are processed in that region, so their media streams connect from
+// it. Twilio's default edge for a region applies when edge is empty, and
+// region us1 when only the edge is given.
+func twilioRegionalAPIURL(region, edge string) string {
+	switch {
+	case region == "" && edge == "":
+		return "https://api.twilio.com"
+	case edge == "":
+		return "https://api." + region + ".twilio.com"
+	case region == "":
+		region = "us1"
+	}
+	return "https://api." + edge + "." + region + ".twilio.com"
+}
+
+// twilioRequest calls the Twilio REST API under the configured account, e.g.
+// path "/Calls/CA123.json", decoding the JSON response into v when non-nil.
+func twilioRequest(method, path string, form url.Values, v interface{}) error {
+	accountSID, authToken := secret("TWILIO_ACCOUNT_SID"), secret("TWILIO_AUTH_TOKEN")
+	if accountSID == "" || authToken == "" {
+		return fmt.Errorf("TWILIO_ACCOUNT_SID and TWILIO_AUTH_TOKEN must be set")
+	}
+
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, config.TwilioAPIURL+"/2010-04-01/Accounts/"+accountSID+path, body)
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.SetBasicAuth(accountSID, authToken)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := twilioClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("unexpected status code: %d: %s (code %d)", resp.StatusCode, apiErr.Message, apiErr.Code)
+	}
+
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return fmt.Errorf("error parsing JSON: %v", err)
+		}
+	}
+	return nil
+}
+
+func updateCall(callSid string, params url.Values) error {
+	return twilioRequest(http.MethodPost, "/Calls/"+callSid+".json", params, nil)
+}
+
+// hangupCall ends a live call.
+func hangupCall(callSid string) error {
+	return updateCall(callSid, url.Values{"Status": {"completed"}})
+}
+
+// redirectCall makes Twilio fetch new TwiML for a live call from twimlURL.
+func redirectCall(callSid, twimlURL string) error {
+	return updateCall(callSid, url.Values{"Url": {twimlURL}, "Method": {http.MethodPost}})
+}
+
+// updateCallTwiML replaces a live call's instructions with inline TwiML.
+func updateCallTwiML(callSid, twiml string) error {
+	return updateCall(callSid, url.Values{"Twiml": {twiml}})
+}
+
+func sendSMS(from, to, body string) error {
+	return twilioRequest(http.MethodPost, "/Messages.json", url.Values{"From": {from}, "To": {to}, "Body": {body}}, nil)
+}
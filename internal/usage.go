@@ -0,0 +1,260 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// pricingConfig, under "pricing" in CONFIG_FILE, estimates what a call
+// cost: OpenAI tokens per million by kind, plus telephony per minute.
+type pricingConfig struct {
+	TextInputPerMillion   float64 `json:"text_input_per_million"`
+	AudioInputPerMillion  float64 `json:"audio_input_per_million"`
+	CachedInputPerMillion float64 `json:"cached_input_per_million"`
+	TextOutputPerMillion  float64 `json:"text_output_per_million"`
+	AudioOutputPerMillion float64 `json:"audio_output_per_million"`
+	PerMinute             float64 `json:"per_minute"`
+}
+
+var (
+	openAITokensTotal    = newCounter("openai_tokens_total", "OpenAI Realtime tokens used, by direction (input or output), modality (text or audio) and whether input tokens were cached.", "direction", "modality", "cached")
+	openAIResponsesTotal = newCounter("openai_responses_total", "OpenAI Realtime responses finished, by status.", "status")
+)
+
+// tokenUsage is the token breakdown OpenAI reports in response.done. Cached
+// tokens are part of the input counts.
+type tokenUsage struct {
+	InputTextTokens   int64 `json:"input_text_tokens"`
+	InputAudioTokens  int64 `json:"input_audio_tokens"`
+	CachedTextTokens  int64 `json:"cached_text_tokens"`
+	CachedAudioTokens int64 `json:"cached_audio_tokens"`
+	OutputTextTokens  int64 `json:"output_text_tokens"`
+	OutputAudioTokens int64 `json:"output_audio_tokens"`
+}
+
+func parseTokenUsage(usage map[string]interface{}) tokenUsage {
+	count := func(details map[string]interface{}, key string) int64 {
+		n, _ := details[key].(float64)
+		return int64(n)
+	}
+	input, _ := usage["input_token_details"].(map[string]interface{})
+	cached, _ := input["cached_tokens_details"].(map[string]interface{})
+	output, _ := usage["output_token_details"].(map[string]interface{})
+	return tokenUsage{
+		InputTextTokens:   count(input, "text_tokens"),
+		InputAudioTokens:  count(input, "audio_tokens"),
+		CachedTextTokens:  count(cached, "text_tokens"),
+		CachedAudioTokens: count(cached, "audio_tokens"),
+		OutputTextTokens:  count(output, "text_tokens"),
+		OutputAudioTokens: count(output, "audio_tokens"),
+	}
+}
+
+// addUsage counts the usage OpenAI reports in a response.done event
+// against the call, its tenant and the metrics.
+func (s *callSession) addUsage(response map[string]interface{}) {
+	status, _ := response["status"].(string)
+	openAIResponsesTotal.addFor(s, 1, status)
+
+	usage, _ := response["usage"].(map[string]interface{})
+	total, _ := usage["total_tokens"].(float64)
+	s.tenant.addTokens(int64(total))
+	tokens := parseTokenUsage(usage)
+	s.mu.Lock()
+	s.tokens.add(tokens)
+	s.mu.Unlock()
+
+	for _, c := range []struct {
+		direction, modality, cached string
+		n                           int64
+	}{
+		{"input", "text", "false", tokens.InputTextTokens - tokens.CachedTextTokens},
+		{"input", "text", "true", tokens.CachedTextTokens},
+		{"input", "audio", "false", tokens.InputAudioTokens - tokens.CachedAudioTokens},
+		{"input", "audio", "true", tokens.CachedAudioTokens},
+		{"output", "text", "false", tokens.OutputTextTokens},
+		{"output", "audio", "false", tokens.OutputAudioTokens},
+	} {
+		if c.n > 0 {
+			openAITokensTotal.addFor(s, float64(c.n), c.direction, c.modality, c.cached)
+		}
+	}
+}
+
+func (u *tokenUsage) add(other tokenUsage) {
+	u.InputTextTokens += other.InputTextTokens
+	u.InputAudioTokens += other.InputAudioTokens
+	u.CachedTextTokens += other.CachedTextTokens
+	u.CachedAudioTokens += other.CachedAudioTokens
+	u.OutputTextTokens += other.OutputTextTokens
+	u.OutputAudioTokens += other.OutputAudioTokens
+}
+
+func (u tokenUsage) inputTokens() int64 {
+	return u.InputTextTokens + u.InputAudioTokens
+}
+
+func (u tokenUsage) outputTokens() int64 {
+	return u.OutputTextTokens + u.OutputAudioTokens
+}
+
+func estimateCost(tokens tokenUsage, duration time.Duration) float64 {
+	p := config.File.Pricing
+	cost := float64(tokens.InputTextTokens-tokens.CachedTextTokens)*p.TextInputPerMillion +
+		float64(tokens.InputAudioTokens-tokens.CachedAudioTokens)*p.AudioInputPerMillion +
+		float64(tokens.CachedTextTokens+tokens.CachedAudioTokens)*p.CachedInputPerMillion +
+		float64(tokens.OutputTextTokens)*p.TextOutputPerMillion +
+		float64(tokens.OutputAudioTokens)*p.AudioOutputPerMillion
+	return cost/1e6 + duration.Minutes()*p.PerMinute
+}
+
+// usageRow aggregates the calls of one tenant and agent on one day (UTC).
+// The agent is the number the assistant answered or called from.
+type usageRow struct {
+	Day    string `json:"day"`
+	Tenant string `json:"tenant,omitempty"`
+	Agent  string `json:"agent,omitempty"`
+	tokenUsage
+	Calls         int     `json:"calls"`
+	Seconds       float64 `json:"seconds"`
+	ToolCalls     int     `json:"tool_calls"`
+	EstimatedCost float64 `json:"estimated_cost"`
+}
+
+func (r *usageRow) add(other usageRow) {
+	r.tokenUsage.add(other.tokenUsage)
+	r.Calls += other.Calls
+	r.Seconds += other.Seconds
+	r.ToolCalls += other.ToolCalls
+	r.EstimatedCost += other.EstimatedCost
+}
+
+var usageRows = struct {
+	sync.Mutex
+	rows []*usageRow
+}{}
+
+func readUsageFile(path string) ([]*usageRow, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	var rows []*usageRow
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	return rows, nil
+}
+
+func loadUsage() error {
+	if config.UsageFile == "" {
+		return nil
+	}
+	rows, err := readUsageFile(config.UsageFile)
+	if err != nil {
+		return err
+	}
+	usageRows.Lock()
+	usageRows.rows = rows
+	usageRows.Unlock()
+	return nil
+}
+
+// saveUsage must be called with usageRows locked.
+func saveUsage() {
+	if config.UsageFile == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(usageRows.rows, "", "  ")
+	if err != nil {
+		log.Println("Error marshaling usage:", err)
+		return
+	}
+	if err := os.WriteFile(config.UsageFile+".tmp", data, 0o600); err != nil {
+		log.Println("Error saving usage:", err)
+		return
+	}
+	if err := os.Rename(config.UsageFile+".tmp", config.UsageFile); err != nil {
+		log.Println("Error saving usage:", err)
+	}
+}
+
+// recordUsage adds a finished call to its day's row.
+func (s *callSession) recordUsage() {
+	s.mu.Lock()
+	duration := s.endedAt.Sub(s.startedAt)
+	call := usageRow{
+		Day:           s.startedAt.UTC().Format(time.DateOnly),
+		Tenant:        s.tenant.id(),
+		Agent:         s.line,
+		tokenUsage:    s.tokens,
+		Calls:         1,
+		Seconds:       duration.Seconds(),
+		ToolCalls:     s.toolCalls,
+		EstimatedCost: estimateCost(s.tokens, duration),
+	}
+	s.mu.Unlock()
+
+	usageRows.Lock()
+	defer usageRows.Unlock()
+	for _, row := range usageRows.rows {
+		if row.Day == call.Day && row.Tenant == call.Tenant && row.Agent == call.Agent {
+			row.add(call)
+			saveUsage()
+			return
+		}
+	}
+	usageRows.rows = append(usageRows.rows, &call)
+	saveUsage()
+}
+
+// usageFilter selects rows by day range (inclusive, YYYY-MM-DD), tenant and
+// agent; empty fields match everything.
+type usageFilter struct {
+	From, To      string
+	Tenant, Agent string
+}
+
+func (f usageFilter) matches(row *usageRow) bool {
+	return (f.From == "" || row.Day >= f.From) && (f.To == "" || row.Day <= f.To) &&
+		(f.Tenant == "" || row.Tenant == f.Tenant) && (f.Agent == "" || row.Agent == f.Agent)
+}
+
+func filterUsage(rows []*usageRow, f usageFilter) []usageRow {
+	matched := []usageRow{}
+	for _, row := range rows {
+		if f.matches(row) {
+			matched = append(matched, *row)
+		}
+	}
+	sortUsage(matched)
+	return matched
+}
+
+func sortUsage(rows []usageRow) {
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.Tenant != b.Tenant {
+			return a.Tenant < b.Tenant
+		}
+		return a.Agent < b.Agent
+	})
+}
+
+func listUsage(f usageFilter) []usageRow {
+	usageRows.Lock()
+	defer usageRows.Unlock()
+	return filterUsage(usageRows.rows, f)
+}
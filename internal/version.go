@@ -0,0 +1,7 @@
+package internal
+
+var (
+	Version   = "dev"
+	Commit    = "none"
+	BuildDate = "unknown"
+)
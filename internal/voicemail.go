@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/xml"
+	"log"
+)
+
+var voicemailTool = &tool{
+	name:        "leave_voicemail",
+	description: "Leave the pre-approved voicemail message and end the call. Call this as soon as you reach a voicemail box or answering machine and its greeting has finished; do not speak yourself.",
+	parameters:  map[string]interface{}{"type": "object", "properties": map[string]interface{}{}},
+	enabled:     voicemailConfigured,
+	silent:      true,
+	run: func(s *callSession, arguments string) (string, error) {
+		if err := updateCallTwiML(s.callSid(), voicemailTwiML()); err != nil {
+			return "", err
+		}
+		s.record("voicemail", "")
+		go voicemailDelivered(s.callSid(), s.phoneNumber, "tool", s.summary())
+		return "The voicemail is being left and the call will end.", nil
+	},
+}
+
+func voicemailConfigured() bool {
+	return config.VoicemailMessage != "" || config.VoicemailAudioURL != ""
+}
+
+// voicemailTwiML plays VOICEMAIL_AUDIO_URL, or reads VOICEMAIL_MESSAGE, and
+// hangs up.
+func voicemailTwiML() string {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><Response><Pause length="1"/>`)
+	if config.VoicemailAudioURL != "" {
+		b.WriteString("<Play>")
+		xml.EscapeText(&b, []byte(config.VoicemailAudioURL))
+		b.WriteString("</Play>")
+	} else {
+		b.WriteString("<Say>")
+		xml.EscapeText(&b, []byte(config.VoicemailMessage))
+		b.WriteString("</Say>")
+	}
+	b.WriteString("<Hangup/></Response>")
+	return b.String()
+}
+
+// voicemailDelivered sends the voicemail webhook event once the message has
+// been handed to Twilio. via is "amd" or "tool".
+func voicemailDelivered(callSid, phoneNumber, via string, call map[string]interface{}) {
+	payload := map[string]string{"call_sid": callSid, "phone_number": phoneNumber, "via": via}
+	if err := deliverWebhook("voicemail", call, payload); err != nil {
+		log.Println("Error delivering voicemail webhook:", err)
+	}
+}
@@ -0,0 +1,325 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"text/template"
+	"time"
+)
+
+var webhookClient = &http.Client{Timeout: 30 * time.Second}
+
+// newWebhookClient builds the HTTP client for tool webhooks, presenting
+// WEBHOOK_CLIENT_CERT/WEBHOOK_CLIENT_KEY for mutual TLS and trusting
+// WEBHOOK_CA_CERT in addition to the system roots when they are set.
+func newWebhookClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+
+	if config.WebhookClientCert != "" || config.WebhookClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(config.WebhookClientCert, config.WebhookClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("error loading webhook client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if config.WebhookCACert != "" {
+		pem, err := os.ReadFile(config.WebhookCACert)
+		if err != nil {
+			return nil, fmt.Errorf("error reading webhook CA certificate: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.WebhookCACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}, nil
+}
+
+// webhookTarget is one destination for an event, configured under
+// "webhooks" in CONFIG_FILE. Each target is delivered to concurrently and
+// retried on its own; failures of optional targets are only logged.
+type webhookTarget struct {
+	URL         string            `json:"url"`
+	Headers     map[string]string `json:"headers"`
+	MaxAttempts int               `json:"max_attempts"`
+	Backoff     string            `json:"backoff"`
+	Optional    bool              `json:"optional"`
+
+	// Template, or the file named by TemplateFile, is a text/template
+	// rendering the request body in place of the default JSON payload.
+	Template     string `json:"template"`
+	TemplateFile string `json:"template_file"`
+	template     *template.Template
+}
+
+// webhookTemplateData is what body templates are executed against. Data is
+// the default payload decoded into generic JSON values, so templates refer
+// to its fields by their JSON names, e.g. {{.Data.email}}.
+type webhookTemplateData struct {
+	Event string
+	Call  map[string]interface{}
+	Data  interface{}
+}
+
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"now": time.Now,
+}
+
+func parseWebhookTemplates() error {
+	if err := parseTemplates(config.File.Webhooks); err != nil {
+		return err
+	}
+	for id, tenant := range config.File.Tenants {
+		if err := parseTemplates(tenant.Webhooks); err != nil {
+			return fmt.Errorf("tenant %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+func parseTemplates(webhooks map[string][]webhookTarget) error {
+	for event, targets := range webhooks {
+		for i := range targets {
+			target := &targets[i]
+			text := target.Template
+			if target.TemplateFile != "" {
+				data, err := os.ReadFile(target.TemplateFile)
+				if err != nil {
+					return fmt.Errorf("error reading %s webhook template: %v", event, err)
+				}
+				text = string(data)
+			}
+			if text == "" {
+				continue
+			}
+
+			tmpl, err := template.New(event).Funcs(webhookTemplateFuncs).Option("missingkey=zero").Parse(text)
+			if err != nil {
+				return fmt.Errorf("error parsing %s webhook template for %s: %v", event, target.URL, err)
+			}
+			target.template = tmpl
+		}
+	}
+	return nil
+}
+
+func (t webhookTarget) render(event string, call map[string]interface{}, body []byte) ([]byte, error) {
+	if t.template == nil {
+		return body, nil
+	}
+
+	data := webhookTemplateData{Event: event, Call: call}
+	if err := json.Unmarshal(body, &data.Data); err != nil {
+		return nil, fmt.Errorf("error decoding payload: %v", err)
+	}
+
+	var rendered bytes.Buffer
+	if err := t.template.Execute(&rendered, data); err != nil {
+		return nil, fmt.Errorf("error rendering template: %v", err)
+	}
+	if !json.Valid(rendered.Bytes()) {
+		return nil, fmt.Errorf("template did not render valid JSON")
+	}
+	return rendered.Bytes(), nil
+}
+
+type webhookStatusError struct {
+	code int
+}
+
+func (e *webhookStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.code)
+}
+
+// webhookTargets returns the destinations for an event. Without any
+// configured targets, schedule events still go to WEBHOOK_URL, identity
+// checks to VERIFICATION_WEBHOOK_URL, caller lookups to CALLER_LOOKUP_URL
+// and customer context requests to CUSTOMER_CONTEXT_URL.
+func webhookTargets(event string) []webhookTarget {
+	if targets, ok := config.File.Webhooks[event]; ok {
+		return targets
+	}
+	if event == "schedule" && config.WebhookURL != "" {
+		return []webhookTarget{{URL: config.WebhookURL}}
+	}
+	if event == "verify_identity" && config.VerificationWebhookURL != "" {
+		return []webhookTarget{{URL: config.VerificationWebhookURL}}
+	}
+	if event == "caller_lookup" && config.CallerLookupURL != "" {
+		return []webhookTarget{{URL: config.CallerLookupURL}}
+	}
+	if event == "customer_context" && config.CustomerContextURL != "" {
+		return []webhookTarget{{URL: config.CustomerContextURL}}
+	}
+	if event == "approval_request" && config.ApprovalURL != "" {
+		return []webhookTarget{{URL: config.ApprovalURL}}
+	}
+	if event == "error" && config.ErrorWebhookURL != "" {
+		return []webhookTarget{{URL: config.ErrorWebhookURL}}
+	}
+	return nil
+}
+
+// callWebhookTargets returns the destinations for an event of a call. A
+// tenant's calls only use the tenant's own webhooks.
+func callWebhookTargets(event string, call map[string]interface{}) []webhookTarget {
+	if tenant := callTenant(call); tenant != nil {
+		return tenant.Webhooks[event]
+	}
+	return webhookTargets(event)
+}
+
+// deliverWebhook sends payload to every target for the event. call is the
+// summary of the call the event belongs to, available to body templates.
+func deliverWebhook(event string, call map[string]interface{}, payload interface{}) error {
+	targets := callWebhookTargets(event, call)
+	if len(targets) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %v", err)
+	}
+
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := target.deliver(event, call, body)
+			if err != nil {
+				if event != "error" {
+					reportError("webhook", fmt.Sprintf("%s webhook to %s failed: %v", event, target.URL, err), callErrorTags(call), nil)
+				}
+				if target.Optional {
+					log.Printf("Error delivering %s webhook to optional target %s: %v\n", event, target.URL, err)
+					return
+				}
+				recordFailedWebhook(event, call, target.URL, body, err)
+				errs[i] = fmt.Errorf("%s: %v", target.URL, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func (t webhookTarget) deliver(event string, call map[string]interface{}, body []byte) error {
+	body, err := t.render(event, call, body)
+	if err != nil {
+		return err
+	}
+
+	attempts := t.MaxAttempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+	backoff, err := time.ParseDuration(t.Backoff)
+	if err != nil {
+		backoff = time.Second
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := t.post(event, body)
+		if err == nil {
+			return nil
+		}
+
+		var statusErr *webhookStatusError
+		retryable := !errors.As(err, &statusErr) || statusErr.code >= 500 || statusErr.code == http.StatusTooManyRequests
+		if attempt >= attempts || !retryable {
+			return err
+		}
+
+		log.Printf("Retrying %s webhook to %s after attempt %d: %v\n", event, t.URL, attempt, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (t webhookTarget) post(event string, body []byte) error {
+	resp, err := t.do(event, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// queryWebhook sends payload to the first target for the event once, and
+// decodes its JSON response into v. It is for events whose answer the call
+// waits on, such as identity checks.
+func queryWebhook(event string, call map[string]interface{}, payload interface{}, v interface{}) error {
+	targets := callWebhookTargets(event, call)
+	if len(targets) == 0 {
+		return fmt.Errorf("no %s webhook configured", event)
+	}
+	target := targets[0]
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %v", err)
+	}
+	body, err = target.render(event, call, body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := target.do(event, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("error decoding response: %v", err)
+	}
+	return nil
+}
+
+func (t webhookTarget) do(event string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event)
+	for name, value := range t.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %v", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, &webhookStatusError{code: resp.StatusCode}
+	}
+
+	return resp, nil
+}